@@ -0,0 +1,699 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/complete"
+	"github.com/scripthaus-dev/scripthaus/pkg/docsite"
+	"github.com/scripthaus-dev/scripthaus/pkg/export"
+	"github.com/scripthaus-dev/scripthaus/pkg/helptext"
+	"github.com/scripthaus-dev/scripthaus/pkg/importer"
+	"github.com/scripthaus-dev/scripthaus/pkg/manpage"
+	"github.com/scripthaus-dev/scripthaus/pkg/render"
+	"github.com/scripthaus-dev/scripthaus/pkg/shellinit"
+	"github.com/scripthaus-dev/scripthaus/pkg/tui"
+)
+
+// the command registry.  every top-level command declares its options, sub-commands, and
+// arguments here, dispatch, help (the command list and option descriptions), man pages,
+// "did you mean" suggestions, shell completion, option parsing (parseCommandArgs), and
+// --json errors all come from it
+
+// kinds of arguments (and option values), used for completion
+const (
+	argScript   = "script"   // [playbook]::[command]
+	argPlaybook = "playbook" // a playbook file or "." / "^"
+)
+
+type optDef struct {
+	Names   []string // e.g. {"-v", "--verbose"}, the last name is the canonical name (parsedOpt.Name)
+	Arg     string   // the value placeholder, e.g. "[tag]" ("" for options without a value)
+	EqArg   bool     // the value is optional and can only be passed as --opt=value (e.g. --notify=failure)
+	Kind    string   // the kind of value (for completion), argScript or argPlaybook
+	Choices []string // the possible values (for completion)
+	Desc    string   // for help and man pages (lines after the first are continuation lines)
+}
+
+func (def *optDef) canonicalName() string {
+	return def.Names[len(def.Names)-1]
+}
+
+func (def *optDef) takesValue() bool {
+	return def.Arg != "" && !def.EqArg
+}
+
+type cliCommand struct {
+	Name        string
+	Summary     string // one line, for the main help and the man page NAME section
+	HelpText    string // prose, "[:options]" is replaced with the options (see commandHelpText)
+	Opts        []optDef
+	SubCommands []string // possible values of the first argument
+	ArgKinds    []string // the kind of each argument (for completion, "" for none)
+	StopAtArg   bool     // options are only parsed before the first argument (the rest are passed through)
+	MaxArgs     int      // -1 for no limit
+	Usage       string   // for argument errors, e.g. "scripthaus show [playbook]::[command]"
+	Hidden      bool     // not shown in suggestions or completion
+	RawArgs     bool     // the command does its own argument parsing
+	Run         func(gopts globalOptsType) (int, error)
+}
+
+type parsedOpt struct {
+	Name  string // canonical name
+	Flag  string // the name as passed
+	Value string
+}
+
+type parsedArgs struct {
+	Opts    []parsedOpt // in the order they were passed
+	Args    []string
+	RestIdx int // index in Args of the arguments after "--" (-1 if there was no "--")
+}
+
+func (p *parsedArgs) Has(name string) bool {
+	for _, opt := range p.Opts {
+		if opt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var globalOptDefs = []optDef{
+	{Names: []string{"-p", "--playbook"}, Arg: "[file]", Kind: argPlaybook, Desc: "specify a playbook to use"},
+	{Names: []string{"-v", "--verbose"}, Desc: "more debugging output"},
+	{Names: []string{"-q", "--quiet"}, Desc: "do not show version and command summary info (command output only)"},
+	{Names: []string{"-s", "--summary"}, Desc: "print a summary line (exit code, duration) after the command finishes"},
+	{Names: []string{"--strict"}, Desc: "playbook warnings (invalid directives, bad languages, etc.) are errors,\nalso set with {\"strict\": true} in $SCRIPTHAUS_HOME/config.json"},
+	{Names: []string{"--json"}, Desc: "print errors as a JSON object on stderr, {\"error\", \"command\", \"usage\"}\n(also for commands given their own --json option, e.g. 'list --json')"},
+	{Names: []string{"--color"}, Arg: "[auto|always|never]", Choices: []string{"auto", "always", "never"}, Desc: "color output (default auto, colors terminals unless NO_COLOR is set)"},
+}
+
+var cliCommands []*cliCommand
+
+func init() {
+	cliCommands = []*cliCommand{
+		{Name: "version", Summary: "print version and exit", HelpText: helptext.VersionText, MaxArgs: -1, Run: func(gopts globalOptsType) (int, error) {
+			runVersionCommand(gopts)
+			return 0, nil
+		}},
+		{Name: "run", Summary: "runs a playbook command", HelpText: helptext.RunText, StopAtArg: true, MaxArgs: -1, ArgKinds: []string{argScript},
+			Opts: []optDef{
+				{Names: []string{"--nolog"}, Desc: "will not log this command to scripthaus history"},
+				{Names: []string{"--log"}, Desc: "force logging of command to scripthaus history (default)"},
+				{Names: []string{"--env"}, Arg: "[VAR=VAL]", Desc: "additional environment variables, 'var=val;var=val' (can be repeated)"},
+				{Names: []string{"--env-file"}, Arg: "[file.env]", Desc: "additional environment variables from a .env file (can be repeated,\nlater files and --env values override earlier ones)"},
+				{Names: []string{"--tag"}, Arg: "[tag]", Desc: "run all commands in the playbook with the tag (default playbook \".\")"},
+				{Names: []string{"--tmux-pane"}, Desc: "run in a new tmux pane (split from the current one)"},
+				{Names: []string{"--tmux-window"}, Desc: "run in a new tmux window (named after the command)"},
+				{Names: []string{"--report"}, Arg: "[format]=[file]", Desc: "write a json or junit (XML) report of the run (can be repeated)"},
+				{Names: []string{"--notify"}, Arg: "[mode]", EqArg: true, Choices: []string{"failure"}, Desc: "send a notification when the command finishes (see Notifications),\n--notify=failure only notifies if the command fails"},
+				{Names: []string{"--pipe"}, Desc: "run the command arguments as a pipeline, e.g. --pipe .a .b or --pipe .a x '|' .b\n(see Pipelines)"},
+				{Names: []string{"--matrix"}, Arg: "[var=v1,v2,...]", Desc: "run the command once per value (can be repeated, see Matrix Runs)"},
+				{Names: []string{"--each"}, Desc: "run the command once per line of stdin, the line is $1 (see Each Line)"},
+				{Names: []string{"--parallel"}, Arg: "[n]", Desc: "with --each, run up to n commands at the same time"},
+				{Names: []string{"--timeout"}, Arg: "[duration]", Desc: "terminate the command after [duration], e.g. 30s or 10m (see Stopping Commands)"},
+				{Names: []string{"-x", "--trace"}, Desc: "print each command/line of the script to stderr as it runs (see Tracing)"},
+				{Names: []string{"-y", "--yes"}, Desc: "run without confirming a code block or an untrusted playbook (see Code Blocks and Trust)"},
+				{Names: []string{"--from"}, Arg: "[playbook]", Kind: argPlaybook, Desc: "look up a bare command name in this playbook (see Name Precedence)"},
+			},
+			Run: func(gopts globalOptsType) (int, error) {
+				if len(gopts.CommandArgs) == 0 && gopts.PlaybookFile == "" && tui.IsInteractive() {
+					return runPickCommand(gopts)
+				}
+				return runRunCommand(gopts)
+			}},
+		{Name: "pick", Summary: "fuzzy find a command (with a preview) and run it", HelpText: helptext.PickText, MaxArgs: -1, Run: runPickCommand},
+		{Name: "list", Summary: "list commands available in playbook", HelpText: helptext.ListText, MaxArgs: 1, ArgKinds: []string{argPlaybook}, Usage: "scripthaus list [playbook]",
+			Opts: []optDef{
+				{Names: []string{"--tag"}, Arg: "[tag]", Desc: "only list commands with the given tag (can be repeated)"},
+				{Names: []string{"--all"}, Desc: "find every playbook in the project (respects .gitignore) and list them by file,\n\"list --all ^\" (or \"^tools/\") lists the global directory and its sub-directories"},
+				{Names: []string{"--everywhere"}, Desc: "list everything you can run from here (see Everywhere)"},
+				{Names: []string{"-v", "--verbose"}, Desc: "show where each command is defined (file:line, for jumping to it in an editor)"},
+				{Names: []string{"--json"}, Desc: "output the commands as a JSON array (same as --format json)"},
+				{Names: []string{"--format"}, Arg: "[text|json]", Desc: "output format (default text)"},
+			},
+			Run: runListCommand},
+		{Name: "add", Summary: "quickly add a command to a playbook", HelpText: helptext.AddText, MaxArgs: -1, ArgKinds: []string{argScript}, Usage: "scripthaus add [opts] [playbook]::[script]",
+			Opts: []optDef{
+				{Names: []string{"-t", "--type"}, Arg: "[scripttype]", Choices: base.ValidScriptTypes(), Desc: "(required) the language type for the command (e.g. bash, python3)\n(defaults to the original type with --from-history)"},
+				{Names: []string{"-m", "--message"}, Arg: "[message]", Desc: "add some help text for the command.  markdown format"},
+				{Names: []string{"-s", "--short-desc"}, Arg: "[desc]", Desc: "short description for command (one line)"},
+				{Names: []string{"-c"}, Arg: "[command-text]", Desc: "the text for the command to be added"},
+				{Names: []string{"--from-history"}, Arg: "[id]", Desc: "use the command line of history item [id] as the script text"},
+				{Names: []string{"--edit"}, Desc: "write the command in your editor (from a prefilled template)"},
+				{Names: []string{"--section"}, Arg: "[heading]", Desc: "add the command at the end of the section with the given\nmarkdown heading (the heading is created if missing)"},
+				{Names: []string{"--dry-run"}, Desc: "print messages, but do not modify playbook file"},
+			},
+			Run: runAddCommand},
+		{Name: "remove", Summary: "remove a command from a playbook", HelpText: helptext.RemoveText, MaxArgs: 1, ArgKinds: []string{argScript}, Usage: "scripthaus remove [remove-opts] [playbook]::[command]",
+			Opts: []optDef{
+				{Names: []string{"--with-doc"}, Desc: "also remove the command's help text (the markdown before the code block)"},
+				{Names: []string{"--dry-run"}, Desc: "print the lines to be removed, but do not modify the playbook"},
+			},
+			Run: runRemoveCommand},
+		{Name: "mv", Summary: "move or rename a command (within or between playbooks)", HelpText: helptext.MvText, MaxArgs: 2, ArgKinds: []string{argScript, argScript}, Usage: "scripthaus mv [mv-opts] [playbook]::[command] [playbook]::[new-command]",
+			Opts: []optDef{
+				{Names: []string{"--section"}, Arg: "[heading]", Desc: "insert the command at the end of the section with the\ngiven markdown heading (the heading is created if missing)"},
+				{Names: []string{"--dry-run"}, Desc: "print the command text to be moved, but do not modify any files"},
+			},
+			Run: runMvCommand},
+		{Name: "show", Summary: "show help and script text for a playbook command", HelpText: helptext.ShowText, MaxArgs: 1, ArgKinds: []string{argScript}, Usage: "scripthaus show [playbook]::[command]",
+			Opts: []optDef{
+				{Names: []string{"--code"}, Desc: "print only the script text"},
+				{Names: []string{"--doc"}, Desc: "print only the help text (markdown)"},
+				{Names: []string{"--meta"}, Desc: "print the parsed metadata (language, location, directives with line numbers)"},
+				{Names: []string{"--raw"}, Desc: "print the raw markdown even when stdout is a terminal"},
+				{Names: []string{"--from"}, Arg: "[playbook]", Kind: argPlaybook, Desc: "look up a bare command name in this playbook (e.g. \"--from ^ build\")"},
+			},
+			Run: runShowCommand},
+		{Name: "edit", Summary: "open a playbook command in your editor", HelpText: helptext.EditText, MaxArgs: 1, ArgKinds: []string{argScript}, Usage: "scripthaus edit [playbook]::[command]", Run: runEditCommand},
+		{Name: "which", Summary: "show how a playbook/command name resolves (file, line, project root)", HelpText: helptext.WhichText, MaxArgs: 1, ArgKinds: []string{argScript}, Usage: "scripthaus which [playbook]::[command]", Run: runWhichCommand},
+		{Name: "history", Summary: "show command history", HelpText: helptext.HistoryText, MaxArgs: 2, SubCommands: []string{"show", "open", "env"}, Usage: "scripthaus history [show|open|env] [id]",
+			Opts: []optDef{
+				{Names: []string{"-n"}, Arg: "[num]", Desc: "print last n commands"},
+				{Names: []string{"--all"}, Desc: "print all history"},
+				{Names: []string{"--full"}, Desc: "show full history item (all fields, multiple lines)"},
+				{Names: []string{"--json"}, Desc: "output full records in JSON format (can process with jq)"},
+				{Names: []string{"--format"}, Arg: "[template]", Desc: "print each item with a Go template (see Format Templates)"},
+				{Names: []string{"--unique"}, Desc: "collapse identical runs (same playbook, command, and args) to the most\nrecent one, with the number of runs (-n counts unique entries)"},
+			},
+			Run: runHistoryCommand},
+		{Name: "rerun", Summary: "run the last (or last failed) command of the project again", HelpText: helptext.RerunText, MaxArgs: 0, Usage: "scripthaus rerun [--last | --last-failed]",
+			Opts: []optDef{
+				{Names: []string{"--last"}, Desc: "rerun the most recent run (the default)"},
+				{Names: []string{"--last-failed"}, Desc: "rerun the most recent run with a non-zero exit code"},
+			},
+			Run: runRerunCommand},
+		{Name: "bench", Summary: "run a command repeatedly and report min/mean/p95 durations", HelpText: helptext.BenchText, StopAtArg: true, MaxArgs: -1, ArgKinds: []string{argScript}, Usage: "scripthaus bench [bench-opts] [playbook]::[command] [script-opts]",
+			Opts: []optDef{
+				{Names: []string{"-n", "--runs"}, Arg: "[n]", Desc: "number of measured runs (default 10)"},
+				{Names: []string{"-w", "--warmup"}, Arg: "[n]", Desc: "runs before measuring (default 0)"},
+				{Names: []string{"--show-output"}, Desc: "do not suppress the command's output"},
+				{Names: []string{"-i", "--ignore-failure"}, Desc: "keep going when a run fails (failed runs are measured too)"},
+				{Names: []string{"--json"}, Desc: "print the durations and statistics as JSON (in milliseconds)"},
+				{Names: []string{"--nolog"}, Desc: "do not log the runs to history (no comparison next time)"},
+			},
+			Run: runBenchCommand},
+		{Name: "manage", Summary: "manage history items", HelpText: helptext.ManageText, MaxArgs: 3, Usage: "scripthaus manage [sub-command]",
+			SubCommands: []string{"clear-history", "delete-db", "remove-history-range", "renumber-history", "vacuum", "export-json", "import-json", "encrypt-history"},
+			Run:         runManageCommand},
+		{Name: "search", Summary: "search command names, descriptions, help, and scripts across playbooks", HelpText: helptext.SearchText, MaxArgs: -1, Usage: "scripthaus search [search-opts] [term]",
+			Opts: []optDef{
+				{Names: []string{"-c", "--case-sensitive"}, Desc: "case sensitive match (default is case insensitive)"},
+				{Names: []string{"--all-lines"}, Desc: "print every matching line (default is at most 5 per command)"},
+			},
+			Run: runSearchCommand},
+		{Name: "fmt", Summary: "normalize the formatting of a playbook", HelpText: helptext.FmtText, MaxArgs: 1, ArgKinds: []string{argPlaybook}, Usage: "scripthaus fmt [playbook]",
+			Opts: []optDef{
+				{Names: []string{"--check"}, Desc: "do not modify the playbook, exit with code 1 if it is not formatted"},
+				{Names: []string{"--sort"}, Desc: "sort the commands under each section (level 1-3 heading) by name"},
+			},
+			Run: runFmtCommand},
+		{Name: "diff", Summary: "show the commands added, removed, renamed, or changed between git revisions", HelpText: helptext.DiffText, MaxArgs: 3, ArgKinds: []string{argPlaybook}, Usage: "scripthaus diff [diff-opts] [playbook] [rev] [rev2]",
+			Opts: []optDef{
+				{Names: []string{"--json"}, Desc: "print the changes as JSON"},
+				{Names: []string{"--exit-code"}, Desc: "exit with code 1 if anything changed (like 'git diff --exit-code')"},
+			},
+			Run: runDiffCommand},
+		{Name: "import", Summary: "import npm scripts (package.json) or make targets (Makefile) into a playbook", HelpText: helptext.ImportText, MaxArgs: 2, ArgKinds: []string{"", argPlaybook}, Usage: "scripthaus import [import-opts] [source-file] [playbook]",
+			Opts: []optDef{
+				{Names: []string{"--from"}, Arg: "[npm|make]", Choices: []string{importer.SourceNpm, importer.SourceMake}, Desc: "the type of the source file"},
+				{Names: []string{"--section"}, Arg: "[heading]", Desc: "add the commands at the end of this section (created if missing)"},
+				{Names: []string{"--dry-run"}, Desc: "print the updated playbook, do not write it"},
+			},
+			Run: runImportCommand},
+		{Name: "export", Summary: "generate a Makefile, justfile, or Taskfile.yml that wraps a playbook", HelpText: helptext.ExportText, MaxArgs: 1, ArgKinds: []string{argPlaybook}, Usage: "scripthaus export [export-opts] [playbook]",
+			Opts: []optDef{
+				{Names: []string{"-f", "--format"}, Arg: "[format]", Choices: export.Formats(), Desc: "make (default), just, or task"},
+				{Names: []string{"-o", "--output"}, Arg: "[file]", Desc: "write to [file] (e.g. Makefile, justfile, Taskfile.yml)"},
+				{Names: []string{"--force"}, Desc: "overwrite an output file that was not generated by scripthaus"},
+			},
+			Run: runExportCommand},
+		{Name: "export-script", Summary: "write a command as a standalone executable script", HelpText: helptext.ExportScriptText, MaxArgs: 1, ArgKinds: []string{argScript}, Usage: "scripthaus export-script [playbook]::[command]",
+			Opts: []optDef{
+				{Names: []string{"-o", "--output"}, Arg: "[file]", Desc: "write the script to [file]"},
+				{Names: []string{"--force"}, Desc: "overwrite an output file that was not generated by scripthaus"},
+			},
+			Run: runExportScriptCommand},
+		{Name: "mcp", Summary: "run a Model Context Protocol server (stdio) for AI assistants", HelpText: helptext.McpText, MaxArgs: 0, Usage: "scripthaus mcp [mcp-opts]",
+			Opts: []optDef{
+				{Names: []string{"--allow"}, Arg: "[pattern]", Desc: "allow run_command to run matching commands (can be repeated)"},
+			},
+			Run: runMcpCommand},
+		{Name: "hooks", Summary: "install git hooks that run playbook commands", HelpText: helptext.HooksText, MaxArgs: 2, SubCommands: []string{"list", "install", "uninstall"}, ArgKinds: []string{"", argPlaybook}, Usage: "scripthaus hooks [list|install|uninstall] [playbook]",
+			Opts: []optDef{
+				{Names: []string{"--force"}, Desc: "overwrite existing hooks that were not generated by scripthaus"},
+			},
+			Run: runHooksCommand},
+		{Name: "trust", Summary: "approve playbooks to run without a trust prompt", HelpText: helptext.TrustText, MaxArgs: 1, ArgKinds: []string{argPlaybook}, Usage: "scripthaus trust [--list | --revoke] [playbook]",
+			Opts: []optDef{
+				{Names: []string{"--list"}, Desc: "list the trusted playbooks (trusted, changed, or missing) and trusted roots"},
+				{Names: []string{"--revoke"}, Desc: "remove the playbook from the trust store"},
+			},
+			Run: runTrustCommand},
+		{Name: "publish", Summary: "publish a playbook to your team's registry", HelpText: helptext.PublishText, MaxArgs: 2, ArgKinds: []string{argPlaybook}, Usage: "scripthaus publish [playbook] [@team/name]",
+			Opts: []optDef{
+				{Names: []string{"--sign-key"}, Arg: "[keyfile]", Desc: "sign with this ssh private key (overrides \"sign_key\")"},
+			},
+			Run: runPublishCommand},
+		{Name: "fetch", Summary: "fetch @team/name playbooks from the registry", HelpText: helptext.FetchText, MaxArgs: -1, Usage: "scripthaus fetch [@team/name]...",
+			Opts: []optDef{
+				{Names: []string{"--sha256"}, Arg: "[checksum]", Desc: "the playbook must have this sha256 (one name only)"},
+			},
+			Run: runFetchCommand},
+		{Name: "update", Summary: "update the registry playbooks pinned in scripthaus.lock", HelpText: helptext.UpdateText, MaxArgs: -1, Usage: "scripthaus update [@team/name]...", Run: runUpdateCommand},
+		{Name: "daemon", Summary: "run a JSON-RPC server (stdio) for editor integrations", HelpText: helptext.DaemonText, MaxArgs: 0, Usage: "scripthaus daemon", Run: runDaemonCommand},
+		{Name: "shell-init", Summary: "print shell functions that run playbook commands (bash, zsh, or fish)", HelpText: helptext.ShellInitText, MaxArgs: 1, SubCommands: shellinit.ShellNames(), Usage: "scripthaus shell-init [shell-init-opts] [bash|zsh|fish]",
+			Opts: []optDef{
+				{Names: []string{"--tag"}, Arg: "[tag]", Desc: "only commands with this tag (can be repeated)"},
+				{Names: []string{"--alias"}, Arg: "[name]=[command]", Kind: argScript, Desc: "add a function for the command (can be repeated)"},
+				{Names: []string{"--prefix"}, Arg: "[prefix]", Desc: "prefix for the function names of playbook commands, e.g. \"sh-\""},
+				{Names: []string{"--pick"}, Arg: "[name]", EqArg: true, Desc: "add a fuzzy-run helper (default name \"shr\")"},
+			},
+			Run: runShellInitCommand},
+		{Name: "completion", Summary: "print a shell completion script (bash, zsh, or fish)", HelpText: helptext.CompletionText, MaxArgs: 1, SubCommands: complete.ShellNames(), Usage: "scripthaus completion [bash|zsh|fish]", Run: runCompletionCommand},
+		{Name: "man", Summary: "print (or write) the man pages for scripthaus and its commands", HelpText: helptext.ManText, MaxArgs: 1, Usage: "scripthaus man [man-opts] [command]",
+			Opts: []optDef{
+				{Names: []string{"--dir"}, Arg: "[dir]", Desc: "write scripthaus.1 and a scripthaus-[command].1 page for every command to [dir]"},
+			},
+			Run: runManCommand},
+		{Name: "docs", Summary: "generate a static docs site (HTML or markdown) from the project's playbooks", HelpText: helptext.DocsText, MaxArgs: 1, Usage: "scripthaus docs [docs-opts] [dir]",
+			Opts: []optDef{
+				{Names: []string{"-f", "--format"}, Arg: "[format]", Choices: []string{docsite.FormatHtml, docsite.FormatMarkdown}, Desc: "html (default) or markdown"},
+				{Names: []string{"--title"}, Arg: "[title]", Desc: "title for the index page (default \"Playbooks\")"},
+			},
+			Run: runDocsCommand},
+		{Name: "help", Summary: "describe commands and usage", HelpText: helptext.MainHelpText, MaxArgs: -1, Run: func(gopts globalOptsType) (int, error) {
+			runHelpCommand(gopts, true)
+			return 0, nil
+		}},
+		{Name: "__complete", Hidden: true, RawArgs: true, Run: runCompleteCommand},
+	}
+	// 'help [topic]', the commands and the extra help topics (see runHelpCommand)
+	lookupCommand("help").SubCommands = append(cliCommandNames(), "directives", "plugins", "overview")
+	lookupCommand("man").SubCommands = cliCommandNames()
+}
+
+// returns nil if there is no such command
+func lookupCommand(name string) *cliCommand {
+	for _, cmd := range cliCommands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// the (non-hidden) top-level command names
+func cliCommandNames() []string {
+	var rtn []string
+	for _, cmd := range cliCommands {
+		if !cmd.Hidden {
+			rtn = append(rtn, cmd.Name)
+		}
+	}
+	return rtn
+}
+
+// the option as shown in help, e.g. "-n, --runs [n]" or "--pick[=name]"
+func (def *optDef) helpName() string {
+	name := strings.Join(def.Names, ", ")
+	if def.EqArg {
+		return name + "[=" + strings.Trim(def.Arg, "[]") + "]"
+	}
+	if def.Arg != "" {
+		return name + " " + def.Arg
+	}
+	return name
+}
+
+// width of the option name column in help
+const helpOptWidth = 25
+
+// "    [name]  - [desc]" lines (the description goes on the next line if the name is too long)
+func optsHelpText(defs []optDef) string {
+	if len(defs) == 0 {
+		return "    none"
+	}
+	indent := strings.Repeat(" ", 4+helpOptWidth)
+	var lines []string
+	for _, def := range defs {
+		name := def.helpName()
+		descLines := strings.Split(def.Desc, "\n")
+		if len(name) < helpOptWidth {
+			lines = append(lines, fmt.Sprintf("    %-*s- %s", helpOptWidth, name, descLines[0]))
+		} else {
+			lines = append(lines, "    "+name, indent+"- "+descLines[0])
+		}
+		for _, line := range descLines[1:] {
+			lines = append(lines, indent+"  "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the command list of the main help
+func commandsHelpText() string {
+	var lines []string
+	for _, cmd := range cliCommands {
+		if !cmd.Hidden {
+			lines = append(lines, fmt.Sprintf("    %-16s- %s", cmd.Name, cmd.Summary))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// the command's help text with the options (and for the main help, the commands) filled in
+func commandHelpText(cmd *cliCommand) string {
+	text := strings.ReplaceAll(cmd.HelpText, "[:options]", optsHelpText(cmd.Opts))
+	if cmd.Name == "help" {
+		text = strings.ReplaceAll(text, "[:commands]", commandsHelpText())
+		text = strings.ReplaceAll(text, "[:global-options]", optsHelpText(globalOptDefs))
+	}
+	return text
+}
+
+var usageLineRe = regexp.MustCompile("^Usage: ([^\n]*)\n*")
+var optsSectionRe = regexp.MustCompile("(?m)^[\\w -]*Options:\n\\[:options\\]\n*")
+
+// the man page for a command ("help" is the main scripthaus page).  the options are a section
+// of their own, so they are taken out of the description
+func commandManPage(cmd *cliCommand) manpage.Page {
+	page := manpage.Page{Name: "scripthaus-" + cmd.Name, Summary: cmd.Summary, SeeAlso: []string{"scripthaus"}}
+	defs := cmd.Opts
+	if cmd.Name == "help" {
+		page.Name, page.Summary, page.SeeAlso = "scripthaus", "run commands from markdown playbooks", nil
+		defs = globalOptDefs
+		for _, other := range cliCommands {
+			if !other.Hidden && other.Name != "help" {
+				page.SeeAlso = append(page.SeeAlso, "scripthaus-"+other.Name)
+			}
+		}
+	}
+	text := cmd.HelpText
+	if m := usageLineRe.FindStringSubmatch(text); m != nil {
+		page.Synopsis = m[1]
+		text = text[len(m[0]):]
+	}
+	text = optsSectionRe.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, "[:global-options]", "")
+	page.Description = strings.ReplaceAll(text, "[:commands]", commandsHelpText())
+	for _, def := range defs {
+		page.Options = append(page.Options, manpage.Option{Name: def.helpName(), Desc: def.Desc})
+	}
+	return page
+}
+
+func runManCommand(gopts globalOptsType) (int, error) {
+	parsed, err := parseCommandArgs("man", gopts.CommandArgs)
+	if err != nil {
+		return 1, err
+	}
+	var outDir string
+	for _, opt := range parsed.Opts {
+		if opt.Name == "--dir" {
+			outDir = opt.Value
+		}
+	}
+	if outDir == "" {
+		cmd := lookupCommand("help")
+		if len(parsed.Args) > 0 {
+			cmd = lookupCommand(parsed.Args[0])
+			if cmd == nil || cmd.Hidden {
+				return 1, fmt.Errorf("invalid command '%s'%s", parsed.Args[0], base.DidYouMeanStr(base.Suggest(parsed.Args[0], cliCommandNames())))
+			}
+		}
+		fmt.Print(commandManPage(cmd).Roff(base.ScriptHausVersion))
+		return 0, nil
+	}
+	if len(parsed.Args) > 0 {
+		return 1, fmt.Errorf("Usage: %s, --dir writes every page (no command)", lookupCommand("man").Usage)
+	}
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return 1, fmt.Errorf("cannot create man page directory: %w", err)
+	}
+	for _, cmd := range cliCommands {
+		if cmd.Hidden {
+			continue
+		}
+		page := commandManPage(cmd)
+		fileName := filepath.Join(outDir, page.Name+".1")
+		err = os.WriteFile(fileName, []byte(page.Roff(base.ScriptHausVersion)), 0644)
+		if err != nil {
+			return 1, fmt.Errorf("cannot write man page: %w", err)
+		}
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] wrote %s\n", fileName)
+		}
+	}
+	return 0, nil
+}
+
+// an error in the command line (an invalid option or arguments), --json errors include the usage
+type usageError struct {
+	Usage string
+	Msg   string
+}
+
+func (e *usageError) Error() string {
+	return e.Msg
+}
+
+// the --json form of an error (printed to stderr)
+type jsonError struct {
+	Error       string   `json:"error"`
+	Command     string   `json:"command,omitempty"`
+	Usage       string   `json:"usage,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func printJsonError(jerr jsonError) {
+	barr, _ := json.Marshal(jerr)
+	fmt.Fprintf(os.Stderr, "%s\n", barr)
+}
+
+// prints an error that stops scripthaus (a JSON object with --json)
+func printCliError(gopts globalOptsType, err error) {
+	if !gopts.JsonErrors {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] %s %v\n\n", render.MakeColorizer(os.Stderr).Error("ERROR"), err)
+		return
+	}
+	jerr := jsonError{Error: err.Error(), Command: gopts.CommandName}
+	var uerr *usageError
+	if errors.As(err, &uerr) {
+		jerr.Usage = uerr.Usage
+	}
+	printJsonError(jerr)
+}
+
+// errors are JSON with the global --json option, or when the command is given its own --json
+// option (e.g. 'list --json')
+func wantsJsonErrors(gopts globalOptsType) bool {
+	if gopts.JsonErrors {
+		return true
+	}
+	cmd := lookupCommand(gopts.CommandName)
+	if cmd == nil || findOptDef(cmd.Opts, "--json") == nil {
+		return false
+	}
+	for _, argStr := range gopts.CommandArgs {
+		if argStr == "--" {
+			break
+		}
+		if argStr == "--json" {
+			return true
+		}
+	}
+	return false
+}
+
+func findOptDef(defs []optDef, name string) *optDef {
+	for idx := range defs {
+		for _, defName := range defs[idx].Names {
+			if defName == name {
+				return &defs[idx]
+			}
+		}
+	}
+	return nil
+}
+
+// parses options (anywhere in args, or only before the first argument with stopAtArg) and
+// arguments.  options with values can be passed as "--opt value" or "--opt=value".  "--" ends
+// the options.  cmdName is only used for error messages ("" for the global options)
+func parseOpts(cmdName string, defs []optDef, stopAtArg bool, args []string) (*parsedArgs, error) {
+	rtn := &parsedArgs{RestIdx: -1}
+	cmdStr := "scripthaus"
+	if cmdName != "" {
+		cmdStr = "scripthaus " + cmdName
+	}
+	for idx := 0; idx < len(args); idx++ {
+		argStr := args[idx]
+		if argStr == "--" {
+			rtn.RestIdx = len(rtn.Args)
+			rtn.Args = append(rtn.Args, args[idx+1:]...)
+			break
+		}
+		if !isOption(argStr) {
+			rtn.Args = append(rtn.Args, argStr)
+			if stopAtArg {
+				rtn.Args = append(rtn.Args, args[idx+1:]...)
+				break
+			}
+			continue
+		}
+		flag, value, hasValue := argStr, "", false
+		if eqIdx := strings.Index(argStr, "="); eqIdx != -1 && strings.HasPrefix(argStr, "--") {
+			flag, value, hasValue = argStr[:eqIdx], argStr[eqIdx+1:], true
+		}
+		def := findOptDef(defs, flag)
+		if def == nil {
+			return nil, fmt.Errorf("invalid option '%s' passed to %s", flag, cmdStr)
+		}
+		if def.Arg == "" && hasValue {
+			return nil, fmt.Errorf("option '%s' passed to %s does not take a value", flag, cmdStr)
+		}
+		if def.takesValue() && !hasValue {
+			if idx+1 >= len(args) {
+				return nil, fmt.Errorf("'%s %s' missing value", flag, def.Arg)
+			}
+			idx++
+			value = args[idx]
+		}
+		rtn.Opts = append(rtn.Opts, parsedOpt{Name: def.canonicalName(), Flag: flag, Value: value})
+	}
+	return rtn, nil
+}
+
+// parses the arguments of a registered command (checks MaxArgs)
+func parseCommandArgs(cmdName string, args []string) (*parsedArgs, error) {
+	cmd := lookupCommand(cmdName)
+	if cmd == nil {
+		return nil, fmt.Errorf("invalid command '%s' (internal error)", cmdName)
+	}
+	rtn, err := parseOpts(cmdName, cmd.Opts, cmd.StopAtArg, args)
+	if err != nil {
+		return nil, &usageError{Usage: cmd.Usage, Msg: err.Error()}
+	}
+	if cmd.MaxArgs >= 0 && len(rtn.Args) > cmd.MaxArgs {
+		return nil, &usageError{Usage: cmd.Usage, Msg: fmt.Sprintf("Usage: %s, too many arguments passed, extras = '%s'", cmd.Usage, strings.Join(rtn.Args[cmd.MaxArgs:], " "))}
+	}
+	return rtn, nil
+}
+
+// 'scripthaus __complete [words...]', called by the completion scripts (see pkg/complete).  the
+// last word is the word being completed.  prints the candidates, one per line
+func runCompleteCommand(gopts globalOptsType) (int, error) {
+	words := gopts.CommandArgs
+	cur := ""
+	if len(words) > 0 {
+		cur = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	for _, cand := range completeCandidates(words, cur) {
+		fmt.Println(cand)
+	}
+	return 0, nil
+}
+
+func completeOptValue(def *optDef, cur string, playbookFile string) []string {
+	if def.Kind == argPlaybook {
+		return complete.Playbooks(cur)
+	}
+	if def.Kind == argScript {
+		return complete.ScriptTargets(cur, playbookFile)
+	}
+	return complete.Words(def.Choices, cur)
+}
+
+func optNames(defs []optDef) []string {
+	var rtn []string
+	for _, def := range defs {
+		rtn = append(rtn, def.Names...)
+	}
+	return rtn
+}
+
+func completeCandidates(words []string, cur string) []string {
+	if len(words) > 0 {
+		if def := findOptDef(globalOptDefs, words[len(words)-1]); def != nil && def.takesValue() {
+			return completeOptValue(def, cur, "")
+		}
+	}
+	cgopts, err := parseGlobalOpts(append([]string{"scripthaus"}, words...))
+	if err != nil {
+		return nil
+	}
+	if cgopts.CommandName == "" {
+		if isOption(cur) {
+			return complete.Words(optNames(globalOptDefs), cur)
+		}
+		return complete.Words(cliCommandNames(), cur)
+	}
+	cmd := lookupCommand(cgopts.CommandName)
+	if cmd == nil || cmd.RawArgs {
+		return nil
+	}
+	args := cgopts.CommandArgs
+	if len(args) > 0 {
+		if def := findOptDef(cmd.Opts, args[len(args)-1]); def != nil && def.takesValue() {
+			return completeOptValue(def, cur, cgopts.PlaybookFile)
+		}
+	}
+	parsed, err := parseOpts(cmd.Name, cmd.Opts, cmd.StopAtArg, args)
+	if err != nil {
+		return nil
+	}
+	if isOption(cur) {
+		if cmd.StopAtArg && len(parsed.Args) > 0 {
+			return nil
+		}
+		return complete.Words(optNames(cmd.Opts), cur)
+	}
+	argIdx := len(parsed.Args)
+	if cmd.Name == "run" {
+		// after "|" in a run pipeline the next argument is a command again
+		for idx, argStr := range parsed.Args {
+			if argStr == pipeSeparator {
+				argIdx = len(parsed.Args) - idx - 1
+			}
+		}
+	}
+	if argIdx == 0 && len(cmd.SubCommands) > 0 {
+		return complete.Words(cmd.SubCommands, cur)
+	}
+	if argIdx >= len(cmd.ArgKinds) {
+		return nil
+	}
+	return completeOptValue(&optDef{Kind: cmd.ArgKinds[argIdx]}, cur, cgopts.PlaybookFile)
+}
+
+func runCompletionCommand(gopts globalOptsType) (int, error) {
+	parsed, err := parseCommandArgs("completion", gopts.CommandArgs)
+	if err != nil {
+		return 1, err
+	}
+	if len(parsed.Args) != 1 {
+		return 1, fmt.Errorf("Usage: scripthaus completion [bash|zsh|fish]")
+	}
+	script, err := complete.ShellScript(parsed.Args[0])
+	if err != nil {
+		return 1, err
+	}
+	fmt.Print(script)
+	return 0, nil
+}
@@ -7,24 +7,59 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/alessio/shellescape"
 	"github.com/joho/godotenv"
 	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/bench"
 	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/daemon"
+	"github.com/scripthaus-dev/scripthaus/pkg/direnv"
+	"github.com/scripthaus-dev/scripthaus/pkg/docsite"
+	"github.com/scripthaus-dev/scripthaus/pkg/export"
+	"github.com/scripthaus-dev/scripthaus/pkg/githooks"
 	"github.com/scripthaus-dev/scripthaus/pkg/helptext"
 	"github.com/scripthaus-dev/scripthaus/pkg/history"
+	"github.com/scripthaus-dev/scripthaus/pkg/importer"
+	"github.com/scripthaus-dev/scripthaus/pkg/mcp"
 	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/notify"
+	"github.com/scripthaus-dev/scripthaus/pkg/otlp"
+	"github.com/scripthaus-dev/scripthaus/pkg/output"
 	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/playbookdiff"
+	"github.com/scripthaus-dev/scripthaus/pkg/plugin"
+	"github.com/scripthaus-dev/scripthaus/pkg/registry"
+	"github.com/scripthaus-dev/scripthaus/pkg/render"
+	"github.com/scripthaus-dev/scripthaus/pkg/report"
+	"github.com/scripthaus-dev/scripthaus/pkg/runhooks"
+	"github.com/scripthaus-dev/scripthaus/pkg/runlog"
+	"github.com/scripthaus-dev/scripthaus/pkg/scripthaus"
+	"github.com/scripthaus-dev/scripthaus/pkg/search"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+	"github.com/scripthaus-dev/scripthaus/pkg/shellinit"
+	"github.com/scripthaus-dev/scripthaus/pkg/tmux"
+	"github.com/scripthaus-dev/scripthaus/pkg/trust"
+	"github.com/scripthaus-dev/scripthaus/pkg/tui"
 )
 
 func runVersionCommand(gopts globalOptsType) {
@@ -40,67 +75,125 @@ func runHelpCommand(gopts globalOptsType, showVersion bool) {
 	if len(gopts.CommandArgs) > 0 {
 		subHelpCommand = gopts.CommandArgs[0]
 	}
-	if subHelpCommand == "run" {
-		fmt.Printf("\n%s\n\n", helptext.RunText)
-	} else if subHelpCommand == "list" {
-		fmt.Printf("\n%s\n\n", helptext.ListText)
-	} else if subHelpCommand == "show" {
-		fmt.Printf("\n%s\n\n", helptext.ShowText)
-	} else if subHelpCommand == "add" {
-		fmt.Printf("\n%s\n\n", helptext.AddText)
-	} else if subHelpCommand == "history" {
-		fmt.Printf("\n%s\n\n", helptext.HistoryText)
-	} else if subHelpCommand == "manage" {
-		fmt.Printf("\n%s\n\n", helptext.ManageText)
-	} else if subHelpCommand == "version" {
-		fmt.Printf("\n%s\n\n", helptext.VersionText)
+	if subHelpCommand == "plugins" {
+		fmt.Printf("\n%s\n\n", helptext.PluginsText)
+		if plugins := plugin.List(); len(plugins) > 0 {
+			fmt.Printf("Installed plugins:\n")
+			for _, name := range plugins {
+				fmt.Printf("    %s\n", name)
+			}
+			fmt.Printf("\n")
+		}
+	} else if subHelpCommand == "directives" {
+		fmt.Printf("\n%s\n\n", helptext.DirectivesText)
 	} else if subHelpCommand == "overview" {
 		fmt.Printf("\n%s\n\n", helptext.OverviewText)
+	} else if cmd := lookupCommand(subHelpCommand); cmd != nil && cmd.HelpText != "" {
+		fmt.Printf("\n%s\n\n", commandHelpText(cmd))
 	} else {
-		fmt.Printf("\n%s\n\n", helptext.MainHelpText)
+		fmt.Printf("\n%s\n\n", commandHelpText(lookupCommand("help")))
+	}
+}
+
+// runs an external 'scripthaus-[name]' plugin with the rest of the arguments
+func runPluginCommand(pluginExe string, gopts globalOptsType) (int, error) {
+	cmd := exec.Command(pluginExe, gopts.CommandArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = plugin.Env(gopts.PlaybookFile)
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, fmt.Errorf("cannot run plugin '%s': %w", pluginExe, err)
 	}
+	return 0, nil
 }
 
 func runInvalidCommand(gopts globalOptsType) {
+	if gopts.JsonErrors {
+		printJsonError(jsonError{Error: fmt.Sprintf("invalid command '%s'", gopts.CommandName), Command: gopts.CommandName, Suggestions: base.Suggest(gopts.CommandName, cliCommandNames())})
+		return
+	}
 	fmt.Printf("\n[^scripthaus] ERROR Invalid Command '%s'\n", gopts.CommandName)
+	if suggestions := base.Suggest(gopts.CommandName, cliCommandNames()); len(suggestions) > 0 {
+		fmt.Printf("[^scripthaus] %s\n", base.DidYouMeanStr(suggestions))
+	}
 	fmt.Printf("\n")
 	runHelpCommand(gopts, false)
 }
 
 type listOptsType struct {
 	PlaybookFile string
+	Tags         []string
+	Format       string
+	All          bool
+	Everywhere   bool // SCRIPTHAUS_PATH, the project chain, and the global playbook with shadowing
+	Verbose      bool // show each command's location
 }
 
 // returns exitcode, error
 func runExecItem(execItem *commanddef.ExecItem, warnings []string, gopts globalOptsType) (int, error) {
-	if execItem.HItem != nil {
-		err := history.InsertHistoryItem(execItem.HItem)
-		if err != nil {
-			// keep going, this is just a warning, should not stop the command from running
-			fmt.Fprintf(os.Stderr, "[^scripthaus] error trying to add run to history db: %v\n", err)
-		}
+	var runLog *runlog.RunLog
+	var span *otlp.Span
+	hooks := scripthaus.ExecHooks{
+		BeforeStart: func([]*commanddef.ExecItem) {
+			if execItem.HItem != nil && execItem.HItem.HistoryId != 0 && config.Get().RunLogs {
+				var err error
+				runLog, err = runlog.Start(execItem)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[^scripthaus] %v\n", err)
+				}
+			}
+			if gopts.Verbose > 0 && len(warnings) > 0 {
+				color := render.MakeColorizer(os.Stderr)
+				for _, warning := range warnings {
+					fmt.Fprintf(os.Stderr, "%s %s\n", color.Warning("WARNING:"), warning)
+				}
+				fmt.Fprintf(os.Stderr, "\n")
+			}
+			if otlpCfg := config.Get().Otlp; otlpCfg != nil && otlpCfg.Endpoint != "" {
+				span = otlp.StartSpan(execItem.CmdDef.OrigScriptName(), os.Getenv(otlp.TraceParentVarName))
+				if execItem.Cmd.Env == nil {
+					execItem.Cmd.Env = os.Environ()
+				}
+				// so anything the command traces joins the run's trace
+				execItem.Cmd.Env = append(execItem.Cmd.Env, otlp.TraceParentVarName+"="+span.TraceParent())
+			}
+			runUserHook(runhooks.PreRun, execItem, nil, gopts)
+		},
+		Started: func(execItems []*commanddef.ExecItem) func() {
+			return forwardInterrupts(execItems...)
+		},
 	}
-	if gopts.Verbose > 0 && len(warnings) > 0 {
-		for _, warning := range warnings {
-			fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning)
+	result, err := scripthaus.RunExecItem(execItem, scripthaus.DBHistory, hooks)
+	printHistoryErr(result.HistoryErr)
+	if !result.Started {
+		if runLog != nil {
+			runLog.Finish(1, err)
 		}
-		fmt.Fprintf(os.Stderr, "\n")
+		exportRunSpan(span, execItem, 1, err, gopts)
+		runUserHook(runhooks.PostRun, execItem, &runhooks.Outcome{ExitCode: 1, Error: err.Error()}, gopts)
+		return 1, err
 	}
-	startTs := time.Now()
-	err := execItem.Cmd.Start()
 	if err != nil {
-		return 1, fmt.Errorf("cannot start command '%s': %w", execItem.CmdShortName(), err)
+		fmt.Fprintf(os.Stderr, "[^scripthaus] %v\n", err)
 	}
-	err = execItem.Cmd.Wait()
-	cmdDuration := time.Since(startTs)
-	exitCode := 0
-	if err != nil {
-		exitCode = err.(*exec.ExitError).ExitCode()
+	exitCode, cmdDuration := result.ExitCode, result.Duration
+	if result.TermReason != "" {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] '%s' was terminated (%s)\n", execItem.CmdDef.OrigScriptName(), result.TermReason)
 	}
-	if execItem.HItem != nil {
-		execItem.HItem.ExitCode = sql.NullInt64{Valid: true, Int64: int64(exitCode)}
-		execItem.HItem.DurationMs = sql.NullInt64{Valid: true, Int64: cmdDuration.Milliseconds()}
+	if runLog != nil {
+		finishErr := runLog.Finish(exitCode, nil)
+		if finishErr != nil {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] error writing run log %s: %v\n", runLog.Dir, finishErr)
+		}
 	}
+	exportRunSpan(span, execItem, exitCode, nil, gopts)
+	sendRunNotification(execItem, exitCode, cmdDuration, gopts)
+	runUserHook(runhooks.PostRun, execItem, &runhooks.Outcome{ExitCode: exitCode, DurationMs: cmdDuration.Milliseconds()}, gopts)
 	if gopts.ShowSummary {
 		var warningsStr string
 		var noLogStr string
@@ -111,651 +204,4956 @@ func runExecItem(execItem *commanddef.ExecItem, warnings []string, gopts globalO
 			noLogStr = fmt.Sprintf(" (not logged)")
 		}
 		fmt.Printf("\n")
-		fmt.Printf("[^scripthaus] ran '%s', duration=%0.3fs, exitcode=%d%s%s\n", execItem.CmdShortName(), cmdDuration.Seconds(), exitCode, noLogStr, warningsStr)
-	}
-	if execItem.HItem != nil {
-		err = history.UpdateHistoryItem(execItem.HItem)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "[^scripthaus] error trying to update history item in db: %v\n", err)
-		}
+		color := render.MakeColorizer(os.Stdout)
+		fmt.Printf("[^scripthaus] ran '%s', duration=%0.3fs, exitcode=%s%s%s\n", color.Name(execItem.CmdShortName()), cmdDuration.Seconds(), color.ExitCode(exitCode), noLogStr, color.Warning(warningsStr))
 	}
 	return exitCode, nil
 }
 
-// returns (foundCommand, err)
-func resolvePlaybookCommand(playbookFile string, playbookScriptName string, gopts globalOptsType) (*commanddef.CommandDef, error) {
-	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookFile)
-	if err != nil {
-		return nil, err
-	}
-	found, mdSource, err := pathutil.TryReadFile(resolvedPlaybook.ResolvedFile, "playbook", false)
+// history db errors are warnings, they do not stop the command from running
+func printHistoryErr(err error) {
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(os.Stderr, "[^scripthaus] error trying to write run to history db: %v\n", err)
 	}
-	if !found {
-		return nil, fmt.Errorf("cannot find playbook '%s' (resolved to '%s')", playbookFile, resolvedPlaybook.ResolvedFile)
+}
+
+// the signals that stop scripthaus, all of them are received by guardSignals
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+var signalLock = &sync.Mutex{}
+var signalForwarders = make(map[int]func()) // id => terminate function (see forwardInterrupts)
+var nextForwarderId = 0
+
+// while the commands run, SIGINT/SIGTERM/SIGHUP terminates them (see ExecItem.Terminate) rather
+// than killing scripthaus, so the run is still logged.  returns a function that stops forwarding
+func forwardInterrupts(execItems ...*commanddef.ExecItem) func() {
+	var once sync.Once
+	signalLock.Lock()
+	defer signalLock.Unlock()
+	nextForwarderId++
+	forwarderId := nextForwarderId
+	signalForwarders[forwarderId] = func() {
+		once.Do(func() {
+			for _, execItem := range execItems {
+				execItem.Terminate(commanddef.TermReasonInterrupt)
+			}
+		})
 	}
-	cmdDefs, warnings, err := mdparser.ParseCommands(resolvedPlaybook, mdSource)
-	if err != nil {
-		return nil, err
+	return func() {
+		signalLock.Lock()
+		defer signalLock.Unlock()
+		delete(signalForwarders, forwarderId)
 	}
-	var foundCommand *commanddef.CommandDef
-	for _, cmdDef := range cmdDefs {
-		if cmdDef.Name == playbookScriptName {
-			foundCommand = &cmdDef
-			break
+}
+
+// receives the stop signals.  while commands run the signal is forwarded to them (see
+// forwardInterrupts).  otherwise scripthaus stops, but first finishes the open history rows as
+// interrupted (see scripthaus.RunRecord), then the signal is re-raised so scripthaus dies from it
+func guardSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, stopSignals...)
+	go func() {
+		for sig := range sigCh {
+			signalLock.Lock()
+			var forwarders []func()
+			for _, fn := range signalForwarders {
+				forwarders = append(forwarders, fn)
+			}
+			signalLock.Unlock()
+			if len(forwarders) > 0 {
+				for _, fn := range forwarders {
+					fn()
+				}
+				continue
+			}
+			sigNum := 1
+			if sysSig, ok := sig.(syscall.Signal); ok {
+				sigNum = int(sysSig)
+			}
+			scripthaus.FinishInterrupted(128 + sigNum)
+			signal.Reset(sig)
+			if proc, err := os.FindProcess(os.Getpid()); err == nil {
+				proc.Signal(sig)
+			}
+			// not every platform can signal itself
+			time.Sleep(100 * time.Millisecond)
+			os.Exit(128 + sigNum)
 		}
-	}
-	if foundCommand == nil {
-		fmt.Printf("[^scripthaus] ERROR could not find script '%s' inside of playbook '%s'\n", playbookScriptName, resolvedPlaybook.ResolvedFile)
-		fmt.Printf("\n")
-		printWarnings(gopts, warnings, true)
-		return nil, nil
-	}
-	return foundCommand, nil
+	}()
 }
 
-func runRunCommand(gopts globalOptsType) (int, error) {
-	runOpts, err := parseRunOpts(gopts)
-	if err != nil {
-		return 1, err
+// finishes the open history rows (runs that are still going) as interrupted before exiting
+func exit(exitCode int) {
+	scripthaus.FinishInterrupted(1)
+	os.Exit(exitCode)
+}
+
+// sends the run's span to the configured OTLP endpoint (does nothing if span is nil)
+func exportRunSpan(span *otlp.Span, execItem *commanddef.ExecItem, exitCode int, runErr error, gopts globalOptsType) {
+	if span == nil {
+		return
 	}
-	ctx := context.Background()
-	script := runOpts.Script
-	foundCommand, err := resolvePlaybookCommand(script.PlaybookFile, script.PlaybookCommand, gopts)
-	if foundCommand == nil || err != nil {
-		return 1, err
+	span.End = time.Now()
+	cdef := execItem.CmdDef
+	span.Attrs["scripthaus.playbook"] = cdef.Playbook.ResolvedFile
+	span.Attrs["scripthaus.command"] = cdef.Name
+	span.Attrs["scripthaus.lang"] = cdef.Lang
+	span.Attrs["scripthaus.exit_code"] = exitCode
+	span.Attrs["scripthaus.duration_ms"] = span.End.Sub(span.Start).Milliseconds()
+	if execItem.HItem != nil && execItem.HItem.HistoryId != 0 {
+		span.Attrs["scripthaus.history_id"] = execItem.HItem.HistoryId
 	}
-	err = foundCommand.CheckCommand(runOpts.RunSpec)
-	if err != nil {
-		return 1, err
+	if hostName, err := os.Hostname(); err == nil {
+		span.Attrs["host.name"] = hostName
 	}
-	execItem, err := foundCommand.BuildExecCommand(ctx, runOpts.RunSpec)
-	if err != nil {
-		return 1, err
+	if runErr != nil {
+		span.Attrs["error.message"] = runErr.Error()
 	}
-	return runExecItem(execItem, foundCommand.Warnings, gopts)
+	span.Error = runErr != nil || exitCode != 0
+	err := otlp.Export(config.Get().Otlp, base.ScriptHausVersion, span)
+	if err != nil && !gopts.Quiet {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] otlp: %v\n", err)
+	}
+}
 
+// runs the config.json pre_run/post_run hook (if set), failures are printed but do not change the run
+func runUserHook(hookName string, execItem *commanddef.ExecItem, outcome *runhooks.Outcome, gopts globalOptsType) {
+	shellCmd := config.Get().PreRun
+	if hookName == runhooks.PostRun {
+		shellCmd = config.Get().PostRun
+	}
+	err := runhooks.Run(hookName, shellCmd, execItem, outcome)
+	if err != nil && !gopts.Quiet {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] %v\n", err)
+	}
 }
 
-func resolveScript(cmdName string, scriptName string, curPlaybookFile string, allowBarePlaybook bool) (commanddef.ScriptDef, error) {
-	var emptyRtn commanddef.ScriptDef
-	if scriptName == "-" {
-		return emptyRtn, fmt.Errorf("invalid script '%s', must specify a [command] to run from <stdin>", scriptName)
+// desktop and webhook notifications for commands with 'notify' (or 'run --notify')
+func sendRunNotification(execItem *commanddef.ExecItem, exitCode int, cmdDuration time.Duration, gopts globalOptsType) {
+	if execItem.Notify == "" || (execItem.Notify == notify.ModeFailure && exitCode == 0) {
+		return
 	}
-	if curPlaybookFile != "" {
-		if !mdparser.IsValidScriptName(scriptName) {
-			return emptyRtn, fmt.Errorf("invalid characters in playbook command name '%s' (playbook specified with --playbook '%s')", scriptName, curPlaybookFile)
+	msg := notify.Message{Command: execItem.CmdDef.OrigScriptName(), ExitCode: exitCode, DurationMs: cmdDuration.Milliseconds()}
+	msg.HostName, _ = os.Hostname()
+	cfg := config.Get()
+	if cfg.NotifyDesktop == nil || *cfg.NotifyDesktop {
+		err := notify.Desktop(msg)
+		if err != nil && gopts.Verbose > 0 {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] cannot send desktop notification: %v\n", err)
 		}
-		return commanddef.ScriptDef{PlaybookFile: curPlaybookFile, PlaybookCommand: scriptName}, nil
-	}
-	if strings.HasSuffix(scriptName, "/") {
-		return emptyRtn, fmt.Errorf("invalid script '%s', cannot have a trailing slash", scriptName)
 	}
-	if strings.HasSuffix(scriptName, ".md") {
-		if allowBarePlaybook {
-			return commanddef.ScriptDef{PlaybookFile: scriptName}, nil
+	if cfg.NotifyWebhook != "" {
+		err := notify.Webhook(cfg.NotifyWebhook, msg)
+		if err != nil && !gopts.Quiet {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] %v\n", err)
 		}
-		return emptyRtn, fmt.Errorf("no playbook script specified, usage: %s %s::[command]", cmdName, scriptName)
 	}
-	playFile, playCommand, err := pathutil.SplitScriptName(scriptName)
+}
+
+// resolves, reads, and parses a playbook (including its includes)
+// returns (resolvedPlaybook, cmdDefs, warnings, err)
+func loadPlaybook(playbookFile string) (*pathutil.ResolvedPlaybook, []commanddef.CommandDef, []string, error) {
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookFile)
 	if err != nil {
-		return emptyRtn, err
-	}
-	if playFile == "" {
-		playFile = "."
+		return nil, nil, nil, err
 	}
-	if !allowBarePlaybook && playCommand == "" {
-		return emptyRtn, fmt.Errorf("playbook command name cannot be empty")
+	cmdDefs, warnings, err := loadResolvedPlaybook(resolvedPlaybook)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	if playCommand != "" && !mdparser.IsValidScriptName(playCommand) {
-		return emptyRtn, fmt.Errorf("invalid characters in playbook command name '%s'", playCommand)
+	return resolvedPlaybook, cmdDefs, warnings, nil
+}
+
+// returns (cmdDefs, warnings, err)
+func loadResolvedPlaybook(resolvedPlaybook *pathutil.ResolvedPlaybook) ([]commanddef.CommandDef, []string, error) {
+	src, err := mdparser.ReadPlaybookSource(resolvedPlaybook)
+	if err != nil {
+		return nil, nil, err
 	}
-	return commanddef.ScriptDef{PlaybookFile: playFile, PlaybookCommand: playCommand}, nil
+	return src.Commands()
 }
 
-func parseRunOpts(gopts globalOptsType) (commanddef.RunOptsType, error) {
-	var rtn commanddef.RunOptsType
-	var err error
-	rtn.Script.PlaybookFile = gopts.PlaybookFile
-	iter := &OptsIter{Opts: gopts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if argStr == "--env" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s \"[VAR=VAL]\" or %s file.env' missing value", argStr, argStr)
-			}
-			envVal := iter.Next()
-			if strings.Index(envVal, "=") != -1 {
-				envPairs := strings.Split(envVal, ";")
-				for _, envPair := range envPairs {
-					envPair = strings.TrimSpace(envPair)
-					if envPair == "" {
-						continue
-					}
-					if strings.Index(envPair, "=") == -1 {
-						// TODO warning?
-						continue
-					}
-					rtn.RunSpec.Env = append(rtn.RunSpec.Env, envPair)
-				}
-			} else {
-				envFile := iter.Next()
-				envMap, err := godotenv.Read(envFile)
-				if err != nil {
-					return rtn, fmt.Errorf("%s '%s', cannot read env file: %w", argStr, envFile, err)
-				}
-				for envVar, envVal := range envMap {
-					rtn.RunSpec.Env = append(rtn.RunSpec.Env, fmt.Sprintf("%s=%s", envVar, envVal))
-				}
+// max number of playbooks parsed at the same time by loadResolvedPlaybooks
+const maxPlaybookLoaders = 8
+
+type playbookLoadResult struct {
+	Playbook *pathutil.ResolvedPlaybook
+	CmdDefs  []commanddef.CommandDef
+	Warnings []string
+	Err      error
+}
+
+// loads (reads and parses) the playbooks using a bounded pool of workers.  results are
+// returned in the same order as playbooks (so output does not depend on scheduling)
+func loadResolvedPlaybooks(playbooks []*pathutil.ResolvedPlaybook) []playbookLoadResult {
+	rtn := make([]playbookLoadResult, len(playbooks))
+	numWorkers := runtime.NumCPU()
+	if numWorkers > maxPlaybookLoaders {
+		numWorkers = maxPlaybookLoaders
+	}
+	if numWorkers > len(playbooks) {
+		numWorkers = len(playbooks)
+	}
+	idxCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				cmdDefs, warnings, err := loadResolvedPlaybook(playbooks[idx])
+				rtn[idx] = playbookLoadResult{Playbook: playbooks[idx], CmdDefs: cmdDefs, Warnings: warnings, Err: err}
 			}
+		}()
+	}
+	for idx := range playbooks {
+		idxCh <- idx
+	}
+	close(idxCh)
+	wg.Wait()
+	return rtn
+}
+
+// searches the playbooks on SCRIPTHAUS_PATH (in order) for the command.  the first playbook
+// with the command wins, a notice is printed if later playbooks also have it (shadowed)
+func findPathCommand(playbookScriptName string, gopts globalOptsType) (*commanddef.CommandDef, error) {
+	playbooks, pathWarnings := pathutil.DefaultResolver().ResolvePathPlaybooks()
+	printWarnings(gopts, pathWarnings, false)
+	var searched []string
+	var allNames []string
+	results := loadResolvedPlaybooks(playbooks)
+	for ridx, result := range results {
+		searched = append(searched, result.Playbook.ResolvedFile)
+		cmdDefs, err := result.CmdDefs, result.Err
+		if err != nil {
+			printWarnings(gopts, []string{err.Error()}, false)
 			continue
 		}
-		if argStr == "--nolog" {
-			rtn.RunSpec.NoLog = true
-			rtn.RunSpec.ForceLog = false
-			continue
-		}
-		if argStr == "--log" {
-			rtn.RunSpec.NoLog = false
-			rtn.RunSpec.ForceLog = true
-			continue
-		}
-		if strings.HasPrefix(argStr, "-") && argStr != "-" && !strings.HasPrefix(argStr, "-/") {
-			return rtn, fmt.Errorf("invalid option '%s' passed to scripthaus run command", argStr)
-		}
-		rtn.Script, err = resolveScript("run", argStr, rtn.Script.PlaybookFile, false)
-		if err != nil {
-			return rtn, err
+		for idx := range cmdDefs {
+			if cmdDefs[idx].MatchesName(playbookScriptName) {
+				if err := checkStrict(gopts, result.Warnings, cmdDefs); err != nil {
+					return nil, fmt.Errorf("%s: %w", result.Playbook.OrigShowStr(), err)
+				}
+				printShadowNotice(gopts, &cmdDefs[idx], playbookScriptName, results[ridx+1:])
+				return &cmdDefs[idx], nil
+			}
 		}
-		rtn.RunSpec.ScriptArgs = iter.Rest()
-		break
+		allNames = append(allNames, commandNames(cmdDefs)...)
 	}
-	if rtn.Script.PlaybookFile == "" {
-		return rtn, fmt.Errorf("Usage: scripthaus run [run-opts] [playbook]::[command] [script-opts], no playbook specified")
+	if len(searched) == 0 {
+		return nil, fmt.Errorf("could not find command '%s', no playbooks found on %s", playbookScriptName, base.ScPathVarName)
 	}
-	if rtn.Script.PlaybookCommand == "" {
-		return rtn, fmt.Errorf("Usage: scripthaus run [run-opts] [playbook]::[command] [script-opts], no command specified")
+	var didYouMean string
+	if suggestions := base.Suggest(playbookScriptName, allNames); len(suggestions) > 0 {
+		didYouMean = ", " + base.DidYouMeanStr(suggestions)
 	}
-	return rtn, nil
+	return nil, fmt.Errorf("could not find command '%s' in any playbook on %s (%s)%s", playbookScriptName, base.ScPathVarName, strings.Join(searched, ", "), didYouMean)
 }
 
-func printWarnings(gopts globalOptsType, warnings []string, spaceAfter bool) {
-	if gopts.Quiet || len(warnings) == 0 {
+// prints a notice for each later SCRIPTHAUS_PATH playbook that also has the command
+func printShadowNotice(gopts globalOptsType, cdef *commanddef.CommandDef, playbookScriptName string, laterResults []playbookLoadResult) {
+	if gopts.Quiet {
 		return
 	}
-	for _, warning := range warnings {
-		fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning)
-	}
-	if spaceAfter {
-		fmt.Fprintf(os.Stderr, "\n")
+	for _, result := range laterResults {
+		for idx := range result.CmdDefs {
+			if !result.CmdDefs[idx].MatchesName(playbookScriptName) {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "[^scripthaus] '%s' resolved to '%s', it shadows '%s' (use --from %s to run that one)\n", playbookScriptName, cdef.OrigScriptName(), result.CmdDefs[idx].OrigScriptName(), result.Playbook.OrigName)
+			break
+		}
 	}
 }
 
-func parseListOpts(gopts globalOptsType) (listOptsType, error) {
-	var rtn listOptsType
-	rtn.PlaybookFile = gopts.PlaybookFile
-	iter := &OptsIter{Opts: gopts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if isOption(argStr) {
-			return rtn, fmt.Errorf("Invalid option '%s' passed to scripthaus list command", argStr)
-		}
-		rtn.PlaybookFile = argStr
-		if iter.HasNext() {
-			return rtn, fmt.Errorf("Usage: scripthaus list [playbook], too many arguments passed, extras = '%s'", strings.Join(iter.Rest(), " "))
-		}
-		break
+// the names and aliases of the commands (for suggestions)
+func commandNames(cmdDefs []commanddef.CommandDef) []string {
+	var rtn []string
+	for _, cdef := range cmdDefs {
+		rtn = append(rtn, cdef.Name)
+		rtn = append(rtn, cdef.Aliases...)
 	}
-	if rtn.PlaybookFile == "" {
-		return rtn, fmt.Errorf("Usage: scripthaus list [playbook], no playbook specified")
+	return rtn
+}
+
+// returns (foundCommand, err)
+// an empty playbookFile searches SCRIPTHAUS_PATH
+func resolvePlaybookCommand(playbookFile string, playbookScriptName string, gopts globalOptsType) (*commanddef.CommandDef, error) {
+	if playbookFile == "" {
+		return findPathCommand(playbookScriptName, gopts)
 	}
-	return rtn, nil
+	cmdDef, _, err := resolvePlaybookCommandSource(playbookFile, playbookScriptName, gopts)
+	return cmdDef, err
 }
 
-func runListCommandInternal(gopts globalOptsType, playbookFile string) (int, error) {
-	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookFile)
-	if err != nil {
-		return 1, err
+// like resolvePlaybookCommand, but also resolves code block selectors ("README.md::#3" or
+// "README.md::Install dependencies") for run and show
+func resolveScriptCommand(script commanddef.ScriptDef, gopts globalOptsType) (*commanddef.CommandDef, error) {
+	if script.PlaybookFile == "" || !mdparser.IsBlockSelector(script.PlaybookCommand) {
+		return resolvePlaybookCommand(script.PlaybookFile, script.PlaybookCommand, gopts)
 	}
-	found, mdSource, err := pathutil.TryReadFile(resolvedPlaybook.ResolvedFile, "playbook", false)
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(script.PlaybookFile)
 	if err != nil {
-		return 1, err
-	}
-	if !found {
-		return 1, fmt.Errorf("cannot find playbook '%s' (resolved to '%s')", playbookFile, resolvedPlaybook.ResolvedFile)
+		return nil, err
 	}
-	commands, warnings, err := mdparser.ParseCommands(resolvedPlaybook, mdSource)
+	src, err := mdparser.ReadPlaybookSource(resolvedPlaybook)
 	if err != nil {
-		return 1, err
+		return nil, err
 	}
-	printWarnings(gopts, warnings, true)
-	fmt.Printf("%s\n", resolvedPlaybook.OrigShowStr())
-	maxScriptNameLen := 0
-	for _, command := range commands {
-		origScriptName := command.OrigScriptName()
-		if len(origScriptName) > maxScriptNameLen {
-			maxScriptNameLen = len(origScriptName)
-		}
+	return src.FindBlock(script.PlaybookCommand)
+}
+
+// trust status of a playbook read from stdin.  there is no file to trust, so its commands are
+// always confirmed (on /dev/tty, stdin is the playbook) unless --yes
+const statusStdin = "stdin"
+
+// returns the trust status of the command's playbook (see pkg/trust) and the loaded trust store
+// (nil for statusStdin)
+func checkPlaybookTrust(cdef *commanddef.CommandDef) (string, *trust.Store, error) {
+	if cdef.Playbook == nil {
+		return trust.StatusTrusted, nil, nil
 	}
-	if maxScriptNameLen > 40 {
-		maxScriptNameLen = 40
+	if cdef.Playbook.CanonicalName == "-" {
+		return statusStdin, nil, nil
 	}
-	for _, command := range commands {
-		if command.ShortText != "" {
-			shortText := command.ShortText
-			if len(shortText) > 80 {
-				shortText = shortText[0:77] + "..."
-			}
-			fmt.Printf("  %-*s - %s\n", maxScriptNameLen, command.OrigScriptName(), shortText)
-		} else {
-			fmt.Printf("  %-*s\n", maxScriptNameLen, command.OrigScriptName())
-		}
+	store, err := trust.Load()
+	if err != nil {
+		return "", nil, err
 	}
-	return 0, nil
+	status, err := store.Check(cdef.Playbook.ResolvedFile, config.Get().TrustedRoots)
+	return status, store, err
 }
 
-func runListCommand(gopts globalOptsType) (int, error) {
-	listOpts, err := parseListOpts(gopts)
-	if err != nil {
-		return 1, err
+func untrustedError(cdef *commanddef.CommandDef, status string) error {
+	if status == statusStdin {
+		return fmt.Errorf("playbook read from <stdin> is not trusted, commands from it must be confirmed")
 	}
-	return runListCommandInternal(gopts, listOpts.PlaybookFile)
+	what := "is not trusted"
+	if status == trust.StatusChanged {
+		what = "changed since it was trusted"
+	}
+	return fmt.Errorf("playbook '%s' %s, review it and run 'scripthaus trust %s'", cdef.Playbook.ResolvedFile, what, cdef.Playbook.ResolvedFile)
 }
 
-type showOptsType struct {
-	Script commanddef.ScriptDef
+// for commands that run without a terminal (daemon, mcp), the command's playbook must be trusted
+func requireTrusted(cdef *commanddef.CommandDef) error {
+	status, _, err := checkPlaybookTrust(cdef)
+	if err != nil {
+		return err
+	}
+	if status != trust.StatusTrusted {
+		return untrustedError(cdef, status)
+	}
+	return nil
 }
 
-func parseShowOpts(gopts globalOptsType) (showOptsType, error) {
-	var rtn showOptsType
-	var err error
-	rtn.Script.PlaybookFile = gopts.PlaybookFile
-	iter := &OptsIter{Opts: gopts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if isOption(argStr) {
-			return rtn, fmt.Errorf("invalid option '%s' passed to scripthaus show command", argStr)
-		}
-		rtn.Script, err = resolveScript("show", argStr, rtn.Script.PlaybookFile, true)
+// commands from a playbook that was never trusted (or changed since) or was read from stdin, and
+// blocks selected by index or heading (not written as scripthaus commands), are shown and confirmed
+// before they run (unless --yes).  confirming an untrusted playbook's command trusts the playbook
+func confirmRun(cdef *commanddef.CommandDef, runOpts commanddef.RunOptsType) error {
+	if runOpts.Yes {
+		return nil
+	}
+	status, store, err := checkPlaybookTrust(cdef)
+	if err != nil {
+		return err
+	}
+	if status == trust.StatusTrusted && !cdef.IsBlock {
+		return nil
+	}
+	var promptIn io.Reader = os.Stdin
+	var promptOut io.Writer = os.Stdout
+	if status == statusStdin {
+		// stdin is the playbook, the answer has to come from the terminal
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
 		if err != nil {
-			return rtn, err
+			return fmt.Errorf("%w (or pass --yes to run it without a terminal)", untrustedError(cdef, status))
 		}
-		if iter.HasNext() {
-			return rtn, fmt.Errorf("Usage: scripthaus show [playbook]::[script], too many arguments passed, extras = '%s'", strings.Join(iter.Rest(), " "))
+		defer tty.Close()
+		promptIn, promptOut = tty, tty
+	} else if !tui.IsInteractive() {
+		if status != trust.StatusTrusted {
+			return fmt.Errorf("%w (or pass --yes to run it without a terminal)", untrustedError(cdef, status))
 		}
-		break
+		return fmt.Errorf("block '%s' must be confirmed before it runs, pass --yes to run it without a terminal", cdef.OrigScriptName())
 	}
-	return rtn, nil
+	kind := "command"
+	if cdef.IsBlock {
+		kind = "block"
+	}
+	switch status {
+	case trust.StatusNew:
+		fmt.Fprintf(promptOut, "[^scripthaus] playbook %s has not been trusted yet\n", cdef.Playbook.ResolvedFile)
+	case trust.StatusChanged:
+		fmt.Fprintf(promptOut, "[^scripthaus] playbook %s changed since you trusted it\n", cdef.Playbook.ResolvedFile)
+	case statusStdin:
+		fmt.Fprintf(promptOut, "[^scripthaus] playbook read from <stdin>\n")
+	}
+	fmt.Fprintf(promptOut, "[^scripthaus] %s '%s' (%s):\n\n%s\n\n", kind, cdef.OrigScriptName(), cdef.Location(), cdef.RawCodeText)
+	if status == trust.StatusNew || status == trust.StatusChanged {
+		fmt.Fprintf(promptOut, "[^scripthaus] trust this playbook and run the %s? [y/N] ", kind)
+	} else {
+		fmt.Fprintf(promptOut, "[^scripthaus] run this %s? [y/N] ", kind)
+	}
+	answer, _ := bufio.NewReader(promptIn).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return fmt.Errorf("not running %s '%s'", kind, cdef.OrigScriptName())
+	}
+	if status == trust.StatusNew || status == trust.StatusChanged {
+		err = store.Trust(cdef.Playbook.ResolvedFile)
+		if err == nil {
+			err = store.Save()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-type historyOptsType struct {
-	ShowNum int
-	ShowAll bool
-
-	FormatFull bool
-	FormatJson bool
-}
+var direnvWarned = make(map[string]bool)
 
-func parseHistoryOpts(opts globalOptsType) (historyOptsType, error) {
-	var rtn historyOptsType
-	iter := &OptsIter{Opts: opts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if argStr == "--all" {
-			rtn.ShowAll = true
-			continue
-		}
-		if argStr == "--full" {
-			rtn.FormatFull = true
-			continue
-		}
-		if argStr == "--json" {
-			rtn.FormatJson = true
-			continue
-		}
-		if argStr == "-n" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s [num]' missing num", argStr)
-			}
-			numStr := iter.Next()
-			num, err := strconv.Atoi(numStr)
-			if err != nil {
-				return rtn, fmt.Errorf("'%s %s' invalid number: %w", argStr, numStr, err)
-			}
-			rtn.ShowNum = num
-			continue
-		}
-		if isOption(argStr) {
-			return rtn, fmt.Errorf("invalid option '%s' passed to scripthaus history command", argStr)
-		}
-		iter.Pos = iter.Pos - 1
-		return rtn, fmt.Errorf("too many arguments passed to scripthaus history command, extras = '%s'", strings.Join(iter.Rest(), " "))
+// warns (once per project) when the command's project has a .envrc that is not loaded, the command
+// would not see the environment a shell in the project has (unless "direnv" is "load" or "off")
+func warnDirenv(cdef *commanddef.CommandDef, gopts globalOptsType) {
+	if config.Get().DirenvMode() != config.DirenvWarn || cdef.Playbook == nil {
+		return
 	}
-	return rtn, nil
+	projectDir := cdef.Playbook.ProjectDir
+	if direnvWarned[projectDir] || !direnv.HasEnvrc(projectDir) || direnv.IsLoaded(projectDir) {
+		return
+	}
+	direnvWarned[projectDir] = true
+	printWarnings(gopts, []string{fmt.Sprintf("%s is not loaded, the command runs without its environment (set \"direnv\": \"load\" in %s to load it, or \"off\" to not warn)", filepath.Join(projectDir, direnv.EnvrcFileName), config.ConfigFileName)}, false)
 }
 
-func runHistoryCommand(opts globalOptsType) (int, error) {
-	historyOpts, err := parseHistoryOpts(opts)
+// like resolvePlaybookCommand (but playbookFile must be set), also returns the playbook source
+// (for commands that edit the playbook).  the command is nil if it was not found
+func resolvePlaybookCommandSource(playbookFile string, playbookScriptName string, gopts globalOptsType) (*commanddef.CommandDef, *mdparser.PlaybookSource, error) {
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookFile)
 	if err != nil {
-		return 1, err
+		return nil, nil, err
 	}
-	query := history.HistoryQuery{
-		ShowAll: historyOpts.ShowAll,
-		ShowNum: historyOpts.ShowNum,
+	src, err := mdparser.ReadPlaybookSource(resolvedPlaybook)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmdDefs, warnings, err := src.Commands()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkStrict(gopts, warnings, cmdDefs); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", resolvedPlaybook.OrigShowStr(), err)
+	}
+	if playbookScriptName == "" {
+		if resolvedPlaybook.Config == nil || resolvedPlaybook.Config.DefaultCommand == "" {
+			return nil, nil, fmt.Errorf("no command specified and playbook %s has no default_command", resolvedPlaybook.OrigShowStr())
+		}
+		playbookScriptName = resolvedPlaybook.Config.DefaultCommand
+	}
+	var foundCommand *commanddef.CommandDef
+	for _, cmdDef := range cmdDefs {
+		if cmdDef.MatchesName(playbookScriptName) {
+			foundCommand = &cmdDef
+			break
+		}
+	}
+	if foundCommand == nil {
+		fmt.Printf("[^scripthaus] ERROR could not find script '%s' inside of playbook '%s'\n", playbookScriptName, resolvedPlaybook.ResolvedFile)
+		if suggestions := base.Suggest(playbookScriptName, commandNames(cmdDefs)); len(suggestions) > 0 {
+			fmt.Printf("[^scripthaus] %s\n", base.DidYouMeanStr(suggestions))
+		}
+		fmt.Printf("\n")
+		printWarnings(gopts, warnings, true)
+		return nil, src, nil
+	}
+	return foundCommand, src, nil
+}
+
+func runRunCommand(gopts globalOptsType) (int, error) {
+	runOpts, err := parseRunOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	ctx := context.Background()
+	var rpt *runReport
+	if len(runOpts.Reports) > 0 && runOpts.Tmux == "" {
+		rpt = makeRunReport(runOpts.Reports)
+		defer rpt.write(gopts)
+	}
+	if len(runOpts.Tags) > 0 {
+		if runOpts.Tmux != "" && launchInTmux(runOpts.Tmux, "tag:"+strings.Join(runOpts.Tags, ","), gopts) {
+			return 0, nil
+		}
+		return runTaggedCommands(ctx, runOpts, rpt, gopts)
+	}
+	if len(runOpts.Pipeline) > 0 {
+		if runOpts.Tmux != "" && launchInTmux(runOpts.Tmux, "pipeline", gopts) {
+			return 0, nil
+		}
+		return runPipeline(ctx, runOpts, gopts)
+	}
+	script := runOpts.Script
+	foundCommand, err := resolveScriptCommand(script, gopts)
+	if foundCommand == nil || err != nil {
+		return 1, err
+	}
+	axes := commanddef.MergeMatrixAxes(foundCommand.GetMatrix(), runOpts.Matrix)
+	if runOpts.Each && len(axes) > 0 {
+		return 1, fmt.Errorf("--each cannot be used with a matrix command")
+	}
+	if runOpts.Each || len(axes) > 0 {
+		// the script arguments and environment are checked for each run
+		err = foundCommand.CheckPlatform()
+	} else {
+		err = foundCommand.CheckCommand(runOpts.RunSpec)
+	}
+	if err != nil {
+		rpt.addError(foundCommand, runOpts.RunSpec.ScriptArgs, "", err)
+		return 1, err
+	}
+	if runOpts.Tmux != "" && launchInTmux(runOpts.Tmux, foundCommand.Name, gopts) {
+		return 0, nil
+	}
+	// after the tmux launch, so the command is confirmed in the new pane
+	err = confirmRun(foundCommand, runOpts)
+	if err != nil {
+		return 1, err
+	}
+	warnDirenv(foundCommand, gopts)
+	if runOpts.Each {
+		return runEachCommand(ctx, foundCommand, runOpts, rpt, gopts)
+	}
+	if len(axes) > 0 {
+		return runMatrixCommand(ctx, foundCommand, runOpts.RunSpec, axes, rpt, gopts)
+	}
+	execItem, err := scripthaus.BuildExecItem(ctx, foundCommand, runOpts.RunSpec)
+	if err != nil {
+		rpt.addError(foundCommand, runOpts.RunSpec.ScriptArgs, "", err)
+		return 1, err
+	}
+	return rpt.runExecItem(execItem, runOpts.RunSpec.ScriptArgs, foundCommand.Warnings, gopts)
+
+}
+
+type matrixCellResult struct {
+	Name     string
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// runs the command once per combination of the matrix axes (one after the other) with the
+// cell's values added to the environment.  keeps going after failures, prints a pass/fail table
+// at the end and returns 1 if any cell failed
+func runMatrixCommand(ctx context.Context, cdef *commanddef.CommandDef, runSpec commanddef.SpecType, axes []commanddef.MatrixAxis, rpt *runReport, gopts globalOptsType) (int, error) {
+	cells, err := commanddef.MatrixCells(axes)
+	if err != nil {
+		return 1, err
+	}
+	var results []matrixCellResult
+	numFailed := 0
+	for _, cell := range cells {
+		cellResult := matrixCellResult{Name: commanddef.MatrixCellName(cell)}
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] running '%s' [%s]\n", cdef.OrigScriptName(), cellResult.Name)
+		}
+		startTs := time.Now()
+		execItem, err := scripthaus.BuildMatrixCell(ctx, cdef, runSpec, cell)
+		if err == nil {
+			cellResult.ExitCode, err = rpt.runExecItem(execItem, runSpec.ScriptArgs, cdef.Warnings, gopts)
+		} else {
+			rpt.addError(cdef, runSpec.ScriptArgs, cellResult.Name, err)
+		}
+		cellResult.Duration = time.Since(startTs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			cellResult.Err = err
+		}
+		if cellResult.Err != nil || cellResult.ExitCode != 0 {
+			numFailed++
+		}
+		results = append(results, cellResult)
+	}
+	if !gopts.Quiet {
+		printMatrixResults(cdef, results, numFailed)
+	}
+	if numFailed > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func printMatrixResults(cdef *commanddef.CommandDef, results []matrixCellResult, numFailed int) {
+	color := render.MakeColorizer(os.Stdout)
+	nameWidth := 0
+	for _, result := range results {
+		if len(result.Name) > nameWidth {
+			nameWidth = len(result.Name)
+		}
+	}
+	fmt.Printf("\n[^scripthaus] matrix '%s', %d run(s), %d failed\n", cdef.OrigScriptName(), len(results), numFailed)
+	for _, result := range results {
+		var status string
+		if result.Err != nil {
+			status = color.Error("error") + "  " + result.Err.Error()
+		} else if result.ExitCode != 0 {
+			status = color.Error("failed") + "  exitcode=" + color.ExitCode(result.ExitCode)
+		} else {
+			status = color.Success("ok")
+		}
+		fmt.Printf("  %-*s  %s  %s\n", nameWidth, result.Name, color.Dim(fmt.Sprintf("%7.3fs", result.Duration.Seconds())), status)
+	}
+}
+
+// the non-blank lines of r (trimmed)
+func readEachLines(r io.Reader) ([]string, error) {
+	var rtn []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			rtn = append(rtn, line)
+		}
+	}
+	return rtn, scanner.Err()
+}
+
+// runs the command once per line of stdin with the line as the first script argument (before
+// the script arguments given on the command line).  with --parallel N up to N runs happen at
+// the same time and each run's output is printed (all at once) when it finishes.  keeps going
+// after failures, returns 1 if any run failed
+func runEachCommand(ctx context.Context, cdef *commanddef.CommandDef, runOpts commanddef.RunOptsType, rpt *runReport, gopts globalOptsType) (int, error) {
+	if render.IsTerminal(os.Stdin) {
+		return 1, fmt.Errorf("--each reads lines from stdin, e.g. \"cat hosts.txt | scripthaus run --each %s\"", cdef.OrigScriptName())
+	}
+	lines, err := readEachLines(os.Stdin)
+	if err != nil {
+		return 1, fmt.Errorf("--each cannot read stdin: %w", err)
+	}
+	if len(lines) == 0 {
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] --each, no input lines\n")
+		}
+		return 0, nil
+	}
+	parallel := runOpts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	if parallel > len(lines) {
+		parallel = len(lines)
+	}
+	failed := make([]string, len(lines))
+	outputLock := &sync.Mutex{}
+	workCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range workCh {
+				failed[idx] = runEachLine(ctx, cdef, runOpts.RunSpec, lines[idx], parallel > 1, outputLock, rpt, gopts)
+			}
+		}()
+	}
+	for idx := range lines {
+		workCh <- idx
+	}
+	close(workCh)
+	wg.Wait()
+	numFailed := 0
+	for _, failedStr := range failed {
+		if failedStr != "" {
+			numFailed++
+		}
+	}
+	if !gopts.Quiet {
+		fmt.Printf("\n[^scripthaus] ran '%s' for %d line(s), %d failed\n", cdef.OrigScriptName(), len(lines), numFailed)
+		for idx, failedStr := range failed {
+			if failedStr != "" {
+				fmt.Printf("  failed: %s (%s)\n", lines[idx], failedStr)
+			}
+		}
+	}
+	if numFailed > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// returns "" if the run succeeded, otherwise why it failed ("exitcode=N" or the error).
+// buffered captures the run's output and prints it (holding outputLock) when the run finishes
+func runEachLine(ctx context.Context, cdef *commanddef.CommandDef, runSpec commanddef.SpecType, line string, buffered bool, outputLock *sync.Mutex, rpt *runReport, gopts globalOptsType) string {
+	runSpec.ScriptArgs = append([]string{line}, runSpec.ScriptArgs...)
+	// stdin belongs to --each
+	runSpec.Stdin = bytes.NewReader(nil)
+	var outBuf bytes.Buffer
+	if buffered {
+		runSpec.Stdout = &outBuf
+		runSpec.Stderr = &outBuf
+	} else if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] running '%s' %s\n", cdef.OrigScriptName(), line)
+	}
+	exitCode := 0
+	execItem, err := scripthaus.BuildExecItem(ctx, cdef, runSpec)
+	if err == nil {
+		exitCode, err = rpt.runExecItem(execItem, runSpec.ScriptArgs, cdef.Warnings, gopts)
+	} else {
+		rpt.addError(cdef, runSpec.ScriptArgs, "", err)
+	}
+	var failedStr string
+	if err != nil {
+		failedStr = err.Error()
+	} else if exitCode != 0 {
+		failedStr = fmt.Sprintf("exitcode=%d", exitCode)
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if buffered {
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] '%s' %s\n", cdef.OrigScriptName(), line)
+		}
+		os.Stdout.Write(outBuf.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+	}
+	return failedStr
+}
+
+// runs the pipeline's commands at the same time, the stdout of each command is piped into the
+// stdin of the next (see scripthaus.RunPipeline)
+func runPipeline(ctx context.Context, runOpts commanddef.RunOptsType, gopts globalOptsType) (int, error) {
+	var stages []scripthaus.PipeStage
+	var warnings []string
+	for _, stage := range runOpts.Pipeline {
+		cdef, err := resolveScriptCommand(stage.Script, gopts)
+		if cdef == nil || err != nil {
+			return 1, err
+		}
+		err = confirmRun(cdef, runOpts)
+		if err != nil {
+			return 1, err
+		}
+		warnDirenv(cdef, gopts)
+		runSpec := runOpts.RunSpec
+		runSpec.ScriptArgs = stage.ScriptArgs
+		err = cdef.CheckCommand(runSpec)
+		if err != nil {
+			return 1, err
+		}
+		stages = append(stages, scripthaus.PipeStage{Def: cdef, Spec: runSpec})
+		warnings = append(warnings, cdef.Warnings...)
+	}
+	hooks := scripthaus.ExecHooks{
+		BeforeStart: func([]*commanddef.ExecItem) {
+			if gopts.Verbose > 0 {
+				printWarnings(gopts, warnings, true)
+			}
+		},
+		Started: func(execItems []*commanddef.ExecItem) func() {
+			return forwardInterrupts(execItems...)
+		},
+	}
+	result, err := scripthaus.RunPipeline(ctx, stages, scripthaus.DBHistory, hooks)
+	if result == nil {
+		return 1, err
+	}
+	printHistoryErr(result.HistoryErr)
+	if result.TermReason != "" {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] pipeline was terminated (%s)\n", result.TermReason)
+	}
+	if gopts.ShowSummary {
+		color := render.MakeColorizer(os.Stdout)
+		fmt.Printf("\n[^scripthaus] ran pipeline '%s', duration=%0.3fs, exitcode=%s\n", color.Name(result.Pipeline), result.Duration.Seconds(), color.ExitCode(result.ExitCode))
+	}
+	if !result.Started {
+		return result.ExitCode, err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] %v\n", err)
+	}
+	return result.ExitCode, nil
+}
+
+// collects results for 'run --report' (a nil *runReport runs commands without reporting)
+type runReport struct {
+	Report *report.Report
+	Specs  []report.Spec
+
+	lock        *sync.Mutex // results can be added from concurrent runs ('run --each --parallel')
+	outputFiles map[string]bool
+}
+
+// reportSpecs were validated by parseRunOpts
+func makeRunReport(reportSpecs []string) *runReport {
+	rtn := &runReport{Report: report.MakeReport(base.ScriptHausVersion), lock: &sync.Mutex{}, outputFiles: make(map[string]bool)}
+	for _, specStr := range reportSpecs {
+		spec, _ := report.ParseSpec(specStr)
+		rtn.Specs = append(rtn.Specs, spec)
+	}
+	return rtn
+}
+
+func (rpt *runReport) makeResult(cdef *commanddef.CommandDef, args []string, warnings []string) report.Result {
+	// relative to the current directory when possible (used as the junit classname)
+	playbookFile := cdef.Playbook.ResolvedFile
+	if cwd, err := os.Getwd(); err == nil {
+		if relFile, err := filepath.Rel(cwd, playbookFile); err == nil && !strings.HasPrefix(relFile, "..") {
+			playbookFile = relFile
+		}
+	}
+	return report.Result{
+		Command:  cdef.OrigScriptName(),
+		Name:     cdef.Name,
+		Playbook: playbookFile,
+		Args:     args,
+		Warnings: warnings,
+	}
+}
+
+// records a command that could not be run (matrixCell is "" if this is not a matrix run)
+func (rpt *runReport) addError(cdef *commanddef.CommandDef, args []string, matrixCell string, err error) {
+	if rpt == nil {
+		return
+	}
+	result := rpt.makeResult(cdef, args, cdef.Warnings)
+	result.Matrix = matrixCell
+	result.Start = time.Now()
+	result.End = result.Start
+	result.ExitCode = 1
+	result.Error = err.Error()
+	rpt.add(result)
+}
+
+func (rpt *runReport) add(result report.Result) {
+	rpt.lock.Lock()
+	defer rpt.lock.Unlock()
+	rpt.Report.Add(result)
+}
+
+// the output capture file for a run, unique within the report (runs of the same command get
+// ".2", ".3", ... suffixes)
+func (rpt *runReport) outputFileName(name string) string {
+	rpt.lock.Lock()
+	defer rpt.lock.Unlock()
+	outputFile := report.OutputFileName(rpt.Specs[0].File, name)
+	for idx := 2; rpt.outputFiles[outputFile]; idx++ {
+		outputFile = report.OutputFileName(rpt.Specs[0].File, fmt.Sprintf("%s.%d", name, idx))
+	}
+	rpt.outputFiles[outputFile] = true
+	return outputFile
+}
+
+// runExecItem, also capturing the output to a file next to the (first) report file
+func (rpt *runReport) runExecItem(execItem *commanddef.ExecItem, args []string, warnings []string, gopts globalOptsType) (int, error) {
+	if rpt == nil {
+		return runExecItem(execItem, warnings, gopts)
+	}
+	result := rpt.makeResult(execItem.CmdDef, args, warnings)
+	result.Matrix = execItem.MatrixCell
+	var outBuf bytes.Buffer
+	execItem.Cmd.Stdout = io.MultiWriter(execItem.Cmd.Stdout, &outBuf)
+	execItem.Cmd.Stderr = io.MultiWriter(execItem.Cmd.Stderr, &outBuf)
+	result.Start = time.Now()
+	exitCode, err := runExecItem(execItem, warnings, gopts)
+	result.End = time.Now()
+	result.DurationMs = result.End.Sub(result.Start).Milliseconds()
+	result.ExitCode = exitCode
+	if err != nil {
+		result.Error = err.Error()
+	}
+	outputName := execItem.CmdDef.Name
+	if execItem.MatrixCell != "" {
+		outputName += "." + execItem.MatrixCell
+	}
+	outputFile := rpt.outputFileName(outputName)
+	outStr := secrets.Redact([]string{outBuf.String()}, execItem.SecretVals)[0]
+	writeErr := os.WriteFile(outputFile, []byte(outStr), 0644)
+	if writeErr != nil {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] cannot write command output for report: %v\n", writeErr)
+	} else {
+		result.OutputFile = outputFile
+	}
+	rpt.add(result)
+	return exitCode, err
+}
+
+// errors are printed (the run's exit code is not changed)
+func (rpt *runReport) write(gopts globalOptsType) {
+	for _, spec := range rpt.Specs {
+		err := rpt.Report.Write(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			continue
+		}
+		if gopts.Verbose > 0 {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] wrote %s report to %s\n", spec.Format, spec.File)
+		}
+	}
+}
+
+// re-runs this 'scripthaus run' invocation (minus the --tmux-* option) in a new tmux pane or
+// window.  returns false (after printing why) if tmux is not available, the caller should then
+// run the command in the foreground
+func launchInTmux(mode string, name string, gopts globalOptsType) bool {
+	err := tmux.Available()
+	if err == nil {
+		var argv []string
+		argv, err = makeTmuxRunArgv(gopts)
+		if err == nil {
+			var cwd string
+			cwd, err = os.Getwd()
+			if err == nil {
+				err = tmux.Launch(mode, name, cwd, argv)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] --tmux-%s: %v, running in the foreground\n", mode, err)
+		return false
+	}
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] started '%s' in a new tmux %s\n", name, mode)
+	}
+	return true
+}
+
+func makeTmuxRunArgv(gopts globalOptsType) ([]string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find scripthaus executable: %w", err)
+	}
+	argv := []string{exePath}
+	for i := 0; i < gopts.Verbose; i++ {
+		argv = append(argv, "-v")
+	}
+	if gopts.Quiet {
+		argv = append(argv, "-q")
+	}
+	if gopts.ShowSummary {
+		argv = append(argv, "-s")
+	}
+	if gopts.ColorMode != "" {
+		argv = append(argv, "--color", gopts.ColorMode)
+	}
+	if gopts.PlaybookFile != "" {
+		argv = append(argv, "-p", gopts.PlaybookFile)
+	}
+	argv = append(argv, "run")
+	// run-opts (same parsing as parseRunOpts), the --tmux-* options are dropped
+	parsed, err := parseCommandArgs("run", gopts.CommandArgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range parsed.Opts {
+		if opt.Name == "--tmux-pane" || opt.Name == "--tmux-window" {
+			continue
+		}
+		def := findOptDef(lookupCommand("run").Opts, opt.Flag)
+		if def.takesValue() {
+			argv = append(argv, opt.Flag, opt.Value)
+		} else if def.EqArg && opt.Value != "" {
+			argv = append(argv, opt.Flag+"="+opt.Value)
+		} else {
+			argv = append(argv, opt.Flag)
+		}
+	}
+	argv = append(argv, parsed.Args...)
+	return argv, nil
+}
+
+// runs every command in the playbook that has one of runOpts.Tags (in playbook order).
+// keeps going after failures, returns 1 if any command failed.
+func runTaggedCommands(ctx context.Context, runOpts commanddef.RunOptsType, rpt *runReport, gopts globalOptsType) (int, error) {
+	resolvedPlaybook, cmdDefs, warnings, err := loadPlaybook(runOpts.Script.PlaybookFile)
+	if err != nil {
+		return 1, err
+	}
+	if err := checkStrict(gopts, warnings, cmdDefs); err != nil {
+		return 1, fmt.Errorf("%s: %w", resolvedPlaybook.OrigShowStr(), err)
+	}
+	if gopts.Verbose > 0 {
+		printWarnings(gopts, warnings, true)
+	}
+	for idx := range cmdDefs {
+		if cmdDefs[idx].HasAnyTag(runOpts.Tags) {
+			if err := confirmRun(&cmdDefs[idx], runOpts); err != nil {
+				return 1, err
+			}
+			warnDirenv(&cmdDefs[idx], gopts)
+		}
+	}
+	tagsStr := strings.Join(runOpts.Tags, ", ")
+	var failed []string
+	numRun := 0
+	numSkipped := 0
+	for idx := range cmdDefs {
+		cmdDef := &cmdDefs[idx]
+		if !cmdDef.HasAnyTag(runOpts.Tags) {
+			continue
+		}
+		if platformErr := cmdDef.CheckPlatform(); platformErr != nil {
+			if !gopts.Quiet {
+				fmt.Printf("[^scripthaus] skipping '%s', %v\n", cmdDef.OrigScriptName(), platformErr)
+			}
+			numSkipped++
+			continue
+		}
+		numRun++
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] running '%s' (tag %s)\n", cmdDef.OrigScriptName(), tagsStr)
+		}
+		exitCode := 0
+		if len(cmdDef.GetMatrix()) > 0 {
+			// cells that fail are reported by runMatrixCommand
+			exitCode, err = runMatrixCommand(ctx, cmdDef, runOpts.RunSpec, cmdDef.GetMatrix(), rpt, gopts)
+		} else {
+			var execItem *commanddef.ExecItem
+			execItem, err = scripthaus.BuildExecItem(ctx, cmdDef, runOpts.RunSpec)
+			if err == nil {
+				exitCode, err = rpt.runExecItem(execItem, runOpts.RunSpec.ScriptArgs, cmdDef.Warnings, gopts)
+			} else {
+				rpt.addError(cmdDef, runOpts.RunSpec.ScriptArgs, "", err)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			failed = append(failed, cmdDef.OrigScriptName())
+		} else if exitCode != 0 {
+			failed = append(failed, fmt.Sprintf("%s (exitcode=%d)", cmdDef.OrigScriptName(), exitCode))
+		}
+	}
+	if numRun == 0 && numSkipped == 0 {
+		return 1, fmt.Errorf("no commands found with tag %s", tagsStr)
+	}
+	if !gopts.Quiet {
+		var skippedStr string
+		if numSkipped > 0 {
+			skippedStr = fmt.Sprintf(", %d skipped (platform)", numSkipped)
+		}
+		fmt.Printf("\n[^scripthaus] ran %d command(s) with tag %s, %d failed%s\n", numRun, tagsStr, len(failed), skippedStr)
+		for _, failedName := range failed {
+			fmt.Printf("  failed: %s\n", failedName)
+		}
+	}
+	if len(failed) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func resolveScript(cmdName string, scriptName string, curPlaybookFile string, allowBarePlaybook bool) (commanddef.ScriptDef, error) {
+	var emptyRtn commanddef.ScriptDef
+	if scriptName == "-" {
+		return emptyRtn, fmt.Errorf("invalid script '%s', must specify a [command] to run from <stdin>", scriptName)
+	}
+	// run and show can select an un-annotated code block by index or heading (see mdparser.FindBlock)
+	allowBlock := (cmdName == "run" || cmdName == "show")
+	if curPlaybookFile != "" {
+		if strings.Contains(scriptName, "::") || strings.HasPrefix(scriptName, "^") {
+			return emptyRtn, fmt.Errorf("invalid command name '%s', the playbook is already specified (--playbook or --from '%s')", scriptName, curPlaybookFile)
+		}
+		if !mdparser.IsValidScriptName(scriptName) && !(allowBlock && mdparser.IsBlockSelector(scriptName)) {
+			return emptyRtn, fmt.Errorf("invalid characters in playbook command name '%s' (playbook specified with --playbook or --from '%s')", scriptName, curPlaybookFile)
+		}
+		return commanddef.ScriptDef{PlaybookFile: curPlaybookFile, PlaybookCommand: scriptName}, nil
+	}
+	if strings.HasSuffix(scriptName, "/") {
+		return emptyRtn, fmt.Errorf("invalid script '%s', cannot have a trailing slash", scriptName)
+	}
+	if strings.HasSuffix(scriptName, ".md") {
+		if allowBarePlaybook {
+			return commanddef.ScriptDef{PlaybookFile: scriptName}, nil
+		}
+		return emptyRtn, fmt.Errorf("no playbook script specified, usage: %s %s::[command]", cmdName, scriptName)
+	}
+	playFile, playCommand, err := pathutil.SplitScriptName(scriptName)
+	if err != nil {
+		return emptyRtn, err
+	}
+	if playFile == "" && playCommand == "" {
+		playFile = "."
+	}
+	// a command without a playbook prefix leaves PlaybookFile empty (searches SCRIPTHAUS_PATH)
+	if !allowBarePlaybook && playCommand == "" {
+		return emptyRtn, fmt.Errorf("playbook command name cannot be empty")
+	}
+	if playCommand != "" && !mdparser.IsValidScriptName(playCommand) {
+		if !allowBlock || !mdparser.IsBlockSelector(playCommand) {
+			return emptyRtn, fmt.Errorf("invalid characters in playbook command name '%s'", playCommand)
+		}
+		if playFile == "" {
+			return emptyRtn, fmt.Errorf("selecting block '%s' requires a playbook, e.g. README.md::%s", playCommand, playCommand)
+		}
+	}
+	return commanddef.ScriptDef{PlaybookFile: playFile, PlaybookCommand: playCommand}, nil
+}
+
+const pipeSeparator = "|"
+
+// without --pipe, args are a pipeline only if the first command is directly followed by a "|"
+// arg.  any other "|" arg (or an arg containing "|", e.g. a regex 'a|b') goes to the script
+func isPipeline(args []string, pipeMode bool) bool {
+	return pipeMode || (len(args) > 1 && args[1] == pipeSeparator)
+}
+
+func hasPipeSeparator(args []string) bool {
+	for _, arg := range args {
+		if arg == pipeSeparator {
+			return true
+		}
+	}
+	return false
+}
+
+// args is everything after the run-opts, stages are separated by "|" args.  with --pipe, a
+// single quoted ".a x | .b" arg is split on whitespace, and with no "|" args every arg is a command
+func parsePipeline(args []string, pipeMode bool, curPlaybookFile string) ([]commanddef.PipeStage, error) {
+	if pipeMode && len(args) == 1 && strings.Contains(args[0], pipeSeparator) {
+		args = strings.Fields(strings.ReplaceAll(args[0], pipeSeparator, " "+pipeSeparator+" "))
+	}
+	var stages [][]string
+	if pipeMode && !hasPipeSeparator(args) {
+		for _, arg := range args {
+			stages = append(stages, []string{arg})
+		}
+	} else {
+		stages = append(stages, nil)
+		for _, arg := range args {
+			if arg == pipeSeparator {
+				stages = append(stages, nil)
+				continue
+			}
+			stages[len(stages)-1] = append(stages[len(stages)-1], arg)
+		}
+	}
+	var rtn []commanddef.PipeStage
+	for _, stage := range stages {
+		if len(stage) == 0 {
+			return nil, fmt.Errorf("invalid pipeline, empty command")
+		}
+		script, err := resolveScript("run", stage[0], curPlaybookFile, false)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, commanddef.PipeStage{Script: script, ScriptArgs: stage[1:]})
+	}
+	if len(rtn) < 2 {
+		return nil, fmt.Errorf("invalid pipeline, needs at least two commands")
+	}
+	return rtn, nil
+}
+
+// returns the variables in a .env file as VAR=VAL entries (sorted by name)
+func readEnvFile(fileName string) ([]string, error) {
+	finfo, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("env file not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat env file: %w", err)
+	}
+	if finfo.IsDir() {
+		return nil, fmt.Errorf("env file is a directory")
+	}
+	envMap, err := godotenv.Read(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read env file: %w", err)
+	}
+	var envVars []string
+	for envVar, envVal := range envMap {
+		envVars = append(envVars, fmt.Sprintf("%s=%s", envVar, envVal))
+	}
+	sort.Strings(envVars)
+	return envVars, nil
+}
+
+// a VAR=VAL line that readEnvFile reads back as the same value.  values are single quoted (no
+// escapes or variable expansion) unless they contain a single quote or a newline
+func envFileLine(envVar string, envVal string) string {
+	if !strings.ContainsAny(envVal, "'\n\r") {
+		return fmt.Sprintf("%s='%s'", envVar, envVal)
+	}
+	escaper := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r", "\"", "\\\"", "!", "\\!", "$", "\\$", "`", "\\`")
+	return fmt.Sprintf("%s=\"%s\"", envVar, escaper.Replace(envVal))
+}
+
+func parseRunOpts(gopts globalOptsType) (commanddef.RunOptsType, error) {
+	var rtn commanddef.RunOptsType
+	var pipeMode bool
+	rtn.Script.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("run", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--env":
+			if strings.Index(opt.Value, "=") == -1 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be 'VAR=VAL' (use --env-file to read a .env file)", opt.Flag, opt.Value)
+			}
+			envPairs := strings.Split(opt.Value, ";")
+			for _, envPair := range envPairs {
+				envPair = strings.TrimSpace(envPair)
+				if envPair == "" {
+					continue
+				}
+				if strings.Index(envPair, "=") == -1 {
+					// TODO warning?
+					continue
+				}
+				rtn.RunSpec.Env = append(rtn.RunSpec.Env, envPair)
+			}
+		case "--env-file":
+			envVars, err := readEnvFile(opt.Value)
+			if err != nil {
+				return rtn, fmt.Errorf("%s '%s': %w", opt.Flag, opt.Value, err)
+			}
+			rtn.RunSpec.Env = append(rtn.RunSpec.Env, envVars...)
+		case "--tag":
+			rtn.Tags = append(rtn.Tags, opt.Value)
+		case "--tmux-pane", "--tmux-window":
+			rtn.Tmux = strings.TrimPrefix(opt.Name, "--tmux-")
+		case "--report":
+			_, err = report.ParseSpec(opt.Value)
+			if err != nil {
+				return rtn, err
+			}
+			rtn.Reports = append(rtn.Reports, opt.Value)
+		case "--notify":
+			rtn.RunSpec.Notify = notify.ParseMode(opt.Value)
+			if rtn.RunSpec.Notify == "" {
+				return rtn, fmt.Errorf("invalid '%s=%s', must be --notify or --notify=failure", opt.Flag, opt.Value)
+			}
+		case "--pipe":
+			pipeMode = true
+		case "--trace":
+			rtn.RunSpec.Trace = true
+		case "--timeout":
+			rtn.RunSpec.Timeout, err = time.ParseDuration(opt.Value)
+			if err != nil || rtn.RunSpec.Timeout <= 0 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be a duration, e.g. '30s' or '10m'", opt.Flag, opt.Value)
+			}
+		case "--each":
+			rtn.Each = true
+		case "--parallel":
+			rtn.Parallel, err = strconv.Atoi(opt.Value)
+			if err != nil || rtn.Parallel < 1 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be a positive number", opt.Flag, opt.Value)
+			}
+		case "--matrix":
+			axes, err := commanddef.ParseMatrixAxes(opt.Value)
+			if err != nil {
+				return rtn, fmt.Errorf("%s: %w", opt.Flag, err)
+			}
+			rtn.Matrix = commanddef.MergeMatrixAxes(rtn.Matrix, axes)
+		case "--nolog":
+			rtn.RunSpec.NoLog = true
+			rtn.RunSpec.ForceLog = false
+		case "--log":
+			rtn.RunSpec.NoLog = false
+			rtn.RunSpec.ForceLog = true
+		case "--yes":
+			rtn.Yes = true
+		case "--from":
+			if gopts.PlaybookFile != "" {
+				return rtn, fmt.Errorf("%s cannot be combined with --playbook", opt.Flag)
+			}
+			rtn.Script.PlaybookFile = opt.Value
+		}
+	}
+	if len(parsed.Args) > 0 {
+		argStr, restArgs := parsed.Args[0], parsed.Args[1:]
+		if isPipeline(parsed.Args, pipeMode) {
+			rtn.Pipeline, err = parsePipeline(parsed.Args, pipeMode, rtn.Script.PlaybookFile)
+			if err != nil {
+				return rtn, err
+			}
+			rtn.Script = rtn.Pipeline[0].Script
+			rtn.RunSpec.ScriptArgs = rtn.Pipeline[0].ScriptArgs
+		} else {
+			rtn.Script, err = resolveScript("run", argStr, rtn.Script.PlaybookFile, true)
+			if err != nil {
+				return rtn, err
+			}
+			rtn.RunSpec.ScriptArgs = restArgs
+		}
+	}
+	if pipeMode && len(rtn.Pipeline) == 0 {
+		return rtn, fmt.Errorf("Usage: scripthaus run --pipe [command] [command]..., no commands specified")
+	}
+	if len(rtn.Pipeline) > 0 && (len(rtn.Tags) > 0 || len(rtn.Reports) > 0) {
+		return rtn, fmt.Errorf("a pipeline cannot be combined with --tag or --report")
+	}
+	if len(rtn.Matrix) > 0 && (len(rtn.Pipeline) > 0 || len(rtn.Tags) > 0) {
+		return rtn, fmt.Errorf("--matrix cannot be combined with --tag or a pipeline")
+	}
+	if rtn.Parallel > 0 && !rtn.Each {
+		return rtn, fmt.Errorf("--parallel can only be used with --each")
+	}
+	if rtn.Each && (len(rtn.Pipeline) > 0 || len(rtn.Tags) > 0 || len(rtn.Matrix) > 0 || rtn.Tmux != "") {
+		return rtn, fmt.Errorf("--each cannot be combined with --tag, --matrix, --tmux-*, or a pipeline")
+	}
+	if len(rtn.Tags) > 0 {
+		if rtn.Script.PlaybookCommand != "" {
+			return rtn, fmt.Errorf("Usage: scripthaus run --tag [tag] [playbook] [script-opts], cannot specify a command with --tag")
+		}
+		if rtn.Script.PlaybookFile == "" {
+			rtn.Script.PlaybookFile = "."
+		}
+	}
+	if rtn.Script.PlaybookFile == "" && rtn.Script.PlaybookCommand == "" {
+		return rtn, fmt.Errorf("Usage: scripthaus run [run-opts] [playbook]::[command] [script-opts], no playbook specified")
+	}
+	// empty PlaybookCommand runs the playbook's default_command
+	return rtn, nil
+}
+
+func printWarnings(gopts globalOptsType, warnings []string, spaceAfter bool) {
+	if gopts.Quiet || len(warnings) == 0 {
+		return
+	}
+	color := render.MakeColorizer(os.Stderr)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "%s %s\n", color.Warning("WARNING:"), warning)
+	}
+	if spaceAfter {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+// in strict mode (--strict or "strict" in config.json) playbook warnings are errors.  checks the
+// parser warnings and the directive warnings of every command, prints them (even with --quiet)
+// and returns an error.  returns nil when not in strict mode
+func checkStrict(gopts globalOptsType, warnings []string, cmdDefs []commanddef.CommandDef) error {
+	if !gopts.Strict {
+		return nil
+	}
+	allWarnings := append([]string(nil), warnings...)
+	for idx := range cmdDefs {
+		for _, warning := range cmdDefs[idx].DirectiveWarnings() {
+			allWarnings = append(allWarnings, fmt.Sprintf("command '%s': %s", cmdDefs[idx].Name, warning))
+		}
+	}
+	if len(allWarnings) == 0 {
+		return nil
+	}
+	gopts.Quiet = false
+	printWarnings(gopts, allWarnings, true)
+	return fmt.Errorf("%d playbook warning(s) (strict mode)", len(allWarnings))
+}
+
+func parseListOpts(gopts globalOptsType) (listOptsType, error) {
+	var rtn listOptsType
+	rtn.PlaybookFile = gopts.PlaybookFile
+	rtn.Verbose = gopts.Verbose > 0
+	parsed, err := parseCommandArgs("list", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--tag":
+			rtn.Tags = append(rtn.Tags, opt.Value)
+		case "--json":
+			rtn.Format = output.FormatJson
+		case "--all":
+			rtn.All = true
+		case "--everywhere":
+			rtn.Everywhere = true
+		case "--verbose":
+			rtn.Verbose = true
+		case "--format":
+			rtn.Format = opt.Value
+		}
+	}
+	if len(parsed.Args) > 0 {
+		rtn.PlaybookFile = parsed.Args[0]
+	}
+	if rtn.All && rtn.PlaybookFile != "" && rtn.PlaybookFile != "." && !strings.HasPrefix(rtn.PlaybookFile, "^") {
+		return rtn, fmt.Errorf("Usage: scripthaus list --all [list-opts] [^dir/], the playbook for --all must be '.' or '^' (or a directory under '^')")
+	}
+	if rtn.Everywhere && (rtn.All || rtn.PlaybookFile != "") {
+		return rtn, fmt.Errorf("Usage: scripthaus list --everywhere [list-opts], cannot specify a playbook or --all with --everywhere")
+	}
+	// empty PlaybookFile lists every playbook on SCRIPTHAUS_PATH
+	return rtn, nil
+}
+
+func runListCommandInternal(gopts globalOptsType, listOpts listOptsType) (int, error) {
+	formatter, err := output.GetListFormatter(listOpts.Format, render.MakeColorizer(os.Stdout), listOpts.Verbose)
+	if err != nil {
+		return 1, err
+	}
+	var playbooks []*pathutil.ResolvedPlaybook
+	numPath := 0
+	if listOpts.Everywhere {
+		var warnings []string
+		playbooks, numPath, warnings = pathutil.DefaultResolver().ResolveEverywherePlaybooks()
+		printWarnings(gopts, warnings, true)
+		if len(playbooks) == 0 {
+			return 1, fmt.Errorf("no playbooks found (no project, global, or %s playbooks)", base.ScPathVarName)
+		}
+	} else if listOpts.All && strings.HasPrefix(listOpts.PlaybookFile, "^") {
+		playbooks, err = findGlobalPlaybooks(listOpts.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+		if len(playbooks) == 0 {
+			return 1, fmt.Errorf("no playbooks found in '%s'", listOpts.PlaybookFile)
+		}
+	} else if listOpts.All {
+		playbooks, err = findProjectPlaybooks()
+		if err != nil {
+			return 1, err
+		}
+		if len(playbooks) == 0 {
+			return 1, fmt.Errorf("no playbooks found")
+		}
+	} else if listOpts.PlaybookFile == "" {
+		// lists the commands from every playbook on SCRIPTHAUS_PATH
+		var warnings []string
+		playbooks, warnings = pathutil.DefaultResolver().ResolvePathPlaybooks()
+		printWarnings(gopts, warnings, true)
+		if len(playbooks) == 0 {
+			return 1, fmt.Errorf("no playbook specified and no playbooks found on %s", base.ScPathVarName)
+		}
+	} else {
+		resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(listOpts.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+		playbooks = append(playbooks, resolvedPlaybook)
+	}
+	exitCode := 0
+	var listings []output.PlaybookListing
+	for pidx, result := range loadResolvedPlaybooks(playbooks) {
+		listing, err := makePlaybookListing(gopts, result, listOpts.Tags)
+		if err != nil {
+			if len(playbooks) == 1 {
+				return 1, err
+			}
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			exitCode = 1
+			continue
+		}
+		if listOpts.All && len(listing.Commands) == 0 {
+			continue
+		}
+		if listOpts.Everywhere {
+			listing.Origin = playbookOrigin(listing.Playbook, pidx < numPath)
+		}
+		listings = append(listings, listing)
+	}
+	if listOpts.Everywhere {
+		printWarnings(gopts, markShadowedCommands(listings), true)
+	}
+	err = formatter.WriteList(os.Stdout, listings)
+	if err != nil {
+		return 1, err
+	}
+	return exitCode, nil
+}
+
+// finds every playbook under the project root (or the current directory if
+// there is no project root), respecting .gitignore files
+func findProjectPlaybooks() ([]*pathutil.ResolvedPlaybook, error) {
+	resolver := pathutil.DefaultResolver()
+	namePrefix := "."
+	rootDir, err := resolver.FindPrefixDir(".")
+	if err != nil {
+		namePrefix = "./"
+		rootDir, err = resolver.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get current working directory: %w", err)
+		}
+	}
+	files, err := pathutil.FindPlaybookFiles(rootDir, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search for playbooks in '%s': %w", rootDir, err)
+	}
+	var rtn []*pathutil.ResolvedPlaybook
+	for _, relFile := range files {
+		playbookName := namePrefix + relFile
+		if namePrefix == "." && relFile == pathutil.DefaultScFile {
+			playbookName = "."
+		}
+		resolvedPlaybook, err := resolver.ResolvePlaybook(playbookName)
+		if err != nil {
+			// not a valid playbook name, fall back to the absolute path
+			resolvedPlaybook, err = resolver.ResolvePlaybook(filepath.Join(rootDir, relFile))
+			if err != nil {
+				continue
+			}
+		}
+		rtn = append(rtn, resolvedPlaybook)
+	}
+	return rtn, nil
+}
+
+// origin marker for 'list --everywhere'
+func playbookOrigin(playbook *pathutil.ResolvedPlaybook, onPath bool) string {
+	var rtn string
+	switch {
+	case playbook.OrigName == "^":
+		rtn = "global"
+	case playbook.OrigName == ".":
+		rtn = "project"
+	case strings.Trim(playbook.OrigName, ".") == "":
+		rtn = "parent project"
+	}
+	if !onPath {
+		return rtn
+	}
+	if rtn == "" {
+		return base.ScPathVarName
+	}
+	return rtn + ", " + base.ScPathVarName
+}
+
+// the listings are in precedence order, a command name (or alias) defined in an earlier playbook
+// shadows the same name in later playbooks.  sets Origin and ShadowedBy, returns the shadowing warnings
+func markShadowedCommands(listings []output.PlaybookListing) []string {
+	var warnings []string
+	firstDef := make(map[string]string) // name -> fullname
+	for _, listing := range listings {
+		entries := listing.Commands
+		for idx := range entries {
+			entries[idx].Origin = listing.Origin
+			if firstDef[entries[idx].Name] != "" {
+				entries[idx].ShadowedBy = firstDef[entries[idx].Name]
+				warnings = append(warnings, fmt.Sprintf("command '%s' is shadowed by '%s'", entries[idx].FullName, entries[idx].ShadowedBy))
+			}
+			for _, alias := range entries[idx].Aliases {
+				if firstDef[alias] != "" {
+					warnings = append(warnings, fmt.Sprintf("alias '%s' of command '%s' is shadowed by '%s'", alias, entries[idx].FullName, firstDef[alias]))
+				}
+			}
+		}
+		for _, entry := range entries {
+			for _, name := range append([]string{entry.Name}, entry.Aliases...) {
+				if firstDef[name] == "" {
+					firstDef[name] = entry.FullName
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// finds every playbook in the global scripthaus directory (or a sub-directory, e.g. "^tools/")
+// and its sub-directories, respecting .gitignore files
+func findGlobalPlaybooks(dirName string) ([]*pathutil.ResolvedPlaybook, error) {
+	resolver := pathutil.DefaultResolver()
+	scHome, err := resolver.GetScHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	subDir := strings.TrimPrefix(dirName, "^")
+	if subDir != "" && !strings.HasSuffix(subDir, "/") {
+		subDir += "/"
+	}
+	rootDir := filepath.Join(scHome, filepath.FromSlash(subDir))
+	files, err := pathutil.FindPlaybookFiles(rootDir, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot search for playbooks in '%s': %w", rootDir, err)
+	}
+	var rtn []*pathutil.ResolvedPlaybook
+	for _, relFile := range files {
+		playbookName := "^" + subDir + relFile
+		if relFile == pathutil.DefaultScFile || strings.HasSuffix(relFile, "/"+pathutil.DefaultScFile) {
+			// "^tools/" for tools/scripthaus.md
+			playbookName = "^" + subDir + strings.TrimSuffix(relFile, pathutil.DefaultScFile)
+		}
+		resolvedPlaybook, err := resolver.ResolvePlaybook(playbookName)
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, resolvedPlaybook)
+	}
+	return rtn, nil
+}
+
+func makePlaybookListing(gopts globalOptsType, result playbookLoadResult, tags []string) (output.PlaybookListing, error) {
+	rtn := output.PlaybookListing{Playbook: result.Playbook}
+	if result.Err != nil {
+		return rtn, result.Err
+	}
+	if err := checkStrict(gopts, result.Warnings, result.CmdDefs); err != nil {
+		return rtn, fmt.Errorf("%s: %w", result.Playbook.OrigShowStr(), err)
+	}
+	printWarnings(gopts, result.Warnings, true)
+	commands := result.CmdDefs
+	for idx := range commands {
+		if len(tags) == 0 || commands[idx].HasAnyTag(tags) {
+			rtn.Commands = append(rtn.Commands, output.MakeCommandEntry(&commands[idx]))
+		}
+	}
+	return rtn, nil
+}
+
+func runListCommand(gopts globalOptsType) (int, error) {
+	listOpts, err := parseListOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	return runListCommandInternal(gopts, listOpts)
+}
+
+const (
+	showSelectCode = "code"
+	showSelectDoc  = "doc"
+	showSelectMeta = "meta"
+)
+
+type showOptsType struct {
+	Script commanddef.ScriptDef
+	Select string // "" shows everything, otherwise showSelectCode, showSelectDoc, or showSelectMeta
+	Raw    bool   // do not render markdown (even if stdout is a terminal)
+}
+
+func parseShowOpts(gopts globalOptsType) (showOptsType, error) {
+	var rtn showOptsType
+	rtn.Script.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("show", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--code", "--doc", "--meta":
+			if rtn.Select != "" {
+				return rtn, fmt.Errorf("only one of --code, --doc, or --meta can be passed to scripthaus show command")
+			}
+			rtn.Select = opt.Name[2:]
+		case "--raw":
+			rtn.Raw = true
+		case "--from":
+			if gopts.PlaybookFile != "" {
+				return rtn, fmt.Errorf("%s cannot be combined with --playbook", opt.Flag)
+			}
+			rtn.Script.PlaybookFile = opt.Value
+		}
+	}
+	if len(parsed.Args) > 0 {
+		rtn.Script, err = resolveScript("show", parsed.Args[0], rtn.Script.PlaybookFile, true)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	return rtn, nil
+}
+
+type historyOptsType struct {
+	ShowNum int
+	ShowAll bool
+
+	FormatFull     bool
+	FormatJson     bool
+	FormatTemplate string // 'history --format', a Go template for each item
+	Unique         bool
+
+	OpenId int // 'history open [id]', the run log directory for the item
+	ShowId int // 'history show [id]', the full record for the item
+	EnvId  int // 'history env [id]', the recorded environment variables for the item
+}
+
+func parseHistoryOpts(opts globalOptsType) (historyOptsType, error) {
+	var rtn historyOptsType
+	parsed, err := parseCommandArgs("history", opts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--all":
+			rtn.ShowAll = true
+		case "--full":
+			rtn.FormatFull = true
+		case "--json":
+			rtn.FormatJson = true
+		case "--format":
+			rtn.FormatTemplate = opt.Value
+		case "--unique":
+			rtn.Unique = true
+		case "-n":
+			num, err := strconv.Atoi(opt.Value)
+			if err != nil {
+				return rtn, fmt.Errorf("'%s %s' invalid number: %w", opt.Flag, opt.Value, err)
+			}
+			rtn.ShowNum = num
+		}
+	}
+	if len(parsed.Args) > 0 {
+		subCmd := parsed.Args[0]
+		if subCmd != "show" && subCmd != "open" && subCmd != "env" {
+			return rtn, fmt.Errorf("too many arguments passed to scripthaus history command, extras = '%s'", strings.Join(parsed.Args, " "))
+		}
+		if len(parsed.Args) < 2 {
+			return rtn, fmt.Errorf("Usage: scripthaus history %s [id], missing id", subCmd)
+		}
+		historyId, err := strconv.Atoi(parsed.Args[1])
+		if err != nil || historyId <= 0 {
+			return rtn, fmt.Errorf("invalid history id '%s' passed to scripthaus history %s", parsed.Args[1], subCmd)
+		}
+		if subCmd == "show" {
+			rtn.ShowId = historyId
+		} else if subCmd == "env" {
+			rtn.EnvId = historyId
+		} else {
+			rtn.OpenId = historyId
+		}
+	}
+	if rtn.FormatTemplate != "" && (rtn.FormatJson || rtn.FormatFull) {
+		return rtn, fmt.Errorf("--format cannot be combined with --json or --full")
+	}
+	return rtn, nil
+}
+
+// runs left without an exit code by a scripthaus process that was killed are marked as
+// interrupted before history is read (see history.SweepInterrupted)
+func sweepInterruptedRuns() {
+	_, err := history.SweepInterrupted()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[^scripthaus] error checking for interrupted runs in history db: %v\n", err)
+	}
+}
+
+func runHistoryCommand(opts globalOptsType) (int, error) {
+	historyOpts, err := parseHistoryOpts(opts)
+	if err != nil {
+		return 1, err
+	}
+	sweepInterruptedRuns()
+	if historyOpts.OpenId != 0 {
+		return openHistoryRunDir(historyOpts.OpenId)
+	}
+	if historyOpts.ShowId != 0 {
+		return showHistoryItem(historyOpts.ShowId, historyOpts.FormatJson)
+	}
+	if historyOpts.EnvId != 0 {
+		return printHistoryEnv(historyOpts.EnvId)
+	}
+	query := history.HistoryQuery{
+		ShowAll: historyOpts.ShowAll,
+		ShowNum: historyOpts.ShowNum,
+		Unique:  historyOpts.Unique,
+	}
+	var tmpl *template.Template
+	if historyOpts.FormatTemplate != "" {
+		tmpl, err = history.ParseFormatTemplate(historyOpts.FormatTemplate)
+		if err != nil {
+			return 1, err
+		}
 	}
 	items, err := history.QueryHistory(query)
 	if err != nil {
-		return 1, err
+		return 1, err
+	}
+	// ignore error (just use "")
+	henv := history.MakeHistoryEnv()
+	if tmpl != nil {
+		for _, item := range items {
+			err = item.WriteTemplate(os.Stdout, tmpl, henv)
+			if err != nil {
+				return 1, err
+			}
+		}
+		return 0, nil
+	}
+	color := render.MakeColorizer(os.Stdout)
+	if historyOpts.FormatJson {
+		records := []history.HistoryItemJson{}
+		for _, item := range items {
+			records = append(records, item.ToJson())
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return 0, enc.Encode(records)
+	}
+	for _, item := range items {
+		if historyOpts.FormatFull {
+			str := item.FullString(henv, color)
+			fmt.Printf("%s", str)
+			continue
+		} else {
+			str := item.CompactString(henv, color)
+			fmt.Printf("%s", str)
+			continue
+		}
+	}
+	return 0, nil
+}
+
+type rerunOptsType struct {
+	FailedOnly bool
+}
+
+func parseRerunOpts(gopts globalOptsType) (rerunOptsType, error) {
+	var rtn rerunOptsType
+	parsed, err := parseCommandArgs("rerun", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	// the last of --last and --last-failed wins
+	for _, opt := range parsed.Opts {
+		rtn.FailedOnly = (opt.Name == "--last-failed")
+	}
+	return rtn, nil
+}
+
+// runs the most recent (failed) run of the current project again, with the same playbook
+// command, script arguments, and matrix cell
+func runRerunCommand(gopts globalOptsType) (int, error) {
+	rerunOpts, err := parseRerunOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	sweepInterruptedRuns()
+	henv := history.MakeHistoryEnv()
+	item, err := history.FindLastRun(henv, rerunOpts.FailedOnly)
+	if err != nil {
+		return 1, err
+	}
+	if item == nil {
+		where := henv.ProjectDir
+		if where == "" {
+			where = henv.Cwd
+		}
+		if rerunOpts.FailedOnly {
+			return 1, fmt.Errorf("no failed runs in history for %s", where)
+		}
+		return 1, fmt.Errorf("no runs in history for %s", where)
+	}
+	if pipeline := item.Pipeline(); pipeline != "" {
+		return 1, fmt.Errorf("history item %d is a pipeline, run it again with: scripthaus run --pipe '%s'", item.HistoryId, pipeline)
+	}
+	if item.PlaybookFile == "" || item.PlaybookFile == "-" || item.PlaybookCommand == "" {
+		return 1, fmt.Errorf("history item %d was not run from a playbook file, cannot rerun it", item.HistoryId)
+	}
+	var runArgs []string
+	if cellName := item.MatrixCell(); cellName != "" {
+		for _, entry := range strings.Split(cellName, ",") {
+			runArgs = append(runArgs, "--matrix", entry)
+		}
+	}
+	// secret values are redacted before the command line is stored, never rerun with the placeholder
+	cmdLine := item.DecodeCmdLine()
+	for _, arg := range cmdLine {
+		if strings.Contains(arg, secrets.RedactedStr) {
+			return 1, fmt.Errorf("history item %d has redacted arguments (secret values are not saved in history), run it again with the real values: scripthaus run %s", item.HistoryId, strings.TrimSpace(item.ScriptString(henv)+" "+item.CmdLineStr()))
+		}
+	}
+	runArgs = append(runArgs, item.PlaybookFile+"::"+item.PlaybookCommand)
+	runArgs = append(runArgs, cmdLine...)
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] rerun %d: %s\n", item.HistoryId, strings.TrimSpace(item.ScriptString(henv)+" "+item.CmdLineStr()))
+	}
+	gopts.CommandName = "run"
+	gopts.CommandArgs = runArgs
+	gopts.PlaybookFile = ""
+	return runRunCommand(gopts)
+}
+
+// number of output lines shown from the start and the end of each run log by 'history show'
+const historyShowLogLines = 5
+
+// prints every field of the history item, plus the environment changes and the start/end of
+// the output when the run has a run log directory
+func showHistoryItem(historyId int, formatJson bool) (int, error) {
+	item, err := history.GetHistoryItem(historyId)
+	if err != nil {
+		return 1, err
+	}
+	if item == nil {
+		return 1, fmt.Errorf("history item %d not found", historyId)
+	}
+	if formatJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return 0, enc.Encode(item.ToJson())
+	}
+	color := render.MakeColorizer(os.Stdout)
+	henv := history.MakeHistoryEnv()
+	printField := func(name string, val string) {
+		if val != "" {
+			fmt.Printf("%-15s %s\n", name+":", val)
+		}
+	}
+	scriptName := item.ScriptString(henv)
+	if pipeline := item.Pipeline(); pipeline != "" {
+		scriptName = pipeline
+	}
+	printField("history id", strconv.FormatInt(item.HistoryId, 10))
+	printField("command", color.Name(scriptName))
+	printField("args", item.CmdLineStr())
+	playbookFile := item.ResolvedPlaybookFile()
+	if playbookFile == "" {
+		playbookFile = item.PlaybookFile + " " + color.Dim("(cannot be resolved)")
+	}
+	printField("playbook", playbookFile)
+	printField("project", item.ProjectDir)
+	printField("language", item.ScriptType)
+	printField("matrix", item.MatrixCell())
+	printField("start", time.UnixMilli(item.Ts).Format("2006-01-02 15:04:05"))
+	printField("cwd", item.Cwd)
+	if item.ExitCode.Valid {
+		printField("duration", (time.Duration(item.DurationMs.Int64) * time.Millisecond).String())
+		printField("exit code", color.ExitCode(int(item.ExitCode.Int64)))
+	} else {
+		printField("exit code", color.Dim("(still running, or scripthaus exited before the command finished)"))
+	}
+	printField("terminated", item.GetMetadata()[history.TermReasonMdKey])
+	printField("user", item.SysUser)
+	printField("host", item.HostName)
+	printField("ip", item.IpAddr)
+	printField("version", item.ScVersion)
+	if env, found := item.GetEnv(); found && len(env) > 0 {
+		fmt.Printf("\n%s\n", color.Heading("env set by scripthaus"))
+		for _, envEntry := range env {
+			fmt.Printf("    %s\n", envEntry)
+		}
+	}
+	runDir := item.RunDir()
+	if runDir == "" {
+		fmt.Printf("\n%s\n", color.Dim(fmt.Sprintf("environment and output were not captured (set \"run_logs\": true in %s)", config.ConfigFileName)))
+		return 0, nil
+	}
+	printField("run dir", runDir)
+	if envDiff, err := runlog.ReadEnvDiff(runDir); err == nil {
+		printEnvMap := func(title string, env map[string]string) {
+			if len(env) == 0 {
+				return
+			}
+			fmt.Printf("\n%s\n", color.Heading(title))
+			var names []string
+			for name := range env {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Printf("    %s=%s\n", name, env[name])
+			}
+		}
+		printEnvMap("env added", envDiff.Added)
+		printEnvMap("env changed", envDiff.Changed)
+		if len(envDiff.Removed) > 0 {
+			fmt.Printf("\n%s\n    %s\n", color.Heading("env removed"), strings.Join(envDiff.Removed, " "))
+		}
+	}
+	for _, logName := range []string{"stdout.log", "stderr.log"} {
+		head, tail, numLines, err := runlog.ReadLogHeadTail(runDir, logName, historyShowLogLines)
+		if err != nil || numLines == 0 {
+			continue
+		}
+		fmt.Printf("\n%s %s\n", color.Heading(logName), color.Dim(fmt.Sprintf("(%d lines)", numLines)))
+		for _, line := range head {
+			fmt.Printf("    %s\n", line)
+		}
+		if len(tail) > 0 {
+			fmt.Printf("    %s\n", color.Dim(fmt.Sprintf("... %d lines ...", numLines-len(head)-len(tail))))
+			for _, line := range tail {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+	return 0, nil
+}
+
+// prints the variables scripthaus set for the run in .env format, so the run can be repeated
+// with --env-file (redacted values have to be filled in)
+func printHistoryEnv(historyId int) (int, error) {
+	item, err := history.GetHistoryItem(historyId)
+	if err != nil {
+		return 1, err
+	}
+	if item == nil {
+		return 1, fmt.Errorf("history item %d not found", historyId)
+	}
+	env, found := item.GetEnv()
+	if !found {
+		return 1, fmt.Errorf("history item %d has no recorded environment (set \"record_env\": true in %s to record it)", historyId, config.ConfigFileName)
+	}
+	for _, envEntry := range env {
+		parts := strings.SplitN(envEntry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fmt.Printf("%s\n", envFileLine(parts[0], parts[1]))
+	}
+	return 0, nil
+}
+
+// opens the run log directory in the file browser when stdout is a terminal, otherwise
+// (or if there is no way to open it) prints the directory
+func openHistoryRunDir(historyId int) (int, error) {
+	item, err := history.GetHistoryItem(historyId)
+	if err != nil {
+		return 1, err
+	}
+	if item == nil {
+		return 1, fmt.Errorf("history item %d not found", historyId)
+	}
+	runDir := item.RunDir()
+	if runDir == "" {
+		return 1, fmt.Errorf("history item %d has no run log directory (set \"run_logs\": true in %s to enable them)", historyId, config.ConfigFileName)
+	}
+	if _, err := os.Stat(runDir); err != nil {
+		return 1, fmt.Errorf("run log directory for history item %d is missing: %w", historyId, err)
+	}
+	if render.IsTerminal(os.Stdout) {
+		openerCmd := "xdg-open"
+		if runtime.GOOS == "darwin" {
+			openerCmd = "open"
+		} else if runtime.GOOS == "windows" {
+			openerCmd = "explorer"
+		}
+		if _, lookErr := exec.LookPath(openerCmd); lookErr == nil && exec.Command(openerCmd, runDir).Run() == nil {
+			fmt.Printf("[^scripthaus] opened %s\n", runDir)
+			return 0, nil
+		}
+	}
+	fmt.Printf("%s\n", runDir)
+	return 0, nil
+}
+
+type manageOptsType struct {
+	ManageCommand string
+	StartId       int
+	EndId         int
+	FileName      string // export-json and import-json, "" or "-" for stdout/stdin
+}
+
+func parseManageOpts(opts globalOptsType) (manageOptsType, error) {
+	var rtn manageOptsType
+	parsed, err := parseCommandArgs("manage", opts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	if len(parsed.Args) == 0 {
+		return rtn, nil
+	}
+	rtn.ManageCommand = parsed.Args[0]
+	subArgs := parsed.Args[1:]
+	maxSubArgs := 0
+	if rtn.ManageCommand == "remove-history-range" {
+		if len(subArgs) < 2 {
+			return rtn, fmt.Errorf("Usage: scripthaus manage remove-history-range [start-id] [end-id], not enough arguments passed")
+		}
+		rtn.StartId, err = strconv.Atoi(subArgs[0])
+		if err != nil {
+			return rtn, fmt.Errorf("invalid [start-id] '%s' passed to scripthaus manage remove-history-range: %w", subArgs[0], err)
+		}
+		rtn.EndId, err = strconv.Atoi(subArgs[1])
+		if err != nil {
+			return rtn, fmt.Errorf("invalid [end-id] '%s' passed to scripthaus manage remove-history-range: %w", subArgs[1], err)
+		}
+		maxSubArgs = 2
+	}
+	if (rtn.ManageCommand == "export-json" || rtn.ManageCommand == "import-json") && len(subArgs) > 0 {
+		rtn.FileName = subArgs[0]
+		maxSubArgs = 1
+	}
+	if len(subArgs) > maxSubArgs {
+		return rtn, fmt.Errorf("Usage: scripthaus manage, too many arguments passed, extras = '%s'", strings.Join(subArgs[maxSubArgs:], " "))
+	}
+	return rtn, nil
+}
+
+func runManageCommand(opts globalOptsType) (int, error) {
+	manageOpts, err := parseManageOpts(opts)
+	if err != nil {
+		return 1, err
+	}
+	if manageOpts.ManageCommand == "clear-history" {
+		numRemoved, err := history.RemoveHistoryItems(true, 0, 0)
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] all %d history items removed\n\n", numRemoved)
+	} else if manageOpts.ManageCommand == "delete-db" {
+		err = history.RemoveDB()
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] history db deleted\n\n")
+	} else if manageOpts.ManageCommand == "remove-history-range" {
+		numRemoved, err := history.RemoveHistoryItems(false, manageOpts.StartId, manageOpts.EndId)
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] %d history items removed\n\n", numRemoved)
+	} else if manageOpts.ManageCommand == "renumber-history" {
+		err = history.ReNumberHistory()
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] history items renumbered\n\n")
+	} else if manageOpts.ManageCommand == "vacuum" {
+		beforeSize, afterSize, err := history.VacuumDB()
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] history db vacuumed, %s => %s (reclaimed %s)\n\n", formatByteSize(beforeSize), formatByteSize(afterSize), formatByteSize(beforeSize-afterSize))
+	} else if manageOpts.ManageCommand == "export-json" {
+		return runManageExportJson(manageOpts.FileName)
+	} else if manageOpts.ManageCommand == "import-json" {
+		return runManageImportJson(manageOpts.FileName)
+	} else if manageOpts.ManageCommand == "encrypt-history" {
+		numEncrypted, err := history.EncryptHistory()
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] %d history items encrypted\n\n", numEncrypted)
+	} else {
+		if manageOpts.ManageCommand == "" {
+			return 1, fmt.Errorf("no sub-command passed to scripthaus manage")
+		} else {
+			return 1, fmt.Errorf("invalid manage sub-command '%s'", manageOpts.ManageCommand)
+		}
+	}
+	return 0, nil
+}
+
+func runManageExportJson(fileName string) (int, error) {
+	if fileName == "" || fileName == "-" {
+		_, err := history.ExportJson(os.Stdout)
+		if err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	fd, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 1, fmt.Errorf("cannot create export file '%s': %w", fileName, err)
+	}
+	numItems, err := history.ExportJson(fd)
+	closeErr := fd.Close()
+	if err != nil {
+		return 1, err
+	}
+	if closeErr != nil {
+		return 1, fmt.Errorf("cannot write export file '%s': %w", fileName, closeErr)
+	}
+	fmt.Printf("[^scripthaus] %d history items exported to '%s'\n\n", numItems, fileName)
+	return 0, nil
+}
+
+func runManageImportJson(fileName string) (int, error) {
+	var r io.Reader = os.Stdin
+	if fileName != "" && fileName != "-" {
+		fd, err := os.Open(fileName)
+		if err != nil {
+			return 1, fmt.Errorf("cannot open import file '%s': %w", fileName, err)
+		}
+		defer fd.Close()
+		r = fd
+	} else if render.IsTerminal(os.Stdin) {
+		return 1, fmt.Errorf("Usage: scripthaus manage import-json [file], no file passed (and stdin is a terminal)")
+	}
+	numImported, numSkipped, err := history.ImportJson(r)
+	if err != nil {
+		return 1, err
+	}
+	if numSkipped > 0 {
+		fmt.Printf("[^scripthaus] %d history items imported (%d already in history, skipped)\n\n", numImported, numSkipped)
+	} else {
+		fmt.Printf("[^scripthaus] %d history items imported\n\n", numImported)
+	}
+	return 0, nil
+}
+
+func formatByteSize(size int64) string {
+	if size < 0 {
+		size = 0
+	}
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	if size < 1024*1024 {
+		return fmt.Sprintf("%.1fKB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%.1fMB", float64(size)/(1024*1024))
+}
+
+type publishOptsType struct {
+	PlaybookFile string
+	Name         string // "@team/name", "" for the default name
+	SignKey      string // --sign-key, overrides the registry's sign_key
+}
+
+func parsePublishOpts(gopts globalOptsType) (publishOptsType, error) {
+	rtn := publishOptsType{PlaybookFile: gopts.PlaybookFile}
+	parsed, err := parseCommandArgs("publish", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		if opt.Name == "--sign-key" {
+			rtn.SignKey = opt.Value
+		}
+	}
+	args := parsed.Args
+	if rtn.PlaybookFile == "" && len(args) > 0 {
+		rtn.PlaybookFile, args = args[0], args[1:]
+	}
+	if len(args) > 0 {
+		rtn.Name = args[0]
+	}
+	if rtn.PlaybookFile == "" {
+		return rtn, fmt.Errorf("Usage: scripthaus publish [playbook] [@team/name], no playbook specified")
+	}
+	return rtn, nil
+}
+
+// the @team/name to publish a playbook as, defaults to the registry's team and the playbook's file name
+func publishName(publishOpts publishOptsType, resolvedPlaybook *pathutil.ResolvedPlaybook, cfg *registry.Config) (registry.Name, error) {
+	if publishOpts.Name != "" {
+		return registry.ParseName(publishOpts.Name)
+	}
+	if cfg == nil || cfg.Team == "" {
+		return registry.Name{}, fmt.Errorf("no @team/name given and no \"team\" set in the registry config")
+	}
+	baseName := strings.TrimSuffix(filepath.Base(resolvedPlaybook.ResolvedFile), ".md")
+	if baseName == strings.TrimSuffix(pathutil.DefaultScFile, ".md") {
+		return registry.Name{}, fmt.Errorf("cannot name a published %s after its file, pass @team/name", pathutil.DefaultScFile)
+	}
+	return registry.ParseName("@" + cfg.Team + "/" + baseName)
+}
+
+func runPublishCommand(gopts globalOptsType) (int, error) {
+	publishOpts, err := parsePublishOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	cfg := config.Get().Registry
+	resolvedPlaybook, cmdDefs, warnings, err := loadPlaybook(publishOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
+	}
+	if err := checkStrict(gopts, warnings, cmdDefs); err != nil {
+		return 1, err
+	}
+	printWarnings(gopts, warnings, false)
+	name, err := publishName(publishOpts, resolvedPlaybook, cfg)
+	if err != nil {
+		return 1, err
+	}
+	_, data, err := pathutil.TryReadFile(resolvedPlaybook.ResolvedFile, "playbook", false)
+	if err != nil {
+		return 1, err
+	}
+	signKey := publishOpts.SignKey
+	if signKey == "" && cfg != nil {
+		signKey = cfg.SignKey
+	}
+	changed, err := registry.Publish(cfg, name, data, signKey)
+	if err != nil {
+		return 1, err
+	}
+	if !changed {
+		fmt.Printf("[^scripthaus] %s is already up to date in %s\n", name, cfg.Location())
+		return 0, nil
+	}
+	signedStr := ""
+	if signKey != "" {
+		signedStr = ", signed"
+	}
+	fmt.Printf("[^scripthaus] published %s (%d commands%s) to %s\n", name, len(cmdDefs), signedStr, cfg.Location())
+	return 0, nil
+}
+
+// the project's lock file ("" and nil if there is no project)
+func readProjectLockfile() (string, *pathutil.Lockfile, error) {
+	resolver := pathutil.DefaultResolver()
+	lockFileName := resolver.ProjectLockFile()
+	if lockFileName == "" {
+		return "", nil, nil
+	}
+	lockfile, err := resolver.ReadLockfile(lockFileName)
+	if err != nil {
+		return "", nil, err
+	}
+	return lockFileName, lockfile, nil
+}
+
+func parseRegistryNames(args []string) ([]registry.Name, error) {
+	var rtn []registry.Name
+	for _, arg := range args {
+		name, err := registry.ParseName(arg)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, name)
+	}
+	return rtn, nil
+}
+
+// fetches the playbooks.  inside a project the fetched versions are pinned in scripthaus.lock,
+// playbooks that are already pinned are fetched at the pinned version (no args fetches every
+// pinned playbook)
+func runFetchCommand(gopts globalOptsType) (int, error) {
+	parsed, err := parseCommandArgs("fetch", gopts.CommandArgs)
+	if err != nil {
+		return 1, err
+	}
+	var expectedSha256 string
+	for _, opt := range parsed.Opts {
+		if opt.Name == "--sha256" {
+			expectedSha256 = opt.Value
+		}
+	}
+	if expectedSha256 != "" && len(parsed.Args) != 1 {
+		return 1, fmt.Errorf("--sha256 can only be used when fetching one playbook")
+	}
+	lockFileName, lockfile, err := readProjectLockfile()
+	if err != nil {
+		return 1, err
+	}
+	args := parsed.Args
+	if len(args) == 0 && lockfile != nil {
+		args = lockfile.Names()
+	}
+	if len(args) == 0 {
+		return 1, fmt.Errorf("Usage: scripthaus fetch [@team/name]..., no playbook specified (and no playbooks pinned in %s)", pathutil.LockFileName)
+	}
+	names, err := parseRegistryNames(args)
+	if err != nil {
+		return 1, err
+	}
+	cfg := config.Get().Registry
+	exitCode := 0
+	lockChanged := false
+	for _, name := range names {
+		var fetched *registry.Fetched
+		entry, pinned := pathutil.LockEntry{}, false
+		if lockfile != nil {
+			entry, pinned = lockfile.Playbooks[name.String()]
+		}
+		if pinned {
+			fetched, err = registry.FetchPinned(cfg, name, entry)
+		} else {
+			fetched, err = registry.Fetch(cfg, name)
+		}
+		if err == nil {
+			// a pinned (possibly older) version does not replace the latest version
+			err = saveFetchedPlaybook(gopts, fetched, expectedSha256, !pinned, lockfile != nil)
+		}
+		if err != nil {
+			if len(names) == 1 {
+				return 1, err
+			}
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			exitCode = 1
+			continue
+		}
+		if lockfile != nil && !pinned {
+			lockfile.Playbooks[name.String()] = fetched.LockEntry()
+			lockChanged = true
+		}
+	}
+	if lockChanged {
+		err = pathutil.WriteLockfile(lockFileName, lockfile)
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("[^scripthaus] pinned in %s\n", lockFileName)
+	}
+	return exitCode, nil
+}
+
+// verifies the fetched playbook (checksum and signature) and checks that it parses before saving
+// it (a bad fetch does not replace a good playbook).  saves it as the latest version and/or as a
+// pinned copy
+func saveFetchedPlaybook(gopts globalOptsType, fetched *registry.Fetched, expectedSha256 string, latest bool, pin bool) error {
+	verifyResult, err := registry.Verify(config.Get().Registry, fetched, expectedSha256)
+	if err != nil {
+		return err
+	}
+	fileName, err := registry.LocalFile(fetched.Name)
+	if err != nil {
+		return err
+	}
+	name := fetched.Name.String()
+	src := &mdparser.PlaybookSource{Playbook: &pathutil.ResolvedPlaybook{OrigName: name, CanonicalName: name, ResolvedFile: fileName}, Source: fetched.Data}
+	cmdDefs, warnings, err := src.Commands()
+	if err != nil {
+		return fmt.Errorf("fetched %s does not parse: %w", name, err)
+	}
+	printWarnings(gopts, warnings, false)
+	var savedFiles []string
+	if latest {
+		savedFile, err := registry.SaveLocal(fetched, false)
+		if err != nil {
+			return err
+		}
+		savedFiles = append(savedFiles, savedFile)
+	}
+	if pin {
+		savedFile, err := registry.SaveLocal(fetched, true)
+		if err != nil {
+			return err
+		}
+		savedFiles = append(savedFiles, savedFile)
+	}
+	// registry playbooks are not in a trusted root, only a verified signature trusts them
+	if verifyResult.Signer != "" {
+		store, err := trust.Load()
+		if err != nil {
+			return err
+		}
+		for _, savedFile := range savedFiles {
+			err = store.Trust(savedFile)
+			if err != nil {
+				return err
+			}
+		}
+		err = store.Save()
+		if err != nil {
+			return err
+		}
+	}
+	verifiedStr := ""
+	if verifyResult.Checksum {
+		verifiedStr += ", checksum ok"
+	}
+	if verifyResult.Signer != "" {
+		verifiedStr += ", signed by " + verifyResult.Signer
+	}
+	fmt.Printf("[^scripthaus] fetched %s (%s, %d commands%s), run with 'scripthaus run %s::[command]'\n", name, revisionStr(fetched.LockEntry()), len(cmdDefs), verifiedStr, name)
+	if verifyResult.NewKey {
+		fmt.Printf("[^scripthaus] trusting %s for @%s playbooks from now on (first signed playbook from the team)\n", verifyResult.Signer, fetched.Name.Team)
+	}
+	if verifyResult.Signer == "" && !gopts.Quiet {
+		fmt.Printf("[^scripthaus] %s is not signed, review it before running its commands (or trust it with 'scripthaus trust %s')\n", name, name)
+	}
+	return nil
+}
+
+// "rev abc1234", or "sha256 abc1234" for url registries
+func revisionStr(entry pathutil.LockEntry) string {
+	if entry.Revision != "" {
+		return fmt.Sprintf("rev %.7s", entry.Revision)
+	}
+	return fmt.Sprintf("sha256 %.7s", entry.Sha256)
+}
+
+// fetches the latest version of the pinned playbooks (all of them if none are given) and updates scripthaus.lock
+func runUpdateCommand(gopts globalOptsType) (int, error) {
+	parsed, err := parseCommandArgs("update", gopts.CommandArgs)
+	if err != nil {
+		return 1, err
+	}
+	lockFileName, lockfile, err := readProjectLockfile()
+	if err != nil {
+		return 1, err
+	}
+	if lockfile == nil {
+		return 1, fmt.Errorf("no project found (no scripthaus.md in this directory or above), nothing to update")
+	}
+	args := parsed.Args
+	if len(args) == 0 {
+		args = lockfile.Names()
+	}
+	if len(args) == 0 {
+		fmt.Printf("[^scripthaus] no playbooks pinned in %s\n", lockFileName)
+		return 0, nil
+	}
+	names, err := parseRegistryNames(args)
+	if err != nil {
+		return 1, err
+	}
+	cfg := config.Get().Registry
+	exitCode := 0
+	lockChanged := false
+	for _, name := range names {
+		oldEntry, pinned := lockfile.Playbooks[name.String()]
+		fetched, err := registry.Fetch(cfg, name)
+		if err == nil && (!pinned || oldEntry.Sha256 != fetched.Sha256) {
+			err = saveFetchedPlaybook(gopts, fetched, "", true, true)
+		}
+		if err != nil {
+			if len(names) == 1 {
+				return 1, err
+			}
+			fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n", err)
+			exitCode = 1
+			continue
+		}
+		if pinned && oldEntry.Sha256 == fetched.Sha256 {
+			fmt.Printf("[^scripthaus] %s is up to date (%s)\n", name, revisionStr(oldEntry))
+			continue
+		}
+		if pinned {
+			fmt.Printf("[^scripthaus] updated %s (%s => %s)\n", name, revisionStr(oldEntry), revisionStr(fetched.LockEntry()))
+		}
+		lockfile.Playbooks[name.String()] = fetched.LockEntry()
+		lockChanged = true
+	}
+	if lockChanged {
+		err = pathutil.WriteLockfile(lockFileName, lockfile)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return exitCode, nil
+}
+
+type benchOptsType struct {
+	Script        commanddef.ScriptDef
+	RunSpec       commanddef.SpecType
+	Runs          int
+	Warmup        int
+	ShowOutput    bool
+	IgnoreFailure bool
+	FormatJson    bool
+}
+
+const defaultBenchRuns = 10
+
+func parseBenchOpts(gopts globalOptsType) (benchOptsType, error) {
+	rtn := benchOptsType{Runs: defaultBenchRuns}
+	rtn.Script.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("bench", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--runs":
+			rtn.Runs, err = strconv.Atoi(opt.Value)
+			if err != nil || rtn.Runs < 1 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be a positive number", opt.Flag, opt.Value)
+			}
+		case "--warmup":
+			rtn.Warmup, err = strconv.Atoi(opt.Value)
+			if err != nil || rtn.Warmup < 0 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be a number", opt.Flag, opt.Value)
+			}
+		case "--show-output":
+			rtn.ShowOutput = true
+		case "--ignore-failure":
+			rtn.IgnoreFailure = true
+		case "--json":
+			rtn.FormatJson = true
+		case "--nolog":
+			rtn.RunSpec.NoLog = true
+		}
+	}
+	if len(parsed.Args) == 0 {
+		return rtn, fmt.Errorf("Usage: scripthaus bench [bench-opts] [playbook]::[command] [script-opts], no command specified")
+	}
+	rtn.Script, err = resolveScript("bench", parsed.Args[0], rtn.Script.PlaybookFile, false)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.RunSpec.ScriptArgs = parsed.Args[1:]
+	return rtn, nil
+}
+
+type benchRunResult struct {
+	Duration   time.Duration
+	ExitCode   int
+	Output     []byte // stdout and stderr (not set with --show-output)
+	TermReason string
+	HItem      *history.HistoryItem // nil if the run was not logged
+}
+
+// runs the command once, the run is logged to history with the bench id (unless benchId is "")
+func runBenchOnce(cdef *commanddef.CommandDef, runSpec commanddef.SpecType, benchId string, showOutput bool) (*benchRunResult, error) {
+	var outBuf bytes.Buffer
+	runSpec.Stdin = bytes.NewReader(nil)
+	if !showOutput {
+		runSpec.Stdout = &outBuf
+		runSpec.Stderr = &outBuf
+	}
+	if benchId == "" {
+		runSpec.NoLog = true
+	}
+	execItem, err := cdef.BuildExecCommand(context.Background(), runSpec)
+	if err != nil {
+		return nil, err
+	}
+	if execItem.HItem != nil {
+		execItem.HItem.SetMetadata(history.BenchMdKey, benchId)
+	}
+	hooks := scripthaus.ExecHooks{
+		Started: func(execItems []*commanddef.ExecItem) func() {
+			return forwardInterrupts(execItems...)
+		},
+	}
+	result, err := scripthaus.RunExecItem(execItem, scripthaus.DBHistory, hooks)
+	printHistoryErr(result.HistoryErr)
+	if !result.Started {
+		return nil, err
+	}
+	return &benchRunResult{Duration: result.Duration, ExitCode: result.ExitCode, Output: outBuf.Bytes(), TermReason: result.TermReason, HItem: execItem.HItem}, nil
+}
+
+type benchStatsJson struct {
+	Runs     int     `json:"runs"`
+	MeanMs   float64 `json:"mean_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	MinMs    float64 `json:"min_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+type benchResultJson struct {
+	Command     string          `json:"command"`
+	Warmup      int             `json:"warmup"`
+	Failed      int             `json:"failed"`
+	DurationsMs []float64       `json:"durations_ms"`
+	Stats       benchStatsJson  `json:"stats"`
+	Previous    *benchStatsJson `json:"previous,omitempty"` // the previous benchmark of the command (from history)
+	PreviousTs  int64           `json:"previous_ts,omitempty"`
+}
+
+func durationMs(dur time.Duration) float64 {
+	return float64(dur.Microseconds()) / 1000
+}
+
+func makeBenchStatsJson(stats bench.Stats) benchStatsJson {
+	return benchStatsJson{Runs: stats.Runs, MeanMs: durationMs(stats.Mean), StdDevMs: durationMs(stats.StdDev), MinMs: durationMs(stats.Min), P95Ms: durationMs(stats.P95), MaxMs: durationMs(stats.Max)}
+}
+
+// runs the command Warmup times (not measured or logged), then Runs times, and prints
+// min/mean/p95/max.  runs are logged to history (tagged with a bench id), so the result is
+// compared with the previous benchmark of the same command and arguments
+func runBenchCommand(gopts globalOptsType) (int, error) {
+	benchOpts, err := parseBenchOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	cdef, err := resolveScriptCommand(benchOpts.Script, gopts)
+	if cdef == nil || err != nil {
+		return 1, err
+	}
+	if len(cdef.GetMatrix()) > 0 {
+		return 1, fmt.Errorf("cannot bench '%s', it is a matrix command (pass a cell's variables with --env to 'scripthaus run')", cdef.OrigScriptName())
+	}
+	err = cdef.CheckCommand(benchOpts.RunSpec)
+	if err != nil {
+		return 1, err
+	}
+	err = confirmRun(cdef, commanddef.RunOptsType{})
+	if err != nil {
+		return 1, err
+	}
+	warnDirenv(cdef, gopts)
+	benchId := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if !gopts.Quiet && !benchOpts.FormatJson {
+		fmt.Printf("[^scripthaus] bench '%s', %d run(s)", cdef.OrigScriptName(), benchOpts.Runs)
+		if benchOpts.Warmup > 0 {
+			fmt.Printf(" (after %d warmup run(s))", benchOpts.Warmup)
+		}
+		fmt.Printf("\n")
+	}
+	var durations []time.Duration
+	var firstItem *history.HistoryItem
+	numFailed := 0
+	for idx := 0; idx < benchOpts.Warmup+benchOpts.Runs; idx++ {
+		isWarmup := idx < benchOpts.Warmup
+		runBenchId := benchId
+		if isWarmup {
+			runBenchId = ""
+		}
+		result, err := runBenchOnce(cdef, benchOpts.RunSpec, runBenchId, benchOpts.ShowOutput)
+		if err != nil {
+			return 1, err
+		}
+		if result.TermReason != "" {
+			return 1, fmt.Errorf("bench '%s' was stopped (%s)", cdef.OrigScriptName(), result.TermReason)
+		}
+		if result.ExitCode != 0 {
+			numFailed++
+			if !benchOpts.IgnoreFailure {
+				os.Stderr.Write(result.Output)
+				return 1, fmt.Errorf("run %d of '%s' failed (exitcode=%d), use --ignore-failure to bench failing commands", idx+1, cdef.OrigScriptName(), result.ExitCode)
+			}
+		}
+		if gopts.Verbose > 0 && !benchOpts.FormatJson {
+			label := fmt.Sprintf("run %d", idx-benchOpts.Warmup+1)
+			if isWarmup {
+				label = fmt.Sprintf("warmup %d", idx+1)
+			}
+			fmt.Printf("  %-10s %0.3fs  exitcode=%d\n", label, result.Duration.Seconds(), result.ExitCode)
+		}
+		if isWarmup {
+			continue
+		}
+		durations = append(durations, result.Duration)
+		if firstItem == nil {
+			firstItem = result.HItem
+		}
+	}
+	stats := bench.Compute(durations)
+	var prevItems []*history.HistoryItem
+	if firstItem != nil {
+		prevItems, err = history.FindPreviousBench(firstItem, benchId)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] cannot read the previous benchmark from history: %v\n", err)
+		}
+	}
+	var prevStats bench.Stats
+	var prevTs int64
+	if len(prevItems) > 0 {
+		var prevDurations []time.Duration
+		for _, item := range prevItems {
+			prevDurations = append(prevDurations, time.Duration(item.DurationMs.Int64)*time.Millisecond)
+		}
+		prevStats = bench.Compute(prevDurations)
+		// items are most recent first
+		prevTs = prevItems[len(prevItems)-1].Ts
+	}
+	if benchOpts.FormatJson {
+		rtn := benchResultJson{Command: cdef.OrigScriptName(), Warmup: benchOpts.Warmup, Failed: numFailed, Stats: makeBenchStatsJson(stats)}
+		for _, dur := range durations {
+			rtn.DurationsMs = append(rtn.DurationsMs, durationMs(dur))
+		}
+		if prevStats.Runs > 0 {
+			prevJson := makeBenchStatsJson(prevStats)
+			rtn.Previous = &prevJson
+			rtn.PreviousTs = prevTs
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return 0, enc.Encode(rtn)
+	}
+	color := render.MakeColorizer(os.Stdout)
+	fmt.Printf("  %-7s %0.3fs %s\n", "mean", stats.Mean.Seconds(), color.Dim(fmt.Sprintf("± %0.3fs", stats.StdDev.Seconds())))
+	fmt.Printf("  %-7s %0.3fs\n", "min", stats.Min.Seconds())
+	fmt.Printf("  %-7s %0.3fs\n", "p95", stats.P95.Seconds())
+	fmt.Printf("  %-7s %0.3fs\n", "max", stats.Max.Seconds())
+	if numFailed > 0 {
+		fmt.Printf("  %-7s %s\n", "failed", color.Error(fmt.Sprintf("%d of %d run(s)", numFailed, benchOpts.Runs)))
+	}
+	if prevStats.Runs > 0 {
+		fmt.Printf("  %-7s %0.3fs mean of %d run(s) %s (%+0.1f%%)\n", "before", prevStats.Mean.Seconds(), prevStats.Runs, color.Dim(time.UnixMilli(prevTs).Format("[2006-01-02 15:04:05]")), bench.Change(prevStats.Mean, stats.Mean)*100)
+	}
+	return 0, nil
+}
+
+type hooksOptsType struct {
+	SubCommand   string
+	PlaybookFile string
+	Force        bool
+}
+
+func parseHooksOpts(gopts globalOptsType) (hooksOptsType, error) {
+	rtn := hooksOptsType{PlaybookFile: gopts.PlaybookFile}
+	parsed, err := parseCommandArgs("hooks", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.Force = parsed.Has("--force")
+	if len(parsed.Args) > 0 {
+		rtn.SubCommand = parsed.Args[0]
+	}
+	if len(parsed.Args) > 1 {
+		rtn.PlaybookFile = parsed.Args[1]
+	}
+	if rtn.SubCommand == "" {
+		rtn.SubCommand = "list"
+	}
+	if rtn.SubCommand != "list" && rtn.SubCommand != "install" && rtn.SubCommand != "uninstall" {
+		return rtn, fmt.Errorf("invalid hooks sub-command '%s' (must be list, install, or uninstall)", rtn.SubCommand)
+	}
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	return rtn, nil
+}
+
+func runHooksCommand(gopts globalOptsType) (int, error) {
+	hooksOpts, err := parseHooksOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	resolvedPlaybook, cmdDefs, warnings, err := loadPlaybook(hooksOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
+	}
+	topLevel, err := githooks.TopLevel(resolvedPlaybook.PlaybookDir())
+	if err != nil {
+		return 1, err
+	}
+	hooksDir, err := githooks.HooksDir(resolvedPlaybook.PlaybookDir())
+	if err != nil {
+		return 1, err
+	}
+	// hook -> names to pass to 'scripthaus run' (relative to the top of the working tree)
+	hookCmds := make(map[string][]string)
+	var hookNames []string
+	for idx := range cmdDefs {
+		cdef := &cmdDefs[idx]
+		for _, hook := range cdef.GetHooks() {
+			if !githooks.IsKnownHook(hook) {
+				warnings = append(warnings, fmt.Sprintf("command '%s', unknown git hook '%s' in 'hook' directive (ignoring)", cdef.Name, hook))
+				continue
+			}
+			relFile, err := filepath.Rel(topLevel, cdef.Playbook.ResolvedFile)
+			if err != nil || strings.HasPrefix(relFile, "..") {
+				warnings = append(warnings, fmt.Sprintf("command '%s', playbook %s is outside of the git repository %s (ignoring)", cdef.Name, cdef.Playbook.OrigShowStr(), topLevel))
+				continue
+			}
+			if hookCmds[hook] == nil {
+				hookNames = append(hookNames, hook)
+			}
+			hookCmds[hook] = append(hookCmds[hook], fmt.Sprintf("./%s::%s", filepath.ToSlash(relFile), cdef.Name))
+		}
+	}
+	sort.Strings(hookNames)
+	printWarnings(gopts, warnings, false)
+	if hooksOpts.SubCommand == "list" {
+		if len(hookNames) == 0 {
+			fmt.Printf("[^scripthaus] no 'hook' directives found in %s\n", resolvedPlaybook.OrigShowStr())
+			return 0, nil
+		}
+		for _, hook := range hookNames {
+			status := "not installed"
+			generated, err := githooks.IsGenerated(filepath.Join(hooksDir, hook))
+			if err != nil {
+				return 1, err
+			}
+			if generated {
+				status = "installed"
+			} else if _, err := os.Stat(filepath.Join(hooksDir, hook)); err == nil {
+				status = "not installed, existing hook"
+			}
+			fmt.Printf("%-20s %s (%s)\n", hook, strings.Join(hookCmds[hook], ", "), status)
+		}
+		return 0, nil
+	}
+	if hooksOpts.SubCommand == "uninstall" {
+		// nothing is declared, so the loop below removes every generated hook
+		hookNames = nil
+		hookCmds = nil
+	}
+	exitCode := 0
+	numWritten := 0
+	for _, hook := range hookNames {
+		hookFile := filepath.Join(hooksDir, hook)
+		generated, err := githooks.IsGenerated(hookFile)
+		if err != nil {
+			return 1, err
+		}
+		if _, statErr := os.Stat(hookFile); statErr == nil && !generated && !hooksOpts.Force {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] %s exists and was not generated by scripthaus, use --force to overwrite it\n", hookFile)
+			exitCode = 1
+			continue
+		}
+		relSource, _ := filepath.Rel(topLevel, resolvedPlaybook.ResolvedFile)
+		err = os.MkdirAll(hooksDir, 0755)
+		if err == nil {
+			err = os.WriteFile(hookFile, []byte(githooks.MakeShim(hook, filepath.ToSlash(relSource), hookCmds[hook])), 0755)
+		}
+		if err == nil {
+			err = os.Chmod(hookFile, 0755)
+		}
+		if err != nil {
+			return 1, fmt.Errorf("cannot write hook %s: %w", hookFile, err)
+		}
+		numWritten++
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] installed %s hook (%s)\n", hook, strings.Join(hookCmds[hook], ", "))
+		}
+	}
+	// remove shims we generated for hooks that are no longer declared
+	entries, err := os.ReadDir(hooksDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 1, fmt.Errorf("cannot read hooks directory %s: %w", hooksDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || hookCmds[entry.Name()] != nil {
+			continue
+		}
+		hookFile := filepath.Join(hooksDir, entry.Name())
+		generated, err := githooks.IsGenerated(hookFile)
+		if err != nil || !generated {
+			continue
+		}
+		err = os.Remove(hookFile)
+		if err != nil {
+			return 1, fmt.Errorf("cannot remove hook %s: %w", hookFile, err)
+		}
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] removed %s hook\n", entry.Name())
+		}
+	}
+	if hooksOpts.SubCommand == "install" && numWritten == 0 && exitCode == 0 && !gopts.Quiet {
+		fmt.Printf("[^scripthaus] no 'hook' directives found in %s\n", resolvedPlaybook.OrigShowStr())
+	}
+	if numWritten > 0 {
+		// hooks run without a terminal (no trust prompt), installing them approves the playbooks
+		var hookFiles []string
+		for idx := range cmdDefs {
+			if len(cmdDefs[idx].GetHooks()) > 0 {
+				hookFiles = append(hookFiles, cmdDefs[idx].Playbook.ResolvedFile)
+			}
+		}
+		err = trustPlaybookFiles(hookFiles, gopts)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return exitCode, nil
+}
+
+type trustOptsType struct {
+	PlaybookFile string
+	List         bool
+	Revoke       bool
+}
+
+func parseTrustOpts(gopts globalOptsType) (trustOptsType, error) {
+	rtn := trustOptsType{PlaybookFile: gopts.PlaybookFile}
+	parsed, err := parseCommandArgs("trust", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.List = parsed.Has("--list")
+	rtn.Revoke = parsed.Has("--revoke")
+	if rtn.List && rtn.Revoke {
+		return rtn, fmt.Errorf("--list and --revoke cannot be used together")
+	}
+	if len(parsed.Args) > 0 {
+		if rtn.List {
+			return rtn, fmt.Errorf("--list does not take a playbook")
+		}
+		rtn.PlaybookFile = parsed.Args[0]
+	}
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	if rtn.PlaybookFile == "-" {
+		return rtn, fmt.Errorf("playbooks read from stdin cannot be trusted (they are always run)")
+	}
+	return rtn, nil
+}
+
+// approves the playbooks' current contents (skips duplicates and playbooks that are already trusted)
+func trustPlaybookFiles(files []string, gopts globalOptsType) error {
+	store, err := trust.Load()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	changed := false
+	for _, fileName := range files {
+		if seen[fileName] {
+			continue
+		}
+		seen[fileName] = true
+		status, err := store.Check(fileName, config.Get().TrustedRoots)
+		if err != nil {
+			return err
+		}
+		if status == trust.StatusTrusted {
+			if gopts.Verbose > 0 {
+				fmt.Printf("[^scripthaus] %s is already trusted\n", fileName)
+			}
+			continue
+		}
+		err = store.Trust(fileName)
+		if err != nil {
+			return err
+		}
+		changed = true
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] trusted %s\n", fileName)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return store.Save()
+}
+
+func runTrustCommand(gopts globalOptsType) (int, error) {
+	trustOpts, err := parseTrustOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	if trustOpts.List {
+		store, err := trust.Load()
+		if err != nil {
+			return 1, err
+		}
+		entries := store.List()
+		if len(entries) == 0 {
+			fmt.Printf("[^scripthaus] no trusted playbooks (%s)\n", store.FileName)
+		}
+		for _, entry := range entries {
+			fmt.Printf("%-8s %s\n", entry.Status, entry.File)
+		}
+		for _, root := range config.Get().TrustedRoots {
+			fmt.Printf("%-8s %s\n", "root", root)
+		}
+		return 0, nil
+	}
+	if trustOpts.Revoke {
+		store, err := trust.Load()
+		if err != nil {
+			return 1, err
+		}
+		// a playbook that no longer exists can still be revoked by its path
+		fileName, err := filepath.Abs(trustOpts.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+		if _, found := store.Playbooks[fileName]; !found {
+			resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(trustOpts.PlaybookFile)
+			if err != nil {
+				return 1, err
+			}
+			fileName = resolvedPlaybook.ResolvedFile
+		}
+		if !store.Revoke(fileName) {
+			return 1, fmt.Errorf("playbook '%s' is not in the trust store", fileName)
+		}
+		err = store.Save()
+		if err != nil {
+			return 1, err
+		}
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] revoked trust for %s\n", fileName)
+		}
+		return 0, nil
+	}
+	// the playbook must parse, its includes are trusted with it
+	resolvedPlaybook, cmdDefs, warnings, err := loadPlaybook(trustOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
+	}
+	printWarnings(gopts, warnings, false)
+	files := []string{resolvedPlaybook.ResolvedFile}
+	for idx := range cmdDefs {
+		files = append(files, cmdDefs[idx].Playbook.ResolvedFile)
+	}
+	if trust.InTrustedRoot(resolvedPlaybook.ResolvedFile, config.Get().TrustedRoots) && !gopts.Quiet {
+		fmt.Printf("[^scripthaus] %s is in a trusted root (SCRIPTHAUS_HOME or trusted_roots), it is always trusted\n", resolvedPlaybook.ResolvedFile)
+	}
+	err = trustPlaybookFiles(files, gopts)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func runShowCommand(gopts globalOptsType) (int, error) {
+	showOpts, err := parseShowOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	if showOpts.Script.PlaybookFile == "" && showOpts.Script.PlaybookCommand == "" {
+		return 1, fmt.Errorf("Usage: scripthaus show [playbook]::[script], no playbook specified")
+	}
+	if showOpts.Script.PlaybookCommand == "" && showOpts.Select == "" {
+		return runListCommandInternal(gopts, listOptsType{PlaybookFile: showOpts.Script.PlaybookFile, Verbose: gopts.Verbose > 0})
+	}
+	foundCommand, err := resolveScriptCommand(showOpts.Script, gopts)
+	if foundCommand == nil || err != nil {
+		return 1, err
+	}
+	if showOpts.Select == showSelectCode {
+		// script text only (no banners) so the output can be piped, e.g. "scripthaus show --code .build | sh"
+		fmt.Print(foundCommand.ScriptText)
+		if !strings.HasSuffix(foundCommand.ScriptText, "\n") {
+			fmt.Printf("\n")
+		}
+		return 0, nil
+	}
+	renderMd := !showOpts.Raw && render.ColorEnabled(os.Stdout)
+	if showOpts.Select == showSelectDoc {
+		if foundCommand.HelpText != "" && renderMd {
+			fmt.Print(render.Markdown(foundCommand.HelpText))
+		} else if foundCommand.HelpText != "" {
+			fmt.Printf("%s\n", foundCommand.HelpText)
+		}
+		return 0, nil
+	}
+	if showOpts.Select == showSelectMeta {
+		fmt.Print(foundCommand.MetaStr())
+		return 0, nil
+	}
+	fmt.Printf("[^scripthaus] show '%s' (%s)\n\n", foundCommand.FullScriptName(), foundCommand.Location())
+	argsHelp := foundCommand.ArgsHelpStr()
+	if argsHelp != "" {
+		fmt.Printf("Usage: scripthaus run %s\n\n%s\n", foundCommand.UsageStr(), argsHelp)
+	}
+	if renderMd {
+		if foundCommand.HelpText != "" {
+			fmt.Printf("%s\n", render.Markdown(foundCommand.HelpText))
+		}
+		fmt.Printf("%s\n", render.Markdown(foundCommand.RawCodeText))
+		return 0, nil
+	}
+	fmt.Printf("%s\n\n%s\n\n", foundCommand.HelpText, foundCommand.RawCodeText)
+	return 0, nil
+}
+
+type addOptsType struct {
+	Script     commanddef.ScriptDef
+	ScriptType string
+	ScriptText string
+	ShortDesc  string
+	Message    string
+	Section    string
+	Edit       bool
+	HistoryId  int
+	DryRun     bool
+}
+
+func parseAddOpts(opts globalOptsType) (addOptsType, error) {
+	var rtn addOptsType
+	rtn.Script.PlaybookFile = opts.PlaybookFile
+	parsed, err := parseCommandArgs("add", opts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--type":
+			rtn.ScriptType = opt.Value
+		case "--message":
+			rtn.Message = opt.Value
+		case "--short-desc":
+			rtn.ShortDesc = opt.Value
+			if strings.Index(rtn.ShortDesc, "\n") != -1 {
+				return rtn, fmt.Errorf("'%s [desc]' short description cannot contain a newline character", opt.Flag)
+			}
+			if len(rtn.ShortDesc) > 80 {
+				return rtn, fmt.Errorf("'%s [desc]' short description cannot be more than 80 characters", opt.Flag)
+			}
+		case "-c":
+			rtn.ScriptText = opt.Value
+		case "--section":
+			rtn.Section = strings.TrimSpace(opt.Value)
+		case "--edit":
+			rtn.Edit = true
+		case "--from-history":
+			rtn.HistoryId, err = strconv.Atoi(opt.Value)
+			if err != nil || rtn.HistoryId <= 0 {
+				return rtn, fmt.Errorf("'%s [history-id]' invalid history id '%s'", opt.Flag, opt.Value)
+			}
+		case "--dry-run":
+			rtn.DryRun = true
+		}
+	}
+	// the arguments after "--" are the script text
+	targetArgs := parsed.Args
+	if parsed.RestIdx != -1 {
+		targetArgs = parsed.Args[:parsed.RestIdx]
+		rtn.ScriptText = strings.Join(parsed.Args[parsed.RestIdx:], " ")
+	}
+	var targetStr string
+	for _, argStr := range targetArgs {
+		if argStr == "-" {
+			rtn.ScriptText = "-" // stdin
+			continue
+		}
+		if targetStr != "" {
+			return rtn, fmt.Errorf("Usage: scripthaus add [opts] [playbook]::[script], too many arguments passed, extras = '%s'", argStr)
+		}
+		targetStr = argStr
+	}
+	if targetStr != "" {
+		rtn.Script, err = resolveScript("add", targetStr, rtn.Script.PlaybookFile, false)
+		if err != nil {
+			return rtn, err
+		}
+		if rtn.Script.PlaybookFile == "" {
+			// add never searches SCRIPTHAUS_PATH, defaults to the project playbook
+			rtn.Script.PlaybookFile = "."
+		}
+	}
+	if rtn.Script.PlaybookFile == "" {
+		return rtn, fmt.Errorf("No playbook/script passed to 'add' command.  Usage: scripthaus add [opts] [playbook]::[script]")
+	}
+	if rtn.HistoryId > 0 && rtn.ScriptText != "" {
+		return rtn, fmt.Errorf("cannot pass script text to 'add' command with --from-history")
+	}
+	if rtn.ScriptText == "" && !rtn.Edit && rtn.HistoryId == 0 {
+		return rtn, fmt.Errorf("No script text passed to 'add' command.  Use '-c [script-text]', '--' for rest of arguments, '-' for stdin, '--from-history [id]', or '--edit'")
+	}
+	return rtn, nil
+}
+
+func runAddCommand(gopts globalOptsType) (int, error) {
+	addOpts, err := parseAddOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	var realScriptText string
+	if addOpts.HistoryId > 0 {
+		hitem, err := history.GetHistoryItem(addOpts.HistoryId)
+		if err != nil {
+			return 1, err
+		}
+		if hitem == nil {
+			return 1, fmt.Errorf("history item %d not found", addOpts.HistoryId)
+		}
+		realScriptText = hitem.CmdLineStr()
+		if realScriptText == "" {
+			return 1, fmt.Errorf("history item %d has an empty command line", addOpts.HistoryId)
+		}
+		if addOpts.ScriptType == "" {
+			// infer the script type from the original run
+			addOpts.ScriptType = hitem.ScriptType
+		}
+	} else if addOpts.ScriptText == "-" {
+		scriptTextBytes, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return 1, fmt.Errorf("reading from <stdin>: %w", err)
+		}
+		if len(scriptTextBytes) == 0 {
+			return 1, fmt.Errorf("reading script-text from <stdin>, but got empty string")
+		}
+		realScriptText = string(scriptTextBytes)
+	} else {
+		realScriptText = addOpts.ScriptText
+	}
+	if len(realScriptText) > 5000 {
+		return 1, fmt.Errorf("script-text too long, max-size for add is 5k (edit the file manually if this was not a mistake)")
+	}
+	if strings.Index(realScriptText, "```") != -1 {
+		return 1, fmt.Errorf("script-text cannot contain the the markdown code fence characters \"```\", this block must be added to the .md file manually")
+	}
+	if addOpts.Script.PlaybookFile == "-" || addOpts.Script.PlaybookFile == "<stdin>" {
+		return 1, fmt.Errorf("playbook file cannot be '-' (<stdin>) for 'add' command")
+	}
+	if addOpts.ScriptType == "" {
+		return 1, fmt.Errorf("must specify a script-type using '-t'")
+	}
+	if realType, _ := config.Get().ResolveLangAlias(addOpts.ScriptType); base.IsValidScriptType(realType) {
+		// write the block with the real script type (e.g. "-t py" => python3)
+		addOpts.ScriptType = realType
+	}
+	if !base.IsValidScriptType(addOpts.ScriptType) {
+		return 1, fmt.Errorf("must specify a valid script type ('%s' is not valid), must be one of: %s", addOpts.ScriptType, strings.Join(base.ValidScriptTypes(), ", "))
+	}
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(addOpts.Script.PlaybookFile)
+	if err != nil {
+		if strings.Index(err.Error(), "not found") != -1 {
+			fmt.Printf("[^scripthaus] add will not create a new markdown file.  touch the file and re-run the add if this was your intention\n")
+		}
+		return 1, err
+	}
+	cmdDefs, _, err := loadResolvedPlaybook(resolvedPlaybook)
+	if err != nil {
+		return 1, err
+	}
+	for _, def := range cmdDefs {
+		if def.Name == addOpts.Script.PlaybookCommand {
+			return 1, fmt.Errorf("script with name '%s' already exists in playbook file %s", addOpts.Script.PlaybookCommand, resolvedPlaybook.OrigShowStr())
+		}
+	}
+	var buf bytes.Buffer
+	blockText := makeAddBlockText(addOpts, realScriptText)
+	if addOpts.Edit {
+		blockText, err = editAddBlockText(addOpts, resolvedPlaybook, blockText)
+		if err != nil {
+			return 1, err
+		}
+	}
+	fmt.Printf("[^scripthaus] adding command '%s' to %s:\n", addOpts.Script.PlaybookCommand, resolvedPlaybook.OrigShowStr())
+	buf.WriteString("\n")
+	buf.WriteString(blockText)
+	fmt.Printf("%s\n", buf.String())
+	if addOpts.DryRun {
+		fmt.Printf("[^scripthaus] Not modifying file, --dry-run specified\n")
+		return 0, nil
+	}
+	err = pathutil.UpdateFileLocked(resolvedPlaybook.ResolvedFile, func(mdSource []byte) ([]byte, error) {
+		// re-check under the lock, the playbook could have changed since it was read
+		curDefs, _, err := mdparser.ParseCommands(resolvedPlaybook, mdSource)
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range curDefs {
+			if def.Name == addOpts.Script.PlaybookCommand {
+				return nil, fmt.Errorf("script with name '%s' already exists in playbook file %s", addOpts.Script.PlaybookCommand, resolvedPlaybook.OrigShowStr())
+			}
+		}
+		if addOpts.Section != "" {
+			return mdparser.InsertCommandText(mdSource, buf.String(), addOpts.Section), nil
+		}
+		newSource := append([]byte{}, mdSource...)
+		if len(newSource) > 0 && newSource[len(newSource)-1] != '\n' {
+			newSource = append(newSource, '\n')
+		}
+		return append(newSource, buf.Bytes()...), nil
+	})
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// returns the markdown for a new command (help message and code block)
+func makeAddBlockText(addOpts addOptsType, scriptText string) string {
+	var buf bytes.Buffer
+	message := addOpts.Message
+	if message == "" && addOpts.Edit {
+		message = addEditMessagePlaceholder
+	}
+	if message != "" {
+		buf.WriteString(fmt.Sprintf("%s\n\n", message))
+	}
+	buf.WriteString(fmt.Sprintf("```%s\n", addOpts.ScriptType))
+	shortDesc := addOpts.ShortDesc
+	if shortDesc == "" && addOpts.Edit {
+		shortDesc = addEditShortDescPlaceholder
+	}
+	if shortDesc != "" {
+		shortDesc = " - " + shortDesc
+	}
+	buf.WriteString(fmt.Sprintf("%s @scripthaus command %s%s\n", base.GetCommentString(addOpts.ScriptType), addOpts.Script.PlaybookCommand, shortDesc))
+	buf.WriteString(fmt.Sprintf("%s\n", strings.TrimRight(scriptText, "\n")))
+	buf.WriteString(fmt.Sprintf("```\n"))
+	return buf.String()
+}
+
+const addEditMessagePlaceholder = "Help text for the command (markdown)."
+const addEditShortDescPlaceholder = "short description"
+
+// opens the new command's markdown (prefilled from addOpts) in $EDITOR, then validates that the
+// result contains exactly one command with the expected name.  on failure the edited file is kept
+func editAddBlockText(addOpts addOptsType, playbook *pathutil.ResolvedPlaybook, templateText string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "scripthaus-add-*.md")
+	if err != nil {
+		return "", fmt.Errorf("cannot create temp file for --edit: %w", err)
+	}
+	tmpName := tmpFile.Name()
+	_, err = tmpFile.WriteString(templateText)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("cannot write temp file for --edit: %w", err)
+	}
+	// open with the cursor on the script (the line after the command directive)
+	lineNo := strings.Count(templateText[:strings.Index(templateText, "@scripthaus command")], "\n") + 2
+	editorArgs, err := makeEditorArgs(tmpName, lineNo)
+	if err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+	editCmd := exec.Command(editorArgs[0], editorArgs[1:]...)
+	editCmd.Stdin = os.Stdin
+	editCmd.Stdout = os.Stdout
+	editCmd.Stderr = os.Stderr
+	err = editCmd.Run()
+	if err != nil {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("editor '%s' failed, command not added: %w", editorArgs[0], err)
+	}
+	editedBytes, err := os.ReadFile(tmpName)
+	if err != nil {
+		return "", fmt.Errorf("cannot read edited file '%s': %w", tmpName, err)
+	}
+	editedText := strings.Trim(string(editedBytes), "\n") + "\n"
+	if strings.TrimSpace(editedText) == "" || editedText == templateText {
+		os.Remove(tmpName)
+		return "", fmt.Errorf("command text was empty or not modified, command not added")
+	}
+	cmdDefs, warnings, err := mdparser.ParseCommands(playbook, []byte(editedText))
+	if err == nil && len(cmdDefs) != 1 {
+		err = fmt.Errorf("edited text must contain exactly one command, found %d", len(cmdDefs))
+	} else if err == nil && cmdDefs[0].Name != addOpts.Script.PlaybookCommand {
+		err = fmt.Errorf("edited command is named '%s', expected '%s'", cmdDefs[0].Name, addOpts.Script.PlaybookCommand)
+	}
+	if err != nil {
+		for _, warning := range warnings {
+			fmt.Printf("[^scripthaus] WARNING %s\n", warning)
+		}
+		return "", fmt.Errorf("%v (edited text saved in '%s')", err, tmpName)
+	}
+	os.Remove(tmpName)
+	return editedText, nil
+}
+
+func parseWhichOpts(gopts globalOptsType) (commanddef.ScriptDef, error) {
+	var rtn commanddef.ScriptDef
+	rtn.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("which", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	if len(parsed.Args) > 0 {
+		rtn, err = resolveScript("which", parsed.Args[0], rtn.PlaybookFile, true)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	if rtn.PlaybookFile == "" && rtn.PlaybookCommand == "" {
+		rtn.PlaybookFile = "."
+	}
+	return rtn, nil
+}
+
+// prints how a playbook (and command) resolves, a debugging view of the resolver
+func runWhichCommand(gopts globalOptsType) (int, error) {
+	script, err := parseWhichOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	resolver := pathutil.DefaultResolver()
+	var playbook *pathutil.ResolvedPlaybook
+	var foundCommand *commanddef.CommandDef
+	source := "playbook name"
+	if script.PlaybookCommand == "" {
+		playbook, err = resolver.ResolvePlaybook(script.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+	} else {
+		if script.PlaybookFile == "" {
+			source = base.ScPathVarName
+		}
+		foundCommand, err = resolvePlaybookCommand(script.PlaybookFile, script.PlaybookCommand, gopts)
+		if foundCommand == nil || err != nil {
+			return 1, err
+		}
+		playbook = foundCommand.Playbook
+	}
+	cwd, _ := resolver.Getwd()
+	projectRoot, err := resolver.FindPrefixDir(".")
+	if err != nil {
+		projectRoot = "(none)"
+	}
+	scHome, err := resolver.GetScHomeDir()
+	if err != nil {
+		scHome = "(none)"
+	}
+	if foundCommand != nil {
+		fmt.Printf("command:        %s\n", foundCommand.Name)
+		if len(foundCommand.Aliases) > 0 {
+			fmt.Printf("aliases:        %s\n", strings.Join(foundCommand.Aliases, ", "))
+		}
+		fmt.Printf("location:       %s:%d\n", playbook.ResolvedFile, foundCommand.StartLineNo)
+	}
+	fmt.Printf("playbook:       %s\n", playbook.OrigName)
+	fmt.Printf("resolved file:  %s\n", playbook.ResolvedFile)
+	fmt.Printf("resolved by:    %s\n", source)
+	if playbook.ProjectDir != "" {
+		fmt.Printf("playbook root:  %s\n", playbook.ProjectDir)
+	}
+	fmt.Printf("canonical name: %s (recorded in history)\n", playbook.CanonicalName)
+	fmt.Printf("cwd:            %s\n", cwd)
+	fmt.Printf("project root:   %s\n", projectRoot)
+	fmt.Printf("global dir:     %s\n", scHome)
+	return 0, nil
+}
+
+type importOptsType struct {
+	SourceFile   string
+	Source       string
+	PlaybookFile string
+	Section      string
+	DryRun       bool
+}
+
+func parseImportOpts(gopts globalOptsType) (importOptsType, error) {
+	rtn := importOptsType{PlaybookFile: gopts.PlaybookFile}
+	parsed, err := parseCommandArgs("import", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--from":
+			rtn.Source = opt.Value
+		case "--section":
+			rtn.Section = strings.TrimSpace(opt.Value)
+		case "--dry-run":
+			rtn.DryRun = true
+		}
+	}
+	posArgs := parsed.Args
+	if len(posArgs) == 0 {
+		return rtn, fmt.Errorf("Usage: scripthaus import [import-opts] [source-file] [playbook], no source file specified")
+	}
+	if len(posArgs) == 2 && gopts.PlaybookFile != "" {
+		return rtn, fmt.Errorf("Usage: scripthaus import [import-opts] [source-file] [playbook], too many arguments passed")
+	}
+	rtn.SourceFile = posArgs[0]
+	if len(posArgs) == 2 {
+		rtn.PlaybookFile = posArgs[1]
+	}
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	if rtn.Source == "" {
+		rtn.Source = importer.DetectSource(rtn.SourceFile)
+		if rtn.Source == "" {
+			return rtn, fmt.Errorf("cannot detect the type of '%s', use --from [%s|%s]", rtn.SourceFile, importer.SourceNpm, importer.SourceMake)
+		}
+	}
+	return rtn, nil
+}
+
+// the script text for an imported command, which runs in the source file's directory
+func makeImportScriptText(cmd importer.Command, sourceDir string, playbookDir string) string {
+	var buf strings.Builder
+	buf.WriteString("# @scripthaus cd :playbook\n")
+	if relDir, err := filepath.Rel(playbookDir, sourceDir); err == nil && relDir != "." {
+		buf.WriteString(fmt.Sprintf("cd %s\n", shellescape.Quote(filepath.ToSlash(relDir))))
+	}
+	buf.WriteString(cmd.ScriptText)
+	return buf.String()
+}
+
+func skippedWarnings(skipped []string, playbook *pathutil.ResolvedPlaybook) []string {
+	var rtn []string
+	for _, name := range skipped {
+		rtn = append(rtn, fmt.Sprintf("command '%s' already exists in %s (skipping)", name, playbook.OrigShowStr()))
+	}
+	return rtn
+}
+
+func runImportCommand(gopts globalOptsType) (int, error) {
+	importOpts, err := parseImportOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	found, sourceData, err := pathutil.TryReadFile(importOpts.SourceFile, "import file", false)
+	if err != nil {
+		return 1, err
+	}
+	if !found {
+		return 1, fmt.Errorf("cannot find import file '%s'", importOpts.SourceFile)
+	}
+	importCmds, warnings, err := importer.Parse(importOpts.Source, sourceData)
+	if err != nil {
+		return 1, fmt.Errorf("cannot import '%s': %w", importOpts.SourceFile, err)
+	}
+	if importOpts.PlaybookFile == "-" || importOpts.PlaybookFile == "<stdin>" {
+		return 1, fmt.Errorf("playbook file cannot be '-' (<stdin>) for 'import' command")
+	}
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(importOpts.PlaybookFile)
+	if err != nil {
+		if strings.Index(err.Error(), "not found") != -1 {
+			fmt.Printf("[^scripthaus] import will not create a new markdown file.  touch the file and re-run the import if this was your intention\n")
+		}
+		return 1, err
+	}
+	sourceDir, err := filepath.Abs(filepath.Dir(importOpts.SourceFile))
+	if err != nil {
+		return 1, err
+	}
+	var blockTexts []string
+	var importedNames []string
+	for _, cmd := range importCmds {
+		if strings.Index(cmd.ScriptText, "```") != -1 {
+			warnings = append(warnings, fmt.Sprintf("'%s' contains the markdown code fence characters \"```\" (skipping)", cmd.OrigName))
+			continue
+		}
+		shortDesc := strings.TrimSpace(cmd.ShortText)
+		if len(shortDesc) > 80 {
+			shortDesc = shortDesc[0:77] + "..."
+		}
+		addOpts := addOptsType{
+			Script:     commanddef.ScriptDef{PlaybookCommand: cmd.Name},
+			ScriptType: "bash",
+			ShortDesc:  shortDesc,
+		}
+		blockTexts = append(blockTexts, makeAddBlockText(addOpts, makeImportScriptText(cmd, sourceDir, resolvedPlaybook.PlaybookDir())))
+		importedNames = append(importedNames, cmd.Name)
+	}
+	var skipped []string
+	// existing commands are skipped (checked again under the lock when writing)
+	makeNewSource := func(mdSource []byte) ([]byte, error) {
+		curDefs, _, err := mdparser.ParseCommands(resolvedPlaybook, mdSource)
+		if err != nil {
+			return nil, err
+		}
+		existing := make(map[string]bool)
+		for _, def := range curDefs {
+			existing[def.Name] = true
+		}
+		var keptTexts []string
+		skipped = nil
+		for idx, name := range importedNames {
+			if existing[name] {
+				skipped = append(skipped, name)
+				continue
+			}
+			keptTexts = append(keptTexts, blockTexts[idx])
+		}
+		if len(keptTexts) == 0 {
+			return nil, fmt.Errorf("no new commands to import into %s", resolvedPlaybook.OrigShowStr())
+		}
+		return mdparser.InsertCommandText(mdSource, strings.Join(keptTexts, "\n"), importOpts.Section), nil
+	}
+	if importOpts.DryRun {
+		_, mdSource, err := pathutil.TryReadFile(resolvedPlaybook.ResolvedFile, "playbook", false)
+		if err != nil {
+			return 1, err
+		}
+		newSource, err := makeNewSource(mdSource)
+		printWarnings(gopts, append(warnings, skippedWarnings(skipped, resolvedPlaybook)...), false)
+		if err != nil {
+			return 1, err
+		}
+		fmt.Printf("%s", newSource)
+		fmt.Printf("[^scripthaus] Not modifying file, --dry-run specified\n")
+		return 0, nil
+	}
+	err = pathutil.UpdateFileLocked(resolvedPlaybook.ResolvedFile, makeNewSource)
+	printWarnings(gopts, append(warnings, skippedWarnings(skipped, resolvedPlaybook)...), false)
+	if err != nil {
+		return 1, err
+	}
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] imported %d command(s) from %s into %s\n", len(importedNames)-len(skipped), importOpts.SourceFile, resolvedPlaybook.OrigShowStr())
+	}
+	return 0, nil
+}
+
+type exportOptsType struct {
+	PlaybookFile string
+	Format       string
+	OutputFile   string
+	Force        bool
+}
+
+func parseExportOpts(gopts globalOptsType) (exportOptsType, error) {
+	rtn := exportOptsType{PlaybookFile: gopts.PlaybookFile, Format: export.FormatMake}
+	parsed, err := parseCommandArgs("export", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--format":
+			rtn.Format = opt.Value
+		case "--output":
+			rtn.OutputFile = opt.Value
+		case "--force":
+			rtn.Force = true
+		}
+	}
+	if len(parsed.Args) > 0 {
+		rtn.PlaybookFile = parsed.Args[0]
+	}
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	if export.DefaultFileName(rtn.Format) == "" {
+		return rtn, fmt.Errorf("invalid export format '%s', must be one of: %s", rtn.Format, strings.Join(export.Formats(), ", "))
+	}
+	return rtn, nil
+}
+
+func runExportCommand(gopts globalOptsType) (int, error) {
+	exportOpts, err := parseExportOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	resolvedPlaybook, cmdDefs, warnings, err := loadPlaybook(exportOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
+	}
+	printWarnings(gopts, warnings, false)
+	if len(cmdDefs) == 0 {
+		return 1, fmt.Errorf("playbook %s has no commands to export", resolvedPlaybook.OrigShowStr())
+	}
+	var targets []export.Target
+	for _, cdef := range cmdDefs {
+		targets = append(targets, export.Target{
+			Name:    cdef.Name,
+			RunName: cdef.OrigScriptName(),
+			Desc:    cdef.ShortText,
+		})
+	}
+	var buf bytes.Buffer
+	exportWarnings, err := export.Write(&buf, exportOpts.Format, resolvedPlaybook.OrigName, targets)
+	if err != nil {
+		return 1, err
+	}
+	printWarnings(gopts, exportWarnings, false)
+	if exportOpts.OutputFile == "" {
+		fmt.Print(buf.String())
+		return 0, nil
+	}
+	found, existing, err := pathutil.TryReadFile(exportOpts.OutputFile, "output", false)
+	if err != nil {
+		return 1, err
+	}
+	if found && !exportOpts.Force && !bytes.Contains(existing, []byte("generated by 'scripthaus export'")) {
+		return 1, fmt.Errorf("'%s' exists and was not generated by scripthaus export, use --force to overwrite it", exportOpts.OutputFile)
+	}
+	err = os.WriteFile(exportOpts.OutputFile, buf.Bytes(), 0644)
+	if err != nil {
+		return 1, fmt.Errorf("cannot write '%s': %w", exportOpts.OutputFile, err)
+	}
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] exported %d command(s) from %s to %s\n", len(targets), resolvedPlaybook.OrigShowStr(), exportOpts.OutputFile)
+	}
+	return 0, nil
+}
+
+type exportScriptOptsType struct {
+	Script     commanddef.ScriptDef
+	OutputFile string
+	Force      bool
+}
+
+func parseExportScriptOpts(gopts globalOptsType) (exportScriptOptsType, error) {
+	var rtn exportScriptOptsType
+	rtn.Script.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("export-script", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--output":
+			rtn.OutputFile = opt.Value
+		case "--force":
+			rtn.Force = true
+		}
+	}
+	if len(parsed.Args) == 0 {
+		return rtn, fmt.Errorf("Usage: scripthaus export-script [playbook]::[command], no command specified")
+	}
+	rtn.Script, err = resolveScript("export-script", parsed.Args[0], rtn.Script.PlaybookFile, false)
+	if err != nil {
+		return rtn, err
+	}
+	return rtn, nil
+}
+
+func runExportScriptCommand(gopts globalOptsType) (int, error) {
+	exportOpts, err := parseExportScriptOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	foundCommand, err := resolvePlaybookCommand(exportOpts.Script.PlaybookFile, exportOpts.Script.PlaybookCommand, gopts)
+	if foundCommand == nil || err != nil {
+		return 1, err
+	}
+	scriptText, warnings, err := foundCommand.StandaloneScript()
+	if err != nil {
+		return 1, err
+	}
+	printWarnings(gopts, append(foundCommand.Warnings, warnings...), false)
+	if exportOpts.OutputFile == "" {
+		fmt.Print(scriptText)
+		return 0, nil
+	}
+	found, existing, err := pathutil.TryReadFile(exportOpts.OutputFile, "output", false)
+	if err != nil {
+		return 1, err
+	}
+	if found && !exportOpts.Force && !bytes.Contains(existing, []byte(commanddef.StandaloneMarker)) {
+		return 1, fmt.Errorf("'%s' exists and was not generated by scripthaus export-script, use --force to overwrite it", exportOpts.OutputFile)
+	}
+	err = os.WriteFile(exportOpts.OutputFile, []byte(scriptText), 0755)
+	if err == nil {
+		// WriteFile does not change the mode of an existing file
+		err = os.Chmod(exportOpts.OutputFile, 0755)
+	}
+	if err != nil {
+		return 1, fmt.Errorf("cannot write '%s': %w", exportOpts.OutputFile, err)
+	}
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] exported command '%s' to %s\n", foundCommand.OrigScriptName(), exportOpts.OutputFile)
+	}
+	return 0, nil
+}
+
+type docsOptsType struct {
+	OutputDir string
+	Format    string
+	Title     string
+}
+
+func parseDocsOpts(gopts globalOptsType) (docsOptsType, error) {
+	rtn := docsOptsType{Format: docsite.FormatHtml, Title: "Playbooks"}
+	parsed, err := parseCommandArgs("docs", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--format":
+			rtn.Format = opt.Value
+			if rtn.Format == "md" {
+				rtn.Format = docsite.FormatMarkdown
+			}
+		case "--title":
+			rtn.Title = opt.Value
+		}
+	}
+	if len(parsed.Args) > 0 {
+		rtn.OutputDir = parsed.Args[0]
+	}
+	if rtn.OutputDir == "" {
+		rtn.OutputDir = "scripthaus-docs"
+	}
+	if rtn.Format != docsite.FormatHtml && rtn.Format != docsite.FormatMarkdown {
+		return rtn, fmt.Errorf("invalid docs format '%s', must be one of: %s, %s", rtn.Format, docsite.FormatHtml, docsite.FormatMarkdown)
+	}
+	return rtn, nil
+}
+
+func runDocsCommand(gopts globalOptsType) (int, error) {
+	docsOpts, err := parseDocsOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	var playbooks []*pathutil.ResolvedPlaybook
+	if gopts.PlaybookFile != "" {
+		resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(gopts.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+		playbooks = append(playbooks, resolvedPlaybook)
+	} else {
+		playbooks, err = findProjectPlaybooks()
+		if err != nil {
+			return 1, err
+		}
+	}
+	outDir, err := filepath.Abs(docsOpts.OutputDir)
+	if err != nil {
+		return 1, err
+	}
+	var sitePlaybooks []docsite.Playbook
+	for _, playbook := range playbooks {
+		if strings.HasPrefix(playbook.ResolvedFile, outDir+string(filepath.Separator)) {
+			// generated by a previous run (markdown format)
+			continue
+		}
+		src, err := mdparser.ReadPlaybookSource(playbook)
+		if err != nil {
+			printWarnings(gopts, []string{fmt.Sprintf("cannot read playbook %s (skipping)", playbook.OrigShowStr())}, false)
+			continue
+		}
+		cmdDefs, warnings, err := src.Commands()
+		if err != nil {
+			printWarnings(gopts, []string{err.Error()}, false)
+			continue
+		}
+		printWarnings(gopts, warnings, false)
+		sitePb := docsite.Playbook{Playbook: playbook, Source: src.Source}
+		for idx := range cmdDefs {
+			sitePb.Commands = append(sitePb.Commands, &cmdDefs[idx])
+		}
+		sitePlaybooks = append(sitePlaybooks, sitePb)
+	}
+	if len(sitePlaybooks) == 0 {
+		return 1, fmt.Errorf("no playbooks found")
+	}
+	numFiles, err := docsite.MakeSite(docsOpts.Title, sitePlaybooks).Write(docsOpts.OutputDir, docsOpts.Format)
+	if err != nil {
+		return 1, err
+	}
+	if !gopts.Quiet {
+		indexName := "index.html"
+		if docsOpts.Format == docsite.FormatMarkdown {
+			indexName = "index.md"
+		}
+		fmt.Printf("[^scripthaus] wrote %d file(s) for %d playbook(s) to %s\n", numFiles, len(sitePlaybooks), filepath.Join(docsOpts.OutputDir, indexName))
+	}
+	return 0, nil
+}
+
+type mcpOptsType struct {
+	Allow []string
+}
+
+func parseMcpOpts(gopts globalOptsType) (mcpOptsType, error) {
+	var rtn mcpOptsType
+	parsed, err := parseCommandArgs("mcp", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		rtn.Allow = append(rtn.Allow, opt.Value)
+	}
+	return rtn, nil
+}
+
+// max bytes of command output returned by the run_command tool
+const mcpMaxOutput = 100 * 1024
+
+// an entry for the list_commands tool
+type mcpCommandEntry struct {
+	output.CommandEntry
+	Runnable bool `json:"runnable"` // matches the mcp allowlist
+}
+
+// resolves a command name (same rules as 'scripthaus run'), an error if it is not found
+func findCommandByName(name string, gopts globalOptsType) (*commanddef.CommandDef, error) {
+	script, err := resolveScript("run", name, "", false)
+	if err != nil {
+		return nil, err
+	}
+	var cdef *commanddef.CommandDef
+	if script.PlaybookFile == "" {
+		cdef, err = findPathCommand(script.PlaybookCommand, gopts)
+	} else {
+		cdef, err = resolvePlaybookCommand(script.PlaybookFile, script.PlaybookCommand, gopts)
+	}
+	if err != nil {
+		return nil, err
 	}
-	// ignore error (just use "")
-	henv := history.MakeHistoryEnv()
-	for idx, item := range items {
-		if historyOpts.FormatJson {
-			barr, err := item.MarshalJSON()
+	if cdef == nil {
+		return nil, fmt.Errorf("command '%s' not found", name)
+	}
+	return cdef, nil
+}
+
+func makeMcpTools(gopts globalOptsType, allow []string) []mcp.Tool {
+	isRunnable := func(cdef *commanddef.CommandDef) bool {
+		return mcp.Allowed(allow, cdef.OrigScriptName(), cdef.FullScriptName())
+	}
+	commandSchema := map[string]interface{}{
+		"type":        "string",
+		"description": "command name, as passed to 'scripthaus run' (e.g. \".build\" or \"./docs/ops.md::deploy\")",
+	}
+	listTool := mcp.Tool{
+		Name:        "list_commands",
+		Description: "Lists the scripthaus playbook commands in the project (or in one playbook).  Returns a JSON array with each command's name, usage, description, and whether run_command may run it.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"playbook": map[string]interface{}{"type": "string", "description": "playbook file (optional, defaults to every playbook in the project)"},
+			},
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Playbook string `json:"playbook"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			var playbooks []*pathutil.ResolvedPlaybook
+			if params.Playbook != "" {
+				resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(params.Playbook)
+				if err != nil {
+					return "", err
+				}
+				playbooks = append(playbooks, resolvedPlaybook)
+			} else {
+				var err error
+				playbooks, err = findProjectPlaybooks()
+				if err != nil {
+					return "", err
+				}
+			}
+			entries := []mcpCommandEntry{}
+			for _, result := range loadResolvedPlaybooks(playbooks) {
+				cmdDefs, err := result.CmdDefs, result.Err
+				if err != nil {
+					return "", err
+				}
+				for idx := range cmdDefs {
+					entries = append(entries, mcpCommandEntry{CommandEntry: output.MakeCommandEntry(&cmdDefs[idx]), Runnable: isRunnable(&cmdDefs[idx])})
+				}
+			}
+			barr, err := json.MarshalIndent(entries, "", "  ")
+			return string(barr), err
+		},
+	}
+	showTool := mcp.Tool{
+		Name:        "show_command",
+		Description: "Shows a scripthaus command's usage, arguments, help text, and script.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"command": commandSchema},
+			"required":   []string{"command"},
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Command string `json:"command"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			cdef, err := findCommandByName(params.Command, gopts)
 			if err != nil {
-				continue
+				return "", err
+			}
+			var buf strings.Builder
+			buf.WriteString(fmt.Sprintf("usage: scripthaus run %s\n", cdef.UsageStr()))
+			if cdef.ShortText != "" {
+				buf.WriteString(fmt.Sprintf("description: %s\n", cdef.ShortText))
+			}
+			buf.WriteString(fmt.Sprintf("runnable: %v\n", isRunnable(cdef)))
+			if argsHelp := cdef.ArgsHelpStr(); argsHelp != "" {
+				buf.WriteString("\n" + argsHelp)
+			}
+			if cdef.HelpText != "" {
+				buf.WriteString("\n" + strings.TrimSpace(cdef.HelpText) + "\n")
+			}
+			buf.WriteString(fmt.Sprintf("\n```%s\n%s\n```\n", cdef.Lang, strings.TrimRight(cdef.ScriptText, "\n")))
+			return buf.String(), nil
+		},
+	}
+	tools := []mcp.Tool{listTool, showTool}
+	if len(allow) == 0 {
+		// running commands must be explicitly allowed
+		return tools
+	}
+	runTool := mcp.Tool{
+		Name:        "run_command",
+		Description: fmt.Sprintf("Runs a scripthaus command and returns its exit code and output (stdout and stderr, no stdin).  Only commands on the allowlist can be run: %s", strings.Join(allow, ", ")),
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"command": commandSchema,
+				"args":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "arguments for the command"},
+			},
+			"required": []string{"command"},
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Command string   `json:"command"`
+				Args    []string `json:"args"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
 			}
-			if idx == 0 {
-				fmt.Printf("[")
+			cdef, err := findCommandByName(params.Command, gopts)
+			if err != nil {
+				return "", err
+			}
+			if !isRunnable(cdef) {
+				return "", fmt.Errorf("command '%s' is not on the scripthaus mcp allowlist", cdef.OrigScriptName())
+			}
+			if err := requireTrusted(cdef); err != nil {
+				return "", err
+			}
+			execItem, err := scripthaus.BuildExecItem(ctx, cdef, commanddef.SpecType{ScriptArgs: params.Args})
+			if err != nil {
+				return "", err
+			}
+			var outBuf bytes.Buffer
+			execItem.Cmd.Stdin = nil
+			execItem.Cmd.Stdout = &outBuf
+			execItem.Cmd.Stderr = &outBuf
+			exitCode, err := runExecItem(execItem, cdef.Warnings, gopts)
+			if err != nil {
+				return "", err
+			}
+			outStr := secrets.Redact([]string{outBuf.String()}, execItem.SecretVals)[0]
+			if len(outStr) > mcpMaxOutput {
+				outStr = "... (output truncated)\n" + outStr[len(outStr)-mcpMaxOutput:]
 			}
-			fmt.Printf("%s", string(barr))
-			if idx == len(items)-1 {
-				fmt.Printf("]\n")
+			return fmt.Sprintf("exit code: %d\n\n%s", exitCode, outStr), nil
+		},
+	}
+	return append(tools, runTool)
+}
+
+func runMcpCommand(gopts globalOptsType) (int, error) {
+	mcpOpts, err := parseMcpOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	// stdout is the protocol stream, anything else printed goes to stderr
+	protoOut := os.Stdout
+	os.Stdout = os.Stderr
+	gopts.ShowSummary = false
+	allow := append(append([]string{}, config.Get().McpAllow...), mcpOpts.Allow...)
+	server := &mcp.Server{Name: "scripthaus", Version: base.ScriptHausVersion, Tools: makeMcpTools(gopts, allow)}
+	err = server.Serve(context.Background(), os.Stdin, protoOut)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func runDaemonCommand(gopts globalOptsType) (int, error) {
+	_, err := parseCommandArgs("daemon", gopts.CommandArgs)
+	if err != nil {
+		return 1, err
+	}
+	// stdout is the protocol stream, anything else printed goes to stderr
+	protoOut := os.Stdout
+	os.Stdout = os.Stderr
+	gopts.ShowSummary = false
+	server := &daemon.Server{
+		Version: base.ScriptHausVersion,
+		FindCommand: func(file string, name string) (*commanddef.CommandDef, error) {
+			var cdef *commanddef.CommandDef
+			var err error
+			if file == "" {
+				cdef, err = findCommandByName(name, gopts)
 			} else {
-				fmt.Printf(",\n")
+				cdef, err = resolvePlaybookCommand(file, name, gopts)
+				if err == nil && cdef == nil {
+					err = fmt.Errorf("command '%s' not found in playbook '%s'", name, file)
+				}
 			}
-			continue
-		} else if historyOpts.FormatFull {
-			str := item.FullString(henv)
-			fmt.Printf("%s", str)
-			continue
+			if err == nil {
+				err = requireTrusted(cdef)
+			}
+			return cdef, err
+		},
+		RunExecItem: func(execItem *commanddef.ExecItem, warnings []string) (int, error) {
+			return runExecItem(execItem, warnings, gopts)
+		},
+	}
+	err = server.Serve(os.Stdin, protoOut)
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func parseEditOpts(gopts globalOptsType) (commanddef.ScriptDef, error) {
+	var rtn commanddef.ScriptDef
+	rtn.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("edit", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	if len(parsed.Args) > 0 {
+		rtn, err = resolveScript("edit", parsed.Args[0], rtn.PlaybookFile, true)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	if rtn.PlaybookFile == "" && rtn.PlaybookCommand == "" {
+		rtn.PlaybookFile = "."
+	}
+	return rtn, nil
+}
+
+// returns the editor command line ($VISUAL or $EDITOR) to open fileName at lineNo
+func makeEditorArgs(fileName string, lineNo int) ([]string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	editorArgs := strings.Fields(editor)
+	if len(editorArgs) == 0 {
+		return nil, fmt.Errorf("invalid $EDITOR '%s'", editor)
+	}
+	// "code.cmd" => "code" on windows
+	editorName := strings.TrimSuffix(filepath.Base(editorArgs[0]), filepath.Ext(editorArgs[0]))
+	switch editorName {
+	case "code", "code-insiders", "codium":
+		return append(editorArgs, "-g", fmt.Sprintf("%s:%d", fileName, lineNo)), nil
+
+	case "subl", "zed":
+		return append(editorArgs, fmt.Sprintf("%s:%d", fileName, lineNo)), nil
+
+	default:
+		// vi, vim, nvim, emacs, nano, micro, kak, hx, etc.
+		return append(editorArgs, fmt.Sprintf("+%d", lineNo), fileName), nil
+	}
+}
+
+func runEditCommand(gopts globalOptsType) (int, error) {
+	script, err := parseEditOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	var fileName string
+	lineNo := 1
+	if script.PlaybookCommand == "" && script.PlaybookFile != "" {
+		resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(script.PlaybookFile)
+		if err != nil {
+			return 1, err
+		}
+		fileName = resolvedPlaybook.ResolvedFile
+	} else {
+		foundCommand, err := resolvePlaybookCommand(script.PlaybookFile, script.PlaybookCommand, gopts)
+		if foundCommand == nil || err != nil {
+			return 1, err
+		}
+		fileName = foundCommand.Playbook.ResolvedFile
+		lineNo = foundCommand.StartLineNo
+	}
+	if fileName == "-" {
+		return 1, fmt.Errorf("cannot edit a playbook read from <stdin>")
+	}
+	editorArgs, err := makeEditorArgs(fileName, lineNo)
+	if err != nil {
+		return 1, err
+	}
+	editCmd := exec.Command(editorArgs[0], editorArgs[1:]...)
+	editCmd.Stdin = os.Stdin
+	editCmd.Stdout = os.Stdout
+	editCmd.Stderr = os.Stderr
+	err = editCmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, fmt.Errorf("cannot run editor '%s': %w", editorArgs[0], err)
+	}
+	return 0, nil
+}
+
+type removeOptsType struct {
+	Script  commanddef.ScriptDef
+	WithDoc bool
+	DryRun  bool
+}
+
+func parseRemoveOpts(gopts globalOptsType) (removeOptsType, error) {
+	var rtn removeOptsType
+	rtn.Script.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("remove", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.WithDoc = parsed.Has("--with-doc")
+	rtn.DryRun = parsed.Has("--dry-run")
+	if len(parsed.Args) > 0 {
+		rtn.Script, err = resolveScript("remove", parsed.Args[0], rtn.Script.PlaybookFile, false)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	if rtn.Script.PlaybookCommand == "" {
+		return rtn, fmt.Errorf("Usage: scripthaus remove [remove-opts] [playbook]::[command], no command specified")
+	}
+	if rtn.Script.PlaybookFile == "" {
+		// remove never searches SCRIPTHAUS_PATH, defaults to the project playbook
+		rtn.Script.PlaybookFile = "."
+	}
+	return rtn, nil
+}
+
+func runRemoveCommand(gopts globalOptsType) (int, error) {
+	removeOpts, err := parseRemoveOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	foundCommand, src, err := resolvePlaybookCommandSource(removeOpts.Script.PlaybookFile, removeOpts.Script.PlaybookCommand, gopts)
+	if foundCommand == nil || err != nil {
+		return 1, err
+	}
+	fileName := foundCommand.Playbook.ResolvedFile
+	if fileName == "-" {
+		return 1, fmt.Errorf("cannot remove a command from a playbook read from <stdin>")
+	}
+	startPos, endPos, err := mdparser.CommandRange(foundCommand, removeOpts.WithDoc)
+	if err != nil {
+		return 1, err
+	}
+	mdSource, err := src.CommandSource(foundCommand)
+	if err != nil {
+		return 1, err
+	}
+	if endPos > len(mdSource) {
+		return 1, fmt.Errorf("playbook '%s' changed while reading, try again", fileName)
+	}
+	startLine, endLine := mdparser.RangeLineNos(mdSource, startPos, endPos)
+	fmt.Printf("[^scripthaus] removing command '%s' from %s (lines %d-%d):\n", foundCommand.Name, fileName, startLine, endLine)
+	for idx, line := range strings.Split(strings.TrimRight(string(mdSource[startPos:endPos]), "\n"), "\n") {
+		fmt.Printf("  %5d: %s\n", startLine+idx, line)
+	}
+	if removeOpts.DryRun {
+		fmt.Printf("[^scripthaus] Not modifying file, --dry-run specified\n")
+		return 0, nil
+	}
+	err = pathutil.UpdateFileLocked(fileName, replaceIfUnchanged(fileName, mdSource, mdparser.RemoveRange(mdSource, startPos, endPos)))
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// a modifyFn for pathutil.UpdateFileLocked that replaces the playbook with newSource, but only if
+// it still has the contents it was read with (oldSource), the edit positions came from them
+func replaceIfUnchanged(fileName string, oldSource []byte, newSource []byte) func([]byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		if !bytes.Equal(data, oldSource) {
+			return nil, fmt.Errorf("playbook '%s' changed while reading, try again", fileName)
+		}
+		return newSource, nil
+	}
+}
+
+type mvOptsType struct {
+	Src     commanddef.ScriptDef
+	Dst     commanddef.ScriptDef
+	Section string
+	DryRun  bool
+}
+
+func parseMvOpts(gopts globalOptsType) (mvOptsType, error) {
+	var rtn mvOptsType
+	parsed, err := parseCommandArgs("mv", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--section":
+			rtn.Section = strings.TrimSpace(opt.Value)
+		case "--dry-run":
+			rtn.DryRun = true
+		}
+	}
+	args := parsed.Args
+	if len(args) != 2 {
+		return rtn, fmt.Errorf("Usage: scripthaus mv [mv-opts] [playbook]::[command] [playbook]::[new-command], requires a source and a destination")
+	}
+	rtn.Src, err = resolveScript("mv", args[0], gopts.PlaybookFile, false)
+	if err != nil {
+		return rtn, err
+	}
+	if rtn.Src.PlaybookFile == "" {
+		// mv never searches SCRIPTHAUS_PATH, defaults to the project playbook
+		rtn.Src.PlaybookFile = "."
+	}
+	rtn.Dst, err = resolveScript("mv", args[1], "", true)
+	if err != nil {
+		return rtn, err
+	}
+	if rtn.Dst.PlaybookFile == "" {
+		// a bare name renames the command inside of the source playbook
+		rtn.Dst.PlaybookFile = rtn.Src.PlaybookFile
+	}
+	if rtn.Dst.PlaybookCommand == "" {
+		rtn.Dst.PlaybookCommand = rtn.Src.PlaybookCommand
+	}
+	return rtn, nil
+}
+
+func runMvCommand(gopts globalOptsType) (int, error) {
+	mvOpts, err := parseMvOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	foundCommand, src, err := resolvePlaybookCommandSource(mvOpts.Src.PlaybookFile, mvOpts.Src.PlaybookCommand, gopts)
+	if foundCommand == nil || err != nil {
+		return 1, err
+	}
+	srcFile := foundCommand.Playbook.ResolvedFile
+	if srcFile == "-" || mvOpts.Dst.PlaybookFile == "-" {
+		return 1, fmt.Errorf("cannot move commands from or to a playbook read from <stdin>")
+	}
+	dstPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(mvOpts.Dst.PlaybookFile)
+	if err != nil {
+		if strings.Index(err.Error(), "not found") != -1 {
+			fmt.Printf("[^scripthaus] mv will not create a new markdown file.  touch the file and re-run the mv if this was your intention\n")
+		}
+		return 1, err
+	}
+	dstFile := dstPlaybook.ResolvedFile
+	newName := mvOpts.Dst.PlaybookCommand
+	sameFile := srcFile == dstFile
+	if sameFile && newName == foundCommand.Name && mvOpts.Section == "" {
+		return 1, fmt.Errorf("source and destination are the same, nothing to do")
+	}
+	dstSrc, err := mdparser.ReadPlaybookSource(dstPlaybook)
+	if err != nil {
+		return 1, err
+	}
+	dstDefs, _, err := dstSrc.Commands()
+	if err != nil {
+		return 1, err
+	}
+	for _, def := range dstDefs {
+		if def.MatchesName(newName) && !(sameFile && def.Name == foundCommand.Name) {
+			return 1, fmt.Errorf("script with name '%s' already exists in playbook file %s", newName, dstPlaybook.OrigShowStr())
+		}
+	}
+	startPos, endPos, err := mdparser.CommandRange(foundCommand, true)
+	if err != nil {
+		return 1, err
+	}
+	srcSource, err := src.CommandSource(foundCommand)
+	if err != nil {
+		return 1, err
+	}
+	if endPos > len(srcSource) {
+		return 1, fmt.Errorf("playbook '%s' changed while reading, try again", srcFile)
+	}
+	cmdText := mdparser.RenameCommandText(string(srcSource[startPos:endPos]), foundCommand.Name, newName)
+	startLine, endLine := mdparser.RangeLineNos(srcSource, startPos, endPos)
+	fmt.Printf("[^scripthaus] moving command '%s' from %s (lines %d-%d) to %s as '%s':\n", foundCommand.Name, srcFile, startLine, endLine, dstFile, newName)
+	fmt.Printf("\n%s\n", strings.Trim(cmdText, "\n"))
+	if mvOpts.DryRun {
+		fmt.Printf("\n[^scripthaus] Not modifying files, --dry-run specified\n")
+		return 0, nil
+	}
+	if sameFile {
+		var newSource []byte
+		if mvOpts.Section == "" {
+			// rename in place
+			newSource = append(append(append([]byte{}, srcSource[:startPos]...), cmdText...), srcSource[endPos:]...)
 		} else {
-			str := item.CompactString(henv)
-			fmt.Printf("%s", str)
-			continue
+			newSource = mdparser.InsertCommandText(mdparser.RemoveRange(srcSource, startPos, endPos), cmdText, mvOpts.Section)
+		}
+		err = pathutil.UpdateFileLocked(srcFile, replaceIfUnchanged(srcFile, srcSource, newSource))
+		if err != nil {
+			return 1, err
 		}
+		return 0, nil
+	}
+	dstSource := dstSrc.Source
+	// write the destination first so a failure never loses the command
+	err = pathutil.UpdateFileLocked(dstFile, replaceIfUnchanged(dstFile, dstSource, mdparser.InsertCommandText(dstSource, cmdText, mvOpts.Section)))
+	if err != nil {
+		return 1, err
+	}
+	err = pathutil.UpdateFileLocked(srcFile, replaceIfUnchanged(srcFile, srcSource, mdparser.RemoveRange(srcSource, startPos, endPos)))
+	if err != nil {
+		return 1, fmt.Errorf("command was added to '%s' but could not be removed from '%s' (remove it manually): %w", dstFile, srcFile, err)
 	}
 	return 0, nil
 }
 
-type manageOptsType struct {
-	ManageCommand string
-	StartId       int
-	EndId         int
+type searchOptsType struct {
+	Term          string
+	CaseSensitive bool
+	MaxLines      int
 }
 
-func parseManageOpts(opts globalOptsType) (manageOptsType, error) {
-	var rtn manageOptsType
-	iter := &OptsIter{Opts: opts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if isOption(argStr) {
-			return rtn, fmt.Errorf("invalid option '%s' passed to scripthaus manage", argStr)
+func parseSearchOpts(gopts globalOptsType) (searchOptsType, error) {
+	rtn := searchOptsType{MaxLines: 5}
+	parsed, err := parseCommandArgs("search", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--case-sensitive":
+			rtn.CaseSensitive = true
+		case "--all-lines":
+			rtn.MaxLines = 0
 		}
-		rtn.ManageCommand = argStr
-		if rtn.ManageCommand == "remove-history-range" {
-			if iter.Pos+2 > len(iter.Opts) {
-				return rtn, fmt.Errorf("Usage: scripthaus manage remove-history-range [start-id] [end-id], not enough arguments passed")
-			}
-			var err error
-			startStr := iter.Next()
-			rtn.StartId, err = strconv.Atoi(startStr)
-			if err != nil {
-				return rtn, fmt.Errorf("invalid [start-id] '%s' passed to scripthaus manage remove-history-range: %w", startStr, err)
+	}
+	// the arguments after "--" are joined into one term
+	termArgs := parsed.Args
+	if parsed.RestIdx != -1 {
+		termArgs = append(parsed.Args[:parsed.RestIdx:parsed.RestIdx], strings.Join(parsed.Args[parsed.RestIdx:], " "))
+	}
+	if len(termArgs) > 1 {
+		return rtn, fmt.Errorf("Usage: scripthaus search [search-opts] [term], too many arguments passed, extras = '%s'", strings.Join(termArgs[1:], " "))
+	}
+	if len(termArgs) == 1 {
+		rtn.Term = termArgs[0]
+	}
+	if rtn.Term == "" {
+		return rtn, fmt.Errorf("Usage: scripthaus search [search-opts] [term], no search term specified")
+	}
+	return rtn, nil
+}
+
+// the playbooks searched by 'search', the global directory, the project root, and SCRIPTHAUS_PATH
+// (the -p playbook only if it is given).  returns (playbooks, warnings)
+func findSearchPlaybooks(gopts globalOptsType) ([]*pathutil.ResolvedPlaybook, []string) {
+	resolver := pathutil.DefaultResolver()
+	if gopts.PlaybookFile != "" {
+		resolvedPlaybook, err := resolver.ResolvePlaybook(gopts.PlaybookFile)
+		if err != nil {
+			return nil, []string{err.Error()}
+		}
+		return []*pathutil.ResolvedPlaybook{resolvedPlaybook}, nil
+	}
+	var rtn []*pathutil.ResolvedPlaybook
+	seen := make(map[string]bool)
+	addPlaybook := func(rpb *pathutil.ResolvedPlaybook) {
+		if !seen[rpb.ResolvedFile] {
+			seen[rpb.ResolvedFile] = true
+			rtn = append(rtn, rpb)
+		}
+	}
+	for _, prefix := range []string{"^", "."} {
+		dirName, err := resolver.FindPrefixDir(prefix)
+		if err != nil {
+			continue
+		}
+		files, err := pathutil.FindPlaybookFiles(dirName, false)
+		if err != nil {
+			continue
+		}
+		for _, fileName := range files {
+			playbookName := prefix + fileName
+			if fileName == pathutil.DefaultScFile {
+				playbookName = prefix
 			}
-			endStr := iter.Next()
-			rtn.EndId, err = strconv.Atoi(endStr)
+			resolvedPlaybook, err := resolver.ResolvePlaybook(playbookName)
 			if err != nil {
-				return rtn, fmt.Errorf("invalid [end-id] '%s' passed to scripthaus manage remove-history-range: %w", endStr, err)
+				continue
 			}
+			addPlaybook(resolvedPlaybook)
+		}
+	}
+	pathPlaybooks, warnings := resolver.ResolvePathPlaybooks()
+	for _, rpb := range pathPlaybooks {
+		addPlaybook(rpb)
+	}
+	return rtn, warnings
+}
+
+func parsePickOpts(gopts globalOptsType) (string, error) {
+	parsed, err := parseCommandArgs("pick", gopts.CommandArgs)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(parsed.Args, " "), nil
+}
+
+// the preview pane text for the picker (location, help text, and script)
+func makePickPreview(cdef *commanddef.CommandDef) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("%s:%d\n\n", cdef.Playbook.ResolvedFile, cdef.StartLineNo))
+	if strings.TrimSpace(cdef.HelpText) != "" {
+		buf.WriteString(strings.TrimSpace(cdef.HelpText))
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString(fmt.Sprintf("```%s\n%s\n```", cdef.Lang, strings.TrimRight(cdef.ScriptText, "\n")))
+	return buf.String()
+}
+
+// fuzzy finder over every discoverable command (global, project, and SCRIPTHAUS_PATH playbooks),
+// the chosen command is run with no arguments
+func runPickCommand(gopts globalOptsType) (int, error) {
+	query, err := parsePickOpts(gopts)
+	if err != nil {
+		return 1, err
+	}
+	if !tui.IsInteractive() {
+		return 1, fmt.Errorf("scripthaus pick requires a terminal")
+	}
+	playbooks, warnings := findSearchPlaybooks(gopts)
+	printWarnings(gopts, warnings, false)
+	var items []tui.PickItem
+	var names []string
+	for _, result := range loadResolvedPlaybooks(playbooks) {
+		cmdDefs, err := result.CmdDefs, result.Err
+		if err != nil {
+			printWarnings(gopts, []string{err.Error()}, false)
+			continue
 		}
-		if iter.HasNext() {
-			return rtn, fmt.Errorf("Usage: scripthaus manage, too many arguments passed, extras = '%s'", strings.Join(iter.Rest(), " "))
+		for idx := range cmdDefs {
+			cdef := &cmdDefs[idx]
+			text := cdef.OrigScriptName()
+			if cdef.ShortText != "" {
+				text = text + " - " + cdef.ShortText
+			}
+			items = append(items, tui.PickItem{Text: text, Preview: makePickPreview(cdef)})
+			names = append(names, cdef.OrigScriptName())
 		}
 	}
-	return rtn, nil
+	if len(items) == 0 {
+		return 1, fmt.Errorf("no commands found (searched %d playbook(s))", len(playbooks))
+	}
+	pickIdx, err := tui.Pick(items, query)
+	if err != nil {
+		return 1, err
+	}
+	if pickIdx == -1 {
+		return 1, nil
+	}
+	runOpts := gopts
+	runOpts.CommandName = "run"
+	runOpts.CommandArgs = []string{names[pickIdx]}
+	runOpts.PlaybookFile = ""
+	return runRunCommand(runOpts)
 }
 
-func runManageCommand(opts globalOptsType) (int, error) {
-	manageOpts, err := parseManageOpts(opts)
+func runSearchCommand(gopts globalOptsType) (int, error) {
+	searchOpts, err := parseSearchOpts(gopts)
 	if err != nil {
 		return 1, err
 	}
-	if manageOpts.ManageCommand == "clear-history" {
-		numRemoved, err := history.RemoveHistoryItems(true, 0, 0)
-		if err != nil {
-			return 1, err
-		}
-		fmt.Printf("[^scripthaus] all %d history items removed\n\n", numRemoved)
-	} else if manageOpts.ManageCommand == "delete-db" {
-		err = history.RemoveDB()
-		if err != nil {
-			return 1, err
-		}
-		fmt.Printf("[^scripthaus] history db deleted\n\n")
-	} else if manageOpts.ManageCommand == "remove-history-range" {
-		numRemoved, err := history.RemoveHistoryItems(false, manageOpts.StartId, manageOpts.EndId)
+	playbooks, warnings := findSearchPlaybooks(gopts)
+	printWarnings(gopts, warnings, true)
+	searcher := search.MakeSearcher(searchOpts.Term, searchOpts.CaseSensitive)
+	numMatches := 0
+	for _, result := range loadResolvedPlaybooks(playbooks) {
+		cmdDefs, err := result.CmdDefs, result.Err
 		if err != nil {
-			return 1, err
+			printWarnings(gopts, []string{err.Error()}, false)
+			continue
 		}
-		fmt.Printf("[^scripthaus] %d history items removed\n\n", numRemoved)
-	} else if manageOpts.ManageCommand == "renumber-history" {
-		err = history.ReNumberHistory()
-		if err != nil {
-			return 1, err
+		for idx := range cmdDefs {
+			match := searcher.MatchCommand(&cmdDefs[idx])
+			if match == nil {
+				continue
+			}
+			numMatches++
+			cdef := match.Cmd
+			var shortText string
+			if cdef.ShortText != "" {
+				shortText = " - " + cdef.ShortText
+			}
+			fmt.Printf("%s%s  (%s:%d, matched %s)\n", cdef.OrigScriptName(), shortText, cdef.Playbook.ResolvedFile, cdef.StartLineNo, strings.Join(match.Fields, ", "))
+			for lineIdx, line := range match.Lines {
+				if searchOpts.MaxLines > 0 && lineIdx >= searchOpts.MaxLines {
+					fmt.Printf("  ... %d more matching line(s)\n", len(match.Lines)-lineIdx)
+					break
+				}
+				fmt.Printf("  %5d: %s\n", line.LineNo, line.Text)
+			}
 		}
-		fmt.Printf("[^scripthaus] history items renumbered\n\n")
-	} else {
-		if manageOpts.ManageCommand == "" {
-			return 1, fmt.Errorf("no sub-command passed to scripthaus manage")
-		} else {
-			return 1, fmt.Errorf("invalid manage sub-command '%s'", manageOpts.ManageCommand)
+	}
+	if numMatches == 0 {
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] no commands found matching '%s' (searched %d playbook(s))\n", searchOpts.Term, len(playbooks))
 		}
+		return 1, nil
 	}
 	return 0, nil
 }
 
-func runShowCommand(gopts globalOptsType) (int, error) {
-	showOpts, err := parseShowOpts(gopts)
+type fmtOptsType struct {
+	PlaybookFile string
+	Check        bool
+	Sort         bool
+}
+
+func parseFmtOpts(gopts globalOptsType) (fmtOptsType, error) {
+	var rtn fmtOptsType
+	rtn.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("fmt", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.Check = parsed.Has("--check")
+	rtn.Sort = parsed.Has("--sort")
+	if len(parsed.Args) > 0 {
+		rtn.PlaybookFile = parsed.Args[0]
+	}
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	if rtn.PlaybookFile == "-" || rtn.PlaybookFile == "<stdin>" {
+		return rtn, fmt.Errorf("playbook file cannot be '-' (<stdin>) for 'fmt' command")
+	}
+	return rtn, nil
+}
+
+func runFmtCommand(gopts globalOptsType) (int, error) {
+	fmtOpts, err := parseFmtOpts(gopts)
 	if err != nil {
 		return 1, err
 	}
-	if showOpts.Script.PlaybookFile == "" {
-		return 1, fmt.Errorf("Usage: scripthaus show [playbook]::[script], no playbook specified")
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(fmtOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
 	}
-	if showOpts.Script.PlaybookCommand == "" {
-		return runListCommandInternal(gopts, showOpts.Script.PlaybookFile)
+	mdSource, err := os.ReadFile(resolvedPlaybook.ResolvedFile)
+	if err != nil {
+		return 1, fmt.Errorf("cannot read playbook %s: %w", resolvedPlaybook.OrigShowStr(), err)
 	}
-	foundCommand, err := resolvePlaybookCommand(showOpts.Script.PlaybookFile, showOpts.Script.PlaybookCommand, gopts)
-	if foundCommand == nil || err != nil {
+	formatted, warnings, err := mdparser.FormatPlaybook(mdSource, fmtOpts.Sort)
+	if err != nil {
 		return 1, err
 	}
-	fmt.Printf("[^scripthaus] show '%s'\n\n", foundCommand.FullScriptName())
-	fmt.Printf("%s\n\n%s\n\n", foundCommand.HelpText, foundCommand.RawCodeText)
+	printWarnings(gopts, warnings, false)
+	if bytes.Equal(formatted, mdSource) {
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] %s is already formatted\n", resolvedPlaybook.OrigShowStr())
+		}
+		return 0, nil
+	}
+	if fmtOpts.Check {
+		fmt.Printf("[^scripthaus] %s is not formatted (run 'scripthaus fmt' to fix)\n", resolvedPlaybook.OrigShowStr())
+		return 1, nil
+	}
+	err = pathutil.UpdateFileLocked(resolvedPlaybook.ResolvedFile, replaceIfUnchanged(resolvedPlaybook.ResolvedFile, mdSource, formatted))
+	if err != nil {
+		return 1, err
+	}
+	if !gopts.Quiet {
+		fmt.Printf("[^scripthaus] formatted %s\n", resolvedPlaybook.OrigShowStr())
+	}
 	return 0, nil
 }
 
-type addOptsType struct {
-	Script     commanddef.ScriptDef
-	ScriptType string
-	ScriptText string
-	ShortDesc  string
-	Message    string
-	DryRun     bool
+type diffOptsType struct {
+	PlaybookFile string
+	OldRev       string
+	NewRev       string // "" for the working tree
+	Json         bool
+	ExitCode     bool
 }
 
-func parseAddOpts(opts globalOptsType) (addOptsType, error) {
-	var rtn addOptsType
-	var err error
-	rtn.Script.PlaybookFile = opts.PlaybookFile
-	iter := &OptsIter{Opts: opts.CommandArgs}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if argStr == "-t" || argStr == "--type" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s [type]' missing script type", argStr)
-			}
-			rtn.ScriptType = iter.Next()
-			continue
-		}
-		if argStr == "-m" || argStr == "--message" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s [message]' missing message", argStr)
-			}
-			rtn.Message = iter.Next()
-			continue
-		}
-		if argStr == "-s" || argStr == "--short-desc" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s [desc]' missing short-description string", argStr)
-			}
-			rtn.ShortDesc = iter.Next()
-			if strings.Index(rtn.ShortDesc, "\n") != -1 {
-				return rtn, fmt.Errorf("'%s [desc]' short description cannot contain a newline character", argStr)
-			}
-			if len(rtn.ShortDesc) > 80 {
-				return rtn, fmt.Errorf("'%s [desc]' short description cannot be more than 80 characters", argStr)
-			}
-			continue
-		}
-		if argStr == "-c" {
-			if !iter.HasNext() {
-				return rtn, fmt.Errorf("'%s [script-text]' missing script text", argStr)
+// the first argument is the playbook if it is "." or "^" or an existing file, otherwise the
+// arguments are revisions ("a..b" is the same as "a b")
+func parseDiffOpts(gopts globalOptsType) (diffOptsType, error) {
+	var rtn diffOptsType
+	rtn.PlaybookFile = gopts.PlaybookFile
+	parsed, err := parseCommandArgs("diff", gopts.CommandArgs)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.Json = parsed.Has("--json")
+	rtn.ExitCode = parsed.Has("--exit-code")
+	args := parsed.Args
+	if len(args) > 0 {
+		if finfo, err := os.Stat(args[0]); args[0] == "." || args[0] == "^" || (err == nil && !finfo.IsDir()) {
+			if rtn.PlaybookFile != "" {
+				return rtn, fmt.Errorf("playbook passed twice, as --playbook '%s' and as '%s'", rtn.PlaybookFile, args[0])
 			}
-			rtn.ScriptText = iter.Next()
-			continue
-		}
-		if argStr == "-" {
-			rtn.ScriptText = "-" // stdin
-			continue
-		}
-		if argStr == "--dry-run" {
-			rtn.DryRun = true
-			continue
-		}
-		if argStr == "--" {
-			rtn.ScriptText = strings.Join(iter.Rest(), " ")
-			break
+			rtn.PlaybookFile = args[0]
+			args = args[1:]
 		}
-		if isOption(argStr) {
-			return rtn, fmt.Errorf("invalid option '%s' passed to scripthaus add command", argStr)
-		}
-		rtn.Script, err = resolveScript("add", argStr, rtn.Script.PlaybookFile, false)
-		if err != nil {
-			return rtn, err
+	}
+	if len(args) == 1 && strings.Contains(args[0], "..") {
+		revs := strings.SplitN(args[0], "..", 2)
+		if revs[0] == "" || revs[1] == "" {
+			return rtn, fmt.Errorf("invalid revision range '%s', must be [rev]..[rev2]", args[0])
 		}
+		args = revs
 	}
-	if rtn.Script.PlaybookFile == "" {
-		return rtn, fmt.Errorf("No playbook/script passed to 'add' command.  Usage: scripthaus add [opts] [playbook]::[script]")
+	if len(args) > 2 {
+		return rtn, fmt.Errorf("too many arguments passed to scripthaus diff, extras = '%s'", strings.Join(args[2:], " "))
+	}
+	rtn.OldRev = "HEAD"
+	if len(args) > 0 {
+		rtn.OldRev = args[0]
+	}
+	if len(args) > 1 {
+		rtn.NewRev = args[1]
 	}
-	if rtn.ScriptText == "" {
-		return rtn, fmt.Errorf("No script text passed to 'add' command.  Use '-c [script-text]', '--' for rest of arguments, or '-' for stdin")
+	if rtn.PlaybookFile == "" {
+		rtn.PlaybookFile = "."
+	}
+	if rtn.PlaybookFile == "-" || rtn.PlaybookFile == "<stdin>" {
+		return rtn, fmt.Errorf("playbook file cannot be '-' (<stdin>) for 'diff' command")
 	}
 	return rtn, nil
 }
 
-func runAddCommand(gopts globalOptsType) (errCode int, errRtn error) {
-	addOpts, err := parseAddOpts(gopts)
-	if err != nil {
-		return 1, err
-	}
-	var realScriptText string
-	if addOpts.ScriptText == "-" {
-		scriptTextBytes, err := io.ReadAll(os.Stdin)
+// parses the playbook at the revision ("" for the working tree).  included playbooks are not
+// parsed (they are read from the working tree), so only the playbook's own commands are returned.
+// a playbook that does not exist at the revision has no commands
+func loadPlaybookAtRevision(playbook *pathutil.ResolvedPlaybook, rev string) ([]commanddef.CommandDef, *pathutil.PlaybookConfig, error) {
+	var mdSource []byte
+	if rev == "" {
+		found, data, err := pathutil.TryReadFile(playbook.ResolvedFile, "playbook", false)
 		if err != nil {
-			return 1, fmt.Errorf("reading from <stdin>: %w", err)
+			return nil, nil, err
 		}
-		if len(scriptTextBytes) == 0 {
-			return 1, fmt.Errorf("reading script-text from <stdin>, but got empty string")
+		if !found {
+			return nil, nil, nil
 		}
-		realScriptText = string(scriptTextBytes)
+		mdSource = data
 	} else {
-		realScriptText = addOpts.ScriptText
-	}
-	if len(realScriptText) > 5000 {
-		return 1, fmt.Errorf("script-text too long, max-size for add is 5k (edit the file manually if this was not a mistake)")
-	}
-	if strings.Index(realScriptText, "```") != -1 {
-		return 1, fmt.Errorf("script-text cannot contain the the markdown code fence characters \"```\", this block must be added to the .md file manually")
+		found, data, err := playbookdiff.ReadAtRevision(playbook.ResolvedFile, rev)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !found {
+			return nil, nil, nil
+		}
+		mdSource = data
 	}
-	if addOpts.Script.PlaybookFile == "-" || addOpts.Script.PlaybookFile == "<stdin>" {
-		return 1, fmt.Errorf("playbook file cannot be '-' (<stdin>) for 'add' command")
+	pbCopy := *playbook
+	cmdDefs, _, err := mdparser.ParseCommands(&pbCopy, mdSource)
+	if err != nil {
+		if rev == "" {
+			rev = "the working tree"
+		}
+		return nil, nil, fmt.Errorf("cannot parse playbook %s at %s: %w", playbook.OrigShowStr(), rev, err)
 	}
-	if addOpts.ScriptType == "" {
-		return 1, fmt.Errorf("must specify a script-type using '-t'")
+	return cmdDefs, pbCopy.Config, nil
+}
+
+// reports the commands that were added, removed, renamed, or changed between two git revisions
+// of a playbook (or a revision and the working tree)
+func runDiffCommand(gopts globalOptsType) (int, error) {
+	diffOpts, err := parseDiffOpts(gopts)
+	if err != nil {
+		return 1, err
 	}
-	if !base.IsValidScriptType(addOpts.ScriptType) {
-		return 1, fmt.Errorf("must specify a valid script type ('%s' is not valid), must be one of: %s", addOpts.ScriptType, strings.Join(base.ValidScriptTypes(), ", "))
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(diffOpts.PlaybookFile)
+	if err != nil {
+		return 1, err
 	}
-	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(addOpts.Script.PlaybookFile)
+	oldDefs, oldConfig, err := loadPlaybookAtRevision(resolvedPlaybook, diffOpts.OldRev)
 	if err != nil {
-		if strings.Index(err.Error(), "not found") != -1 {
-			fmt.Printf("[^scripthaus] add will not create a new markdown file.  touch the file and re-run the add if this was your intention\n")
-		}
 		return 1, err
 	}
-	cmdDefs, err := readCommandsFromFile(resolvedPlaybook)
+	newDefs, newConfig, err := loadPlaybookAtRevision(resolvedPlaybook, diffOpts.NewRev)
 	if err != nil {
 		return 1, err
 	}
-	for _, def := range cmdDefs {
-		if def.Name == addOpts.Script.PlaybookCommand {
-			return 1, fmt.Errorf("script with name '%s' already exists in playbook file %s", addOpts.Script.PlaybookCommand, resolvedPlaybook.OrigShowStr())
-		}
+	result := playbookdiff.Diff(oldDefs, oldConfig, newDefs, newConfig)
+	exitCode := 0
+	if diffOpts.ExitCode && !result.IsEmpty() {
+		exitCode = 1
 	}
-	var buf bytes.Buffer
-	fmt.Printf("[^scripthaus] adding command '%s' to %s:\n", addOpts.Script.PlaybookCommand, resolvedPlaybook.OrigShowStr())
-	buf.WriteString("\n")
-	if addOpts.Message != "" {
-		buf.WriteString(fmt.Sprintf("%s\n\n", addOpts.Message))
+	if diffOpts.Json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return exitCode, enc.Encode(result)
 	}
-	buf.WriteString(fmt.Sprintf("```%s\n", addOpts.ScriptType))
-	shortDesc := addOpts.ShortDesc
-	if shortDesc != "" {
-		shortDesc = " - " + shortDesc
+	newName := diffOpts.NewRev
+	if newName == "" {
+		newName = "working tree"
 	}
-	buf.WriteString(fmt.Sprintf("%s @scripthaus command %s%s\n", base.GetCommentString(addOpts.ScriptType), addOpts.Script.PlaybookCommand, shortDesc))
-	buf.WriteString(fmt.Sprintf("%s\n", addOpts.ScriptText))
-	buf.WriteString(fmt.Sprintf("```\n"))
-	fmt.Printf("%s\n", buf.String())
-	if addOpts.DryRun {
-		fmt.Printf("[^scripthaus] Not modifying file, --dry-run specified\n")
-		return 0, nil
+	if result.IsEmpty() {
+		if !gopts.Quiet {
+			fmt.Printf("[^scripthaus] no command changes in %s between %s and %s\n", resolvedPlaybook.OrigShowStr(), diffOpts.OldRev, newName)
+		}
+		return exitCode, nil
 	}
-	fd, err := os.OpenFile(resolvedPlaybook.ResolvedFile, os.O_APPEND|os.O_WRONLY, 0666)
-	defer func() {
-		closeErr := fd.Close()
-		if closeErr != nil && errRtn == nil {
-			errCode = 1
-			errRtn = fmt.Errorf("cannot close/write to playbook %s: %w", resolvedPlaybook.OrigShowStr(), closeErr)
+	color := render.MakeColorizer(os.Stdout)
+	fmt.Printf("[^scripthaus] %s, %s -> %s\n", resolvedPlaybook.OrigShowStr(), diffOpts.OldRev, newName)
+	for _, change := range result.Commands {
+		partsStr := ""
+		if len(change.Parts) > 0 {
+			partsStr = " " + color.Dim("("+strings.Join(change.Parts, ", ")+")")
+		}
+		switch change.Type {
+		case playbookdiff.ChangeAdded:
+			fmt.Printf("  %s %s\n", color.Success("+ added  "), color.Name(change.Name))
+		case playbookdiff.ChangeRemoved:
+			fmt.Printf("  %s %s\n", color.Error("- removed"), color.Name(change.Name))
+		case playbookdiff.ChangeRenamed:
+			fmt.Printf("  %s %s -> %s%s\n", color.Warning("~ renamed"), change.OldName, color.Name(change.Name), partsStr)
+		default:
+			fmt.Printf("  %s %s%s\n", color.Warning("* changed"), color.Name(change.Name), partsStr)
 		}
-	}()
-	if err != nil {
-		fmt.Printf("cannot open playbook %s for append: %v", resolvedPlaybook.OrigShowStr(), err)
 	}
-	_, err = fd.WriteString(buf.String())
-	if err != nil {
-		fmt.Printf("cannot write to playbook %s: %v", resolvedPlaybook.OrigShowStr(), err)
+	if len(result.Playbook) > 0 {
+		fmt.Printf("  %s front matter %s\n", color.Warning("* changed"), color.Dim("("+strings.Join(result.Playbook, ", ")+")"))
 	}
-	return 0, nil
+	return exitCode, nil
+}
+
+type shellInitOptsType struct {
+	Shell    string
+	Tags     []string
+	Aliases  []shellinit.Func // from --alias
+	Prefix   string
+	PickName string // --pick, "" for no fuzzy-run helper
 }
 
-func readCommandsFromFile(playbook *pathutil.ResolvedPlaybook) ([]commanddef.CommandDef, error) {
-	fd, err := os.Open(playbook.ResolvedFile)
+func parseShellInitOpts(gopts globalOptsType) (shellInitOptsType, error) {
+	var rtn shellInitOptsType
+	parsed, err := parseCommandArgs("shell-init", gopts.CommandArgs)
 	if err != nil {
-		return nil, fmt.Errorf("cannot open playbook file %s: %w", playbook.OrigShowStr(), err)
+		return rtn, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--tag":
+			rtn.Tags = append(rtn.Tags, opt.Value)
+		case "--alias":
+			eqIdx := strings.Index(opt.Value, "=")
+			if eqIdx <= 0 || eqIdx == len(opt.Value)-1 {
+				return rtn, fmt.Errorf("invalid %s '%s', must be [name]=[command], e.g. shb=.build", opt.Flag, opt.Value)
+			}
+			rtn.Aliases = append(rtn.Aliases, shellinit.Func{Name: opt.Value[:eqIdx], Target: opt.Value[eqIdx+1:]})
+		case "--prefix":
+			rtn.Prefix = opt.Value
+		case "--pick":
+			rtn.PickName = opt.Value
+			if rtn.PickName == "" {
+				rtn.PickName = shellinit.DefaultPickName
+			}
+		}
 	}
-	defer fd.Close()
-	fileBytes, err := io.ReadAll(fd)
+	if len(parsed.Args) != 1 {
+		return rtn, fmt.Errorf("Usage: scripthaus shell-init [shell-init-opts] [bash|zsh|fish]")
+	}
+	rtn.Shell = parsed.Args[0]
+	if _, err = shellinit.Script(rtn.Shell, nil); err != nil {
+		return rtn, err
+	}
+	return rtn, nil
+}
+
+// the 'scripthaus run' target for a command, project (".") and global ("^") commands are
+// resolved when the function runs, commands from other playbooks by their absolute file
+func shellInitTarget(cdef *commanddef.CommandDef) string {
+	if cdef.Playbook.OrigName == "." || cdef.Playbook.OrigName == "^" {
+		return cdef.Playbook.OrigName + cdef.Name
+	}
+	return cdef.Playbook.ResolvedFile + "::" + cdef.Name
+}
+
+// prints shell functions that run playbook commands, to be eval'd in an rc file.  by default
+// there is one function per command of the global playbook (or the --playbook playbook)
+func runShellInitCommand(gopts globalOptsType) (int, error) {
+	initOpts, err := parseShellInitOpts(gopts)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read playbook file %s: %w", playbook.OrigShowStr(), err)
+		return 1, err
+	}
+	var funcs []shellinit.Func
+	for _, fn := range initOpts.Aliases {
+		if err = shellinit.CheckFuncName(fn.Name); err != nil {
+			return 1, fmt.Errorf("invalid --alias: %w", err)
+		}
+		funcs = append(funcs, fn)
+	}
+	if initOpts.PickName != "" {
+		if err = shellinit.CheckFuncName(initOpts.PickName); err != nil {
+			return 1, fmt.Errorf("invalid --pick name: %w", err)
+		}
+		funcs = append(funcs, shellinit.Func{Name: initOpts.PickName, Desc: "fuzzy-find and run a playbook command", Pick: true})
+	}
+	// with only --alias and --pick just those functions are printed
+	playbookFile := gopts.PlaybookFile
+	withCommands := len(funcs) == 0 || len(initOpts.Tags) > 0 || playbookFile != ""
+	if playbookFile == "" {
+		playbookFile = "^"
+	}
+	if withCommands {
+		var cmdDefs []commanddef.CommandDef
+		resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookFile)
+		if err == nil {
+			cmdDefs, _, err = loadResolvedPlaybook(resolvedPlaybook)
+		}
+		// a missing global playbook is not an error (the output is eval'd by every new shell)
+		if err != nil && gopts.PlaybookFile != "" {
+			return 1, err
+		}
+		for idx := range cmdDefs {
+			cdef := &cmdDefs[idx]
+			if len(initOpts.Tags) > 0 && !cdef.HasAnyTag(initOpts.Tags) {
+				continue
+			}
+			fn := shellinit.Func{Name: initOpts.Prefix + cdef.Name, Target: shellInitTarget(cdef), Desc: cdef.ShortText}
+			if err := shellinit.CheckFuncName(fn.Name); err != nil {
+				fmt.Fprintf(os.Stderr, "[^scripthaus] skipping '%s': %v (use --prefix or --alias)\n", cdef.OrigScriptName(), err)
+				continue
+			}
+			if path := shellinit.Shadows(fn.Name); path != "" {
+				fmt.Fprintf(os.Stderr, "[^scripthaus] skipping '%s': '%s' would shadow %s (use --prefix or --alias)\n", cdef.OrigScriptName(), fn.Name, path)
+				continue
+			}
+			funcs = append(funcs, fn)
+		}
+	}
+	seen := make(map[string]bool)
+	var uniqueFuncs []shellinit.Func
+	for _, fn := range funcs {
+		if seen[fn.Name] {
+			fmt.Fprintf(os.Stderr, "[^scripthaus] skipping duplicate function '%s'\n", fn.Name)
+			continue
+		}
+		seen[fn.Name] = true
+		uniqueFuncs = append(uniqueFuncs, fn)
 	}
-	defs, _, err := mdparser.ParseCommands(playbook, fileBytes)
+	script, err := shellinit.Script(initOpts.Shell, uniqueFuncs)
 	if err != nil {
-		return nil, err
+		return 1, err
 	}
-	return defs, nil
+	fmt.Print(script)
+	return 0, nil
 }
 
 func printVersion() {
@@ -770,99 +5168,99 @@ type globalOptsType struct {
 	CommandName  string
 	CommandArgs  []string
 	ShowSummary  bool
+	ColorMode    string
+	Strict       bool
+	JsonErrors   bool // --json, print errors as JSON (see printCliError)
 }
 
+// parses the global options (before the command name), args[0] is the program name
 func parseGlobalOpts(args []string) (globalOptsType, error) {
 	var opts globalOptsType
-	iter := &OptsIter{Opts: args[1:]}
-	for iter.HasNext() {
-		argStr := iter.Next()
-		if argStr == "-v" || argStr == "--verbose" {
+	parsed, err := parseOpts("", globalOptDefs, true, args[1:])
+	if err != nil {
+		return opts, err
+	}
+	for _, opt := range parsed.Opts {
+		switch opt.Name {
+		case "--verbose":
 			opts.Verbose++
-			continue
-		}
-		if argStr == "-q" || argStr == "--quiet" {
+		case "--quiet":
 			opts.Quiet = true
-			continue
-		}
-		if argStr == "-s" || argStr == "--summary" {
+		case "--summary":
 			opts.ShowSummary = true
-			continue
-		}
-		if argStr == "-p" || argStr == "--playbook" {
-			if !iter.HasNext() {
-				return opts, fmt.Errorf("'%s [playbook]' missing playbook name", argStr)
-			}
-			opts.PlaybookFile = iter.Next()
-			continue
-		}
-		if isOption(argStr) {
-			return opts, fmt.Errorf("Invalid option '%s'", argStr)
+		case "--strict":
+			opts.Strict = true
+		case "--playbook":
+			opts.PlaybookFile = opt.Value
+		case "--color":
+			opts.ColorMode = opt.Value
+		case "--json":
+			opts.JsonErrors = true
 		}
-		opts.CommandName = argStr
-		opts.CommandArgs = iter.Rest()
-		break
+	}
+	if len(parsed.Args) > 0 {
+		opts.CommandName = parsed.Args[0]
+		opts.CommandArgs = parsed.Args[1:]
 	}
 	return opts, nil
 }
 
-type OptsIter struct {
-	Pos  int
-	Opts []string
-}
-
+// "-" and "-::[command]" are the stdin playbook, not options
 func isOption(argStr string) bool {
-	return strings.HasPrefix(argStr, "-") && argStr != "-" && !strings.HasPrefix(argStr, "-/")
-}
-
-func (iter *OptsIter) HasNext() bool {
-	return iter.Pos <= len(iter.Opts)-1
-}
-
-func (iter *OptsIter) Next() string {
-	if iter.Pos >= len(iter.Opts) {
-		return ""
-	}
-	rtn := iter.Opts[iter.Pos]
-	iter.Pos++
-	return rtn
-}
-
-func (iter *OptsIter) Rest() []string {
-	return iter.Opts[iter.Pos:]
+	return strings.HasPrefix(argStr, "-") && argStr != "-" && !strings.HasPrefix(argStr, "-/") && !strings.HasPrefix(argStr, "-::")
 }
 
 func main() {
+	// a panic leaves no history row without an exit code (the panic continues after)
+	defer func() {
+		if r := recover(); r != nil {
+			scripthaus.FinishInterrupted(1)
+			panic(r)
+		}
+	}()
+	guardSignals()
 	// fmt.Printf("args %#v\n", os.Args)
 	gopts, err := parseGlobalOpts(os.Args)
+	if err == nil && gopts.ColorMode != "" {
+		err = render.SetColorMode(gopts.ColorMode)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n\n", err)
-		os.Exit(1)
+		for _, argStr := range os.Args[1:] {
+			gopts.JsonErrors = gopts.JsonErrors || argStr == "--json"
+		}
+		printCliError(gopts, err)
+		exit(1)
+	}
+	gopts.JsonErrors = wantsJsonErrors(gopts)
+	// help and version do not need the config, so they still work if config.json is broken
+	if gopts.CommandName != "" && gopts.CommandName != "help" && gopts.CommandName != "version" {
+		cfg, err := config.Load()
+		if err != nil {
+			printCliError(gopts, err)
+			exit(1)
+		}
+		config.SetGlobal(cfg)
+		if cfg.Strict {
+			gopts.Strict = true
+		}
+	}
+	if exePath, err := os.Executable(); err == nil {
+		commanddef.BinPath = exePath
 	}
 	exitCode := 0
-	if gopts.CommandName == "" || gopts.CommandName == "help" {
+	if gopts.CommandName == "" {
 		runHelpCommand(gopts, true)
-	} else if gopts.CommandName == "version" {
-		runVersionCommand(gopts)
-	} else if gopts.CommandName == "run" {
-		exitCode, err = runRunCommand(gopts)
-	} else if gopts.CommandName == "show" {
-		exitCode, err = runShowCommand(gopts)
-	} else if gopts.CommandName == "add" {
-		exitCode, err = runAddCommand(gopts)
-	} else if gopts.CommandName == "list" {
-		exitCode, err = runListCommand(gopts)
-	} else if gopts.CommandName == "history" {
-		exitCode, err = runHistoryCommand(gopts)
-	} else if gopts.CommandName == "manage" {
-		exitCode, err = runManageCommand(gopts)
+	} else if cmd := lookupCommand(gopts.CommandName); cmd != nil {
+		exitCode, err = cmd.Run(gopts)
+	} else if pluginExe := plugin.Find(gopts.CommandName); pluginExe != "" {
+		exitCode, err = runPluginCommand(pluginExe, gopts)
 	} else {
 		runInvalidCommand(gopts)
-		os.Exit(1)
+		exit(1)
 	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[^scripthaus] ERROR %v\n\n", err)
-		os.Exit(1)
+		printCliError(gopts, err)
+		exit(1)
 	}
-	os.Exit(exitCode)
+	exit(exitCode)
 }
@@ -0,0 +1,47 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRunOptsPipeline(t *testing.T) {
+	// quoted "|" args are script args unless they directly follow the first command
+	for _, args := range [][]string{
+		{".grep", "a|b"},
+		{".grep", "-E", "a|b", "file.txt"},
+		{".grep", "x", "|", ".count"},
+		{"./test.md::grep", "a | b"},
+	} {
+		runOpts, err := parseRunOpts(globalOptsType{CommandName: "run", CommandArgs: args})
+		if err != nil {
+			t.Fatalf("run %q: %v", args, err)
+		}
+		if len(runOpts.Pipeline) > 0 {
+			t.Errorf("run %q: parsed as a pipeline", args)
+		}
+		if !reflect.DeepEqual(runOpts.RunSpec.ScriptArgs, args[1:]) {
+			t.Errorf("run %q: script args %q, want %q", args, runOpts.RunSpec.ScriptArgs, args[1:])
+		}
+	}
+	for _, args := range [][]string{
+		{".a", "|", ".b", "x", "|", ".c"},
+		{"--pipe", ".a", "x", "|", ".b", "x", "|", ".c"},
+		{"--pipe", ".a x | .b x | .c"},
+		{"--pipe", ".a", ".b", ".c"},
+	} {
+		runOpts, err := parseRunOpts(globalOptsType{CommandName: "run", CommandArgs: args})
+		if err != nil {
+			t.Fatalf("run %q: %v", args, err)
+		}
+		if len(runOpts.Pipeline) != 3 {
+			t.Errorf("run %q: %d pipeline stages, want 3", args, len(runOpts.Pipeline))
+		}
+	}
+}
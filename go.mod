@@ -8,5 +8,6 @@ require (
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/mattn/go-sqlite3 v1.14.13
 	github.com/joho/godotenv v1.4.0
+	golang.org/x/crypto v0.9.0
 )
 
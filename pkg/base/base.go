@@ -5,19 +5,22 @@ import "regexp"
 const ScriptHausVersion = "0.5.1"
 const ScHomeVarName = "SCRIPTHAUS_HOME"
 const HomeVarName = "HOME"
+const WinHomeVarName = "USERPROFILE"
 const DBFileName = "scripthaus.db"
 const CurDBVersion = 1
 const ScPathVarName = "SCRIPTHAUS_PATH"
+const HistoryKeyVarName = "SCRIPTHAUS_HISTORY_KEY"
 
 var PlaybookPrefixRe = regexp.MustCompile("^(\\^|[.]*)(?:[a-zA-Z_]|$)")
 var PlaybookFileNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_-]*[.]md$")
 var PlaybookScriptNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_/-]*$")
+var RegistryNameRe = regexp.MustCompile("^@([a-zA-Z0-9_-]+)/([a-zA-Z0-9_-]+)(?:[.]md)?$")
 
 const RunTypePlaybook = "playbook"
 const RunTypeScript = "script"
 
 func ValidScriptTypes() []string {
-	return []string{"sh", "zsh", "tcsh", "bash", "ksh", "fish", "python", "python2", "python3", "js", "node"}
+	return []string{"sh", "zsh", "tcsh", "bash", "ksh", "fish", "python", "python2", "python3", "js", "node", "ts", "typescript", "bun", "cmd", "pwsh", "powershell", "sql"}
 }
 
 func IsValidScriptType(scriptType string) bool {
@@ -28,17 +31,36 @@ func IsValidScriptType(scriptType string) bool {
 	case "python", "python2", "python3":
 		return true
 
-	case "js", "node":
+	case "js", "node", "ts", "typescript", "bun":
 		return true
 
+	case "cmd", "pwsh", "powershell":
+		return true
+
+	case "sql":
+		return true
+
+	default:
+		return false
+	}
+}
+
+// js/ts blocks, run by node or bun (see config "runtimes")
+func IsJsScriptType(scriptType string) bool {
+	switch scriptType {
+	case "js", "node", "ts", "typescript", "bun":
+		return true
 	default:
 		return false
 	}
 }
 
 func GetCommentString(scriptType string) string {
-	if scriptType == "js" || scriptType == "node" {
+	if IsJsScriptType(scriptType) {
 		return "//"
 	}
+	if scriptType == "sql" {
+		return "--"
+	}
 	return "#"
 }
@@ -0,0 +1,97 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package base
+
+import (
+	"sort"
+	"strings"
+)
+
+const maxSuggestions = 3
+
+// levenshtein distance (insertions, deletions, and substitutions), computed on runes
+func EditDistance(s1 string, s2 string) int {
+	r1, r2 := []rune(s1), []rune(s2)
+	prevRow := make([]int, len(r2)+1)
+	curRow := make([]int, len(r2)+1)
+	for j := range prevRow {
+		prevRow[j] = j
+	}
+	for i := 1; i <= len(r1); i++ {
+		curRow[0] = i
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+			curRow[j] = minInt(minInt(prevRow[j]+1, curRow[j-1]+1), prevRow[j-1]+cost)
+		}
+		prevRow, curRow = curRow, prevRow
+	}
+	return prevRow[len(r2)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// returns the candidates closest to name (for "did you mean" messages), best first.
+// candidates must be within an edit distance of a third of the name's length (rounded up)
+// (or start with name).  returns at most 3 suggestions
+func Suggest(name string, candidates []string) []string {
+	type scored struct {
+		Name string
+		Dist int
+	}
+	maxDist := (len(name) + 2) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	var matches []scored
+	seen := make(map[string]bool)
+	lowerName := strings.ToLower(name)
+	for _, candidate := range candidates {
+		if candidate == name || seen[candidate] {
+			continue
+		}
+		dist := EditDistance(lowerName, strings.ToLower(candidate))
+		if dist > maxDist && !(len(name) >= 2 && strings.HasPrefix(candidate, name)) {
+			continue
+		}
+		seen[candidate] = true
+		matches = append(matches, scored{Name: candidate, Dist: dist})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Dist != matches[j].Dist {
+			return matches[i].Dist < matches[j].Dist
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	var rtn []string
+	for idx := 0; idx < len(matches) && idx < maxSuggestions; idx++ {
+		rtn = append(rtn, matches[idx].Name)
+	}
+	return rtn
+}
+
+// "did you mean 'foo' or 'bar'?", returns "" if there are no suggestions
+func DidYouMeanStr(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(suggestions))
+	for idx, suggestion := range suggestions {
+		quoted[idx] = "'" + suggestion + "'"
+	}
+	if len(quoted) == 1 {
+		return "did you mean " + quoted[0] + "?"
+	}
+	return "did you mean " + strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1] + "?"
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// duration statistics for 'scripthaus bench'
+package bench
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type Stats struct {
+	Runs   int
+	Min    time.Duration
+	Max    time.Duration
+	Mean   time.Duration
+	P95    time.Duration
+	StdDev time.Duration // sample standard deviation (0 for a single run)
+}
+
+// returns the zero Stats for no durations
+func Compute(durations []time.Duration) Stats {
+	var rtn Stats
+	rtn.Runs = len(durations)
+	if len(durations) == 0 {
+		return rtn
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rtn.Min = sorted[0]
+	rtn.Max = sorted[len(sorted)-1]
+	rtn.P95 = Percentile(sorted, 95)
+	var sum float64
+	for _, dur := range sorted {
+		sum += float64(dur)
+	}
+	mean := sum / float64(len(sorted))
+	rtn.Mean = time.Duration(mean)
+	if len(sorted) > 1 {
+		var sqSum float64
+		for _, dur := range sorted {
+			sqSum += (float64(dur) - mean) * (float64(dur) - mean)
+		}
+		rtn.StdDev = time.Duration(math.Sqrt(sqSum / float64(len(sorted)-1)))
+	}
+	return rtn
+}
+
+// nearest-rank percentile (0 < p <= 100) of sorted durations
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// the relative change from before to after, e.g. 0.1 for 10% slower (0 if before is 0)
+func Change(before time.Duration, after time.Duration) float64 {
+	if before == 0 {
+		return 0
+	}
+	return float64(after-before) / float64(before)
+}
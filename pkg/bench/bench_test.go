@@ -0,0 +1,60 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	var durations []time.Duration
+	// 20 runs, 1s..20s (out of order)
+	for i := 20; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Second)
+	}
+	stats := Compute(durations)
+	if stats.Runs != 20 || stats.Min != time.Second || stats.Max != 20*time.Second {
+		t.Errorf("bad runs/min/max: %+v", stats)
+	}
+	if stats.Mean != 10500*time.Millisecond {
+		t.Errorf("bad mean %v", stats.Mean)
+	}
+	// nearest rank: ceil(0.95*20) = 19
+	if stats.P95 != 19*time.Second {
+		t.Errorf("bad p95 %v", stats.P95)
+	}
+	if stats.StdDev < 5916*time.Millisecond || stats.StdDev > 5917*time.Millisecond {
+		t.Errorf("bad stddev %v", stats.StdDev)
+	}
+	one := Compute([]time.Duration{time.Second})
+	if one.P95 != time.Second || one.Mean != time.Second || one.StdDev != 0 {
+		t.Errorf("bad single run stats: %+v", one)
+	}
+	if Compute(nil).Runs != 0 {
+		t.Errorf("no durations should be empty stats")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 5},
+		{95, 10},
+		{90, 9},
+		{100, 10},
+		{1, 1},
+	}
+	for _, test := range tests {
+		if got := Percentile(sorted, test.p); got != test.want {
+			t.Errorf("Percentile(%v) = %v, want %v", test.p, got, test.want)
+		}
+	}
+}
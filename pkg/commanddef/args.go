@@ -0,0 +1,254 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const ArgEnvPrefix = "SH_ARG_"
+
+var argNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_-]*$")
+
+// declared with "@scripthaus arg [name] [--required] [--default val] [--desc text]"
+// or "@scripthaus flag [name] [--required] [--bool] [--default val] [--desc text]"
+type ArgSpec struct {
+	Name     string
+	Flag     bool // named flag (--name val) instead of a positional arg
+	Bool     bool // flag takes no value (set to "1" when passed)
+	Required bool
+	Default  string
+	Desc     string
+}
+
+func (arg ArgSpec) EnvVarName() string {
+	return ArgEnvPrefix + strings.ToUpper(strings.ReplaceAll(arg.Name, "-", "_"))
+}
+
+func (arg ArgSpec) UsageStr() string {
+	var str string
+	if arg.Flag && arg.Bool {
+		str = "--" + arg.Name
+	} else if arg.Flag {
+		str = fmt.Sprintf("--%s <val>", arg.Name)
+	} else {
+		str = fmt.Sprintf("<%s>", arg.Name)
+	}
+	if arg.Required {
+		return str
+	}
+	if !arg.Flag {
+		return fmt.Sprintf("[%s]", arg.Name)
+	}
+	return fmt.Sprintf("[%s]", str)
+}
+
+var descOptRe = regexp.MustCompile("(?:^|\\s)--desc(?:\\s|$)")
+
+// dirType is "arg" or "flag".  --desc consumes the rest of the directive (so it can contain spaces,
+// quotes are optional).  the other fields are split with SplitDirectiveData (--default "a b")
+func parseArgDirective(dirType string, data string) (ArgSpec, error) {
+	var rtn ArgSpec
+	rtn.Flag = (dirType == "flag")
+	if loc := descOptRe.FindStringIndex(data); loc != nil {
+		rtn.Desc = singleDirectiveValue(data[loc[1]:])
+		data = data[:loc[0]]
+	}
+	fields, err := SplitDirectiveData(data)
+	if err != nil {
+		return rtn, fmt.Errorf("'%s' directive, %v", dirType, err)
+	}
+	if len(fields) == 0 {
+		return rtn, fmt.Errorf("'%s' directive requires a name", dirType)
+	}
+	rtn.Name = fields[0]
+	if rtn.Flag {
+		rtn.Name = strings.TrimLeft(rtn.Name, "-")
+	}
+	if !argNameRe.MatchString(rtn.Name) {
+		return rtn, fmt.Errorf("'%s' directive has invalid name '%s'", dirType, rtn.Name)
+	}
+	for idx := 1; idx < len(fields); idx++ {
+		field := fields[idx]
+		if field == "--required" {
+			rtn.Required = true
+			continue
+		}
+		if field == "--bool" && rtn.Flag {
+			rtn.Bool = true
+			continue
+		}
+		if field == "--default" {
+			if idx+1 >= len(fields) {
+				return rtn, fmt.Errorf("'%s %s' directive, --default requires a value", dirType, rtn.Name)
+			}
+			idx++
+			rtn.Default = fields[idx]
+			continue
+		}
+		return rtn, fmt.Errorf("'%s %s' directive, invalid option '%s'", dirType, rtn.Name, field)
+	}
+	if rtn.Bool && rtn.Required {
+		return rtn, fmt.Errorf("'%s %s' directive, a --bool flag cannot be --required", dirType, rtn.Name)
+	}
+	return rtn, nil
+}
+
+// returns usage string for command, e.g. ".deploy --region <val> <env> [tag]"
+func (cdef *CommandDef) UsageStr() string {
+	cdef.processDirectives()
+	var buf strings.Builder
+	buf.WriteString(cdef.OrigScriptName())
+	for _, arg := range cdef.Args {
+		if arg.Flag {
+			buf.WriteString(" ")
+			buf.WriteString(arg.UsageStr())
+		}
+	}
+	for _, arg := range cdef.Args {
+		if !arg.Flag {
+			buf.WriteString(" ")
+			buf.WriteString(arg.UsageStr())
+		}
+	}
+	return buf.String()
+}
+
+// multi-line argument descriptions (for show), empty if no args declared
+func (cdef *CommandDef) ArgsHelpStr() string {
+	cdef.processDirectives()
+	if len(cdef.Args) == 0 {
+		return ""
+	}
+	maxNameLen := 0
+	for _, arg := range cdef.Args {
+		if len(arg.UsageStr()) > maxNameLen {
+			maxNameLen = len(arg.UsageStr())
+		}
+	}
+	var buf strings.Builder
+	buf.WriteString("Arguments:\n")
+	for _, arg := range cdef.Args {
+		line := fmt.Sprintf("    %-*s", maxNameLen, arg.UsageStr())
+		if arg.Desc != "" {
+			line += " - " + arg.Desc
+		}
+		if arg.Default != "" {
+			line += fmt.Sprintf(" (default '%s')", arg.Default)
+		}
+		buf.WriteString(strings.TrimRight(line, " "))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func (cdef *CommandDef) hasFlags() bool {
+	for _, arg := range cdef.Args {
+		if arg.Flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (cdef *CommandDef) findFlag(name string) *ArgSpec {
+	for idx := range cdef.Args {
+		if cdef.Args[idx].Flag && cdef.Args[idx].Name == name {
+			return &cdef.Args[idx]
+		}
+	}
+	return nil
+}
+
+// splits out declared flags (--name val, --name=val) from the script args.
+// flags are only parsed if the command declares at least one 'flag' directive.
+// "--" stops flag parsing.  returns (positional-args, flag-values, error)
+func (cdef *CommandDef) parseFlags(scriptArgs []string) ([]string, map[string]string, error) {
+	flagVals := make(map[string]string)
+	if !cdef.hasFlags() {
+		return scriptArgs, flagVals, nil
+	}
+	var positional []string
+	for idx := 0; idx < len(scriptArgs); idx++ {
+		argStr := scriptArgs[idx]
+		if argStr == "--" {
+			positional = append(positional, scriptArgs[idx+1:]...)
+			break
+		}
+		if !strings.HasPrefix(argStr, "--") {
+			positional = append(positional, argStr)
+			continue
+		}
+		flagName := argStr[2:]
+		var flagVal string
+		hasVal := false
+		if eqIdx := strings.Index(flagName, "="); eqIdx != -1 {
+			flagVal = flagName[eqIdx+1:]
+			flagName = flagName[:eqIdx]
+			hasVal = true
+		}
+		flag := cdef.findFlag(flagName)
+		if flag == nil {
+			return nil, nil, fmt.Errorf("unknown flag '--%s', usage: %s", flagName, cdef.UsageStr())
+		}
+		if flag.Bool {
+			if hasVal {
+				return nil, nil, fmt.Errorf("flag '--%s' does not take a value", flagName)
+			}
+			flagVals[flagName] = "1"
+			continue
+		}
+		if !hasVal {
+			if idx+1 >= len(scriptArgs) {
+				return nil, nil, fmt.Errorf("flag '--%s' missing value", flagName)
+			}
+			idx++
+			flagVal = scriptArgs[idx]
+		}
+		flagVals[flagName] = flagVal
+	}
+	return positional, flagVals, nil
+}
+
+// checks required args/flags and returns (positional-args, SH_ARG_[NAME]=[val] env entries, error)
+func (cdef *CommandDef) bindArgs(scriptArgs []string) ([]string, []string, error) {
+	positional, flagVals, err := cdef.parseFlags(scriptArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var env []string
+	posIdx := 0
+	for _, arg := range cdef.Args {
+		var val string
+		hasVal := false
+		if arg.Flag {
+			val, hasVal = flagVals[arg.Name]
+		} else {
+			if posIdx < len(positional) {
+				val = positional[posIdx]
+				hasVal = true
+			}
+			posIdx++
+		}
+		if !hasVal && arg.Required {
+			argType := "argument"
+			if arg.Flag {
+				argType = "flag"
+			}
+			return nil, nil, fmt.Errorf("missing required %s '%s', usage: %s", argType, arg.Name, cdef.UsageStr())
+		}
+		if !hasVal {
+			val = arg.Default
+		}
+		if hasVal || val != "" {
+			env = append(env, fmt.Sprintf("%s=%s", arg.EnvVarName(), val))
+		}
+	}
+	return positional, env, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef_test
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// each command prints its name (argv[0] equivalent) and then each argument on its own line
+const argvPlaybook = "# argv\n\n" +
+	"```bash\n# @scripthaus command sh-argv\necho \"$0\"; for a in \"$@\"; do echo \"$a\"; done\n```\n\n" +
+	"```python\n# @scripthaus command py-argv\nimport sys\nfor a in sys.argv: print(a)\n```\n\n" +
+	"```js\n// @scripthaus command js-argv\nfor (const a of process.argv.slice(1)) console.log(a);\n```\n\n" +
+	"```js\n// @scripthaus command esm-argv\n// @scripthaus esm\nconst args = await Promise.resolve(process.argv.slice(1));\nfor (const a of args) console.log(a);\n```\n"
+
+func parseArgvCommands(t *testing.T) map[string]*commanddef.CommandDef {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "./argv.md", ResolvedFile: "/tmp/argv.md"}
+	defs, _, err := mdparser.ParseCommands(playbook, []byte(argvPlaybook))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	rtn := make(map[string]*commanddef.CommandDef)
+	for idx := range defs {
+		rtn[defs[idx].Name] = &defs[idx]
+	}
+	return rtn
+}
+
+// the script arguments must come through unchanged, including ones that look like options
+var testArgs = []string{"a b", "-c", "--", "", "x"}
+
+func TestScriptArgv(t *testing.T) {
+	cmds := parseArgvCommands(t)
+	tests := []struct {
+		name string
+		exe  string
+	}{
+		{"sh-argv", "bash"},
+		{"py-argv", "python"},
+		{"js-argv", "node"},
+		{"esm-argv", "node"},
+	}
+	for _, test := range tests {
+		cdef := cmds[test.name]
+		if cdef == nil {
+			t.Fatalf("command %s not found", test.name)
+		}
+		if _, err := exec.LookPath(test.exe); err != nil {
+			t.Logf("skipping %s, %s not found", test.name, test.exe)
+			continue
+		}
+		var outBuf bytes.Buffer
+		runSpec := commanddef.SpecType{ScriptArgs: testArgs, NoLog: true, Stdout: &outBuf}
+		err := cdef.CheckCommand(runSpec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		execItem, err := cdef.BuildExecCommand(context.Background(), runSpec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err = execItem.Cmd.Run()
+		execItem.Cleanup()
+		if err != nil {
+			t.Errorf("%s: run error %v", test.name, err)
+			continue
+		}
+		expected := strings.Join(append([]string{cdef.OrigScriptName()}, testArgs...), "\n") + "\n"
+		if outBuf.String() != expected {
+			t.Errorf("%s: bad argv, got %q expected %q", test.name, outBuf.String(), expected)
+		}
+	}
+}
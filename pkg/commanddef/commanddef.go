@@ -9,34 +9,70 @@ package commanddef
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
-	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/direnv"
 	"github.com/scripthaus-dev/scripthaus/pkg/history"
+	"github.com/scripthaus-dev/scripthaus/pkg/notify"
 	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/runtimes"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
 )
 
 type CommandDef struct {
 	Playbook    *pathutil.ResolvedPlaybook
 	Name        string
+	Aliases     []string
 	Lang        string
 	ScriptText  string
 	Info        map[string]string
 	HelpText    string
 	ShortText   string
 	RawCodeText string
+	Section     string // title of the nearest level 1-3 heading above the command ("" if none)
 
-	StartIndex  int
-	StartLineNo int // 1-indexed
+	StartIndex     int  // start of the help text (or the code block if there is no help text)
+	CodeStartIndex int  // start of the opening code fence line (or of the html comment with the block's directives)
+	EndIndex       int  // end of the closing code fence line (first block only for multi-block commands)
+	StartLineNo    int  // 1-indexed
+	NumParts       int  // > 1 if the script is concatenated from multiple blocks (part=N or 'continue')
+	IsBlock        bool // an un-annotated code block selected by index or heading (see mdparser.FindBlock)
 
 	// directives
 	RawDirectives       []RawDirective
+	SectionDirectives   []RawDirective // from html comments in the command's section (LineNo is the playbook line)
 	DirectivesProcessed bool
 	ChangeDir           string
 	NoLog               bool
+	Interpreter         []string          // overrides the default language -> argv mapping
+	Runtimes            map[string]string // from 'runtime' directive, runtime name => version (see pkg/runtimes)
+	Db                  string            // from 'db' directive, the database url (or config name) for sql blocks (see sql.go)
+	Args                []ArgSpec
+	RequiredEnv         []string
+	Env                 []string // from 'env' directives, VAR=VAL (values can be secret references)
+	Tags                []string
+	Hooks               []string      // from 'hook' directives, git hook names (see 'scripthaus hooks')
+	OsList              []string      // from 'os' directive (GOOS names), empty means any
+	ArchList            []string      // from 'arch' directive (GOARCH names), empty means any
+	ShellOpts           []string      // from 'shellopts' directive, nil means use the config default
+	OutputTee           string        // from 'output tee [file]' directive, file name template (see outputtee.go)
+	Notify              string        // from 'notify' directive, "always" or "failure" (see pkg/notify)
+	Stdin               string        // from 'stdin' directive, "inherit" (the default), "closed", or "file:[path]"
+	ESM                 bool          // from 'esm' directive, node/js blocks run as an ES module
+	Matrix              []MatrixAxis  // from 'matrix' directives, run once per combination (see matrix.go)
+	Timeout             time.Duration // from 'timeout' directive, the command is terminated after this long
+	DuplicateLocs       []string      // "file:line" of other blocks that define the same name (the command will not run)
 	Warnings            []string
 }
 
@@ -53,9 +89,17 @@ type SpecType struct {
 
 	ScriptArgs []string
 	ChangeDir  string
+	Notify     string        // from 'run --notify', overrides the 'notify' directive
+	Timeout    time.Duration // from 'run --timeout', overrides the 'timeout' directive
+	Trace      bool          // from 'run -x', print the script's commands/lines as they run (see trace.go)
 
 	// matches exec.Cmd (each entry is of form key=value)
 	Env []string
+
+	// nil means os.Stdin, os.Stdout, and os.Stderr (set by embedders, see pkg/scripthaus)
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 // holds a script-name or playbook-file/playbook-script
@@ -71,9 +115,63 @@ func (cdef *CommandDef) OrigScriptName() string {
 	return fmt.Sprintf("%s::%s", cdef.Playbook.OrigName, cdef.Name)
 }
 
+// "file:line" of the command's definition (the start of its help text or code block)
+func (cdef *CommandDef) Location() string {
+	return fmt.Sprintf("%s:%d", cdef.Playbook.ResolvedFile, cdef.StartLineNo)
+}
+
+// matches the command name or any of its aliases
+func (cdef *CommandDef) MatchesName(name string) bool {
+	if cdef.Name == name {
+		return true
+	}
+	for _, alias := range cdef.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// returns all tags (from 'tag' directives and playbook front matter)
+func (cdef *CommandDef) GetTags() []string {
+	cdef.processDirectives()
+	return cdef.Tags
+}
+
+// returns the git hooks the command is installed for (from 'hook' directives)
+func (cdef *CommandDef) GetHooks() []string {
+	cdef.processDirectives()
+	return cdef.Hooks
+}
+
+// returns the matrix axes from 'matrix' directives (nil if the command is not a matrix command)
+func (cdef *CommandDef) GetMatrix() []MatrixAxis {
+	cdef.processDirectives()
+	return cdef.Matrix
+}
+
+func (cdef *CommandDef) HasAnyTag(tags []string) bool {
+	for _, tag := range cdef.GetTags() {
+		if inSlice(tag, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func inSlice(s string, arr []string) bool {
+	for _, val := range arr {
+		if s == val {
+			return true
+		}
+	}
+	return false
+}
+
 func (cdef *CommandDef) FullScriptName() string {
 	if cdef.Playbook.CanonicalName == "^" || cdef.Playbook.CanonicalName == "." {
-		fmt.Sprintf("%s%s", cdef.Playbook.CanonicalName, cdef.Name)
+		return fmt.Sprintf("%s%s", cdef.Playbook.CanonicalName, cdef.Name)
 	}
 	return fmt.Sprintf("%s::%s", cdef.Playbook.CanonicalName, cdef.Name)
 }
@@ -84,6 +182,36 @@ type ExecItem struct {
 	Cmd            *exec.Cmd
 	FullScriptName string
 	HItem          *history.HistoryItem
+	SecretVals     []string // resolved secret values, must be redacted from any logged output
+	LogArgs        []string // the script arguments as written to history (redacted, see secrets.RedactArgs)
+	TempFiles      []string // removed by Cleanup() after the command exits
+	OutputTeeFile  string   // set if the output is also written to a file ('output tee' directive)
+	Notify         string   // notify when the command finishes, "always" or "failure" (see pkg/notify)
+	MatrixCell     string   // set for each run of a matrix command ("env=dev,region=us")
+	closers        []io.Closer
+
+	// termination (see kill.go)
+	ctx        context.Context
+	cancelFn   context.CancelFunc // cancels the 'timeout' context
+	procGroup  bool
+	exitedCh   chan struct{}
+	termOnce   sync.Once
+	termLock   sync.Mutex
+	termReason string
+}
+
+func (item *ExecItem) Cleanup() {
+	for _, fileName := range item.TempFiles {
+		os.Remove(fileName)
+	}
+	item.TempFiles = nil
+	for _, closer := range item.closers {
+		closer.Close()
+	}
+	item.closers = nil
+	if item.cancelFn != nil {
+		item.cancelFn()
+	}
 }
 
 func (item *ExecItem) CmdShortName() string {
@@ -93,12 +221,40 @@ func (item *ExecItem) CmdShortName() string {
 type RunOptsType struct {
 	Script  ScriptDef
 	RunSpec SpecType // specs can be combined (so they are pulled out separately)
+	Tags    []string // run all commands in the playbook with any of these tags
+	Tmux    string   // "pane" or "window", run in a new tmux pane/window (see pkg/tmux)
+	Reports []string // "[format]=[file]" from --report (see pkg/report)
+
+	// set for 'run a | b | c' or 'run --pipe a b c', stdout of each command is piped into
+	// the next one.  the first stage is the same as Script and RunSpec.ScriptArgs
+	Pipeline []PipeStage
+
+	Matrix []MatrixAxis // from --matrix, merged over the command's 'matrix' directives
+
+	Each     bool // run once per line of stdin (the line is the first script argument)
+	Parallel int  // --parallel, max concurrent runs for Each (0 or 1 runs them one at a time)
+
+	Yes bool // --yes, run code blocks selected by index or heading without confirming
+}
+
+type PipeStage struct {
+	Script     ScriptDef
+	ScriptArgs []string
 }
 
 func setStandardCmdOpts(cmd *exec.Cmd, runSpec SpecType) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if runSpec.Stdin != nil {
+		cmd.Stdin = runSpec.Stdin
+	}
+	if runSpec.Stdout != nil {
+		cmd.Stdout = runSpec.Stdout
+	}
+	if runSpec.Stderr != nil {
+		cmd.Stderr = runSpec.Stderr
+	}
 	cmd.Env = makeFullEnv(runSpec)
 }
 
@@ -114,26 +270,217 @@ func makeOsFileFromString(s string) (*os.File, error) {
 	return reader, nil
 }
 
+// writes the script to a temp file (for interpreters that cannot read the script from an argument)
+func makeTempScriptFile(scriptText string, ext string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "scripthaus-*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temp script file: %w", err)
+	}
+	_, err = tmpFile.WriteString(scriptText)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("cannot write temp script file: %w", err)
+	}
+	return tmpFile.Name(), nil
+}
+
+// '#' is not a comment in cmd.exe, so directive lines are turned into REM lines (keeps line numbers)
+func cmdScriptText(scriptText string) string {
+	lines := strings.Split(scriptText, "\n")
+	for idx, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if (strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//")) && strings.Contains(trimmed, "@scripthaus") {
+			lines[idx] = "REM " + trimmed
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// the script is passed to the interpreter as /dev/fd/3, or over stdin if the
+// interpreter args end with "-" (e.g. "deno run -A -")
+func (cdef *CommandDef) buildInterpreterCommand(runSpec SpecType) (*ExecItem, error) {
+	scriptFd, err := makeOsFileFromString(cdef.ScriptText)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create pipe for interpreter script: %w", err)
+	}
+	interpName := cdef.Interpreter[0]
+	interpArgs := cdef.Interpreter[1:]
+	readStdin := len(interpArgs) > 0 && interpArgs[len(interpArgs)-1] == "-"
+	if !readStdin && runtime.GOOS == "windows" {
+		scriptFd.Close()
+		return nil, fmt.Errorf("interpreter '%s' must read the script from stdin on windows (end the interpreter args with '-')", interpName)
+	}
+	var args []string
+	if readStdin {
+		args = combine(interpArgs, runSpec.ScriptArgs)
+	} else {
+		args = combine(interpArgs, "/dev/fd/3", runSpec.ScriptArgs)
+	}
+	execCmd := exec.Command(interpName, args...)
+	setStandardCmdOpts(execCmd, runSpec)
+	if readStdin {
+		execCmd.Stdin = scriptFd
+	} else {
+		execCmd.ExtraFiles = []*os.File{scriptFd}
+	}
+	return &ExecItem{CmdDef: cdef, CmdName: filepath.Base(interpName), Cmd: execCmd}, nil
+}
+
+var shellOptFlags = map[string]string{
+	"errexit":  "-e",
+	"nounset":  "-u",
+	"pipefail": "-o pipefail",
+	"xtrace":   "-x",
+}
+
+// returns the effective shell options (directive overrides the global config default)
+func (cdef *CommandDef) effectiveShellOpts() []string {
+	if cdef.ShellOpts != nil {
+		return cdef.ShellOpts
+	}
+	var rtn []string
+	for _, opt := range config.Get().ShellOpts {
+		if shellOptFlags[opt] != "" {
+			rtn = append(rtn, opt)
+		}
+	}
+	return rtn
+}
+
+// "set ..." line injected before the script for sh/bash/zsh/ksh blocks ("" if no options)
+func (cdef *CommandDef) shellOptsPrefix() string {
+	if cdef.Lang != "sh" && cdef.Lang != "bash" && cdef.Lang != "zsh" && cdef.Lang != "ksh" {
+		return ""
+	}
+	opts := cdef.effectiveShellOpts()
+	if len(opts) == 0 {
+		return ""
+	}
+	var setFlags []string
+	hasPipefail := false
+	for _, opt := range opts {
+		if opt == "pipefail" {
+			hasPipefail = true
+			continue
+		}
+		setFlags = append(setFlags, shellOptFlags[opt])
+	}
+	var rtn string
+	if len(setFlags) > 0 {
+		rtn = fmt.Sprintf("set %s\n", strings.Join(setFlags, " "))
+	}
+	if hasPipefail {
+		// not every /bin/sh supports pipefail
+		rtn += "(set -o pipefail) 2>/dev/null && set -o pipefail\n"
+	}
+	return rtn
+}
+
+// python -c with a bootstrap that makes sys.argv [command-name, args...] (as if the command was a
+// script file) and names the code after the command in tracebacks.  sys.path[0] stays the
+// current directory and stdin is left for the script
+const pythonBootstrap = `import sys; sys.argv.pop(0); exec(compile(sys.argv.pop(0), sys.argv[0], "exec"))`
+
 func (cdef *CommandDef) buildNormalCommand(ctx context.Context, runSpec SpecType) (*ExecItem, error) {
+	if len(cdef.Interpreter) > 0 {
+		return cdef.buildInterpreterCommand(runSpec)
+	}
 	if cdef.Lang == "sh" || cdef.Lang == "bash" || cdef.Lang == "zsh" || cdef.Lang == "tcsh" || cdef.Lang == "ksh" || cdef.Lang == "fish" {
-		args := append([]string{"-c", cdef.ScriptText, cdef.OrigScriptName()}, runSpec.ScriptArgs...)
-		execCmd := exec.CommandContext(ctx, cdef.Lang, args...)
+		shellName := cdef.Lang
+		if cdef.Lang == "sh" && cdef.Playbook.Config != nil && cdef.Playbook.Config.Shell != "" {
+			shellName = cdef.Playbook.Config.Shell
+		}
+		scriptText := cdef.shellOptsPrefix() + cdef.shellHelperPrefix()
+		if runSpec.Trace {
+			// after the helpers, so only the script's own commands are traced
+			scriptText += traceShellPrefix(cdef.Lang)
+		}
+		scriptText += cdef.ScriptText
+		args := append([]string{"-c", scriptText, cdef.OrigScriptName()}, runSpec.ScriptArgs...)
+		execCmd := exec.Command(shellName, args...)
 		setStandardCmdOpts(execCmd, runSpec)
-		return &ExecItem{CmdDef: cdef, CmdName: cdef.Lang, Cmd: execCmd}, nil
+		return &ExecItem{CmdDef: cdef, CmdName: shellName, Cmd: execCmd}, nil
 	} else if cdef.Lang == "python" || cdef.Lang == "python3" || cdef.Lang == "python2" {
-		args := append([]string{"-c", cdef.ScriptText}, runSpec.ScriptArgs...)
-		execCmd := exec.CommandContext(ctx, cdef.Lang, args...)
+		bootstrap := pythonBootstrap
+		if runSpec.Trace {
+			bootstrap = pythonTraceBootstrap
+		}
+		args := append([]string{"-c", bootstrap, cdef.ScriptText, cdef.OrigScriptName()}, runSpec.ScriptArgs...)
+		execCmd := exec.Command(cdef.Lang, args...)
 		setStandardCmdOpts(execCmd, runSpec)
 		return &ExecItem{CmdDef: cdef, CmdName: cdef.Lang, Cmd: execCmd}, nil
-	} else if cdef.Lang == "node" || cdef.Lang == "js" {
-		args := append([]string{"--eval", cdef.ScriptText, "--"}, runSpec.ScriptArgs...)
-		execCmd := exec.CommandContext(ctx, "node", args...)
+	} else if base.IsJsScriptType(cdef.Lang) {
+		if config.Get().JsRuntime(cdef.Lang) == config.RuntimeBun {
+			return cdef.buildBunCommand(runSpec)
+		}
+		if cdef.Lang == "ts" || cdef.Lang == "typescript" {
+			return nil, fmt.Errorf("cannot run '%s' block with node, set the runtime to \"bun\" in config.json \"runtimes\"", cdef.Lang)
+		}
+		// the command name fills the script path slot, so process.argv.slice(2) is the script arguments
+		var args []string
+		if cdef.ESM {
+			args = append(args, "--input-type=module")
+		}
+		args = append(args, "--eval", cdef.ScriptText, "--", cdef.OrigScriptName())
+		args = append(args, runSpec.ScriptArgs...)
+		execCmd := exec.Command("node", args...)
 		setStandardCmdOpts(execCmd, runSpec)
 		return &ExecItem{CmdDef: cdef, CmdName: "node", Cmd: execCmd}, nil
+	} else if cdef.Lang == "cmd" {
+		// cmd.exe can only run multi-line scripts from a .cmd file
+		echoLine := "@echo off\r\n"
+		if runSpec.Trace {
+			echoLine = "@echo on\r\n"
+		}
+		scriptFile, err := makeTempScriptFile(echoLine+cmdScriptText(cdef.ScriptText), ".cmd")
+		if err != nil {
+			return nil, err
+		}
+		args := append([]string{"/d", "/c", scriptFile}, runSpec.ScriptArgs...)
+		execCmd := exec.Command("cmd.exe", args...)
+		setStandardCmdOpts(execCmd, runSpec)
+		return &ExecItem{CmdDef: cdef, CmdName: "cmd", Cmd: execCmd, TempFiles: []string{scriptFile}}, nil
+	} else if cdef.Lang == "pwsh" || cdef.Lang == "powershell" {
+		// -File (rather than -Command) so the script arguments are available in $args
+		scriptText := cdef.ScriptText
+		if runSpec.Trace {
+			scriptText = pwshTracePrefix + scriptText
+		}
+		scriptFile, err := makeTempScriptFile(scriptText, ".ps1")
+		if err != nil {
+			return nil, err
+		}
+		args := append([]string{"-NoProfile", "-NonInteractive", "-File", scriptFile}, runSpec.ScriptArgs...)
+		execCmd := exec.Command(cdef.Lang, args...)
+		setStandardCmdOpts(execCmd, runSpec)
+		return &ExecItem{CmdDef: cdef, CmdName: cdef.Lang, Cmd: execCmd, TempFiles: []string{scriptFile}}, nil
+	} else if cdef.Lang == "sql" {
+		return cdef.buildSqlCommand(ctx, runSpec)
 	}
 	return nil, fmt.Errorf("invalid command language '%s', not supported", cdef.Lang)
 }
 
+// bun runs the script from a temp file (.ts for typescript blocks), so ES modules and top-level
+// await work without the 'esm' directive.  process.argv.slice(2) is the script arguments
+func (cdef *CommandDef) buildBunCommand(runSpec SpecType) (*ExecItem, error) {
+	ext := ".js"
+	if cdef.Lang == "ts" || cdef.Lang == "typescript" {
+		ext = ".ts"
+	}
+	scriptFile, err := makeTempScriptFile(cdef.ScriptText, ext)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"run", scriptFile}, runSpec.ScriptArgs...)
+	execCmd := exec.Command("bun", args...)
+	setStandardCmdOpts(execCmd, runSpec)
+	return &ExecItem{CmdDef: cdef, CmdName: "bun", Cmd: execCmd, TempFiles: []string{scriptFile}}, nil
+}
+
 func combine(rest ...interface{}) []string {
 	var list []string
 	for _, item := range rest {
@@ -150,43 +497,248 @@ func combine(rest ...interface{}) []string {
 	return list
 }
 
+// source is used for warnings (e.g. "'cd' directive")
+func (cdef *CommandDef) setChangeDir(dirName string, source string) {
+	dirName = strings.TrimSpace(dirName)
+	if dirName == ":playbook" {
+		cdef.ChangeDir = cdef.Playbook.PlaybookDir()
+		return
+	}
+	if dirName == ":current" {
+		cdef.ChangeDir = ""
+		return
+	}
+	if strings.HasPrefix(dirName, "~") {
+		osUser, _ := user.Current()
+		if osUser != nil && osUser.HomeDir != "" {
+			cdef.ChangeDir = filepath.Join(osUser.HomeDir, dirName[1:])
+		}
+		return
+	}
+	if (strings.HasPrefix(dirName, "./") || strings.HasPrefix(dirName, "../")) && cdef.Playbook.PlaybookDir() != "" {
+		cdef.ChangeDir = filepath.Join(cdef.Playbook.PlaybookDir(), dirName)
+		return
+	}
+	if !filepath.IsAbs(dirName) {
+		cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("%s must be absolute or relative to the playbook (./), got '%s' (ignoring)", source, dirName))
+		return
+	}
+	cdef.ChangeDir = dirName
+}
+
+// playbook-wide defaults are applied first, so the command's own directives override them
+func (cdef *CommandDef) applyPlaybookConfig() {
+	pbConfig := cdef.Playbook.Config
+	if pbConfig == nil {
+		return
+	}
+	if pbConfig.ChangeDir != "" {
+		cdef.setChangeDir(pbConfig.ChangeDir, "playbook 'cd' setting")
+	}
+	for _, tag := range pbConfig.Tags {
+		if !inSlice(tag, cdef.Tags) {
+			cdef.Tags = append(cdef.Tags, tag)
+		}
+	}
+}
+
+// all of the valid @scripthaus directive types (code block directives + html comment directives)
+var DirectiveTypes = []string{"command", "alias", "continue", "cd", "nolog", "interpreter", "arg", "flag", "env", "shellopts", "os", "arch", "tag", "require-env", "include", "hook", "output", "notify", "stdin", "esm", "matrix", "timeout", "runtime", "db"}
+
+// true for directives that can be shared by a section (html comment directives), the ones that
+// name or define a single command cannot be
+func IsSectionDirective(dirType string) bool {
+	switch dirType {
+	case "command", "alias", "continue", "arg", "flag", "hook", "include":
+		return false
+	}
+	return inSlice(dirType, DirectiveTypes)
+}
+
 func (cdef *CommandDef) processDirectives() error {
 	if cdef.DirectivesProcessed {
 		return nil
 	}
 	cdef.DirectivesProcessed = true
+	cdef.applyPlaybookConfig()
+	if cdef.Info["interpreter"] != "" {
+		cdef.Interpreter = strings.Fields(cdef.Info["interpreter"])
+	}
+	// section directives come first so the command's own directives override them
+	for _, dir := range cdef.SectionDirectives {
+		cdef.processDirective(dir)
+	}
 	for _, dir := range cdef.RawDirectives {
-		if dir.Type == "command" {
-			continue // already processed
-		} else if dir.Type == "cd" {
-			dirName := strings.TrimSpace(dir.Data)
-			if dirName == ":playbook" {
-				cdef.ChangeDir = cdef.Playbook.PlaybookDir()
+		cdef.processDirective(dir)
+	}
+	return nil
+}
+
+// processes the directives (if they have not been processed yet) and returns the command's warnings
+func (cdef *CommandDef) DirectiveWarnings() []string {
+	cdef.processDirectives()
+	return cdef.Warnings
+}
+
+func (cdef *CommandDef) processDirective(dir RawDirective) {
+	if dir.Type == "command" || dir.Type == "alias" || dir.Type == "continue" {
+		return // already processed (by the parser)
+	} else if dir.Type == "cd" {
+		cdef.setChangeDir(singleDirectiveValue(dir.Data), "'cd' directive")
+	} else if dir.Type == "nolog" {
+		cdef.NoLog = true
+	} else if dir.Type == "esm" {
+		cdef.ESM = true
+	} else if dir.Type == "interpreter" {
+		interp, ok := cdef.directiveFields(dir)
+		if !ok {
+			return
+		}
+		if len(interp) == 0 {
+			cdef.Warnings = append(cdef.Warnings, "'interpreter' directive requires a command (ignoring)")
+			return
+		}
+		cdef.Interpreter = interp
+	} else if dir.Type == "arg" || dir.Type == "flag" {
+		argSpec, err := parseArgDirective(dir.Type, dir.Data)
+		if err != nil {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("%v (ignoring)", err))
+			return
+		}
+		if cdef.findArg(argSpec.Name) != nil {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("duplicate '%s' directive for '%s' (ignoring)", dir.Type, argSpec.Name))
+			return
+		}
+		if !argSpec.Flag && argSpec.Required && cdef.hasOptionalPositional() {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("required 'arg' '%s' declared after an optional arg", argSpec.Name))
+		}
+		cdef.Args = append(cdef.Args, argSpec)
+	} else if dir.Type == "env" {
+		envPairs, ok := cdef.directiveFields(dir)
+		if !ok {
+			return
+		}
+		for _, envPair := range envPairs {
+			eqIdx := strings.Index(envPair, "=")
+			if eqIdx == -1 || !envVarNameRe.MatchString(envPair[:eqIdx]) {
+				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'env' directive, invalid entry '%s', must be VAR=VAL (ignoring)", envPair))
 				continue
 			}
-			if dirName == ":current" {
-				cdef.ChangeDir = ""
+			cdef.Env = append(cdef.Env, envPair)
+		}
+	} else if dir.Type == "shellopts" {
+		shellOpts := []string{}
+		for _, opt := range parsePlatformList(dir.Data, nil) {
+			if opt == "none" {
 				continue
 			}
-			if strings.HasPrefix(dirName, "~") {
-				osUser, _ := user.Current()
-				if osUser != nil && osUser.HomeDir != "" {
-					cdef.ChangeDir = path.Join(osUser.HomeDir, dirName[1:])
-				}
+			if shellOptFlags[opt] == "" {
+				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'shellopts' directive, invalid option '%s' (ignoring)", opt))
 				continue
 			}
-			if !path.IsAbs(dirName) {
-				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'cd' directive must be absolute, got '%s' (ignoring)", dirName))
+			shellOpts = append(shellOpts, opt)
+		}
+		cdef.ShellOpts = shellOpts
+	} else if dir.Type == "os" {
+		cdef.OsList = append(cdef.OsList, parsePlatformList(dir.Data, osAliases)...)
+	} else if dir.Type == "arch" {
+		cdef.ArchList = append(cdef.ArchList, parsePlatformList(dir.Data, archAliases)...)
+	} else if dir.Type == "tag" {
+		tags, _ := cdef.directiveFields(dir)
+		for _, tag := range tags {
+			if !inSlice(tag, cdef.Tags) {
+				cdef.Tags = append(cdef.Tags, tag)
+			}
+		}
+	} else if dir.Type == "output" {
+		fields, ok := cdef.directiveFields(dir)
+		if !ok {
+			return
+		}
+		if len(fields) < 2 || fields[0] != "tee" {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'output' directive must be 'output tee [file]', got '%s' (ignoring)", dir.Data))
+			return
+		}
+		outputTee := singleDirectiveValue(strings.TrimSpace(dir.Data)[len("tee"):])
+		if _, err := expandTemplate(outputTee, cdef.outputTemplateVars(time.Now())); err != nil {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'output' directive, %v (ignoring)", err))
+			return
+		}
+		cdef.OutputTee = outputTee
+	} else if dir.Type == "notify" {
+		mode := notify.ParseMode(dir.Data)
+		if mode == "" {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'notify' directive, invalid mode '%s', must be 'always' or 'failure' (ignoring)", dir.Data))
+			return
+		}
+		cdef.Notify = mode
+	} else if dir.Type == "stdin" {
+		stdin, err := parseStdinDirective(dir.Data)
+		if err != nil {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'stdin' directive, %v (ignoring)", err))
+			return
+		}
+		cdef.Stdin = stdin
+	} else if dir.Type == "matrix" {
+		axes, err := ParseMatrixAxes(dir.Data)
+		if err != nil || len(axes) == 0 {
+			if err == nil {
+				err = fmt.Errorf("requires VAR=val1,val2,...")
+			}
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'matrix' directive, %v (ignoring)", err))
+			return
+		}
+		cdef.Matrix = MergeMatrixAxes(cdef.Matrix, axes)
+	} else if dir.Type == "timeout" {
+		timeout, err := time.ParseDuration(strings.TrimSpace(dir.Data))
+		if err != nil || timeout <= 0 {
+			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'timeout' directive, invalid duration '%s', e.g. '30s' or '10m' (ignoring)", strings.TrimSpace(dir.Data)))
+			return
+		}
+		cdef.Timeout = timeout
+	} else if dir.Type == "runtime" {
+		entries, _ := cdef.directiveFields(dir)
+		for _, entry := range entries {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 || parts[1] == "" {
+				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'runtime' directive, invalid entry '%s', must be name=version, e.g. python=3.11 (ignoring)", entry))
 				continue
 			}
-			cdef.ChangeDir = dirName
-		} else if dir.Type == "nolog" {
-			cdef.NoLog = true
-		} else {
-			cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("invalid directive '%s' (ignoring)", dir.Type))
+			if !runtimes.IsKnown(parts[0]) {
+				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'runtime' directive, unknown runtime '%s' (ignoring)", parts[0]))
+				continue
+			}
+			if cdef.Runtimes == nil {
+				cdef.Runtimes = make(map[string]string)
+			}
+			cdef.Runtimes[parts[0]] = parts[1]
+		}
+	} else if dir.Type == "db" {
+		db := singleDirectiveValue(dir.Data)
+		if db == "" {
+			cdef.Warnings = append(cdef.Warnings, "'db' directive requires a database url or name (ignoring)")
+			return
+		}
+		cdef.Db = db
+	} else if dir.Type == "hook" {
+		hooks, _ := cdef.directiveFields(dir)
+		for _, hook := range hooks {
+			if !inSlice(hook, cdef.Hooks) {
+				cdef.Hooks = append(cdef.Hooks, hook)
+			}
 		}
+	} else if dir.Type == "require-env" {
+		envVars, _ := cdef.directiveFields(dir)
+		for _, envVar := range envVars {
+			if !envVarNameRe.MatchString(envVar) {
+				cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'require-env' directive, invalid variable name '%s' (ignoring)", envVar))
+				continue
+			}
+			cdef.RequiredEnv = append(cdef.RequiredEnv, envVar)
+		}
+	} else {
+		cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("invalid directive '%s' (ignoring)", dir.Type))
 	}
-	return nil
 }
 
 func addToEnvMap(envMap map[string]string, envEntry string) {
@@ -203,29 +755,277 @@ func makeEnvMap(fullEnv []string) map[string]string {
 	return rtn
 }
 
+// removes the entries that are overridden by a later entry for the same variable
+func dedupEnv(env []string) []string {
+	lastIdx := make(map[string]int)
+	for idx, envEntry := range env {
+		lastIdx[strings.SplitN(envEntry, "=", 2)[0]] = idx
+	}
+	var rtn []string
+	for idx, envEntry := range env {
+		if lastIdx[strings.SplitN(envEntry, "=", 2)[0]] == idx {
+			rtn = append(rtn, envEntry)
+		}
+	}
+	return rtn
+}
+
 func makeFullEnv(runSpec SpecType) []string {
 	fullEnv := os.Environ()
 	fullEnv = append(fullEnv, runSpec.Env...)
 	return fullEnv
 }
 
+func (cdef *CommandDef) findArg(name string) *ArgSpec {
+	for idx := range cdef.Args {
+		if cdef.Args[idx].Name == name {
+			return &cdef.Args[idx]
+		}
+	}
+	return nil
+}
+
+func (cdef *CommandDef) hasOptionalPositional() bool {
+	for _, arg := range cdef.Args {
+		if !arg.Flag && !arg.Required {
+			return true
+		}
+	}
+	return false
+}
+
+// the project's direnv environment when "direnv" is "load" in config.json (it goes first, the
+// playbook and the command can override it)
+func (cdef *CommandDef) direnvEnv(ctx context.Context) ([]string, error) {
+	if config.Get().DirenvMode() != config.DirenvLoad || cdef.Playbook == nil {
+		return nil, nil
+	}
+	projectDir := cdef.Playbook.ProjectDir
+	if !direnv.HasEnvrc(projectDir) || direnv.IsLoaded(projectDir) {
+		return nil, nil
+	}
+	return direnv.Export(ctx, projectDir)
+}
+
+func (cdef *CommandDef) playbookEnv() []string {
+	if cdef.Playbook.Config == nil {
+		return nil
+	}
+	return cdef.Playbook.Config.Env
+}
+
+var envVarNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// checks the variables from 'require-env' directives against the environment the
+// script will run with (os env + direnv env + --env + arg env).  empty values count as missing.
+func (cdef *CommandDef) checkRequiredEnv(runSpec SpecType, argsEnv []string) error {
+	if len(cdef.RequiredEnv) == 0 {
+		return nil
+	}
+	projectEnv, err := cdef.direnvEnv(context.Background())
+	if err != nil {
+		return err
+	}
+	envMap := makeEnvMap(combine(os.Environ(), projectEnv, cdef.playbookEnv(), cdef.Env, argsEnv, runSpec.Env))
+	var missing []string
+	for _, envVar := range cdef.RequiredEnv {
+		if envMap[envVar] == "" {
+			missing = append(missing, envVar)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("command '%s' requires environment variables that are not set: %s", cdef.OrigScriptName(), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+var osAliases = map[string]string{"macos": "darwin", "osx": "darwin", "mac": "darwin"}
+var archAliases = map[string]string{"x86_64": "amd64", "x64": "amd64", "aarch64": "arm64"}
+
+// accepts comma and/or space separated lists
+func parsePlatformList(data string, aliases map[string]string) []string {
+	var rtn []string
+	for _, val := range strings.FieldsFunc(data, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		val = strings.ToLower(val)
+		if aliases[val] != "" {
+			val = aliases[val]
+		}
+		rtn = append(rtn, val)
+	}
+	return rtn
+}
+
+// the script text without the @scripthaus directive lines
+func (cdef *CommandDef) ScriptBody() string {
+	return stripDirectiveLines(cdef.ScriptText)
+}
+
+// parsed metadata for 'show --meta' (one "key: value" per line, empty values are omitted)
+func (cdef *CommandDef) MetaStr() string {
+	cdef.processDirectives()
+	var buf strings.Builder
+	writeField := func(key string, val string) {
+		if val != "" {
+			buf.WriteString(fmt.Sprintf("%-12s %s\n", key+":", val))
+		}
+	}
+	writeField("name", cdef.Name)
+	writeField("aliases", strings.Join(cdef.Aliases, ", "))
+	writeField("usage", cdef.UsageStr())
+	writeField("shorttext", cdef.ShortText)
+	writeField("lang", cdef.Lang)
+	writeField("playbook", cdef.Playbook.OrigName)
+	writeField("location", cdef.Location())
+	writeField("section", cdef.Section)
+	if cdef.NumParts > 1 {
+		writeField("parts", fmt.Sprintf("%d", cdef.NumParts))
+	}
+	writeField("tags", strings.Join(cdef.Tags, ", "))
+	writeField("cd", cdef.ChangeDir)
+	writeField("interpreter", strings.Join(cdef.Interpreter, " "))
+	writeField("env", strings.Join(cdef.Env, " "))
+	writeField("require-env", strings.Join(cdef.RequiredEnv, " "))
+	writeField("os", strings.Join(cdef.OsList, " "))
+	writeField("arch", strings.Join(cdef.ArchList, " "))
+	writeField("shellopts", strings.Join(cdef.effectiveShellOpts(), " "))
+	var matrixStrs []string
+	for _, axis := range cdef.Matrix {
+		matrixStrs = append(matrixStrs, axis.String())
+	}
+	writeField("matrix", strings.Join(matrixStrs, " "))
+	if cdef.Timeout > 0 {
+		writeField("timeout", cdef.Timeout.String())
+	}
+	writeField("runtime", strings.Join(cdef.runtimeEntries(), " "))
+	writeField("db", cdef.Db)
+	if cdef.NoLog {
+		writeField("nolog", "true")
+	}
+	if len(cdef.RawDirectives) > 0 || len(cdef.SectionDirectives) > 0 {
+		buf.WriteString("directives:\n")
+		for _, dir := range cdef.SectionDirectives {
+			buf.WriteString(strings.TrimRight(fmt.Sprintf("    %-4d %s %s", dir.LineNo, dir.Type, dir.Data), " "))
+			buf.WriteString(" (section)\n")
+		}
+		for _, dir := range cdef.RawDirectives {
+			buf.WriteString(strings.TrimRight(fmt.Sprintf("    %-4d %s %s", cdef.StartLineNo+dir.LineNo, dir.Type, dir.Data), " "))
+			buf.WriteString("\n")
+		}
+	}
+	for _, warning := range cdef.Warnings {
+		writeField("warning", warning)
+	}
+	return buf.String()
+}
+
+// returns an error if the command's 'os'/'arch' directives do not match the current platform
+func (cdef *CommandDef) CheckPlatform() error {
+	cdef.processDirectives()
+	if len(cdef.DuplicateLocs) > 0 {
+		locs := append([]string{cdef.Location()}, cdef.DuplicateLocs...)
+		return fmt.Errorf("command '%s' is ambiguous, it is defined more than once (%s)", cdef.OrigScriptName(), strings.Join(locs, ", "))
+	}
+	if len(cdef.OsList) > 0 && !inSlice(runtime.GOOS, cdef.OsList) {
+		return fmt.Errorf("command '%s' only runs on os %s (current os is %s)", cdef.OrigScriptName(), strings.Join(cdef.OsList, ","), runtime.GOOS)
+	}
+	if len(cdef.ArchList) > 0 && !inSlice(runtime.GOARCH, cdef.ArchList) {
+		return fmt.Errorf("command '%s' only runs on arch %s (current arch is %s)", cdef.OrigScriptName(), strings.Join(cdef.ArchList, ","), runtime.GOARCH)
+	}
+	return nil
+}
+
 func (cdef *CommandDef) CheckCommand(runSpec SpecType) error {
 	err := cdef.processDirectives()
 	if err != nil {
 		return err
 	}
+	err = cdef.CheckPlatform()
+	if err != nil {
+		return err
+	}
+	if runSpec.Trace {
+		err = cdef.checkTrace()
+		if err != nil {
+			return err
+		}
+	}
+	_, argsEnv, err := cdef.bindArgs(runSpec.ScriptArgs)
+	if err != nil {
+		return err
+	}
+	err = cdef.checkRequiredEnv(runSpec, argsEnv)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 func (cdef *CommandDef) BuildExecCommand(ctx context.Context, runSpec SpecType) (*ExecItem, error) {
+	origScriptArgs := runSpec.ScriptArgs
+	positional, argsEnv, err := cdef.bindArgs(runSpec.ScriptArgs)
+	if err != nil {
+		return nil, err
+	}
+	runSpec.ScriptArgs = positional
+	// recorded unresolved, so secret references are recorded instead of their values
+	recordEnv := combine(cdef.playbookEnv(), cdef.Env, runSpec.Env)
+	projectEnv, err := cdef.direnvEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// runSpec.Env goes last so explicit --env values take precedence
+	runSpec.Env = combine(projectEnv, cdef.contextEnv(), cdef.playbookEnv(), cdef.Env, argsEnv, runSpec.Env)
+	// secrets are resolved at exec time and are never written to history
+	resolvedEnv, secretVals, err := secrets.ResolveEnv(ctx, runSpec.Env)
+	if err != nil {
+		return nil, err
+	}
+	runSpec.Env = resolvedEnv
+	resolvedRuntimes, err := cdef.resolveRuntimes()
+	if err != nil {
+		return nil, err
+	}
+	if len(resolvedRuntimes) > 0 {
+		runSpec.Env = append(runSpec.Env, runtimePathEnv(resolvedRuntimes, runSpec.Env))
+	}
 	execItem, err := cdef.buildNormalCommand(ctx, runSpec)
 	if err != nil {
 		return nil, err
 	}
+	execItem.Cmd = useRuntimeExe(execItem.Cmd, resolvedRuntimes)
+	execItem.ctx = ctx
+	timeout := cdef.Timeout
+	if runSpec.Timeout > 0 {
+		timeout = runSpec.Timeout
+	}
+	if timeout > 0 {
+		execItem.ctx, execItem.cancelFn = context.WithTimeout(ctx, timeout)
+	}
 	if cdef.ChangeDir != "" {
 		execItem.Cmd.Dir = cdef.ChangeDir
 	}
 	execItem.FullScriptName = cdef.FullScriptName()
+	execItem.SecretVals = append(execItem.SecretVals, secretVals...)
+	redactVals := append(secrets.SensitiveEnvVals(os.Environ()), secrets.SensitiveEnvVals(resolvedEnv)...)
+	execItem.LogArgs = secrets.RedactArgs(origScriptArgs, append(redactVals, secretVals...), config.Get().RedactRegexps())
+	execItem.Notify = cdef.Notify
+	if runSpec.Notify != "" {
+		execItem.Notify = runSpec.Notify
+	}
+	if cdef.Stdin != "" && runSpec.Stdin == nil {
+		err = execItem.setupStdin()
+		if err != nil {
+			execItem.Cleanup()
+			return nil, err
+		}
+	}
+	if cdef.OutputTee != "" {
+		err = execItem.setupOutputTee()
+		if err != nil {
+			execItem.Cleanup()
+			return nil, err
+		}
+	}
 	shouldLog := true
 	if runSpec.NoLog {
 		shouldLog = false
@@ -243,7 +1043,10 @@ func (cdef *CommandDef) BuildExecCommand(ctx context.Context, runSpec SpecType)
 		if cdef.ChangeDir != "" {
 			execItem.HItem.Cwd = cdef.ChangeDir
 		}
-		execItem.HItem.EncodeCmdLine(runSpec.ScriptArgs)
+		execItem.HItem.EncodeCmdLine(execItem.LogArgs)
+		if config.Get().RecordEnv {
+			execItem.HItem.SetEnv(secrets.RedactEnv(dedupEnv(recordEnv)))
+		}
 	}
 	return execItem, nil
 }
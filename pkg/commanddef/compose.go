@@ -0,0 +1,52 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"os/exec"
+	"regexp"
+)
+
+const BinVarName = "SCRIPTHAUS_BIN"
+const PlaybookVarName = "SCRIPTHAUS_PLAYBOOK"
+
+// the scripthaus executable passed to commands as SCRIPTHAUS_BIN, set by the CLI.
+// if empty, "scripthaus" is looked up on PATH (for embedders, see pkg/scripthaus)
+var BinPath string
+
+// defined for sh/bash/zsh/ksh scripts that use it, runs a sibling command from the same
+// playbook (not logged to history): sh_run other-command "$@"
+const shRunHelper = `sh_run() { _sh_run_cmd="$1"; shift; "$SCRIPTHAUS_BIN" run --nolog "$SCRIPTHAUS_PLAYBOOK::$_sh_run_cmd" "$@"; }` + "\n"
+
+var shRunUseRe = regexp.MustCompile("\\bsh_run\\b")
+
+// SCRIPTHAUS_BIN and SCRIPTHAUS_PLAYBOOK (so scripts can run other commands from their playbook)
+func (cdef *CommandDef) contextEnv() []string {
+	var rtn []string
+	binPath := BinPath
+	if binPath == "" {
+		binPath, _ = exec.LookPath("scripthaus")
+	}
+	if binPath != "" {
+		rtn = append(rtn, BinVarName+"="+binPath)
+	}
+	if cdef.Playbook != nil && cdef.Playbook.ResolvedFile != "" {
+		rtn = append(rtn, PlaybookVarName+"="+cdef.Playbook.ResolvedFile)
+	}
+	return rtn
+}
+
+// the sh_run function definition if the (sh/bash/zsh/ksh) script uses it, otherwise ""
+func (cdef *CommandDef) shellHelperPrefix() string {
+	if cdef.Lang != "sh" && cdef.Lang != "bash" && cdef.Lang != "zsh" && cdef.Lang != "ksh" {
+		return ""
+	}
+	if !shRunUseRe.MatchString(cdef.ScriptText) {
+		return ""
+	}
+	return shRunHelper
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// an invalid entry is skipped, the entries after it are still used
+func TestDirectiveInvalidEntries(t *testing.T) {
+	cdef := &CommandDef{Name: "test", Playbook: &pathutil.ResolvedPlaybook{OrigName: "test.md", ResolvedFile: "/tmp/test.md"}}
+	cdef.RawDirectives = []RawDirective{
+		{Type: "env", Data: "A=1 bad B=2"},
+		{Type: "require-env", Data: "TOKEN 1bad HOST"},
+		{Type: "shellopts", Data: "errexit bogus pipefail"},
+	}
+	cdef.processDirectives()
+	if !reflect.DeepEqual(cdef.Env, []string{"A=1", "B=2"}) {
+		t.Errorf("env: got %q", cdef.Env)
+	}
+	if !reflect.DeepEqual(cdef.RequiredEnv, []string{"TOKEN", "HOST"}) {
+		t.Errorf("require-env: got %q", cdef.RequiredEnv)
+	}
+	if !reflect.DeepEqual(cdef.ShellOpts, []string{"errexit", "pipefail"}) {
+		t.Errorf("shellopts: got %q", cdef.ShellOpts)
+	}
+	if len(cdef.Warnings) != 3 {
+		t.Errorf("expected 3 warnings, got %q", cdef.Warnings)
+	}
+}
+
+func TestShellOptsNone(t *testing.T) {
+	cdef := &CommandDef{Name: "test", Playbook: &pathutil.ResolvedPlaybook{OrigName: "test.md", ResolvedFile: "/tmp/test.md"}}
+	cdef.ShellOpts = []string{"errexit", "nounset"} // e.g. the config default
+	cdef.RawDirectives = []RawDirective{{Type: "shellopts", Data: "none"}}
+	cdef.processDirectives()
+	if cdef.ShellOpts == nil || len(cdef.ShellOpts) != 0 {
+		t.Errorf("shellopts none: expected an empty list, got %q", cdef.ShellOpts)
+	}
+}
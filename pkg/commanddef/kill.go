@@ -0,0 +1,123 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+)
+
+// why scripthaus terminated a command (recorded in history, see history.TermReasonMdKey)
+const TermReasonTimeout = "timeout"
+const TermReasonInterrupt = "interrupt"
+const TermReasonCancelled = "cancelled"
+
+// starts the command.  if the run's context is done before the command exits (a 'timeout'
+// or a cancelled daemon run) the command is terminated.  when stdin is not a terminal the
+// command gets its own process group so terminating it also stops anything it started
+// (a command reading from the terminal has to stay in the terminal's foreground group)
+func (item *ExecItem) Start() error {
+	if !isTerminalReader(item.Cmd.Stdin) {
+		setProcessGroup(item.Cmd)
+		item.procGroup = true
+	}
+	err := item.Cmd.Start()
+	if err != nil {
+		return err
+	}
+	item.exitedCh = make(chan struct{})
+	if item.ctx != nil {
+		go func() {
+			select {
+			case <-item.ctx.Done():
+				reason := TermReasonCancelled
+				if errors.Is(item.ctx.Err(), context.DeadlineExceeded) {
+					reason = TermReasonTimeout
+				}
+				item.Terminate(reason)
+
+			case <-item.exitedCh:
+			}
+		}()
+	}
+	return nil
+}
+
+// waits for the command started with Start to exit
+func (item *ExecItem) Wait() error {
+	err := item.Cmd.Wait()
+	close(item.exitedCh)
+	return err
+}
+
+// sends SIGTERM to the command (its process group if it has one), and SIGKILL if it has not
+// exited after the grace period ("kill_grace" in config.json).  only the first call does
+// anything, the reason is returned by TermReason.  on windows the command is killed right away
+func (item *ExecItem) Terminate(reason string) {
+	item.termOnce.Do(func() {
+		if item.Cmd.Process == nil || item.exitedCh == nil {
+			return
+		}
+		select {
+		case <-item.exitedCh:
+			return
+		default:
+		}
+		item.termLock.Lock()
+		item.termReason = reason
+		item.termLock.Unlock()
+		terminateProcess(item.Cmd.Process, item.procGroup)
+		go func() {
+			select {
+			case <-item.exitedCh:
+			case <-time.After(config.Get().KillGraceDuration()):
+				killProcess(item.Cmd.Process, item.procGroup)
+			}
+		}()
+	})
+}
+
+// why the command was terminated by Terminate, "" if it exited on its own
+func (item *ExecItem) TermReason() string {
+	item.termLock.Lock()
+	defer item.termLock.Unlock()
+	return item.termReason
+}
+
+// the exit code for the error returned by Wait, 128+[signal] (like a shell) if the command
+// was killed by a signal
+func WaitExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func isTerminalReader(r interface{}) bool {
+	fd, ok := r.(*os.File)
+	if !ok || fd == nil {
+		return false
+	}
+	finfo, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+	return finfo.Mode()&os.ModeCharDevice != 0
+}
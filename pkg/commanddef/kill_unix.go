@@ -0,0 +1,40 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !windows
+// +build !windows
+
+package commanddef
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func signalProcess(proc *os.Process, procGroup bool, sig syscall.Signal) {
+	if procGroup {
+		// the process group id is the pid (Setpgid)
+		syscall.Kill(-proc.Pid, sig)
+		return
+	}
+	proc.Signal(sig)
+}
+
+func terminateProcess(proc *os.Process, procGroup bool) {
+	signalProcess(proc, procGroup, syscall.SIGTERM)
+}
+
+func killProcess(proc *os.Process, procGroup bool) {
+	signalProcess(proc, procGroup, syscall.SIGKILL)
+}
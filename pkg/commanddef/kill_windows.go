@@ -0,0 +1,26 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build windows
+// +build windows
+
+package commanddef
+
+import (
+	"os"
+	"os/exec"
+)
+
+// windows has no process groups to signal (and no SIGTERM), commands are killed directly
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcess(proc *os.Process, procGroup bool) {
+	proc.Kill()
+}
+
+func killProcess(proc *os.Process, procGroup bool) {
+	proc.Kill()
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"fmt"
+	"strings"
+)
+
+// more cells than this is almost certainly a mistake
+const MaxMatrixCells = 256
+
+// one dimension of a matrix run, the command runs once per value with VAR=value in its environment
+type MatrixAxis struct {
+	Var    string
+	Values []string
+}
+
+// "VAR=a,b,c"
+func (axis MatrixAxis) String() string {
+	return axis.Var + "=" + strings.Join(axis.Values, ",")
+}
+
+// parses whitespace separated "VAR=a,b,c" entries (from the 'matrix' directive or 'run --matrix'),
+// values with spaces can be quoted, e.g. MSG="a b,c d"
+func ParseMatrixAxes(data string) ([]MatrixAxis, error) {
+	var rtn []MatrixAxis
+	fields, err := SplitDirectiveData(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		eqIdx := strings.Index(field, "=")
+		if eqIdx == -1 || !envVarNameRe.MatchString(field[:eqIdx]) {
+			return nil, fmt.Errorf("invalid matrix entry '%s', must be VAR=val1,val2,...", field)
+		}
+		axis := MatrixAxis{Var: field[:eqIdx]}
+		for _, val := range strings.Split(field[eqIdx+1:], ",") {
+			if val != "" {
+				axis.Values = append(axis.Values, val)
+			}
+		}
+		if len(axis.Values) == 0 {
+			return nil, fmt.Errorf("invalid matrix entry '%s', no values", field)
+		}
+		rtn = MergeMatrixAxes(rtn, []MatrixAxis{axis})
+	}
+	return rtn, nil
+}
+
+// axes in overrides replace the axes in base with the same variable, new axes are added at the end
+func MergeMatrixAxes(base []MatrixAxis, overrides []MatrixAxis) []MatrixAxis {
+	rtn := append([]MatrixAxis(nil), base...)
+	for _, axis := range overrides {
+		found := false
+		for idx := range rtn {
+			if rtn[idx].Var == axis.Var {
+				rtn[idx] = axis
+				found = true
+				break
+			}
+		}
+		if !found {
+			rtn = append(rtn, axis)
+		}
+	}
+	return rtn
+}
+
+// every combination of the axes' values, each cell is a list of VAR=val entries.
+// the last axis varies fastest (like nested for-loops in axis order)
+func MatrixCells(axes []MatrixAxis) ([][]string, error) {
+	numCells := 1
+	for _, axis := range axes {
+		numCells *= len(axis.Values)
+		if numCells > MaxMatrixCells {
+			return nil, fmt.Errorf("matrix has too many combinations (max %d)", MaxMatrixCells)
+		}
+	}
+	rtn := [][]string{nil}
+	for _, axis := range axes {
+		var next [][]string
+		for _, cell := range rtn {
+			for _, val := range axis.Values {
+				newCell := append(append([]string(nil), cell...), axis.Var+"="+val)
+				next = append(next, newCell)
+			}
+		}
+		rtn = next
+	}
+	return rtn, nil
+}
+
+// "env=dev,region=us" (for output, reports, and history)
+func MatrixCellName(cell []string) string {
+	return strings.Join(cell, ",")
+}
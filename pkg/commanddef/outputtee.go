@@ -0,0 +1,133 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+)
+
+var templateVarRe = regexp.MustCompile("\\{([a-zA-Z_]*)\\}")
+var unsafePathCharsRe = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// template variables for the 'output tee' path
+func (cdef *CommandDef) outputTemplateVars(now time.Time) map[string]string {
+	playbookName := strings.TrimSuffix(filepath.Base(cdef.Playbook.ResolvedFile), filepath.Ext(cdef.Playbook.ResolvedFile))
+	return map[string]string{
+		"date":     now.Format("2006-01-02"),
+		"time":     now.Format("150405"),
+		"datetime": now.Format("2006-01-02T150405"),
+		"ts":       strconv.FormatInt(now.UnixMilli(), 10),
+		"command":  unsafePathCharsRe.ReplaceAllString(cdef.Name, "_"),
+		"playbook": unsafePathCharsRe.ReplaceAllString(playbookName, "_"),
+		"pid":      strconv.Itoa(os.Getpid()),
+	}
+}
+
+// replaces {var} in tmpl, returns an error for unknown variables
+func expandTemplate(tmpl string, vars map[string]string) (string, error) {
+	var badVar string
+	rtn := templateVarRe.ReplaceAllStringFunc(tmpl, func(match string) string {
+		varName := match[1 : len(match)-1]
+		val, found := vars[varName]
+		if !found && badVar == "" {
+			badVar = match
+		}
+		return val
+	})
+	if badVar != "" {
+		return "", fmt.Errorf("unknown variable %s", badVar)
+	}
+	return rtn, nil
+}
+
+// the expanded 'output tee' file name, relative paths are relative to the playbook directory
+func (cdef *CommandDef) outputTeeFile(now time.Time) (string, error) {
+	fileName, err := expandTemplate(cdef.OutputTee, cdef.outputTemplateVars(now))
+	if err != nil {
+		return "", fmt.Errorf("'output' directive, %w", err)
+	}
+	if strings.HasPrefix(fileName, "~/") {
+		osUser, _ := user.Current()
+		if osUser != nil && osUser.HomeDir != "" {
+			fileName = filepath.Join(osUser.HomeDir, fileName[2:])
+		}
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(cdef.Playbook.PlaybookDir(), fileName)
+	}
+	return fileName, nil
+}
+
+// opens the 'output tee' file and tees the command's stdout and stderr into it
+func (item *ExecItem) setupOutputTee() error {
+	fileName, err := item.CmdDef.outputTeeFile(time.Now())
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return fmt.Errorf("'output' directive, cannot create directory for '%s': %w", fileName, err)
+	}
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("'output' directive, cannot open '%s': %w", fileName, err)
+	}
+	teeWriter := &redactWriter{w: fd, secretVals: item.SecretVals}
+	item.Cmd.Stdout = io.MultiWriter(item.Cmd.Stdout, teeWriter)
+	item.Cmd.Stderr = io.MultiWriter(item.Cmd.Stderr, teeWriter)
+	item.closers = append(item.closers, teeWriter, fd)
+	item.OutputTeeFile = fileName
+	return nil
+}
+
+// writes whole lines to w with the secret values redacted (call Close to flush a partial last line)
+func NewRedactWriter(w io.Writer, secretVals []string) io.WriteCloser {
+	return &redactWriter{w: w, secretVals: secretVals}
+}
+
+type redactWriter struct {
+	w          io.Writer
+	secretVals []string
+	buf        []byte
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	if len(rw.secretVals) == 0 {
+		return rw.w.Write(p)
+	}
+	rw.buf = append(rw.buf, p...)
+	nlIdx := bytes.LastIndexByte(rw.buf, '\n')
+	if nlIdx == -1 {
+		return len(p), nil
+	}
+	_, err := io.WriteString(rw.w, secrets.Redact([]string{string(rw.buf[:nlIdx+1])}, rw.secretVals)[0])
+	rw.buf = rw.buf[nlIdx+1:]
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *redactWriter) Close() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(rw.w, secrets.Redact([]string{string(rw.buf)}, rw.secretVals)[0])
+	rw.buf = nil
+	return err
+}
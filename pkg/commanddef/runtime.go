@@ -0,0 +1,87 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/runtimes"
+)
+
+// the 'runtime' directive entries, name=version (sorted by name)
+func (cdef *CommandDef) runtimeEntries() []string {
+	var rtn []string
+	for name, version := range cdef.Runtimes {
+		rtn = append(rtn, name+"="+version)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// resolves the 'runtime' directive (sorted by name), errors if a runtime is not installed
+func (cdef *CommandDef) resolveRuntimes() ([]runtimes.Resolved, error) {
+	var rtn []runtimes.Resolved
+	for _, entry := range cdef.runtimeEntries() {
+		parts := strings.SplitN(entry, "=", 2)
+		resolved, err := runtimes.Resolve(parts[0], parts[1], "", config.Get().RuntimePaths)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, resolved)
+	}
+	return rtn, nil
+}
+
+// PATH=... with the runtimes' bin directories first, so the script's own calls (pip, npm, etc.)
+// get the same versions.  env is the command's env (on top of the current environment)
+func runtimePathEnv(resolved []runtimes.Resolved, env []string) string {
+	var dirs []string
+	for _, rt := range resolved {
+		if !inSlice(rt.BinDir(), dirs) {
+			dirs = append(dirs, rt.BinDir())
+		}
+	}
+	pathVal := makeEnvMap(append(os.Environ(), env...))["PATH"]
+	if pathVal != "" {
+		dirs = append(dirs, pathVal)
+	}
+	return "PATH=" + strings.Join(dirs, string(os.PathListSeparator))
+}
+
+// replaces the command's interpreter with the resolved runtime executable (if the interpreter is
+// one of the runtimes).  also applies to 'interpreter' directives, e.g. "python3 -u"
+func useRuntimeExe(cmd *exec.Cmd, resolved []runtimes.Resolved) *exec.Cmd {
+	if len(resolved) == 0 || len(cmd.Args) == 0 {
+		return cmd
+	}
+	exeName := filepath.Base(cmd.Args[0])
+	rtName := runtimes.ForExecutable(exeName)
+	for _, rt := range resolved {
+		if rt.Name != rtName {
+			continue
+		}
+		exeRt, err := runtimes.Resolve(rt.Name, rt.Version, exeName, config.Get().RuntimePaths)
+		if err != nil {
+			exeRt = rt
+		}
+		newCmd := exec.Command(exeRt.Exe, cmd.Args[1:]...)
+		newCmd.Dir = cmd.Dir
+		newCmd.Env = cmd.Env
+		newCmd.Stdin = cmd.Stdin
+		newCmd.Stdout = cmd.Stdout
+		newCmd.Stderr = cmd.Stderr
+		newCmd.ExtraFiles = cmd.ExtraFiles
+		newCmd.SysProcAttr = cmd.SysProcAttr
+		return newCmd
+	}
+	return cmd
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+)
+
+// sql blocks run with the database's command line client (psql, mysql, or sqlite3), picked by the
+// scheme of the 'db' directive's url.  declared args and flags are bound as parameters (psql
+// variables, mysql user variables, sqlite3 parameters), and the script stops at the first error
+
+type sqlParam struct {
+	Name string
+	Val  string
+}
+
+// the command's args and flags as parameters ("-" in names becomes "_").  args that are not
+// set are bound to "".  positional args that were not declared are an error (there is no way
+// to name them)
+func (cdef *CommandDef) sqlParams(runSpec SpecType) ([]sqlParam, error) {
+	numPositional := 0
+	envMap := makeEnvMap(runSpec.Env)
+	var rtn []sqlParam
+	for _, arg := range cdef.Args {
+		if !arg.Flag {
+			numPositional++
+		}
+		rtn = append(rtn, sqlParam{Name: strings.ReplaceAll(arg.Name, "-", "_"), Val: envMap[arg.EnvVarName()]})
+	}
+	if len(runSpec.ScriptArgs) > numPositional {
+		return nil, fmt.Errorf("too many arguments for sql command '%s', declare them with 'arg' directives, usage: %s", cdef.OrigScriptName(), cdef.UsageStr())
+	}
+	return rtn, nil
+}
+
+// the 'db' directive's url, with $VAR references expanded from the command's environment.  a
+// name without a scheme is a connection from "databases" in config.json (which can be a secret
+// reference).  returns (url, secret values, error)
+func (cdef *CommandDef) sqlDbUrl(ctx context.Context, env []string) (string, []string, error) {
+	if cdef.Db == "" {
+		return "", nil, fmt.Errorf("sql command '%s' has no 'db' directive, e.g. \"-- @scripthaus db $DATABASE_URL\"", cdef.OrigScriptName())
+	}
+	if strings.Contains(cdef.Db, "$") {
+		envMap := makeEnvMap(append(os.Environ(), env...))
+		dbUrl := os.Expand(cdef.Db, func(name string) string { return envMap[name] })
+		if dbUrl == "" {
+			return "", nil, fmt.Errorf("'db' directive '%s' is empty, the environment variable is not set", cdef.Db)
+		}
+		return dbUrl, nil, nil
+	}
+	if strings.Contains(cdef.Db, ":") {
+		return cdef.Db, nil, nil
+	}
+	dbRef, found := config.Get().Databases[cdef.Db]
+	if !found {
+		return "", nil, fmt.Errorf("unknown database '%s', add it to \"databases\" in %s (or use a url)", cdef.Db, config.ConfigFileName)
+	}
+	if !secrets.IsSecretRef(dbRef) {
+		return dbRef, nil, nil
+	}
+	dbUrl, err := secrets.Resolve(ctx, dbRef)
+	if err != nil {
+		return "", nil, fmt.Errorf("database '%s': %w", cdef.Db, err)
+	}
+	return dbUrl, []string{dbUrl}, nil
+}
+
+func sqliteQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// a hex literal, so the value does not depend on the server's quoting mode (NO_BACKSLASH_ESCAPES)
+func mysqlQuote(s string) string {
+	return fmt.Sprintf("CONVERT(X'%s' USING utf8mb4)", hex.EncodeToString([]byte(s)))
+}
+
+// the sqlite3 database file, "sqlite:///abs/path.db", "sqlite://./rel.db", or "sqlite:rel.db"
+// (relative to the command's directory)
+func sqlitePath(dbUrl string) string {
+	for _, prefix := range []string{"sqlite3://", "sqlite://", "sqlite3:", "sqlite:"} {
+		if strings.HasPrefix(dbUrl, prefix) {
+			return strings.TrimPrefix(dbUrl, prefix)
+		}
+	}
+	return dbUrl
+}
+
+func (cdef *CommandDef) buildSqlCommand(ctx context.Context, runSpec SpecType) (*ExecItem, error) {
+	params, err := cdef.sqlParams(runSpec)
+	if err != nil {
+		return nil, err
+	}
+	dbUrl, secretVals, err := cdef.sqlDbUrl(ctx, runSpec.Env)
+	if err != nil {
+		return nil, err
+	}
+	scheme := strings.ToLower(dbUrl[:strings.Index(dbUrl+":", ":")])
+	var clientName, scriptText string
+	var args, clientEnv []string
+	switch scheme {
+	case "postgres", "postgresql":
+		pgUrl, err := url.Parse(dbUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid postgres url: %w", err)
+		}
+		// the password goes in the environment, not on the command line
+		if password, hasPassword := pgUrl.User.Password(); hasPassword {
+			clientEnv = append(clientEnv, "PGPASSWORD="+password)
+			secretVals = append(secretVals, password)
+			pgUrl.User = url.User(pgUrl.User.Username())
+		}
+		clientName = "psql"
+		args = []string{"-v", "ON_ERROR_STOP=1"}
+		if runSpec.Trace {
+			args = append(args, "--echo-queries")
+		}
+		for _, param := range params {
+			args = append(args, "-v", param.Name+"="+param.Val)
+		}
+		scriptText = cdef.ScriptText
+		args = append(args, "-d", pgUrl.String(), "-f")
+
+	case "mysql", "mariadb":
+		myUrl, err := url.Parse(dbUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mysql url: %w", err)
+		}
+		clientName = "mysql"
+		args = []string{"--table"}
+		if runSpec.Trace {
+			args = append(args, "--verbose")
+		}
+		if myUrl.Hostname() != "" {
+			args = append(args, "--host="+myUrl.Hostname())
+		}
+		if myUrl.Port() != "" {
+			args = append(args, "--port="+myUrl.Port())
+		}
+		if myUrl.User != nil {
+			args = append(args, "--user="+myUrl.User.Username())
+			if password, hasPassword := myUrl.User.Password(); hasPassword {
+				clientEnv = append(clientEnv, "MYSQL_PWD="+password)
+				secretVals = append(secretVals, password)
+			}
+		}
+		if dbName := strings.TrimPrefix(myUrl.Path, "/"); dbName != "" {
+			args = append(args, "--database="+dbName)
+		}
+		// the parameters go on the first line (so error line numbers are off by one)
+		var setStmts []string
+		for _, param := range params {
+			setStmts = append(setStmts, fmt.Sprintf("SET @%s = %s;", param.Name, mysqlQuote(param.Val)))
+		}
+		scriptText = cdef.ScriptText
+		if len(setStmts) > 0 {
+			scriptText = strings.Join(setStmts, " ") + "\n" + scriptText
+		}
+
+	case "sqlite", "sqlite3":
+		clientName = "sqlite3"
+		args = []string{"-bail"}
+		if runSpec.Trace {
+			args = append(args, "-echo")
+		}
+		args = append(args, sqlitePath(dbUrl))
+		if len(params) > 0 {
+			args = append(args, ".parameter init")
+			for _, param := range params {
+				args = append(args, fmt.Sprintf("REPLACE INTO temp.sqlite_parameters(key, value) VALUES(%s, %s);", sqliteQuote(":"+param.Name), sqliteQuote(param.Val)))
+			}
+		}
+		scriptText = cdef.ScriptText
+
+	default:
+		return nil, fmt.Errorf("cannot run sql command '%s', unsupported database url scheme '%s' (must be postgres, mysql, or sqlite)", cdef.OrigScriptName(), scheme)
+	}
+	scriptFile, err := makeTempScriptFile(scriptText, ".sql")
+	if err != nil {
+		return nil, err
+	}
+	switch clientName {
+	case "psql":
+		args = append(args, scriptFile)
+	case "mysql":
+		args = append(args, "--execute=source "+scriptFile)
+	case "sqlite3":
+		args = append(args, ".read "+sqliteQuote(scriptFile))
+	}
+	execCmd := exec.Command(clientName, args...)
+	setStandardCmdOpts(execCmd, runSpec)
+	execCmd.Env = append(execCmd.Env, clientEnv...)
+	return &ExecItem{CmdDef: cdef, CmdName: clientName, Cmd: execCmd, TempFiles: []string{scriptFile}, SecretVals: secretVals}, nil
+}
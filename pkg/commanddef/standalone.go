@@ -0,0 +1,201 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+)
+
+// marker written into standalone scripts (so they can be safely overwritten)
+const StandaloneMarker = "generated by 'scripthaus export-script'"
+
+// how each language writes the standalone prelude
+type standaloneLang struct {
+	Shebang     string
+	Comment     string
+	ChangeDirFn func(dir string) string
+	SetEnvFn    func(name string, val string) string
+	RequireFn   func(name string) string
+}
+
+func jsonQuote(s string) string {
+	barr, _ := json.Marshal(s)
+	return string(barr)
+}
+
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func shellStandaloneLang(shebang string) standaloneLang {
+	return standaloneLang{
+		Shebang:     shebang,
+		Comment:     "#",
+		ChangeDirFn: func(dir string) string { return fmt.Sprintf("cd %s || exit 1", shellescape.Quote(dir)) },
+		SetEnvFn:    func(name string, val string) string { return fmt.Sprintf("export %s=%s", name, shellescape.Quote(val)) },
+		RequireFn: func(name string) string {
+			return fmt.Sprintf(": \"${%s:?required environment variable %s is not set}\"", name, name)
+		},
+	}
+}
+
+func (cdef *CommandDef) standaloneLang() (standaloneLang, error) {
+	switch cdef.Lang {
+	case "sh":
+		shellName := "sh"
+		if cdef.Playbook.Config != nil && cdef.Playbook.Config.Shell != "" {
+			shellName = cdef.Playbook.Config.Shell
+		}
+		return shellStandaloneLang("#!/usr/bin/env " + shellName), nil
+
+	case "bash", "zsh", "ksh":
+		return shellStandaloneLang("#!/usr/bin/env " + cdef.Lang), nil
+
+	case "python", "python2", "python3":
+		return standaloneLang{
+			Shebang:     "#!/usr/bin/env " + cdef.Lang,
+			Comment:     "#",
+			ChangeDirFn: func(dir string) string { return fmt.Sprintf("import os; os.chdir(%s)", jsonQuote(dir)) },
+			SetEnvFn: func(name string, val string) string {
+				return fmt.Sprintf("import os; os.environ[%s] = %s", jsonQuote(name), jsonQuote(val))
+			},
+			RequireFn: func(name string) string {
+				return fmt.Sprintf("import os, sys; os.environ.get(%s) or sys.exit(\"required environment variable %s is not set\")", jsonQuote(name), name)
+			},
+		}, nil
+
+	case "node", "js", "ts", "typescript", "bun":
+		return standaloneLang{
+			Shebang:     "#!/usr/bin/env " + config.Get().JsRuntime(cdef.Lang),
+			Comment:     "//",
+			ChangeDirFn: func(dir string) string { return fmt.Sprintf("process.chdir(%s);", jsonQuote(dir)) },
+			SetEnvFn: func(name string, val string) string {
+				return fmt.Sprintf("process.env[%s] = %s;", jsonQuote(name), jsonQuote(val))
+			},
+			RequireFn: func(name string) string {
+				return fmt.Sprintf("if (!process.env[%s]) { console.error(\"required environment variable %s is not set\"); process.exit(1); }", jsonQuote(name), name)
+			},
+		}, nil
+
+	case "pwsh", "powershell":
+		return standaloneLang{
+			Shebang:     "#!/usr/bin/env pwsh",
+			Comment:     "#",
+			ChangeDirFn: func(dir string) string { return fmt.Sprintf("Set-Location -LiteralPath %s", pwshQuote(dir)) },
+			SetEnvFn:    func(name string, val string) string { return fmt.Sprintf("$env:%s = %s", name, pwshQuote(val)) },
+			RequireFn: func(name string) string {
+				return fmt.Sprintf("if (-not $env:%s) { Write-Error 'required environment variable %s is not set'; exit 1 }", name, name)
+			},
+		}, nil
+
+	case "cmd":
+		return standaloneLang{
+			Shebang:     "@echo off",
+			Comment:     "REM",
+			ChangeDirFn: func(dir string) string { return fmt.Sprintf("cd /d \"%s\" || exit /b 1", dir) },
+			SetEnvFn:    func(name string, val string) string { return fmt.Sprintf("set \"%s=%s\"", name, val) },
+			RequireFn: func(name string) string {
+				return fmt.Sprintf("if not defined %s (echo required environment variable %s is not set 1>&2 & exit /b 1)", name, name)
+			},
+		}, nil
+
+	default:
+		return standaloneLang{}, fmt.Errorf("cannot export command '%s' as a standalone script, language '%s' is not supported", cdef.Name, cdef.Lang)
+	}
+}
+
+// returns the text of a standalone executable script for the command: a shebang, the help text
+// as a comment header, and the cd/env/shellopts/require-env directives baked into the script.
+// returns (script, warnings, err)
+func (cdef *CommandDef) StandaloneScript() (string, []string, error) {
+	err := cdef.processDirectives()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(cdef.Interpreter) > 0 {
+		return "", nil, fmt.Errorf("cannot export command '%s' as a standalone script, it uses an 'interpreter' directive", cdef.Name)
+	}
+	lang, err := cdef.standaloneLang()
+	if err != nil {
+		return "", nil, err
+	}
+	var warnings []string
+	var buf strings.Builder
+	writeComment := func(line string) {
+		if line == "" {
+			buf.WriteString(lang.Comment + "\n")
+			return
+		}
+		buf.WriteString(lang.Comment + " " + line + "\n")
+	}
+	buf.WriteString(lang.Shebang + "\n")
+	title := cdef.Name
+	if cdef.ShortText != "" {
+		title += " - " + cdef.ShortText
+	}
+	writeComment(title)
+	writeComment(fmt.Sprintf("%s from %s", StandaloneMarker, cdef.Playbook.OrigShowStr()))
+	if helpText := strings.TrimSpace(cdef.HelpText); helpText != "" {
+		writeComment("")
+		for _, line := range strings.Split(helpText, "\n") {
+			writeComment(strings.TrimRight(line, " \t\r"))
+		}
+	}
+	if len(cdef.Args) > 0 {
+		writeComment("")
+		writeComment("usage: " + cdef.UsageStr())
+		warnings = append(warnings, "'arg' and 'flag' directives are not parsed by standalone scripts, arguments are passed through as-is")
+	}
+	if len(cdef.OsList) > 0 || len(cdef.ArchList) > 0 {
+		warnings = append(warnings, "'os' and 'arch' directives are not checked by standalone scripts")
+	}
+	buf.WriteString("\n")
+	if cdef.ChangeDir != "" {
+		buf.WriteString(lang.ChangeDirFn(cdef.ChangeDir) + "\n")
+	}
+	for _, envEntry := range combine(cdef.playbookEnv(), cdef.Env) {
+		eqIdx := strings.Index(envEntry, "=")
+		if eqIdx == -1 {
+			continue
+		}
+		name, val := envEntry[:eqIdx], envEntry[eqIdx+1:]
+		if secrets.IsSecretRef(val) {
+			warnings = append(warnings, fmt.Sprintf("env var '%s' is a secret reference, it is not written to the standalone script (set it before running)", name))
+			continue
+		}
+		buf.WriteString(lang.SetEnvFn(name, val) + "\n")
+	}
+	for _, name := range cdef.RequiredEnv {
+		buf.WriteString(lang.RequireFn(name) + "\n")
+	}
+	buf.WriteString(cdef.shellOptsPrefix())
+	scriptText := strings.TrimRight(stripDirectiveLines(cdef.ScriptText), "\n")
+	buf.WriteString(scriptText + "\n")
+	if cdef.Lang == "cmd" {
+		return strings.ReplaceAll(buf.String(), "\n", "\r\n"), warnings, nil
+	}
+	return buf.String(), warnings, nil
+}
+
+// removes the @scripthaus directive lines (they are baked into the standalone prelude)
+func stripDirectiveLines(scriptText string) string {
+	var rtn []string
+	for _, line := range strings.Split(scriptText, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if (strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "--")) && strings.Contains(trimmed, "@scripthaus") {
+			continue
+		}
+		rtn = append(rtn, line)
+	}
+	return strings.Join(rtn, "\n")
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const StdinInherit = "inherit"
+const StdinClosed = "closed"
+const StdinFilePrefix = "file:"
+
+// normalizes the 'stdin' directive ("null" and "none" are the same as "closed")
+func parseStdinDirective(data string) (string, error) {
+	data = strings.TrimSpace(data)
+	switch data {
+	case StdinInherit, StdinClosed:
+		return data, nil
+
+	case "null", "none":
+		return StdinClosed, nil
+	}
+	if strings.HasPrefix(data, StdinFilePrefix) {
+		if strings.TrimSpace(data[len(StdinFilePrefix):]) == "" {
+			return "", fmt.Errorf("'file:' requires a file name")
+		}
+		return data, nil
+	}
+	return "", fmt.Errorf("invalid value '%s', must be 'inherit', 'closed', or 'file:[path]'", data)
+}
+
+// the file for 'stdin file:[path]', relative paths are relative to the playbook directory
+func (cdef *CommandDef) stdinFile() string {
+	fileName := strings.TrimSpace(cdef.Stdin[len(StdinFilePrefix):])
+	if strings.HasPrefix(fileName, "~/") {
+		osUser, _ := user.Current()
+		if osUser != nil && osUser.HomeDir != "" {
+			fileName = filepath.Join(osUser.HomeDir, fileName[2:])
+		}
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(cdef.Playbook.PlaybookDir(), fileName)
+	}
+	return fileName
+}
+
+// applies the 'stdin' directive.  does nothing if the interpreter reads the script from stdin
+func (item *ExecItem) setupStdin() error {
+	cdef := item.CmdDef
+	if item.Cmd.Stdin != os.Stdin {
+		return nil
+	}
+	if cdef.Stdin == StdinClosed {
+		// exec.Cmd connects a nil Stdin to the null device
+		item.Cmd.Stdin = nil
+		return nil
+	}
+	if !strings.HasPrefix(cdef.Stdin, StdinFilePrefix) {
+		return nil
+	}
+	fileName := cdef.stdinFile()
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("'stdin' directive, cannot open '%s': %w", fileName, err)
+	}
+	item.Cmd.Stdin = fd
+	item.closers = append(item.closers, fd)
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splits directive data into fields.  fields are separated by whitespace, double or single
+// quotes group text with spaces into one field (quotes can be in the middle of a field, e.g.
+// MSG="hello world").  inside single quotes everything is literal.  outside of quotes and inside
+// double quotes a backslash escapes a quote, a backslash, or whitespace, any other backslash is
+// kept as is (so Windows paths like C:\tmp work unquoted).  '""' is an empty field
+func SplitDirectiveData(data string) ([]string, error) {
+	var rtn []string
+	var buf strings.Builder
+	inField := false
+	var quote rune
+	runes := []rune(data)
+	for idx := 0; idx < len(runes); idx++ {
+		ch := runes[idx]
+		if quote == '\'' {
+			if ch == '\'' {
+				quote = 0
+			} else {
+				buf.WriteRune(ch)
+			}
+			continue
+		}
+		if ch == '\\' {
+			if idx+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			next := runes[idx+1]
+			if isEscapable(next, quote) {
+				buf.WriteRune(next)
+				idx++
+			} else {
+				buf.WriteRune(ch)
+			}
+			inField = true
+			continue
+		}
+		if quote == '"' {
+			if ch == '"' {
+				quote = 0
+			} else {
+				buf.WriteRune(ch)
+			}
+			continue
+		}
+		if ch == '"' || ch == '\'' {
+			quote = ch
+			inField = true
+			continue
+		}
+		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' {
+			if inField {
+				rtn = append(rtn, buf.String())
+				buf.Reset()
+				inField = false
+			}
+			continue
+		}
+		buf.WriteRune(ch)
+		inField = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inField {
+		rtn = append(rtn, buf.String())
+	}
+	return rtn, nil
+}
+
+func isEscapable(ch rune, quote rune) bool {
+	if quote == '"' {
+		return ch == '"' || ch == '\\'
+	}
+	return ch == '"' || ch == '\'' || ch == '\\' || ch == ' ' || ch == '\t'
+}
+
+// the fields of a directive, adds a warning (and returns false) if the data cannot be split
+func (cdef *CommandDef) directiveFields(dir RawDirective) ([]string, bool) {
+	fields, err := SplitDirectiveData(dir.Data)
+	if err != nil {
+		cdef.Warnings = append(cdef.Warnings, fmt.Sprintf("'%s' directive, %v (ignoring)", dir.Type, err))
+		return nil, false
+	}
+	return fields, true
+}
+
+// for directives that take one value (cd, output tee).  a quoted value is unquoted, anything else
+// is used as is (unquoted values with spaces were always allowed, e.g. "cd my dir")
+func singleDirectiveValue(data string) string {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, "\"") && !strings.HasPrefix(data, "'") {
+		return data
+	}
+	fields, err := SplitDirectiveData(data)
+	if err != nil || len(fields) != 1 {
+		return data
+	}
+	return fields[0]
+}
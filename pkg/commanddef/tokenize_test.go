@@ -0,0 +1,104 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+func TestSplitDirectiveData(t *testing.T) {
+	tests := []struct {
+		data   string
+		fields []string
+		errStr string
+	}{
+		{"", nil, ""},
+		{"   \t ", nil, ""},
+		{"a b  c", []string{"a", "b", "c"}, ""},
+		{"  a\tb  ", []string{"a", "b"}, ""},
+		{`"Delete ALL prod data?"`, []string{"Delete ALL prod data?"}, ""},
+		{`MSG="hello world" X=1`, []string{"MSG=hello world", "X=1"}, ""},
+		{`'single "quoted"'`, []string{`single "quoted"`}, ""},
+		{`"it's"`, []string{"it's"}, ""},
+		{`'a\b'`, []string{`a\b`}, ""},
+		{`"say \"hi\""`, []string{`say "hi"`}, ""},
+		{`"back\\slash"`, []string{`back\slash`}, ""},
+		{`"keep \n"`, []string{`keep \n`}, ""},
+		{`a\ b c`, []string{"a b", "c"}, ""},
+		{`it\'s`, []string{"it's"}, ""},
+		{`C:\tmp\dir`, []string{`C:\tmp\dir`}, ""},
+		{`"" x`, []string{"", "x"}, ""},
+		{`''`, []string{""}, ""},
+		{`pre"mid dle"post`, []string{"premid dlepost"}, ""},
+		{`"a"'b'c`, []string{"abc"}, ""},
+		{`"héllo wörld" ✓`, []string{"héllo wörld", "✓"}, ""},
+		{`"unterminated`, nil, "unterminated \" quote"},
+		{`'unterminated`, nil, "unterminated ' quote"},
+		{`trailing\`, nil, "trailing backslash"},
+	}
+	for _, test := range tests {
+		fields, err := SplitDirectiveData(test.data)
+		if test.errStr != "" {
+			if err == nil || err.Error() != test.errStr {
+				t.Errorf("SplitDirectiveData(%q): expected error %q, got %v", test.data, test.errStr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitDirectiveData(%q): unexpected error %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("SplitDirectiveData(%q): expected %q, got %q", test.data, test.fields, fields)
+		}
+	}
+}
+
+func TestQuotedDirectives(t *testing.T) {
+	cdef := &CommandDef{Name: "test", Playbook: &pathutil.ResolvedPlaybook{OrigName: "test.md", ResolvedFile: "/tmp/test.md"}}
+	cdef.RawDirectives = []RawDirective{
+		{Type: "env", Data: `GREETING="hello world" EMPTY="" PLAIN=x`},
+		{Type: "arg", Data: `region --default "us east" --desc "the region"`},
+		{Type: "flag", Data: `msg --default 'a "b"' --desc it's unquoted`},
+		{Type: "tag", Data: `deploy "two words"`},
+		{Type: "env", Data: `BAD="unterminated`},
+	}
+	cdef.processDirectives()
+	expectedEnv := []string{"GREETING=hello world", "EMPTY=", "PLAIN=x"}
+	if !reflect.DeepEqual(cdef.Env, expectedEnv) {
+		t.Errorf("env: expected %q, got %q", expectedEnv, cdef.Env)
+	}
+	if len(cdef.Args) != 2 {
+		t.Fatalf("expected 2 args, got %d (warnings %v)", len(cdef.Args), cdef.Warnings)
+	}
+	if cdef.Args[0].Default != "us east" || cdef.Args[0].Desc != "the region" {
+		t.Errorf("arg: got default %q desc %q", cdef.Args[0].Default, cdef.Args[0].Desc)
+	}
+	if cdef.Args[1].Default != `a "b"` || cdef.Args[1].Desc != "it's unquoted" {
+		t.Errorf("flag: got default %q desc %q", cdef.Args[1].Default, cdef.Args[1].Desc)
+	}
+	if !reflect.DeepEqual(cdef.Tags, []string{"deploy", "two words"}) {
+		t.Errorf("tags: got %q", cdef.Tags)
+	}
+	if len(cdef.Warnings) != 1 || cdef.Warnings[0] != "'env' directive, unterminated \" quote (ignoring)" {
+		t.Errorf("expected one unterminated quote warning, got %q", cdef.Warnings)
+	}
+}
+
+func TestParseMatrixAxesQuoted(t *testing.T) {
+	axes, err := ParseMatrixAxes(`MSG="a b,c d" N=1,2`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []MatrixAxis{{Var: "MSG", Values: []string{"a b", "c d"}}, {Var: "N", Values: []string{"1", "2"}}}
+	if !reflect.DeepEqual(axes, expected) {
+		t.Errorf("expected %v, got %v", expected, axes)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package commanddef
+
+import (
+	"fmt"
+)
+
+// 'run -x' tracing.  shells print each command before running it (set -x or the shell's
+// equivalent), python prints each line of the command's code as it runs, cmd, powershell, and
+// the sql clients echo their commands.  js/ts and 'interpreter' commands cannot be traced
+
+// injected before the script of shell blocks ("" if the language is not a shell)
+func traceShellPrefix(lang string) string {
+	switch lang {
+	case "sh", "bash", "zsh", "ksh":
+		return "set -x\n"
+	case "tcsh":
+		return "set echo\n"
+	case "fish":
+		return "set fish_trace 1\n"
+	}
+	return ""
+}
+
+const pwshTracePrefix = "Set-PSDebug -Trace 1\n"
+
+// like pythonBootstrap, but also installs a line tracer (sys.settrace) that prints each line of the
+// command's code to stderr, "+ [lineno]: [code]" (code from other files, e.g. imports, is skipped)
+const pythonTraceBootstrap = `import sys
+def _shtrace(frame, event, arg):
+    if frame.f_code.co_filename != _shname:
+        return None
+    if event == "line":
+        sys.stderr.write("+ %d: %s\n" % (frame.f_lineno, _shlines[frame.f_lineno - 1].strip()))
+    return _shtrace
+sys.argv.pop(0)
+_shsrc = sys.argv.pop(0)
+_shname = sys.argv[0]
+_shlines = _shsrc.splitlines()
+_shcode = compile(_shsrc, _shname, "exec")
+sys.settrace(_shtrace)
+exec(_shcode)
+`
+
+func (cdef *CommandDef) checkTrace() error {
+	if len(cdef.Interpreter) > 0 {
+		return fmt.Errorf("run -x cannot trace command '%s', it has an 'interpreter' directive", cdef.OrigScriptName())
+	}
+	switch cdef.Lang {
+	case "sh", "bash", "zsh", "ksh", "tcsh", "fish", "python", "python2", "python3", "cmd", "pwsh", "powershell", "sql":
+		return nil
+	}
+	return fmt.Errorf("run -x cannot trace '%s' commands (%s)", cdef.Lang, cdef.OrigScriptName())
+}
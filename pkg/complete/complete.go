@@ -0,0 +1,189 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// dynamic shell completion.  the completion scripts (see scripts.go) call back into
+// 'scripthaus __complete [args]' which prints one candidate per line.  errors are never
+// printed (a playbook that does not parse just has no candidates)
+package complete
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// returns the command names in the playbook, each with the given prefix.  nil if the
+// playbook cannot be resolved or parsed
+func playbookCommands(playbookName string, prefix string) []string {
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(playbookName)
+	if err != nil {
+		return nil
+	}
+	src, err := mdparser.ReadPlaybookSource(resolvedPlaybook)
+	if err != nil {
+		return nil
+	}
+	cmdDefs, _, err := src.Commands()
+	if err != nil {
+		return nil
+	}
+	var rtn []string
+	for _, cdef := range cmdDefs {
+		rtn = append(rtn, prefix+cdef.Name)
+	}
+	return rtn
+}
+
+// "[prefix][file]::" for the playbooks in dirName (not the default scripthaus.md, its commands
+// are completed directly)
+func playbookFiles(dirName string, prefix string, recursive bool) []string {
+	files, err := pathutil.FindPlaybookFiles(dirName, recursive)
+	if err != nil {
+		return nil
+	}
+	var rtn []string
+	for _, relFile := range files {
+		if relFile == pathutil.DefaultScFile {
+			continue
+		}
+		rtn = append(rtn, prefix+filepath.ToSlash(relFile)+"::")
+	}
+	return rtn
+}
+
+// directories ("dir/") and playbooks ("file.md::") for a partial path
+func pathCandidates(cur string) []string {
+	dirPart := ""
+	if slashIdx := strings.LastIndex(cur, "/"); slashIdx != -1 {
+		dirPart = cur[:slashIdx+1]
+	}
+	dirName := dirPart
+	if dirName == "" {
+		dirName = "."
+	}
+	entries, err := os.ReadDir(dirName)
+	if err != nil {
+		return nil
+	}
+	var rtn []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(cur[len(dirPart):], ".") {
+			continue
+		}
+		if entry.IsDir() {
+			rtn = append(rtn, dirPart+name+"/")
+		} else if strings.HasSuffix(name, ".md") {
+			rtn = append(rtn, dirPart+name+"::")
+		}
+	}
+	return rtn
+}
+
+// true for "/abs", "./rel", and "../rel"
+func isPathPrefix(cur string) bool {
+	return strings.HasPrefix(cur, "/") || strings.HasPrefix(cur, "./") || strings.HasPrefix(cur, "../")
+}
+
+// candidates for a [playbook]::[command] argument (run, show, edit, etc.).  with playbookFile
+// (the global --playbook option) only the command names of that playbook are completed
+func ScriptTargets(cur string, playbookFile string) []string {
+	if playbookFile != "" {
+		return filterPrefix(playbookCommands(playbookFile, ""), cur)
+	}
+	var rtn []string
+	if sepIdx := strings.Index(cur, "::"); sepIdx != -1 {
+		playbookName := cur[:sepIdx]
+		rtn = playbookCommands(playbookName, playbookName+"::")
+	} else if isPathPrefix(cur) {
+		rtn = pathCandidates(cur)
+	} else if strings.HasPrefix(cur, "^") {
+		rtn = playbookCommands("^", "^")
+		if scHome, err := pathutil.GetScHomeDir(); err == nil {
+			rtn = append(rtn, playbookFiles(scHome, "^", true)...)
+		}
+	} else if strings.HasPrefix(cur, ".") {
+		rtn = projectTargets()
+	} else {
+		if cur == "" {
+			rtn = projectTargets()
+		}
+		rtn = append(rtn, pathCommands()...)
+		rtn = append(rtn, pathCandidates(cur)...)
+	}
+	return filterPrefix(rtn, cur)
+}
+
+// ".[command]" for the project's scripthaus.md, and ".[file]::" for the project's other playbooks
+func projectTargets() []string {
+	rootDir, err := pathutil.DefaultResolver().FindPrefixDir(".")
+	if err != nil {
+		return nil
+	}
+	rtn := playbookCommands(".", ".")
+	return append(rtn, playbookFiles(rootDir, ".", true)...)
+}
+
+// the commands of the playbooks on SCRIPTHAUS_PATH (run without a prefix)
+func pathCommands() []string {
+	playbooks, _ := pathutil.DefaultResolver().ResolvePathPlaybooks()
+	var rtn []string
+	for _, playbook := range playbooks {
+		rtn = append(rtn, playbookCommands(playbook.ResolvedFile, "")...)
+	}
+	return rtn
+}
+
+// candidates for a playbook argument (list, fmt, --playbook)
+func Playbooks(cur string) []string {
+	var rtn []string
+	if isPathPrefix(cur) {
+		for _, cand := range pathCandidates(cur) {
+			rtn = append(rtn, strings.TrimSuffix(cand, "::"))
+		}
+		return filterPrefix(rtn, cur)
+	}
+	if rootDir, err := pathutil.DefaultResolver().FindPrefixDir("."); err == nil {
+		rtn = append(rtn, ".")
+		for _, cand := range playbookFiles(rootDir, ".", true) {
+			rtn = append(rtn, strings.TrimSuffix(cand, "::"))
+		}
+	}
+	rtn = append(rtn, "^")
+	if scHome, err := pathutil.GetScHomeDir(); err == nil {
+		for _, cand := range playbookFiles(scHome, "^", true) {
+			rtn = append(rtn, strings.TrimSuffix(cand, "::"))
+		}
+	}
+	for _, cand := range pathCandidates(cur) {
+		rtn = append(rtn, strings.TrimSuffix(cand, "::"))
+	}
+	return filterPrefix(rtn, cur)
+}
+
+// the candidates that start with cur (sorted, no duplicates)
+func filterPrefix(candidates []string, cur string) []string {
+	seen := make(map[string]bool)
+	var rtn []string
+	for _, cand := range candidates {
+		if !strings.HasPrefix(cand, cur) || seen[cand] {
+			continue
+		}
+		seen[cand] = true
+		rtn = append(rtn, cand)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// the words that start with cur (for static lists: sub-commands, options)
+func Words(words []string, cur string) []string {
+	return filterPrefix(words, cur)
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package complete
+
+import (
+	"fmt"
+)
+
+// the completion scripts pass the words after "scripthaus" (the last one is the word being
+// completed, possibly "") to 'scripthaus __complete'.  candidates ending in "::" or "/" do not
+// get a trailing space.  when there are no candidates bash and zsh fall back to file completion
+
+// bash splits words at ":" (COMP_WORDBREAKS), so the words are taken from COMP_LINE and the part
+// of the current word up to its last ":" is removed from the candidates
+const bashScript = `# scripthaus bash completion, add to ~/.bashrc:
+#   eval "$(scripthaus completion bash)"
+_scripthaus_complete() {
+    local line="${COMP_LINE:0:$COMP_POINT}"
+    local cur="${line##*[[:space:]]}"
+    local -a words
+    read -ra words <<< "${line%"$cur"}"
+    local IFS=$'\n'
+    COMPREPLY=($(scripthaus __complete "${words[@]:1}" "$cur" 2>/dev/null))
+    if [[ ${#COMPREPLY[@]} -eq 1 && ( "${COMPREPLY[0]}" == *:: || "${COMPREPLY[0]}" == */ ) ]]; then
+        compopt -o nospace
+    fi
+    if [[ "$cur" == *:* && "$COMP_WORDBREAKS" == *:* ]]; then
+        local colon_prefix="${cur%"${cur##*:}"}"
+        COMPREPLY=("${COMPREPLY[@]#"$colon_prefix"}")
+    fi
+}
+complete -o default -F _scripthaus_complete scripthaus
+`
+
+const zshScript = `#compdef scripthaus
+# scripthaus zsh completion, add to ~/.zshrc (after compinit):
+#   eval "$(scripthaus completion zsh)"
+_scripthaus() {
+    local -a cands spacecands nospacecands
+    local cand
+    cands=("${(@f)$(scripthaus __complete "${(@)words[2,CURRENT-1]}" "${words[CURRENT]}" 2>/dev/null)}")
+    cands=(${cands:#})
+    if (( ${#cands} == 0 )); then
+        _files
+        return
+    fi
+    for cand in $cands; do
+        if [[ $cand == *:: || $cand == */ ]]; then
+            nospacecands+=("$cand")
+        else
+            spacecands+=("$cand")
+        fi
+    done
+    (( ${#spacecands} )) && compadd -Q -- $spacecands
+    (( ${#nospacecands} )) && compadd -Q -S '' -- $nospacecands
+}
+compdef _scripthaus scripthaus
+`
+
+const fishScript = `# scripthaus fish completion, add to ~/.config/fish/config.fish:
+#   scripthaus completion fish | source
+function __scripthaus_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    scripthaus __complete $tokens (commandline -ct) 2>/dev/null
+end
+complete -c scripthaus -f -a '(__scripthaus_complete)'
+`
+
+func ShellNames() []string {
+	return []string{"bash", "zsh", "fish"}
+}
+
+func ShellScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashScript, nil
+	case "zsh":
+		return zshScript, nil
+	case "fish":
+		return fishScript, nil
+	}
+	return "", fmt.Errorf("invalid shell '%s' for completion, must be bash, zsh, or fish", shell)
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/otlp"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/registry"
+	"github.com/scripthaus-dev/scripthaus/pkg/runtimes"
+)
+
+const ConfigFileName = "config.json"
+
+// global scripthaus settings, read from $SCRIPTHAUS_HOME/config.json
+type Config struct {
+	ShellOpts      []string          `json:"shellopts,omitempty"`       // default for the 'shellopts' directive
+	LangAliases    map[string]string `json:"lang_aliases,omitempty"`    // fence language => "[scripttype] [options]"
+	McpAllow       []string          `json:"mcp_allow,omitempty"`       // commands 'scripthaus mcp' may run (patterns, "*" is a wildcard)
+	RunLogs        bool              `json:"run_logs,omitempty"`        // log every run to $SCRIPTHAUS_HOME/runs/[historyid] (see pkg/runlog)
+	Otlp           *otlp.Config      `json:"otlp,omitempty"`            // export a span for every run (see pkg/otlp)
+	NotifyWebhook  string            `json:"notify_webhook,omitempty"`  // Slack/Discord webhook for 'run --notify' and the 'notify' directive
+	NotifyDesktop  *bool             `json:"notify_desktop,omitempty"`  // desktop notifications (default true)
+	PreRun         string            `json:"pre_run,omitempty"`         // shell command run before every run (see pkg/runhooks)
+	PostRun        string            `json:"post_run,omitempty"`        // shell command run after every run
+	Runtimes       map[string]string `json:"runtimes,omitempty"`        // js/ts script type => runtime ("node" or "bun")
+	RuntimePaths   runtimes.Paths    `json:"runtime_paths,omitempty"`   // 'runtime' directive versions => executables, e.g. {"python": {"3.11": "/usr/bin/python3.11"}} (see pkg/runtimes)
+	Databases      map[string]string `json:"databases,omitempty"`       // connection name => url (or a secret reference) for the 'db' directive of sql blocks
+	KillGrace      string            `json:"kill_grace,omitempty"`      // how long a terminated command has before SIGKILL (default 5s)
+	Strict         bool              `json:"strict,omitempty"`          // playbook warnings are errors (same as --strict)
+	HistoryKey     string            `json:"history_key,omitempty"`     // encrypts history (see pkg/history/encrypt.go), usually a secret reference
+	RedactPatterns []string          `json:"redact_patterns,omitempty"` // regexps, matching script arguments are redacted in history
+	RecordEnv      bool              `json:"record_env,omitempty"`      // record the variables scripthaus sets (--env, --env-file, 'env' directives) in history
+	CollectIpAddr  *bool             `json:"collect_ipaddr,omitempty"`  // record the local ip address and hostname in history (default true)
+	Registry       *registry.Config  `json:"registry,omitempty"`        // for 'publish' and 'fetch' (see pkg/registry)
+	TrustedRoots   []string          `json:"trusted_roots,omitempty"`   // playbooks under these directories run without a trust prompt (see pkg/trust)
+	Direnv         string            `json:"direnv,omitempty"`          // a project's .envrc: "warn" if it is not loaded (default), "load", or "off" (see pkg/direnv)
+}
+
+const DefaultKillGrace = 5 * time.Second
+
+// the 'kill_grace' duration (validated by Load)
+func (cfg *Config) KillGraceDuration() time.Duration {
+	if cfg.KillGrace == "" {
+		return DefaultKillGrace
+	}
+	grace, err := time.ParseDuration(cfg.KillGrace)
+	if err != nil {
+		return DefaultKillGrace
+	}
+	return grace
+}
+
+const (
+	DirenvWarn = "warn"
+	DirenvLoad = "load"
+	DirenvOff  = "off"
+)
+
+// the 'direnv' mode (validated by Load)
+func (cfg *Config) DirenvMode() string {
+	if cfg.Direnv == "" {
+		return DirenvWarn
+	}
+	return cfg.Direnv
+}
+
+const RuntimeNode = "node"
+const RuntimeBun = "bun"
+
+// the runtime for a js/ts block.  "bun" blocks always run with bun, "ts"/"typescript" default
+// to bun (node cannot run typescript), and "js"/"node" default to node
+func (cfg *Config) JsRuntime(scriptType string) string {
+	if scriptType == "bun" {
+		return RuntimeBun
+	}
+	if runtime := cfg.Runtimes[scriptType]; runtime != "" {
+		return runtime
+	}
+	if scriptType == "ts" || scriptType == "typescript" {
+		return RuntimeBun
+	}
+	return RuntimeNode
+}
+
+// maps a code fence language through the configured lang_aliases, e.g. "console" => "bash strip-prompt".
+// returns (scripttype, options).  returns the language unchanged if there is no alias.
+func (cfg *Config) ResolveLangAlias(lang string) (string, []string) {
+	aliasVal, found := cfg.LangAliases[lang]
+	if !found {
+		return lang, nil
+	}
+	fields := strings.Fields(aliasVal)
+	if len(fields) == 0 {
+		return lang, nil
+	}
+	return fields[0], fields[1:]
+}
+
+var globalLock = &sync.Mutex{}
+var globalConfig *Config
+
+func GetConfigFileName() (string, error) {
+	scHome, err := pathutil.GetScHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(scHome, ConfigFileName), nil
+}
+
+// reads the config file (a missing file is not an error, returns the default config)
+func Load() (*Config, error) {
+	fileName, err := GetConfigFileName()
+	if err != nil {
+		return nil, err
+	}
+	found, fileBytes, err := pathutil.TryReadFile(fileName, "config file", true)
+	if err != nil {
+		return nil, err
+	}
+	rtn := &Config{}
+	if !found {
+		return rtn, nil
+	}
+	err = json.Unmarshal(fileBytes, rtn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse config file '%s': %w", fileName, err)
+	}
+	if grace, err := time.ParseDuration(rtn.KillGrace); rtn.KillGrace != "" && (err != nil || grace < 0) {
+		return nil, fmt.Errorf("invalid kill_grace '%s' in config file '%s', must be a duration, e.g. \"10s\"", rtn.KillGrace, fileName)
+	}
+	for scriptType, runtime := range rtn.Runtimes {
+		if runtime != RuntimeNode && runtime != RuntimeBun {
+			return nil, fmt.Errorf("invalid runtime '%s' for '%s' in config file '%s', must be \"node\" or \"bun\"", runtime, scriptType, fileName)
+		}
+	}
+	for name := range rtn.RuntimePaths {
+		if !runtimes.IsKnown(name) {
+			return nil, fmt.Errorf("invalid runtime_paths in config file '%s', unknown runtime '%s'", fileName, name)
+		}
+	}
+	if rtn.Direnv != "" && rtn.Direnv != DirenvWarn && rtn.Direnv != DirenvLoad && rtn.Direnv != DirenvOff {
+		return nil, fmt.Errorf("invalid direnv '%s' in config file '%s', must be \"warn\", \"load\", or \"off\"", rtn.Direnv, fileName)
+	}
+	for _, pattern := range rtn.RedactPatterns {
+		_, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact_patterns entry '%s' in config file '%s': %w", pattern, fileName, err)
+		}
+	}
+	return rtn, nil
+}
+
+// the compiled 'redact_patterns' (validated by Load)
+func (cfg *Config) RedactRegexps() []*regexp.Regexp {
+	var rtn []*regexp.Regexp
+	for _, pattern := range cfg.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, re)
+	}
+	return rtn
+}
+
+// sets the global config (called once at startup)
+func SetGlobal(cfg *Config) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	globalConfig = cfg
+}
+
+// returns the global config, never nil (returns defaults if SetGlobal was never called)
+func Get() *Config {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	if globalConfig == nil {
+		return &Config{}
+	}
+	return globalConfig
+}
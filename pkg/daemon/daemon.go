@@ -0,0 +1,286 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// a long-lived JSON-RPC server (newline delimited, over stdio) for editor integrations.
+// methods: initialize, commands, codeLenses, run, cancel, shutdown.  run streams
+// "run/output" notifications and ends with a "run/exit" notification
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/jsonrpc"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/scripthaus"
+)
+
+// a command in a playbook file, line numbers are 1-indexed and inclusive
+type CommandInfo struct {
+	Name          string   `json:"name"`
+	FullName      string   `json:"fullname"` // name to pass to 'scripthaus run'
+	Usage         string   `json:"usage"`
+	ShortText     string   `json:"shorttext,omitempty"`
+	Lang          string   `json:"lang"`
+	Aliases       []string `json:"aliases,omitempty"`
+	StartLine     int      `json:"startline"`     // first line of the help text (or the code block)
+	CodeStartLine int      `json:"codestartline"` // opening fence line
+	CodeEndLine   int      `json:"codeendline"`   // closing fence line
+}
+
+// a "Run" code lens for the opening fence line of a command
+type CodeLens struct {
+	Line    int    `json:"line"`
+	EndLine int    `json:"endline"`
+	Title   string `json:"title"`
+	Command string `json:"command"` // pass to the "run" method
+	File    string `json:"file"`
+}
+
+type RunOutput struct {
+	RunId  int    `json:"runid"`
+	Stream string `json:"stream"` // "stdout" or "stderr"
+	Data   string `json:"data"`
+}
+
+type RunExit struct {
+	RunId      int    `json:"runid"`
+	ExitCode   int    `json:"exitcode"`
+	DurationMs int64  `json:"durationms"`
+	Error      string `json:"error,omitempty"` // set if the command could not be started
+}
+
+type Server struct {
+	Version string
+	// resolves a command (file is optional, otherwise the name is resolved like 'scripthaus run')
+	FindCommand func(file string, name string) (*commanddef.CommandDef, error)
+	// runs the exec item to completion (logging it to history), returns the exit code
+	RunExecItem func(execItem *commanddef.ExecItem, warnings []string) (int, error)
+
+	conn      *jsonrpc.Conn
+	lock      sync.Mutex
+	nextRunId int
+	runs      map[int]context.CancelFunc
+	runWg     sync.WaitGroup
+}
+
+// serves requests from r until EOF, then cancels any running commands
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.conn = jsonrpc.MakeConn(w)
+	s.runs = make(map[int]context.CancelFunc)
+	err := jsonrpc.ReadRequests(r, s.conn, func(req jsonrpc.Request) {
+		result, rpcErr, afterFn := s.handleRequest(req)
+		if !req.IsNotification() {
+			s.conn.Respond(req.Id, result, rpcErr)
+		}
+		if afterFn != nil {
+			// after the response, so the client has the run id before any output arrives
+			afterFn()
+		}
+	})
+	s.lock.Lock()
+	for _, cancelFn := range s.runs {
+		cancelFn()
+	}
+	s.lock.Unlock()
+	s.runWg.Wait()
+	return err
+}
+
+func parseParams(req jsonrpc.Request, params interface{}) *jsonrpc.Error {
+	if len(req.Params) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(req.Params, params)
+	if err != nil {
+		return jsonrpc.MakeError(jsonrpc.ErrCodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
+
+// returns (result, error, afterFn), afterFn (can be nil) is called after the response is sent
+func (s *Server) handleRequest(req jsonrpc.Request) (interface{}, *jsonrpc.Error, func()) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"name":    "scripthaus",
+			"version": s.Version,
+			"methods": []string{"commands", "codeLenses", "run", "cancel", "shutdown"},
+		}, nil, nil
+
+	case "shutdown":
+		return nil, nil, nil
+
+	case "commands", "codeLenses":
+		var params struct {
+			File string  `json:"file"`
+			Text *string `json:"text"` // unsaved editor contents (optional)
+		}
+		if rpcErr := parseParams(req, &params); rpcErr != nil {
+			return nil, rpcErr, nil
+		}
+		cmds, err := fileCommands(params.File, params.Text)
+		if err != nil {
+			return nil, jsonrpc.MakeError(jsonrpc.ErrCodeInvalidParams, "%v", err), nil
+		}
+		if req.Method == "commands" {
+			return cmds, nil, nil
+		}
+		lenses := []CodeLens{}
+		for _, cmd := range cmds {
+			lenses = append(lenses, CodeLens{Line: cmd.CodeStartLine, EndLine: cmd.CodeEndLine, Title: "Run " + cmd.Name, Command: cmd.Name, File: params.File})
+		}
+		return lenses, nil, nil
+
+	case "run":
+		var params struct {
+			Command string   `json:"command"`
+			File    string   `json:"file"`
+			Args    []string `json:"args"`
+		}
+		if rpcErr := parseParams(req, &params); rpcErr != nil {
+			return nil, rpcErr, nil
+		}
+		runId, startFn, err := s.prepareRun(params.File, params.Command, params.Args)
+		if err != nil {
+			return nil, jsonrpc.MakeError(jsonrpc.ErrCodeInvalidParams, "%v", err), nil
+		}
+		return map[string]int{"runid": runId}, nil, startFn
+
+	case "cancel":
+		var params struct {
+			RunId int `json:"runid"`
+		}
+		if rpcErr := parseParams(req, &params); rpcErr != nil {
+			return nil, rpcErr, nil
+		}
+		s.lock.Lock()
+		cancelFn := s.runs[params.RunId]
+		s.lock.Unlock()
+		if cancelFn != nil {
+			cancelFn()
+		}
+		return map[string]bool{"cancelled": cancelFn != nil}, nil, nil
+
+	default:
+		return nil, jsonrpc.MakeError(jsonrpc.ErrCodeMethodNotFound, "method '%s' not found", req.Method), nil
+	}
+}
+
+// the commands defined in the file itself (not its includes)
+func fileCommands(fileName string, text *string) ([]CommandInfo, error) {
+	if fileName == "" {
+		return nil, fmt.Errorf("no file specified")
+	}
+	playbook, err := pathutil.DefaultResolver().ResolvePlaybook(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var mdSource []byte
+	if text != nil {
+		mdSource = []byte(*text)
+	} else {
+		found, fileBytes, err := pathutil.TryReadFile(playbook.ResolvedFile, "playbook", false)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("cannot find playbook '%s'", fileName)
+		}
+		mdSource = fileBytes
+	}
+	cmdDefs, _, err := mdparser.ParseCommands(playbook, mdSource)
+	if err != nil {
+		return nil, err
+	}
+	rtn := []CommandInfo{}
+	for idx := range cmdDefs {
+		cdef := &cmdDefs[idx]
+		startLine, _ := mdparser.RangeLineNos(mdSource, cdef.StartIndex, cdef.EndIndex)
+		codeStartLine, codeEndLine := mdparser.RangeLineNos(mdSource, cdef.CodeStartIndex, cdef.EndIndex)
+		rtn = append(rtn, CommandInfo{
+			Name:          cdef.Name,
+			FullName:      cdef.OrigScriptName(),
+			Usage:         cdef.UsageStr(),
+			ShortText:     cdef.ShortText,
+			Lang:          cdef.Lang,
+			Aliases:       cdef.Aliases,
+			StartLine:     startLine,
+			CodeStartLine: codeStartLine,
+			CodeEndLine:   codeEndLine,
+		})
+	}
+	return rtn, nil
+}
+
+// sends command output as "run/output" notifications
+type outputWriter struct {
+	conn   *jsonrpc.Conn
+	runId  int
+	stream string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.conn.Notify("run/output", RunOutput{RunId: w.runId, Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
+
+// resolves and builds the command, returns the run id and a function that starts it.  errors
+// building the command are returned, errors running it are sent in the "run/exit" notification
+func (s *Server) prepareRun(file string, name string, args []string) (int, func(), error) {
+	cdef, err := s.FindCommand(file, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	runSpec := commanddef.SpecType{ScriptArgs: args}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	execItem, err := scripthaus.BuildExecItem(ctx, cdef, runSpec)
+	if err != nil {
+		cancelFn()
+		return 0, nil, err
+	}
+	s.lock.Lock()
+	s.nextRunId++
+	runId := s.nextRunId
+	s.runs[runId] = cancelFn
+	s.lock.Unlock()
+	// secrets are redacted from the output like in history and run logs
+	stdoutWriter := commanddef.NewRedactWriter(outputWriter{conn: s.conn, runId: runId, stream: "stdout"}, execItem.SecretVals)
+	stderrWriter := commanddef.NewRedactWriter(outputWriter{conn: s.conn, runId: runId, stream: "stderr"}, execItem.SecretVals)
+	execItem.Cmd.Stdin = nil
+	execItem.Cmd.Stdout = stdoutWriter
+	execItem.Cmd.Stderr = stderrWriter
+	s.runWg.Add(1)
+	startFn := func() {
+		go s.runCommand(runId, execItem, cdef.Warnings, cancelFn, []io.Closer{stdoutWriter, stderrWriter})
+	}
+	return runId, startFn, nil
+}
+
+// outputClosers flush the redacted output, they are closed before "run/exit" is sent
+func (s *Server) runCommand(runId int, execItem *commanddef.ExecItem, warnings []string, cancelFn context.CancelFunc, outputClosers []io.Closer) {
+	defer s.runWg.Done()
+	startTs := time.Now()
+	exitCode, err := s.RunExecItem(execItem, warnings)
+	for _, closer := range outputClosers {
+		closer.Close()
+	}
+	exitMsg := RunExit{RunId: runId, ExitCode: exitCode, DurationMs: time.Since(startTs).Milliseconds()}
+	if err != nil {
+		exitMsg.Error = err.Error()
+	}
+	s.lock.Lock()
+	delete(s.runs, runId)
+	s.lock.Unlock()
+	cancelFn()
+	s.conn.Notify("run/exit", exitMsg)
+}
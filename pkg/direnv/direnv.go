@@ -0,0 +1,97 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// the direnv environment of a project (its .envrc).  a shell with the direnv hook has the
+// environment loaded when it is in the project, a command run from somewhere else does not.
+// the environment is read with 'direnv export json', so the .envrc must be allowed ('direnv allow')
+package direnv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const EnvrcFileName = ".envrc"
+
+// set by direnv to "-" + the directory of the loaded .envrc
+const DirVarName = "DIRENV_DIR"
+
+// true if dir has a .envrc file
+func HasEnvrc(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	finfo, err := os.Stat(filepath.Join(dir, EnvrcFileName))
+	return err == nil && !finfo.IsDir()
+}
+
+// true if the current environment has dir's .envrc loaded
+func IsLoaded(dir string) bool {
+	return os.Getenv(DirVarName) == "-"+dir
+}
+
+type exportResult struct {
+	env []string
+	err error
+}
+
+var exportLock = &sync.Mutex{}
+var exportCache = make(map[string]exportResult)
+
+// the VAR=VAL entries the .envrc in dir sets (sorted), relative to the current environment (nothing
+// if it is already loaded).  variables the .envrc unsets are ignored.  results are cached per dir
+func Export(ctx context.Context, dir string) ([]string, error) {
+	exportLock.Lock()
+	defer exportLock.Unlock()
+	if result, found := exportCache[dir]; found {
+		return result.env, result.err
+	}
+	env, err := runExport(ctx, dir)
+	exportCache[dir] = exportResult{env: env, err: err}
+	return env, err
+}
+
+func runExport(ctx context.Context, dir string) ([]string, error) {
+	if _, err := exec.LookPath("direnv"); err != nil {
+		return nil, fmt.Errorf("cannot load %s: direnv is not installed", filepath.Join(dir, EnvrcFileName))
+	}
+	cmd := exec.CommandContext(ctx, "direnv", "export", "json")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr == "" {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("cannot load %s: %s", filepath.Join(dir, EnvrcFileName), strings.TrimPrefix(errStr, "direnv: error "))
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return nil, nil
+	}
+	var envMap map[string]*string
+	err = json.Unmarshal(output, &envMap)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse 'direnv export json' output: %w", err)
+	}
+	var rtn []string
+	for name, val := range envMap {
+		if val != nil {
+			rtn = append(rtn, name+"="+*val)
+		}
+	}
+	sort.Strings(rtn)
+	return rtn, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// generates a static docs site (linked HTML pages, or a markdown tree for mkdocs/hugo)
+// from playbooks: a command index, one page per playbook, and one page per command
+package docsite
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const FormatHtml = "html"
+const FormatMarkdown = "markdown"
+
+type Playbook struct {
+	Playbook *pathutil.ResolvedPlaybook
+	Source   []byte // the playbook markdown
+	Commands []*commanddef.CommandDef
+}
+
+// a playbook with its generated page names
+type sitePlaybook struct {
+	Playbook *pathutil.ResolvedPlaybook
+	Source   []byte
+	Commands []*commanddef.CommandDef
+	Slug     string
+	Title    string
+	cmdSlugs map[string]string // command name -> slug
+}
+
+func (pb *sitePlaybook) cmdPage(cdef *commanddef.CommandDef, ext string) string {
+	return pb.Slug + "/" + pb.cmdSlugs[cdef.Name] + ext
+}
+
+type Site struct {
+	Title     string
+	playbooks []*sitePlaybook
+}
+
+var slugInvalidRe = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+func makeSlug(name string) string {
+	return strings.Trim(slugInvalidRe.ReplaceAllString(name, "-"), "-")
+}
+
+// returns a slug that is not in used (adds a numeric suffix), and marks it as used
+func uniqueSlug(name string, defaultName string, used map[string]bool) string {
+	slug := makeSlug(name)
+	if slug == "" {
+		slug = defaultName
+	}
+	rtn := slug
+	for idx := 2; used[strings.ToLower(rtn)]; idx++ {
+		rtn = fmt.Sprintf("%s-%d", slug, idx)
+	}
+	used[strings.ToLower(rtn)] = true
+	return rtn
+}
+
+func MakeSite(title string, playbooks []Playbook) *Site {
+	rtn := &Site{Title: title}
+	// "index" is reserved for the index page
+	usedSlugs := map[string]bool{"index": true}
+	for _, pb := range playbooks {
+		name := pb.Playbook.OrigName
+		if name == "." || name == "^" || name == "" {
+			name = strings.TrimSuffix(filepath.Base(pb.Playbook.ResolvedFile), ".md")
+		}
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), ".md")
+		sitePb := &sitePlaybook{
+			Playbook: pb.Playbook,
+			Source:   pb.Source,
+			Commands: pb.Commands,
+			Slug:     uniqueSlug(name, "playbook", usedSlugs),
+			Title:    pb.Playbook.OrigName,
+			cmdSlugs: make(map[string]string),
+		}
+		usedCmdSlugs := make(map[string]bool)
+		for _, cdef := range pb.Commands {
+			sitePb.cmdSlugs[cdef.Name] = uniqueSlug(cdef.Name, "command", usedCmdSlugs)
+		}
+		rtn.playbooks = append(rtn.playbooks, sitePb)
+	}
+	return rtn
+}
+
+// a command in the index (sorted by name)
+type indexEntry struct {
+	Playbook *sitePlaybook
+	Cmd      *commanddef.CommandDef
+}
+
+func (site *Site) commandIndex() []indexEntry {
+	var rtn []indexEntry
+	for _, pb := range site.playbooks {
+		for _, cdef := range pb.Commands {
+			rtn = append(rtn, indexEntry{Playbook: pb, Cmd: cdef})
+		}
+	}
+	sort.SliceStable(rtn, func(i, j int) bool {
+		return rtn[i].Cmd.Name < rtn[j].Cmd.Name
+	})
+	return rtn
+}
+
+// writes the site to outDir (existing files that are not part of the site are left alone).
+// returns the number of files written
+func (site *Site) Write(outDir string, format string) (int, error) {
+	var files map[string][]byte
+	var err error
+	switch format {
+	case FormatHtml:
+		files, err = site.htmlFiles()
+
+	case FormatMarkdown:
+		files, err = site.markdownFiles()
+
+	default:
+		return 0, fmt.Errorf("invalid docs format '%s', must be one of: %s, %s", format, FormatHtml, FormatMarkdown)
+	}
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fileName := filepath.Join(outDir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err != nil {
+			return 0, fmt.Errorf("cannot create docs directory: %w", err)
+		}
+		err = os.WriteFile(fileName, files[name], 0644)
+		if err != nil {
+			return 0, fmt.Errorf("cannot write docs file: %w", err)
+		}
+	}
+	return len(files), nil
+}
+
+// JSON strings are valid YAML double-quoted strings
+func jsonQuote(s string) string {
+	barr, _ := json.Marshal(s)
+	return string(barr)
+}
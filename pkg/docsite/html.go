@@ -0,0 +1,188 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package docsite
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/render"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+const pageCss = `body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 960px; margin: 0 auto; padding: 0 20px 40px; color: #24292e; line-height: 1.5; }
+nav { padding: 12px 0; border-bottom: 1px solid #e1e4e8; margin-bottom: 16px; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #e1e4e8; padding: 4px 10px; text-align: left; vertical-align: top; }
+code { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: 90%; }
+.sh-command-link { font-size: 85%; }
+.sh-meta td:first-child { font-weight: bold; }
+`
+
+// renders fenced code blocks with syntax highlighting, command blocks get an anchor and a
+// link to the command page
+type codeBlockRenderer struct {
+	cmdLinks map[string]string // command name -> page
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	codeNode := node.(*ast.FencedCodeBlock)
+	var lang string
+	if codeNode.Info != nil {
+		lang = string(codeNode.Language(source))
+	}
+	var code bytes.Buffer
+	lines := codeNode.Lines()
+	for idx := 0; idx < lines.Len(); idx++ {
+		segment := lines.At(idx)
+		code.Write(segment.Value(source))
+	}
+	// the directives can also be in an html comment right before the block
+	var blockDirText string
+	if htmlNode, ok := node.PreviousSibling().(*ast.HTMLBlock); ok {
+		htmlLines := htmlNode.Lines()
+		for idx := 0; idx < htmlLines.Len(); idx++ {
+			segment := htmlLines.At(idx)
+			blockDirText += string(segment.Value(source))
+		}
+	}
+	blockDirs := append(mdparser.ExtractHtmlDirectives(blockDirText), mdparser.ExtractRawDirectives(code.String())...)
+	cmdName, _ := mdparser.GetCommandDirective(blockDirs)
+	if page, found := r.cmdLinks[cmdName]; found && cmdName != "" {
+		fmt.Fprintf(w, "<div class=\"sh-command\" id=\"cmd-%s\"><a class=\"sh-command-link\" href=\"%s\">command %s</a>\n", html.EscapeString(makeSlug(cmdName)), html.EscapeString(page), html.EscapeString(cmdName))
+		w.WriteString(render.CodeBlockHTML(lang, code.String()))
+		w.WriteString("</div>\n")
+		return ast.WalkSkipChildren, nil
+	}
+	w.WriteString(render.CodeBlockHTML(lang, code.String()))
+	return ast.WalkSkipChildren, nil
+}
+
+func markdownToHtml(mdSource []byte, cmdLinks map[string]string) (string, error) {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{cmdLinks: cmdLinks}, 100))),
+	)
+	var buf bytes.Buffer
+	err := md.Convert(mdSource, &buf)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// relRoot is the path from the page back to the site root ("" or "../")
+func (site *Site) htmlPage(title string, relRoot string, body string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	buf.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&buf, "<style>\n%s%s</style>\n</head>\n<body>\n", pageCss, render.HighlightCss)
+	fmt.Fprintf(&buf, "<nav><a href=\"%sindex.html\">%s</a></nav>\n", relRoot, html.EscapeString(site.Title))
+	buf.WriteString(body)
+	buf.WriteString("\n<footer><p><small>generated by 'scripthaus docs'</small></p></footer>\n</body>\n</html>\n")
+	return buf.Bytes()
+}
+
+func (site *Site) htmlFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	files["index.html"] = site.htmlPage(site.Title, "", site.htmlIndexBody())
+	for _, pb := range site.playbooks {
+		cmdLinks := make(map[string]string)
+		for _, cdef := range pb.Commands {
+			if cdef.Playbook.ResolvedFile == pb.Playbook.ResolvedFile {
+				cmdLinks[cdef.Name] = pb.cmdPage(cdef, ".html")
+			}
+		}
+		pbHtml, err := markdownToHtml(mdparser.StripFrontMatter(pb.Source), cmdLinks)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render playbook %s: %w", pb.Playbook.OrigShowStr(), err)
+		}
+		files[pb.Slug+".html"] = site.htmlPage(pb.Title, "", pbHtml)
+		for _, cdef := range pb.Commands {
+			body, err := site.htmlCommandBody(pb, cdef)
+			if err != nil {
+				return nil, err
+			}
+			files[pb.cmdPage(cdef, ".html")] = site.htmlPage(cdef.Name, "../", body)
+		}
+	}
+	return files, nil
+}
+
+func (site *Site) htmlIndexBody() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<h1>%s</h1>\n<h2>Playbooks</h2>\n<ul>\n", html.EscapeString(site.Title))
+	for _, pb := range site.playbooks {
+		fmt.Fprintf(&buf, "<li><a href=\"%s.html\">%s</a> (%d commands)</li>\n", pb.Slug, html.EscapeString(pb.Title), len(pb.Commands))
+	}
+	buf.WriteString("</ul>\n<h2>Commands</h2>\n<table>\n<tr><th>Command</th><th>Description</th><th>Playbook</th></tr>\n")
+	for _, entry := range site.commandIndex() {
+		fmt.Fprintf(&buf, "<tr><td><a href=\"%s\"><code>%s</code></a></td><td>%s</td><td><a href=\"%s.html\">%s</a></td></tr>\n",
+			html.EscapeString(entry.Playbook.cmdPage(entry.Cmd, ".html")), html.EscapeString(entry.Cmd.Name),
+			html.EscapeString(entry.Cmd.ShortText), entry.Playbook.Slug, html.EscapeString(entry.Playbook.Title))
+	}
+	buf.WriteString("</table>\n")
+	return buf.String()
+}
+
+func (site *Site) htmlCommandBody(pb *sitePlaybook, cdef *commanddef.CommandDef) (string, error) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<h1><code>%s</code></h1>\n", html.EscapeString(cdef.Name))
+	if cdef.ShortText != "" {
+		fmt.Fprintf(&buf, "<p>%s</p>\n", html.EscapeString(cdef.ShortText))
+	}
+	buf.WriteString("<table class=\"sh-meta\">\n")
+	writeRow := func(name string, valHtml string) {
+		fmt.Fprintf(&buf, "<tr><td>%s</td><td>%s</td></tr>\n", name, valHtml)
+	}
+	writeRow("Run", fmt.Sprintf("<code>scripthaus run %s</code>", html.EscapeString(cdef.UsageStr())))
+	if len(cdef.Aliases) > 0 {
+		writeRow("Aliases", html.EscapeString(strings.Join(cdef.Aliases, ", ")))
+	}
+	if tags := cdef.GetTags(); len(tags) > 0 {
+		writeRow("Tags", html.EscapeString(strings.Join(tags, ", ")))
+	}
+	writeRow("Language", html.EscapeString(cdef.Lang))
+	pbLink := fmt.Sprintf("<a href=\"../%s.html#cmd-%s\">%s</a>", pb.Slug, html.EscapeString(makeSlug(cdef.Name)), html.EscapeString(pb.Title))
+	if cdef.Playbook.ResolvedFile != pb.Playbook.ResolvedFile {
+		// included from another playbook
+		pbLink = fmt.Sprintf("<a href=\"../%s.html\">%s</a> (included from %s)", pb.Slug, html.EscapeString(pb.Title), html.EscapeString(cdef.Playbook.OrigName))
+	}
+	writeRow("Playbook", fmt.Sprintf("%s, line %d", pbLink, cdef.StartLineNo))
+	buf.WriteString("</table>\n")
+	if argsHelp := cdef.ArgsHelpStr(); argsHelp != "" {
+		fmt.Fprintf(&buf, "<pre>%s</pre>\n", html.EscapeString(strings.TrimRight(argsHelp, "\n")))
+	}
+	if strings.TrimSpace(cdef.HelpText) != "" {
+		helpHtml, err := markdownToHtml([]byte(cdef.HelpText), nil)
+		if err != nil {
+			return "", fmt.Errorf("cannot render help for command '%s': %w", cdef.Name, err)
+		}
+		buf.WriteString(helpHtml)
+	}
+	buf.WriteString("<h2>Script</h2>\n")
+	buf.WriteString(render.CodeBlockHTML(cdef.Lang, cdef.ScriptText))
+	return buf.String(), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package docsite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+)
+
+// front matter with a title (used by mkdocs and hugo)
+func mdFrontMatter(title string) string {
+	return fmt.Sprintf("---\ntitle: %s\n---\n\n", jsonQuote(title))
+}
+
+// escapes "|" so text can be used in a markdown table cell
+func mdTableCell(text string) string {
+	return strings.ReplaceAll(text, "|", "\\|")
+}
+
+// a markdown tree: index.md, one file per playbook (the playbook source), and one file per command
+func (site *Site) markdownFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	files["index.md"] = []byte(site.markdownIndex())
+	for _, pb := range site.playbooks {
+		source := strings.TrimLeft(string(mdparser.StripFrontMatter(pb.Source)), "\r\n")
+		files[pb.Slug+".md"] = []byte(mdFrontMatter(pb.Title) + source)
+		for _, cdef := range pb.Commands {
+			files[pb.cmdPage(cdef, ".md")] = []byte(site.markdownCommand(pb, cdef))
+		}
+	}
+	return files, nil
+}
+
+func (site *Site) markdownIndex() string {
+	var buf strings.Builder
+	buf.WriteString(mdFrontMatter(site.Title))
+	fmt.Fprintf(&buf, "# %s\n\n## Playbooks\n\n", site.Title)
+	for _, pb := range site.playbooks {
+		fmt.Fprintf(&buf, "* [%s](%s.md) (%d commands)\n", pb.Title, pb.Slug, len(pb.Commands))
+	}
+	buf.WriteString("\n## Commands\n\n| Command | Description | Playbook |\n| --- | --- | --- |\n")
+	for _, entry := range site.commandIndex() {
+		fmt.Fprintf(&buf, "| [`%s`](%s) | %s | [%s](%s.md) |\n", entry.Cmd.Name, entry.Playbook.cmdPage(entry.Cmd, ".md"),
+			mdTableCell(entry.Cmd.ShortText), mdTableCell(entry.Playbook.Title), entry.Playbook.Slug)
+	}
+	return buf.String()
+}
+
+func (site *Site) markdownCommand(pb *sitePlaybook, cdef *commanddef.CommandDef) string {
+	var buf strings.Builder
+	buf.WriteString(mdFrontMatter(cdef.Name))
+	fmt.Fprintf(&buf, "# `%s`\n\n", cdef.Name)
+	if cdef.ShortText != "" {
+		fmt.Fprintf(&buf, "%s\n\n", cdef.ShortText)
+	}
+	fmt.Fprintf(&buf, "* Run: `scripthaus run %s`\n", cdef.UsageStr())
+	if len(cdef.Aliases) > 0 {
+		fmt.Fprintf(&buf, "* Aliases: %s\n", strings.Join(cdef.Aliases, ", "))
+	}
+	if tags := cdef.GetTags(); len(tags) > 0 {
+		fmt.Fprintf(&buf, "* Tags: %s\n", strings.Join(tags, ", "))
+	}
+	fmt.Fprintf(&buf, "* Playbook: [%s](../%s.md), line %d\n\n", pb.Title, pb.Slug, cdef.StartLineNo)
+	if argsHelp := cdef.ArgsHelpStr(); argsHelp != "" {
+		fmt.Fprintf(&buf, "```\n%s```\n\n", argsHelp)
+	}
+	if helpText := strings.TrimSpace(cdef.HelpText); helpText != "" {
+		fmt.Fprintf(&buf, "%s\n\n", helpText)
+	}
+	fmt.Fprintf(&buf, "## Script\n\n```%s\n%s\n```\n", cdef.Lang, strings.TrimRight(cdef.ScriptText, "\n"))
+	return buf.String()
+}
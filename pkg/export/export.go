@@ -0,0 +1,147 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// generates Makefiles, justfiles, and Taskfiles whose targets wrap 'scripthaus run'
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+const FormatMake = "make"
+const FormatJust = "just"
+const FormatTask = "task"
+
+// the binary the generated targets call
+const ScriptHausBin = "scripthaus"
+
+func Formats() []string {
+	return []string{FormatMake, FormatJust, FormatTask}
+}
+
+// the conventional file name for the format
+func DefaultFileName(format string) string {
+	switch format {
+	case FormatMake:
+		return "Makefile"
+
+	case FormatJust:
+		return "justfile"
+
+	case FormatTask:
+		return "Taskfile.yml"
+
+	default:
+		return ""
+	}
+}
+
+type Target struct {
+	Name    string // the playbook command name
+	RunName string // the name passed to 'scripthaus run' (e.g. "./scripthaus.md::build")
+	Desc    string // short description (can be empty)
+}
+
+var justNameInvalidRe = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// returns warnings (e.g. target names that had to be changed for the format)
+func Write(w io.Writer, format string, source string, targets []Target) ([]string, error) {
+	switch format {
+	case FormatMake:
+		return writeMakefile(w, source, targets)
+
+	case FormatJust:
+		return writeJustfile(w, source, targets)
+
+	case FormatTask:
+		return writeTaskfile(w, source, targets)
+
+	default:
+		return nil, fmt.Errorf("invalid export format '%s', must be one of: %s", format, strings.Join(Formats(), ", "))
+	}
+}
+
+func header(commentStr string, source string) string {
+	return fmt.Sprintf("%s generated by 'scripthaus export' from %s\n%s edit the playbook and re-export instead of editing this file\n", commentStr, source, commentStr)
+}
+
+// the shell command for the target (the recipe text is run by a shell in every format)
+func runCmdStr(target Target, argsStr string) string {
+	return fmt.Sprintf("%s run %s %s", ScriptHausBin, shellescape.Quote(target.RunName), argsStr)
+}
+
+func writeMakefile(w io.Writer, source string, targets []Target) ([]string, error) {
+	var buf strings.Builder
+	buf.WriteString(header("#", source))
+	buf.WriteString("\n# pass script arguments with ARGS=\"...\"\n")
+	names := make([]string, len(targets))
+	for idx, target := range targets {
+		names[idx] = target.Name
+	}
+	sort.Strings(names)
+	buf.WriteString(fmt.Sprintf(".PHONY: %s\n", strings.Join(names, " ")))
+	for _, target := range targets {
+		buf.WriteString("\n")
+		if target.Desc != "" {
+			buf.WriteString(fmt.Sprintf("## %s - %s\n", target.Name, target.Desc))
+		}
+		// "$" is special to make
+		buf.WriteString(fmt.Sprintf("%s:\n\t%s\n", target.Name, strings.ReplaceAll(runCmdStr(target, ""), "$", "$$")+"$(ARGS)"))
+	}
+	_, err := io.WriteString(w, buf.String())
+	return nil, err
+}
+
+func writeJustfile(w io.Writer, source string, targets []Target) ([]string, error) {
+	var warnings []string
+	var buf strings.Builder
+	buf.WriteString(header("#", source))
+	for _, target := range targets {
+		recipeName := justNameInvalidRe.ReplaceAllString(target.Name, "-")
+		if recipeName != target.Name {
+			warnings = append(warnings, fmt.Sprintf("command '%s' exported as just recipe '%s'", target.Name, recipeName))
+		}
+		buf.WriteString("\n")
+		if target.Desc != "" {
+			buf.WriteString(fmt.Sprintf("# %s\n", target.Desc))
+		}
+		buf.WriteString(fmt.Sprintf("%s *args:\n    %s\n", recipeName, runCmdStr(target, "{{args}}")))
+	}
+	_, err := io.WriteString(w, buf.String())
+	return warnings, err
+}
+
+// JSON strings are valid YAML double-quoted strings
+func yamlQuote(s string) string {
+	barr, _ := json.Marshal(s)
+	return string(barr)
+}
+
+func writeTaskfile(w io.Writer, source string, targets []Target) ([]string, error) {
+	var buf strings.Builder
+	buf.WriteString(header("#", source))
+	buf.WriteString("\nversion: '3'\n\ntasks:\n")
+	for idx, target := range targets {
+		if idx > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(fmt.Sprintf("  %s:\n", yamlQuote(target.Name)))
+		if target.Desc != "" {
+			buf.WriteString(fmt.Sprintf("    desc: %s\n", yamlQuote(target.Desc)))
+		}
+		buf.WriteString("    cmds:\n")
+		buf.WriteString(fmt.Sprintf("      - %s\n", yamlQuote(runCmdStr(target, "{{.CLI_ARGS}}"))))
+	}
+	_, err := io.WriteString(w, buf.String())
+	return nil, err
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// writes .git/hooks shims that run playbook commands (used by 'scripthaus hooks')
+package githooks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// written into every shim, shims without it are never overwritten or removed (without --force)
+const Marker = "generated by 'scripthaus hooks install'"
+
+// client-side hooks from githooks(5)
+var KnownHooks = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch",
+	"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "pre-auto-gc", "post-rewrite",
+	"post-index-change", "fsmonitor-watchman", "reference-transaction", "push-to-checkout",
+	"sendemail-validate", "p4-changelist", "p4-prepare-changelist", "p4-post-changelist", "p4-pre-submit",
+}
+
+func IsKnownHook(name string) bool {
+	for _, hook := range KnownHooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr == "" {
+			errStr = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), errStr)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// returns the top-level directory of the working tree containing dir
+func TopLevel(dir string) (string, error) {
+	return runGit(dir, "rev-parse", "--show-toplevel")
+}
+
+// returns the (absolute) hooks directory for the repository containing dir (respects core.hooksPath)
+func HooksDir(dir string) (string, error) {
+	hooksDir, err := runGit(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	return hooksDir, nil
+}
+
+// the shim for a hook.  runNames are passed to 'scripthaus run' (in order, stopping at the first
+// failure) and are relative to the top of the working tree (where git runs hooks)
+func MakeShim(hook string, source string, runNames []string) string {
+	var buf strings.Builder
+	buf.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&buf, "# %s from %s, do not edit.\n", Marker, source)
+	buf.WriteString("# re-run 'scripthaus hooks install' after changing 'hook' directives.\n")
+	buf.WriteString("if ! command -v scripthaus >/dev/null 2>&1; then\n")
+	fmt.Fprintf(&buf, "    echo \"[^scripthaus] %s hook: scripthaus not found in PATH\" >&2\n", hook)
+	buf.WriteString("    exit 1\n")
+	buf.WriteString("fi\n")
+	for _, runName := range runNames {
+		fmt.Fprintf(&buf, "scripthaus run %s \"$@\" || exit $?\n", shellescape.Quote(runName))
+	}
+	return buf.String()
+}
+
+// true if the file exists and contains Marker
+func IsGenerated(fileName string) (bool, error) {
+	barr, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(barr, []byte(Marker)), nil
+}
@@ -6,20 +6,15 @@ var MainHelpText = strings.TrimSpace(`
 Usage: scripthaus [global-opts] [command] [command-opts]
 
 Commands:
-    version         - print version and exit
-    run             - runs a playbook command
-    list            - list commands available in playbook
-    add             - quickly add a command to a playbook
-    show            - show help and script text for a playbook command
-    history         - show command history
-    manage          - manage history items
-    help            - describe commands and usage
+[:commands]
     help [command]  - specific help for particular command
+    help directives - describe the @scripthaus directives for code blocks
+    help plugins    - external 'scripthaus-[name]' subcommands found on PATH
 
 Global Options:
-    -p, --playbook [file]    - specify a playbook to use
-    -v, --verbose            - more debugging output
-    -q, --quiet              - do not show version and command summary info (command output only)
+[:global-options]
+
+Options that take a value can be passed as '--opt value' or '--opt=value'.
 
 Resources:
     github          - https://github.com/scripthaus-dev/scripthaus
@@ -41,17 +36,170 @@ Examples:
   scripthaus run ^grep-files      # runs the 'grep-files' command from your global scripthaus.md
   scripthaus run .run-webserver   # runs the 'run-webserver command from your project's scripthaus.md file
   scripthaus run .build.md::test  # runs the 'test' command from the build.md file in your project root
+  scripthaus run ^tools/docker.md::build  # playbooks can be in sub-directories of the global directory
+  scripthaus run ^tools/::build   # runs 'build' from tools/scripthaus.md in the global directory
+
+If no command is given, the playbook's 'default_command' (set in the playbook
+front matter) is run.
+
+A command with no playbook prefix (e.g. "scripthaus run build") is searched for
+in every playbook on SCRIPTHAUS_PATH (in order), see 'scripthaus help list'.
+
+Name Precedence:
+The first playbook on SCRIPTHAUS_PATH with the command (or an alias with that
+name) wins.  With the default SCRIPTHAUS_PATH (".:^") a project command
+shadows a global command with the same name.  When a later playbook also has
+the command a notice is printed (not with --quiet).  Use --from to pick the
+playbook for a bare name, or a playbook prefix:
+
+    scripthaus run --from ^ build   # same as "scripthaus run ^build"
+    scripthaus run --from . build   # same as "scripthaus run .build"
+
+'scripthaus list --everywhere' shows every shadowed command.
 
 If the global '--playbook' option is given, then 'playbook' must be ommitted and
 command will interpreted as a command inside of the given playbook.
 
+A playbook of "-" is read from stdin, e.g. "cat gen.md | scripthaus run -::build"
+('show -::build' also works).  The command's own stdin is then empty.
+
 Any arguments after 'command' will be passed verbatim as options to the command.
 
 Run Options:
-    --nolog                  - will not log this command to scripthaus history
-    --log                    - force logging of command to scripthaus history (default)
-    --env 'var=val;var=val'  - specify additional environment variables (';' is seperator)
-    --env 'file.env'         - special additional environment variables from .env file
+[:options]
+
+With --tmux-pane or --tmux-window the command runs in the background tmux pane
+or window (which stays open until you press enter) and this returns right away.
+The pane gets the tmux session's environment, use --env to pass extra variables.
+If you are not inside tmux (or tmux is not installed) the command runs in the
+foreground as usual.
+
+Reports (e.g. "--report json=report.json --report junit=report.xml") are for CI.
+They include the command, args, start/end times, duration, exit code, and
+warnings for each command run (one per command with --tag).  The output of each
+command is also captured (secrets are redacted) to a file next to the first
+report, e.g. "report.build.log".
+
+Code Blocks:
+Fenced code blocks without scripthaus directives (e.g. in a project's README)
+can be run by index ("#1" is the first code block in the file) or by heading
+(the first code block under the heading, case insensitive).  The block is
+shown and you are asked to confirm before it runs (pass --yes to skip this,
+required when not running in a terminal).  'scripthaus show' prints the block.
+
+    scripthaus run README.md::#3
+    scripthaus run 'README.md::Install dependencies'
+
+Trust:
+The first time you run a command from a playbook (e.g. in a freshly cloned
+repository) the command's script is shown and you are asked to confirm.
+Confirming trusts the playbook, and you are asked again only if it changes.
+Playbooks in $SCRIPTHAUS_HOME and under "trusted_roots" are always trusted
+(fetched registry playbooks only if they are signed).
+See 'scripthaus help trust'.
+
+direnv:
+If the command's project root has a .envrc (direnv) that is not loaded in the
+current environment (e.g. the command is run from outside the project) a
+warning is printed, the command would not see the variables a shell in the
+project has.  Set "direnv" in $SCRIPTHAUS_HOME/config.json to "load" to run
+commands with the .envrc's environment ('direnv export', so the .envrc must
+be allowed with 'direnv allow'), or to "off" for no warning.  The playbook's
+env, 'env' directives, and --env override the .envrc's variables, variables
+the .envrc unsets are not unset.
+
+    config.json: {"direnv": "load"}
+
+Script Tracing:
+-x traces the script without editing the playbook.  Shell blocks run with
+"set -x" (tcsh "set echo", fish "fish_trace"), python blocks print each line
+of the command's code as "+ [line]: [code]", cmd blocks run with "echo on",
+powershell blocks with "Set-PSDebug -Trace 1", and sql blocks echo their
+statements (psql --echo-queries, mysql --verbose, sqlite3 -echo).  js/ts blocks
+and commands with an 'interpreter' directive cannot be traced.
+
+Pipelines:
+"|" separates the commands of a pipeline, the stdout of each command is piped
+into the stdin of the next (quote the "|" so your shell does not handle it).
+The pipeline is logged as one history item, and the exit code is the last
+non-zero exit code of the commands (like bash's pipefail).
+
+A "|" directly after the first command starts a pipeline.  Otherwise "|" args
+(and args containing "|", like the regex 'a|b') are passed to the script, so
+use --pipe when the first command has arguments or the whole pipeline is one
+quoted string.
+
+    scripthaus run .extract '|' .transform --fast '|' .load
+    scripthaus run --pipe .extract --since 1d '|' .transform --fast '|' .load
+    scripthaus run --pipe '.extract --since 1d | .transform --fast | .load'
+    scripthaus run --pipe .extract .transform .load   # commands without arguments
+
+Matrix Runs:
+--matrix (or the 'matrix' directive) runs the command once for every
+combination of the values, with the values set as environment variables.  The
+runs happen one after the other and keep going after failures, a pass/fail
+table is printed at the end, and the exit code is 1 if any run failed.  Each
+run is logged as its own history item (and report result).  --matrix replaces
+the directive's values for the same variable.
+
+    scripthaus run --matrix env=dev,staging,prod .deploy
+    scripthaus run --matrix env=dev,prod --matrix region=us,eu .smoke-test
+
+Each Line:
+--each reads lines from stdin (blank lines are skipped) and runs the command
+once per line, with the line as the first script argument (any other script
+arguments come after it).  The command's own stdin is closed.  With
+--parallel the runs happen at the same time and each run's output is printed
+when it finishes.  Failed lines are listed at the end and the exit code is 1
+if any run failed.
+
+    cat hosts.txt | scripthaus run --each .reboot-host
+    cat hosts.txt | scripthaus run --each --parallel 8 .check-host --verbose
+
+Stopping Commands:
+When a command times out ('timeout' directive or --timeout), scripthaus is
+interrupted (Ctrl-C or SIGTERM), or a daemon run is cancelled, the command gets
+SIGTERM and then SIGKILL if it is still running after a grace period (5s,
+"kill_grace" in $SCRIPTHAUS_HOME/config.json).  When stdin is not a terminal
+the command runs in its own process group and the signals go to the whole
+group.  The reason ("timeout", "interrupt", or "cancelled") is recorded in
+history, and the exit code is 128+[signal] (like a shell).
+
+If scripthaus itself stops before a run is recorded (it crashes, or gets a
+signal outside of a running command) the run is recorded as "interrupted".
+Runs of a scripthaus process that was killed outright (SIGKILL) are marked
+"interrupted" (exit code 1, no duration) the next time history is read.
+
+    config.json: {"kill_grace": "30s"}
+
+Notifications:
+--notify (or the 'notify' directive) sends a desktop notification (osascript
+on macOS, notify-send on Linux) with the command, exit code, and duration when
+the command finishes.  To also post to a Slack or Discord incoming webhook, or
+to turn off desktop notifications, set them in $SCRIPTHAUS_HOME/config.json:
+
+    config.json: {"notify_webhook": "https://hooks.slack.com/services/...", "notify_desktop": false}
+
+Tracing:
+To export an OpenTelemetry span for every run (OTLP/HTTP, JSON encoding) set
+an endpoint in $SCRIPTHAUS_HOME/config.json.  Spans have the playbook, command,
+language, exit code, duration, and history id as attributes.  If TRACEPARENT is
+set the span joins that trace, and the command gets a TRACEPARENT for its span.
+
+    config.json: {"otlp": {"endpoint": "http://localhost:4318",
+                           "headers": {"x-api-key": "..."}, "service_name": "deploys"}}
+
+Run Hooks:
+"pre_run" and "post_run" in $SCRIPTHAUS_HOME/config.json are shell commands
+that run before and after every command (sh -c, or cmd /c on windows).  Their
+output goes to stderr, and a failing hook only prints a warning.  The hooks get
+SCRIPTHAUS_HOOK, SCRIPTHAUS_COMMAND, SCRIPTHAUS_COMMAND_NAME,
+SCRIPTHAUS_PLAYBOOK, SCRIPTHAUS_LANG, SCRIPTHAUS_HISTORY_ID (if logged), and
+SCRIPTHAUS_MATRIX (for matrix runs, e.g. "env=dev").
+post_run also gets SCRIPTHAUS_EXIT_CODE, SCRIPTHAUS_DURATION_MS, and
+SCRIPTHAUS_ERROR (if the command could not be started).
+
+    config.json: {"post_run": "~/bin/push-run-metrics.sh"}
 `)
 
 var ListText = strings.TrimSpace(`
@@ -63,10 +211,32 @@ playbook.  The playbook can optionally be passed via the -p option.
 If no playbook is passed list will find all playbooks in the SCRIPTHAUS_PATH
 and list all of their commands.  Playbook can be a relative or absolute path,
 or a reference to the global ScriptHaus directory "^" or the project
-ScriptHaus directory ".".
+ScriptHaus directory ".".  "-" reads the playbook from stdin, e.g.
+"curl -s https://example.com/playbook.md | scripthaus list -".
+
+Commands are grouped under the nearest level 1-3 markdown heading above them
+(e.g. "Build", "Deploy"), commands before the first heading are listed first.
+
+SCRIPTHAUS_PATH is a ":" separated list of playbooks (any playbook name, e.g.
+".", "^", a directory, or an .md file).  When it is not set it defaults to
+".:^" (the project playbook, then the global playbook).
 
 List Options:
-    none
+[:options]
+
+Everywhere:
+--everywhere lists the playbooks on SCRIPTHAUS_PATH (in order), then the
+project playbooks (".", and the parent projects "..", "...") and the global
+playbook "^" if they are not on SCRIPTHAUS_PATH.  Each playbook is marked with
+where it comes from.  A command name defined in an earlier playbook shadows
+the same name in later ones (the earlier command is the one a bare
+"scripthaus run [name]" finds first), shadowed commands are marked and warned
+about.
+
+The JSON output has one object per command with the fields: schema_version,
+name, fullname, usage, shorttext, lang, aliases, tags, section, playbook,
+playbookfile, lineno, and with --everywhere origin and shadowedby.  schema_version (currently 1) only changes when a
+field is removed, renamed, or changes meaning.
 `)
 
 var ShowText = strings.TrimSpace(`
@@ -82,8 +252,476 @@ show all of the commands in the given playbook file.
 
 Note that playbook may also be specified using the global --playbook option.
 
+The banner shows where the command is defined (file:line).  The --code, --doc,
+and --meta options print only part of the command (with no banners), e.g.
+"scripthaus show --code .build | sh".
+
+When stdout is a terminal (and NO_COLOR is not set) the markdown help text and
+code are rendered with terminal styling, otherwise the raw markdown is printed
+("--color always" and "--color never" override the terminal check).
+
 Show Options:
-    none
+[:options]
+`)
+
+var RemoveText = strings.TrimSpace(`
+Usage: scripthaus remove [remove-opts] [playbook]::[command]
+
+The 'remove' command deletes the command's code block from the playbook file
+(the file the command is defined in, which can be an included playbook).  The
+lines to be removed are always printed.  A command without a playbook prefix
+is removed from the project playbook ".".
+
+Commands made up of multiple code blocks (part=N or 'continue') must be
+removed manually.
+
+Remove Options:
+[:options]
+`)
+
+var ImportText = strings.TrimSpace(`
+Usage: scripthaus import [import-opts] [source-file] [playbook]
+
+The 'import' command converts npm scripts (package.json) or make targets
+(Makefile) into bash commands and appends them to a playbook (default ".").
+The source type is detected from the file name, or set with --from.
+
+Imported commands run in the directory of the source file.  npm scripts get
+node_modules/.bin on their PATH and are passed the script arguments.  Make
+recipes keep "$@", "$$" becomes "$", and a multi-line recipe stops at the first
+failing line.  Make prerequisites and variables are not converted, a warning is
+printed so the imported script can be checked.  Commands that already exist in
+the playbook are skipped.
+
+Import Options:
+[:options]
+
+Examples:
+    scripthaus import package.json ./scripthaus.md
+    scripthaus import --section Build Makefile
+`)
+
+var ExportText = strings.TrimSpace(`
+Usage: scripthaus export [export-opts] [playbook]
+
+The 'export' command generates a Makefile, justfile, or Taskfile.yml with one
+target per playbook command.  Each target calls 'scripthaus run', so the
+playbook stays the source of truth (re-export after changing it).  Script
+arguments are passed with ARGS="..." (make), as recipe arguments (just), or
+after "--" (task).  With no playbook the project playbook "." is exported.
+
+The output is printed to stdout unless "-o" is given.  An existing output file
+is only overwritten if it was generated by 'scripthaus export' (or --force).
+
+Export Options:
+[:options]
+`)
+
+var ExportScriptText = strings.TrimSpace(`
+Usage: scripthaus export-script [export-opts] [playbook]::[command]
+
+The 'export-script' command writes a playbook command as a standalone
+executable script, so it can be run on a machine without scripthaus.  The
+script starts with a shebang for its language (sh, bash, zsh, ksh, python,
+node, pwsh, or cmd) and a comment header with the command's help text.  The
+'cd', 'env', 'require-env', and 'shellopts' directives (and playbook env
+settings) are baked into the script.  Secret references in env values are not
+written out, they must be set before the script is run.  'arg' and 'flag'
+directives are not parsed, the script gets its arguments as-is.
+
+The output is printed to stdout unless "-o" is given (the file is made
+executable).  An existing output file is only overwritten if it was generated
+by 'scripthaus export-script' (or --force).
+
+Export-Script Options:
+[:options]
+
+Examples:
+    scripthaus export-script ./scripthaus.md::deploy -o bin/deploy.sh
+`)
+
+var DocsText = strings.TrimSpace(`
+Usage: scripthaus docs [docs-opts] [dir]
+
+The 'docs' command renders every playbook in the project (the same playbooks
+as 'list --all') into a static docs site in [dir] (default "scripthaus-docs").
+Use "-p [playbook]" to only render one playbook.
+
+The site has an index page (playbooks and a command index), a page for each
+playbook, and a page for each command (usage, arguments, help text, and the
+syntax highlighted script).  The "markdown" format writes the same pages as a
+markdown tree (with title front matter) that can be used as a mkdocs docs_dir
+or hugo content directory.
+
+Existing files in [dir] that are not part of the site are left alone.
+
+Docs Options:
+[:options]
+`)
+
+var McpText = strings.TrimSpace(`
+Usage: scripthaus mcp [mcp-opts]
+
+The 'mcp' command runs a Model Context Protocol server over stdin/stdout so
+AI assistants can discover (and optionally run) playbook commands.  Configure
+your assistant to launch "scripthaus mcp" in the project directory.
+
+Tools:
+    list_commands   - list the commands in the project (or one playbook)
+    show_command    - usage, arguments, help text, and script for a command
+    run_command     - run a command and return its exit code and output
+
+run_command is only offered if there is an allowlist, and it only runs
+commands that match it.  Patterns are matched against the command name as
+passed to 'scripthaus run' (e.g. ".test" or "./ops.md::deploy") and its
+canonical name, "*" matches any characters.  Allowed commands run without
+stdin and are logged to history like any other run.
+
+MCP Options:
+[:options]
+
+The allowlist can also be set in $SCRIPTHAUS_HOME/config.json:
+    {"mcp_allow": [".test", ".lint", "./docs/*"]}
+`)
+
+var HooksText = strings.TrimSpace(`
+Usage: scripthaus hooks [list|install|uninstall] [--force] [playbook]
+
+The 'hooks' command installs git hooks (in .git/hooks, or core.hooksPath)
+that run playbook commands.  Mark a command with a 'hook' directive:
+
+    # @scripthaus command lint
+    # @scripthaus hook pre-commit pre-push
+
+Each hook is a small shell script that runs 'scripthaus run' for every command
+with that hook (in playbook order, stopping at the first failure).  The hook's
+arguments are passed through to the commands.  The playbook defaults to your
+project playbook (".").
+
+Sub-commands:
+    list       - show the hooks declared in the playbook and whether they are installed (default)
+    install    - write the hooks, and remove hooks we generated that are no longer declared
+    uninstall  - remove all of the hooks generated by scripthaus
+
+Hooks Options:
+[:options]
+
+Hooks that already exist and were not generated by scripthaus are left alone
+unless --force is given.  Re-run 'scripthaus hooks install' after changing
+'hook' directives (the hooks themselves read the playbook when they run, so
+changes to the commands do not need a re-install).  Hooks run without a
+terminal, so installing them trusts the playbook (a changed playbook must be
+trusted again with 'scripthaus trust', see 'scripthaus help trust').
+`)
+
+var BenchText = strings.TrimSpace(`
+Usage: scripthaus bench [bench-opts] [playbook]::[command] [script-opts]
+
+Runs a playbook command repeatedly (like hyperfine) and reports the mean,
+standard deviation, min, p95, and max durations.  The command's output is
+suppressed (it is printed if a run fails) and stdin is empty.  Commands are
+resolved like 'scripthaus run', and the runs are logged to history (marked
+with a bench id, warmup runs are not logged).  The result is compared with the
+previous benchmark of the same command and arguments from history, so you can
+track build and test commands for regressions:
+
+    scripthaus bench -w 2 -n 20 .build
+      mean    1.234s ± 0.050s
+      min     1.180s
+      p95     1.320s
+      max     1.330s
+      before  1.100s mean of 20 run(s) [2026-10-01 12:00:00] (+12.2%)
+
+A run that fails (non-zero exit code) stops the benchmark unless
+--ignore-failure is given.  'scripthaus -v bench' prints each run's duration.
+
+Options:
+[:options]
+`)
+
+var TrustText = strings.TrimSpace(`
+Usage: scripthaus trust [--list | --revoke] [playbook]
+
+Running a command from a playbook you have not trusted yet (e.g. after cloning
+a repository) shows the command's script and asks you to confirm first, like
+direnv's 'allow'.  Confirming trusts the whole playbook.  Trust is for the
+playbook's contents (its sha256, and the sha256s of the playbooks it includes),
+if the playbook or one of its includes changes you are asked again.
+Without a terminal an untrusted playbook is an error (pass --yes to run it
+anyway), and 'scripthaus daemon' and 'scripthaus mcp' only run commands from
+trusted playbooks.
+
+'scripthaus trust [playbook]' trusts the playbook (and the playbooks it
+includes) as it is now without a prompt, review it first.  The playbook defaults to your project
+playbook (".").  Approvals are kept in $SCRIPTHAUS_HOME/trusted.json.
+
+Global playbooks in $SCRIPTHAUS_HOME are always trusted, and so are playbooks
+under the "trusted_roots" directories in $SCRIPTHAUS_HOME/config.json:
+
+    "trusted_roots": ["~/src/myteam"]
+
+Fetched registry playbooks ($SCRIPTHAUS_HOME/registry) are trusted only if
+'scripthaus fetch' verified their signature, unsigned playbooks are confirmed
+like any other playbook.
+
+Playbooks read from stdin ("-") cannot be trusted, every command from them is
+confirmed on the terminal (/dev/tty, since stdin is the playbook).  Without a
+terminal pass --yes, and 'scripthaus daemon' and 'scripthaus mcp' never run
+them.
+
+Options:
+[:options]
+`)
+
+var PublishText = strings.TrimSpace(`
+Usage: scripthaus publish [playbook] [@team/name]
+
+Publishes the playbook to the registry as @team/name so others can fetch it.
+The name defaults to the registry's "team" and the playbook's file name
+(e.g. "scripthaus publish ./ops.md" publishes @[team]/ops).  The playbook must
+parse without errors.  Publishing again updates the playbook.
+
+The registry is set in $SCRIPTHAUS_HOME/config.json:
+
+    "registry": {"git": "git@github.com:myteam/playbooks.git", "team": "myteam"}
+
+Publishing needs a "git" registry: the repository is cloned, the playbook is
+committed as [team]/[name].md, and pushed (using your git credentials).
+
+A checksum ([name].md.sha256) is published with the playbook.  To sign it, set
+"sign_key" in the registry config (or pass --sign-key) to an ssh private key.
+The signature is written to [name].md.sig with 'ssh-keygen -Y sign -n scripthaus'.
+
+Options:
+[:options]
+`)
+
+var FetchText = strings.TrimSpace(`
+Usage: scripthaus fetch [@team/name]...
+
+Downloads playbooks from the registry to $SCRIPTHAUS_HOME/registry.  Fetched
+playbooks are used like any other playbook, e.g. "scripthaus run @team/ops::deploy"
+or "scripthaus list @team/ops".  Fetch again to update them.
+
+Inside a project (a directory with a scripthaus.md, or below one) the fetched
+version is pinned in scripthaus.lock next to the project's scripthaus.md
+(commit it).  Pinned playbooks always resolve to the pinned version in that
+project, and fetching them again fetches the pinned version (the git revision,
+or for url registries the same sha256).  'scripthaus fetch' with no arguments
+fetches every pinned playbook, e.g. after cloning a project.  Use
+'scripthaus update' to move the pins to the latest versions.
+
+The registry is set in $SCRIPTHAUS_HOME/config.json, either a git repository
+(see 'scripthaus help publish') or an https url that serves [team]/[name].md:
+
+    "registry": {"url": "https://playbooks.example.com"}
+
+Fetched playbooks are verified before they are saved.  If the registry has a
+published checksum ([name].md.sha256) the playbook must match it.  If it is
+signed ([name].md.sig, see 'scripthaus help publish') the signature must be
+valid, and the first key that signs a team's playbooks is trusted for that team
+(saved in $SCRIPTHAUS_HOME/registry/.trusted-keys.json).  After that the team's
+playbooks must be signed by the same key.  Set "require_signature": true in the
+registry config to refuse unsigned playbooks.  Signed playbooks are trusted
+(see 'scripthaus help trust'), unsigned ones are confirmed the first time one of
+their commands runs.
+
+Options:
+[:options]
+`)
+
+var UpdateText = strings.TrimSpace(`
+Usage: scripthaus update [@team/name]...
+
+Fetches the latest version of the playbooks pinned in the project's
+scripthaus.lock (all of them if no names are given) and updates the pins.
+Names that are not pinned yet are fetched and pinned.  See 'scripthaus help fetch'.
+`)
+
+var PluginsText = strings.TrimSpace(`
+Usage: scripthaus [global-opts] [plugin] [plugin-args]
+
+An unknown command 'scripthaus foo' runs the executable 'scripthaus-foo' from
+your PATH (git-style plugins).  The plugin gets the rest of the arguments and
+its exit code is returned.  These environment variables describe the context
+(unset if they cannot be resolved):
+
+    SCRIPTHAUS_HOME          - your global scripthaus directory
+    SCRIPTHAUS_PROJECT_DIR   - the project root (directory with scripthaus.md)
+    SCRIPTHAUS_PLAYBOOK      - the --playbook file, or the project playbook
+    SCRIPTHAUS_VERSION       - the scripthaus version
+
+Built-in commands always take precedence over plugins.
+`)
+
+var DaemonText = strings.TrimSpace(`
+Usage: scripthaus daemon
+
+The 'daemon' command runs a long-lived JSON-RPC 2.0 server over stdin/stdout
+for editor integrations.  Each message is one line of JSON.  Line numbers are
+1-indexed.
+
+Methods:
+    initialize                        - server name, version, and methods
+    commands {file, text?}            - commands defined in the file (name, usage,
+                                        description, help text start line, code
+                                        block start and end lines).  "text" is the
+                                        unsaved editor contents (optional)
+    codeLenses {file, text?}          - a "Run [command]" lens for each code block
+    run {command, file?, args?}       - starts a command, returns {runid}.  without
+                                        "file" the command is resolved like
+                                        'scripthaus run'
+    cancel {runid}                    - kills a running command
+    shutdown                          - no-op, the daemon exits when stdin is closed
+
+Notifications (server to client):
+    run/output {runid, stream, data}  - command output ("stdout" or "stderr")
+    run/exit {runid, exitcode, durationms, error?}
+
+Runs have no stdin and are logged to history like any other run.  Running
+commands are killed when the daemon exits.
+`)
+
+var WhichText = strings.TrimSpace(`
+Usage: scripthaus which [playbook]::[command]
+       scripthaus which [playbook]
+
+The 'which' command shows how a name is resolved: the absolute playbook file,
+the command's line number, the project root and global directory used for the
+"." and "^" prefixes, and the canonical name recorded in history.  Use it when
+prefix resolution (".", "..", "^") or SCRIPTHAUS_PATH picks a different file
+than you expected.
+
+A command without a playbook prefix is searched for on SCRIPTHAUS_PATH (same
+as 'run').  With no arguments the project playbook "." is shown.
+
+Which Options:
+[:options]
+`)
+
+var PickText = strings.TrimSpace(`
+Usage: scripthaus pick [query]
+       scripthaus run          (with no arguments, in a terminal)
+
+The 'pick' command opens a fuzzy finder over every command in your global
+directory, the project root, and SCRIPTHAUS_PATH (or only the --playbook
+playbook if one is given).  The preview pane shows the selected command's
+location, help text, and script.  Choosing a command runs it (with no script
+arguments).  [query] pre-fills the search.
+
+Keys:
+    type to filter, up/down (or ctrl-p/ctrl-n) to move, pgup/pgdn to page,
+    enter to run the selected command, ctrl-u to clear, esc or ctrl-c to cancel
+
+Pick Options:
+[:options]
+`)
+
+var MvText = strings.TrimSpace(`
+Usage: scripthaus mv [mv-opts] [playbook]::[command] [playbook]::[new-command]
+       scripthaus mv [mv-opts] [playbook]::[command] [playbook]
+       scripthaus mv [mv-opts] [playbook]::[command] [new-command]
+
+The 'mv' command moves a command (its code block and the help text before it)
+from one playbook to another, appending it to the end of the destination
+playbook.  The name in the '@scripthaus command' directive (and a level-4
+heading that matches the old name) is updated to the new name.  If only a
+playbook is given the command keeps its name, if only a name is given the
+command is renamed in place.
+
+A source command without a playbook prefix comes from the project playbook ".".
+The destination playbook must already exist and cannot already have a command
+with the new name.  Commands made up of multiple code blocks (part=N or
+'continue') must be moved manually.
+
+Mv Options:
+[:options]
+`)
+
+var EditText = strings.TrimSpace(`
+Usage: scripthaus edit [playbook]::[command]
+       scripthaus edit [playbook]
+
+The 'edit' command opens the playbook in your editor ($VISUAL or $EDITOR,
+default vi) with the cursor at the command's code block.  Commands from
+included playbooks open the file they are defined in.
+
+A command without a playbook prefix is searched for on SCRIPTHAUS_PATH (same
+as 'run').  With no arguments the project playbook "." is opened.
+
+Edit Options:
+[:options]
+`)
+
+var SearchText = strings.TrimSpace(`
+Usage: scripthaus search [search-opts] [term]
+
+The 'search' command finds commands whose name (or alias), short description,
+help text, or script text contains [term].  Matching help and script lines are
+printed with their playbook line numbers.
+
+Searches every playbook in the global ScriptHaus directory "^", in the
+project root ".", and on SCRIPTHAUS_PATH.  If the global --playbook option is
+given, only that playbook is searched.
+
+Exits with code 1 if no commands match.
+
+Search Options:
+[:options]
+    --                       - the rest of the arguments are the search term
+`)
+
+var FmtText = replaceBacktick(strings.TrimSpace(`
+Usage: scripthaus fmt [fmt-opts] [playbook]
+
+The 'fmt' command rewrites a playbook in place with consistent formatting
+so team playbooks stay uniform:
+
+* directive spelling and spacing, e.g. "#  @scripthaus Command foo   -  desc"
+  becomes "# @scripthaus command foo - desc"
+* code fence info strings, e.g. "[:backtick][:backtick][:backtick] Bash  part=2" becomes "[:backtick][:backtick][:backtick]bash part=2"
+* html comment directives, e.g. "<!--@scripthaus include  ./common.md-->"
+
+Front matter is not modified.  The playbook may also be specified using the
+global --playbook option (defaults to the project playbook ".").
+
+Fmt Options:
+[:options]
+`))
+
+var DiffText = strings.TrimSpace(`
+Usage: scripthaus diff [diff-opts] [playbook] [rev] [rev2]
+
+The 'diff' command compares a playbook's commands between two git revisions
+and reports the commands that were added, removed, renamed, or changed, so a
+changed deploy command is not lost in a raw markdown diff.  With no revisions
+it compares HEAD to the working tree, with one revision it compares that
+revision to the working tree.  "[rev]..[rev2]" is the same as "[rev] [rev2]".
+
+    scripthaus diff                      # HEAD vs the working tree
+    scripthaus diff ./deploy.md main     # main vs the working tree
+    scripthaus diff v1.2..v1.3
+
+    [^scripthaus] './deploy.md' (/home/user/proj/deploy.md), v1.2 -> v1.3
+      + added   rollback
+      ~ renamed deploy-prod -> deploy (env)
+      * changed migrate (script, timeout)
+      - removed old-backup
+      * changed front matter (env)
+
+Changed commands list what changed: "script" (the script body, not counting
+directive lines), "lang", "fence" (code fence options), "help" (the help
+text), or the directive types that changed (e.g. "env", "cd", "timeout").
+A removed and an added command with the same script body and language are
+reported as a rename.  Front matter (or config block) settings that changed
+are listed as "front matter".
+
+Only the playbook's own commands are compared, included playbooks are not
+(diff them separately).  The playbook defaults to the project playbook "."
+and may also be specified using the global --playbook option.
+
+Diff Options:
+[:options]
 `)
 
 var VersionText = strings.TrimSpace(`
@@ -119,6 +757,8 @@ var AddText = replaceBacktick(strings.TrimSpace(`
 Usage: scripthaus add [add-opts] [playbook]::[command] -c "[command-text]"
        scripthaus add [add-opts] [playbook]::[command] -- [command-text]...
        scripthaus add [add-opts] [playbook]::[command] - < [command-text-file]
+       scripthaus add [add-opts] [playbook]::[command] --edit
+       scripthaus add [add-opts] [playbook]::[command] --from-history [history-id]
 
 The 'add' command will add a command to the playbook specified, and give it
 the name [command].  There are three ways to specify a command:
@@ -134,31 +774,166 @@ The third form with "-" will read the command from stdin.
 This works great for importing an existing command or to grab
 a set of history commands e.g. - 
 
+With "--from-history" the command line (the script arguments) of a scripthaus
+history item becomes the script text, and the script type defaults to the type
+of the command that was originally run (use "-t" to override).
+
+With "--edit" your editor ($VISUAL or $EDITOR) is opened with a template for
+the new command (help text, command directive, and the script text if one was
+given).  After the editor exits the text is checked (it must contain exactly
+one command with the given name) and added to the playbook.
+
 Add Options:
-    -t, --type [scripttype]    - (required) the language type for the command (e.g. bash, python3)
-    -m, --message [message]    - add some help text for the command.  markdown format
-    -s, --short-desc [desc]    - short description for command (one line)
-    -c [command-text]          - the text for the command to be added
-    --dry-run                  - print messages, but do not modify playbook file
+[:options]
 `))
 
 var HistoryText = replaceBacktick(strings.TrimSpace(`
 Usage: scripthaus history [history-opts]
+       scripthaus history show [id]
+       scripthaus history open [id]
+       scripthaus history env [id]
 
 The history command will show you the last 50 scripthaus commands.
 
+'history show' prints the full record for one history item: the command and
+arguments, the playbook file it ran from, cwd, start time, duration, exit code,
+user, and host.  If the run has a run log directory (see below) it also shows
+the environment variables the command's environment added or changed, and the
+first and last lines of its stdout and stderr.  With --json it prints the
+item's JSON record (see JSON Format).
+
+'history open' opens the run log directory for a history item (or prints the
+directory if stdout is not a terminal).  Run log directories are opt-in, set
+"run_logs": true in $SCRIPTHAUS_HOME/config.json.  Each logged run then gets a
+$SCRIPTHAUS_HOME/runs/[id]/ directory with metadata.json, stdout.log,
+stderr.log, and env-diff.json (variables the command's environment adds,
+changes, or removes).  Secrets are redacted.  Output is still streamed to the
+terminal, but the command's stdout/stderr are no longer a terminal.
+
+'history env' prints the environment variables scripthaus set for a run (see
+Recorded Environment) as a .env file, so the run can be repeated exactly:
+
+    scripthaus history env 42 > run42.env
+    scripthaus run --env-file run42.env ./deploy.md::deploy
+
+Redacted values ("****") have to be filled in (or the lines removed) first.
+
 History Options:
-    -n [num]                 - print last n commands
-    --all                    - print all history
-    --full                   - show full history item (all fields, multiple lines)
-    --json                   - output full records in JSON format (can process with jq)
+[:options]
+
+Format Templates:
+--format takes a Go text/template (like docker and kubectl), it is printed
+once per history item followed by a newline:
+
+    scripthaus history --format '{{.HistoryId}} {{.ScriptName}} {{.ExitCode}}'
+    scripthaus history --format '{{.Date}}{{"\t"}}{{.DurationMs}}ms{{"\t"}}{{.ScriptName}} {{.CmdLine}}'
+    scripthaus history --format '{{if and .Finished (ne .ExitCode 0)}}{{.HistoryId}}{{end}}'
+
+Fields: HistoryId, Ts (unix ms), Date, ScriptName, Version, ProjectDir,
+ProjectName, PlaybookFile, PlaybookCommand, ScriptType, Args (list), CmdLine
+(shell quoted args), Cwd, HostName, IpAddr, SysUser, Finished, DurationMs,
+ExitCode, RunDir, Pipeline, Matrix, TermReason, and RunCount (--unique).  ExitCode and DurationMs
+are 0 while the command is still running (Finished is false).  Functions:
+"json" (e.g. {{json .Args}}) and "join" (e.g. {{join "," .Args}}).
+
+JSON Format:
+'history --json' prints an array of objects with a stable schema.
+"schema_version" (currently 1) only changes when a field is removed, renamed,
+or changes meaning, new fields can be added at any time.  Fields marked with a
+"?" are omitted when they are not set.
+
+    schema_version   - version of this format (1)
+    historyid        - history id
+    ts, date         - start time (unix milliseconds, and local time "2006-01-02T15:04:05")
+    version          - scripthaus version that ran the command
+    projectdir?      - project root, projectname? is its name
+    playbookfile?    - playbook ("." and "^" playbooks are relative to the project/global dir)
+    playbookcommand? - command name
+    scripttype       - language of the command
+    cwd, hostname, ipaddr, sysuser
+    cmdline          - script arguments, a JSON array encoded as a string
+    durationms?      - duration in milliseconds (not set while the command is running)
+    exitcode?        - exit code (not set while the command is running)
+    rundir?          - run log directory (see 'history open')
+    pipeline?        - the commands of a 'run a | b' pipeline
+    matrix?          - the matrix cell, e.g. "env=dev,region=us"
+    termreason?      - "timeout", "interrupt", or "cancelled" if scripthaus stopped the command,
+                       "interrupted" if scripthaus stopped before the run finished
+    env?             - variables set by scripthaus, VAR=VAL entries (see Recorded Environment)
+    runcount?        - number of identical runs (with --unique)
+
+Recorded Environment:
+Set "record_env" in $SCRIPTHAUS_HOME/config.json to record the environment
+variables scripthaus sets for each run with the history item: --env and
+--env-file values, 'env' directives, the playbook's front matter env, and
+matrix cell values.  The rest of the environment is not recorded.  Variables
+are recorded before secret references are resolved, so a reference is
+recorded, not the secret.  Other values of variables whose name contains TOKEN, SECRET,
+PASSWORD, or PASSWD are redacted.  'history show' lists the variables.
+
+    config.json: {"record_env": true}
+
+Host Info:
+Each history item records the hostname and the local ip address (from the
+network interfaces, scripthaus does not connect anywhere to find it).  To not
+record them set "collect_ipaddr" to false in $SCRIPTHAUS_HOME/config.json:
+
+    config.json: {"collect_ipaddr": false}
+
+Redaction:
+Script arguments are redacted (replaced with "****") before they are written
+to history.  An argument is redacted if it contains a resolved secret (see
+"Secrets" in "scripthaus help directives") or the value of an environment
+variable whose name contains TOKEN, SECRET, PASSWORD, or PASSWD, or if it is
+the value of a flag with one of those names ("--token abc123",
+"--password=abc123").  More patterns (Go regexps) can be set in
+$SCRIPTHAUS_HOME/config.json, if a pattern has groups only the groups are
+redacted:
+
+    config.json: {"redact_patterns": ["sk_live_[A-Za-z0-9]+", "(?i)apikey=(\\S+)"]}
+
+Encryption:
+History can be encrypted at rest.  Set $SCRIPTHAUS_HISTORY_KEY or "history_key"
+in $SCRIPTHAUS_HOME/config.json, either can be a secret reference (see "Secrets"
+in "scripthaus help directives") so the key stays in a keychain:
+
+    config.json: {"history_key": "keychain://scripthaus/history"}
+
+The cwd, command line, and metadata of each history item are then stored
+encrypted (AES-256-GCM).  The encryption key is derived from the history key
+with scrypt and a random salt kept in the history db, so the history key can be
+a passphrase.  Reading the history needs the same key.  Items written before
+the key was set (or encrypted by older scripthaus versions) stay readable,
+'scripthaus manage encrypt-history' encrypts them with the derived key.  Run log directories (run_logs) and 'manage export-json' files
+are not encrypted.
 `))
 
+var RerunText = strings.TrimSpace(`
+Usage: scripthaus rerun [--last | --last-failed]
+
+The 'rerun' command finds the most recent run in scripthaus history for the
+current project and runs it again with the same playbook command and script
+arguments (and matrix cell).  Runs count as part of the project if they used
+one of the project's playbooks or ran from a directory inside the project.
+Outside of a project only runs from the current directory are considered.
+
+Pipelines cannot be rerun (rerun prints the 'scripthaus run' command for them).
+Runs with arguments that were redacted as secrets in history ("****") cannot
+be rerun, rerun prints the command to run again with the real values.
+
+Rerun Options:
+[:options]
+`)
+
 var ManageText = replaceBacktick(strings.TrimSpace(`
 Usage: scripthaus manage clear-history
        scripthaus manage delete-db
        scripthaus manage remove-history-range [start-id] [end-id]
        scripthaus manage renumber-history
+       scripthaus manage vacuum
+       scripthaus manage export-json [file]
+       scripthaus manage import-json [file]
+       scripthaus manage encrypt-history
 
 The manage command contains commands to help manage the history database.
 
@@ -166,7 +941,350 @@ clear-history        - will remove all the history items
 delete-db            - will completely delete the scripthaus history database (rm the file)
 remove-history-range - removes the history items between start-id and end-id inclusive
 renumber-history     - will renumber history items by timestamp (starting at 1)
+vacuum               - compacts the history database file (VACUUM and ANALYZE) and reports
+                       the space reclaimed.  sqlite does not shrink the file when items are
+                       removed, run this after clear-history or remove-history-range
+export-json          - writes the entire history (including metadata) as JSON to [file] (or stdout)
+import-json          - adds the items from an export-json file (or stdin) to the history.
+                       imported items get new ids, items already in the history are skipped
+encrypt-history      - encrypts history items written before the history key was set
+                       (and re-encrypts items from older versions with the derived key)
+
+Export-json/import-json move history between machines or scripthaus versions without copying
+the sqlite file.  The export does not include run log directories ($SCRIPTHAUS_HOME/runs).
+The export is not encrypted (see Encryption in "scripthaus help history").
+
+`))
+
+var ShellInitText = strings.TrimSpace(`
+Usage: scripthaus shell-init [shell-init-opts] [bash|zsh|fish]
+
+Prints shell functions that run playbook commands, so commands can be typed
+like aliases.  Eval the output in your shell's rc file:
+
+    bash: eval "$(scripthaus shell-init bash)"       # in ~/.bashrc
+    zsh:  eval "$(scripthaus shell-init zsh)"        # in ~/.zshrc
+    fish: scripthaus shell-init fish | source        # in ~/.config/fish/config.fish
+
+By default there is one function per command in your global playbook (or the
+--playbook playbook), named after the command.  Script arguments are passed
+through:
+
+    deploy() { scripthaus run '^deploy' "$@"; }
+
+--alias adds a function with its own name for any command.  Project commands
+(".build") are resolved in the project you are in when the function runs, so
+one alias works in every project that has the command:
+
+    eval "$(scripthaus shell-init --alias shb=.build --alias sht=.test --pick bash)"
+    shb() { scripthaus run .build "$@"; }
+
+--pick adds a fuzzy-run helper (named "shr", or --pick=[name]) that opens
+'scripthaus pick'.  With only --alias and --pick options just those functions
+are printed.
+
+Commands whose name is not a valid function name, is a shell builtin (e.g.
+"test"), or would shadow a program on PATH are skipped with a warning, use
+--prefix or --alias for them.  The functions are generated when the shell
+starts, commands added to the playbook later need a new shell (or another
+eval).
+
+Shell-Init Options:
+[:options]
+`)
+
+var CompletionText = strings.TrimSpace(`
+Usage: scripthaus completion [bash|zsh|fish]
+
+Prints a shell completion script.  Besides the scripthaus sub-commands it
+completes playbook commands: ".[tab]" completes the commands in your project's
+scripthaus.md and the project's other playbooks (".build.md::"), "^[tab]" your
+global commands, "./test.md::[tab]" the commands in a playbook file, and a bare
+name the commands of the playbooks on SCRIPTHAUS_PATH.  The script calls back
+into scripthaus ('scripthaus __complete') so completions always match the
+playbooks on disk.
+
+    bash: eval "$(scripthaus completion bash)"       # in ~/.bashrc
+    zsh:  eval "$(scripthaus completion zsh)"        # in ~/.zshrc, after compinit
+    fish: scripthaus completion fish | source        # in ~/.config/fish/config.fish
+`)
+
+var ManText = strings.TrimSpace(`
+Usage: scripthaus man [man-opts] [command]
+
+Prints the man page (roff) for scripthaus, or for one of its commands.  The
+pages are generated from the same command definitions as 'scripthaus help'.
+
+    scripthaus man run | man -l -
+    scripthaus man --dir /usr/local/share/man/man1
+
+Man Options:
+[:options]
+`)
+
+var DirectivesText = replaceBacktick(strings.TrimSpace(`
+Directives are special comments inside of a playbook code block that start
+with "@scripthaus".  Use "#", "//", or "--" (sql) as the comment characters
+depending on the language of the block.  Every scripthaus command must have a 'command' directive.
+
+Directives:
+    command [name] - [desc]  - names the command (and gives it an optional short description)
+    shellopts [opt],[opt]... - shell options for sh/bash/zsh/ksh blocks (errexit, nounset, pipefail, xtrace, none)
+    os [os],[os]...          - only run on these operating systems (e.g. darwin,linux)
+    arch [arch],[arch]...    - only run on these architectures (e.g. amd64,arm64)
+    tag [tag]...             - tags for the command (see "list --tag" and "run --tag")
+    continue [name]          - append this block to a previous command (default: the previous command)
+    alias [name]...          - alternate names for the command (e.g. "run .b" for "run .build")
+    cd [dir]                 - run the command in [dir] (absolute, ~/, ./ relative to the playbook, :playbook, or :current)
+    nolog                    - do not log runs of this command to scripthaus history
+    interpreter [cmd] [args] - run the block with a custom interpreter, e.g. "/usr/bin/env Rscript"
+    arg [name] [arg-opts]    - declare a positional argument (see Arguments below)
+    flag [name] [arg-opts]   - declare a named flag, passed as --[name] [val] (see Arguments below)
+    env [var=val]...         - set environment variables (values can be secret references, see below)
+    require-env [var]...     - fail before running if any of the environment variables are not set
+    hook [git-hook]...       - run the command from these git hooks (see "scripthaus help hooks")
+    output tee [file]        - also append the command's output to [file] (see Output Tee below)
+    notify [always|failure]  - send a notification when the command finishes (see "scripthaus help run")
+    esm                      - run a node/js block as an ES module (import, top-level await)
+    matrix [var=v1,v2,...]   - run the command once per combination of values (see "scripthaus help run")
+    timeout [duration]       - terminate the command after [duration], e.g. 30s or 10m (see "scripthaus help run")
+    runtime [name=ver]...    - run with these interpreter versions, e.g. python=3.11 node=20 (see Runtime Versions)
+    db [url|name]            - the database a sql block runs against, e.g. $DATABASE_URL (see SQL Blocks)
+    stdin [mode]             - "inherit" (default), "closed" (read from /dev/null), or "file:[path]"
+                               (relative to the playbook), for commands that should never wait on the terminal
+
+Quoting:
+Directive values are separated by whitespace.  Use double or single quotes for
+values with spaces, and a backslash to escape a quote (inside single quotes
+everything is literal):
+
+    # @scripthaus env GREETING="hello world" NAME="it's" DIR='C:\tmp'
+    # @scripthaus arg target --default "us east" --desc the region to deploy to
+
+Script Arguments:
+The script arguments are passed to each language the way a script file would
+get them, and the command name stands in for the script name:
+
+    sh/bash/zsh/ksh/fish     - $0 is the command name, "$@" are the arguments
+    python                   - sys.argv is [command-name, args...] (sys.path[0] is the current dir)
+    node/js                  - process.argv.slice(2) are the arguments (process.argv[1] is the command name)
+    bun/ts                   - process.argv.slice(2) are the arguments
+    pwsh/powershell          - $args are the arguments
+    cmd                      - %1, %2, ... are the arguments
+    sql                      - declared args and flags are bound as parameters (see SQL Blocks)
+
+Custom Interpreters:
+The 'interpreter' directive (or "interpreter=[cmd]" in the code fence info string)
+overrides the default language to command mapping, so blocks in any language
+can be run.  The script is passed to the interpreter as a file argument
+(/dev/fd/3) followed by the script arguments.  If the interpreter arguments
+end with "-" the script is passed over stdin instead, e.g.
+
+[:backtick][:backtick][:backtick]ts interpreter=deno run -A -
+// @scripthaus command hello
+console.log("hello from deno");
+[:backtick][:backtick][:backtick]
+
+Multi-Block Commands:
+A long script can be split into multiple code blocks (with explanatory markdown
+in between).  Blocks are concatenated in the order they appear in the playbook.
+Either repeat the 'command' directive and add "part=N" to the code fence info
+string, or use the 'continue' directive:
+
+    [:backtick][:backtick][:backtick]bash part=2
+    # @scripthaus command build
+    ...
+    [:backtick][:backtick][:backtick]
+
+Output Tee:
+The 'output tee' directive appends the command's output (stdout and stderr) to
+a file while still streaming it to the terminal.  The file name can use the
+variables {date} (2006-01-02), {time} (150405), {datetime}, {ts} (unix ms),
+{command}, {playbook} (file name without .md), and {pid}.  Relative paths are
+relative to the playbook's directory, missing directories are created, and
+secrets are redacted.  The command's stdout/stderr are no longer a terminal.
+
+    # @scripthaus output tee ./logs/{date}-{command}.log
+
+Running Other Commands:
+Every command gets SCRIPTHAUS_BIN (the scripthaus executable) and
+SCRIPTHAUS_PLAYBOOK (its playbook file) in its environment.  sh, bash, zsh, and
+ksh blocks that use 'sh_run' also get a helper function that runs another
+command from the same playbook (not logged to history):
+
+    sh_run build --release
+    sh_run test "$@"
+
+Other languages can run "$SCRIPTHAUS_BIN" run --nolog "$SCRIPTHAUS_PLAYBOOK::[command]".
+
+Shell Options:
+The 'shellopts' directive injects a "set" line (e.g. "set -e -u") before the
+script for sh, bash, zsh, and ksh blocks.  The default for all commands can be
+set in $SCRIPTHAUS_HOME/config.json, use "shellopts none" to turn it off for
+a single command.
+
+    # @scripthaus shellopts errexit,pipefail,nounset
+    config.json: {"shellopts": ["errexit", "pipefail"]}
+
+JS Runtimes:
+"js" and "node" blocks run with node, "ts", "typescript", and "bun" blocks run
+with bun (which handles typescript, imports, and top-level await without the
+'esm' directive).  The default runtime for each block type can be changed in
+$SCRIPTHAUS_HOME/config.json ("node" or "bun", typescript needs bun):
+
+    {"runtimes": {"js": "bun"}}
+
+Runtime Versions:
+The 'runtime' directive pins interpreter versions (python, node, bun, deno,
+ruby, perl) instead of using whatever is first on PATH.  A version matches
+itself and the more specific versions it is a prefix of ("3.11" matches
+3.11.4, the highest installed match is used).  Each version is looked up in
+"runtime_paths" in $SCRIPTHAUS_HOME/config.json, then with mise ("mise where"),
+then with asdf.  A block in that language runs with the resolved executable,
+and the runtime's bin directory is put first on PATH (so pip, npm, etc. match).
+The command fails if a version is not installed.
+
+    # @scripthaus runtime python=3.11 node=20
+    config.json: {"runtime_paths": {"python": {"3.11": "/usr/bin/python3.11"}}}
+
+SQL Blocks:
+"sql" blocks are run by the database's command line client, picked by the
+scheme of the 'db' directive: postgres:// (psql), mysql:// (mysql), or sqlite:
+(sqlite3, e.g. sqlite:///abs/app.db or sqlite:./app.db relative to the
+command's directory).  $VAR references in the url are expanded from the
+command's environment, and a plain name is a connection from "databases" in
+$SCRIPTHAUS_HOME/config.json (which can be a secret reference).  Passwords are
+passed in the client's environment, not on its command line.  The script
+stops at the first error.
+
+The command's 'arg' and 'flag' values are bound as parameters ("-" in names
+becomes "_", unset args are ""), so values are never pasted into the SQL:
+
+    psql                     - :'name' (a quoted literal), :name (as-is)
+    mysql                    - @name (user variables, set on the first line)
+    sqlite3                  - :name
+
+    [:backtick][:backtick][:backtick]sql
+    -- @scripthaus command find-user - look up a user by email
+    -- @scripthaus db $DATABASE_URL
+    -- @scripthaus arg email --required
+    SELECT id, name FROM users WHERE email = :'email';
+    [:backtick][:backtick][:backtick]
+
+    config.json: {"databases": {"prod": "op://infra/prod-db/url"}}
+
+A 'db' section directive (see Section Directives) sets the database for
+every sql block in a section.
+
+Language Aliases:
+Code fence languages can be mapped to script types in $SCRIPTHAUS_HOME/config.json.
+The "strip-prompt" option keeps only the lines that start with a "$ " prompt
+(with the prompt removed), so "console" style blocks with output can be run.
+
+    {"lang_aliases": {"shell": "bash", "py": "python3", "console": "bash strip-prompt"}}
+
+Secrets:
+Environment values (from 'env' directives, front matter, or --env) can be secret
+references.  They are resolved right before the command runs and the resolved
+values are redacted from anything written to scripthaus history.
+
+    op://[vault]/[item]/[field]        - 1Password CLI ("op read")
+    pass://[path]                      - pass, the standard unix password manager (first line)
+    keychain://[service]/[account]     - macOS keychain or linux secret-tool
+
+    # @scripthaus env DB_PASS=op://prod/db/password
+
+Front Matter:
+A playbook can start with a YAML front matter block that sets defaults for
+every command in the playbook.  A command's own directives override them.
+
+    ---
+    env:                     # environment variables for all commands
+      AWS_REGION: us-east-1
+    cd: :playbook            # default directory (same values as the 'cd' directive)
+    shell: bash              # shell used to run 'sh' blocks
+    tags: [build, ci]        # tags added to every command
+    default_command: build   # command to run with "scripthaus run [playbook]"
+    include: [./common.md]   # include commands from other playbooks
+    autoname: headings       # name un-annotated blocks after their level-4 heading
+    lang_aliases:            # language aliases for this playbook (see Language Aliases)
+      console: bash strip-prompt
+    ---
+
+With "autoname: headings" the first fenced code block (with a valid script
+language and no directives) under a level-4 heading becomes a command named
+after the slugified heading, e.g. "#### Install Dependencies" => install-dependencies.
+
+Config Block:
+The same settings can go in a fenced code block with the language "scripthaus"
+(anywhere in the playbook, it is never a command).  One setting per line,
+"key value" or "key: value", lines starting with # are comments.  'env',
+'tags', 'include', and 'lang_alias' can be repeated.
+
+    ` + "```" + `scripthaus
+    shell bash
+    env AWS_REGION=us-east-1 APP_ENV=dev
+    default_command build
+    lang_alias console bash strip-prompt
+    ` + "```" + `
+
+Settings in a config block override the front matter.
+
+Section Directives:
+Directives in html comments (outside of code blocks) apply to every command
+below them until the next heading of the same or a higher level, so a
+"## Backend" section can share a 'cd' or 'env' between its commands.  A
+command's own directives override them.  Directives before the first heading
+apply to the whole playbook.  'command', 'alias', 'continue', 'arg', 'flag',
+and 'hook' cannot be shared.
+
+    ## Backend
+    <!-- @scripthaus cd ./backend -->
+    <!-- @scripthaus env APP_ENV=dev -->
+
+Block Directives In Html Comments:
+A 'command' directive can also go in an html comment right before the code
+block instead of inside of it (so the script copies cleanly out of the rendered
+markdown).  The directives in that comment, and in the comments right after it,
+apply only to that block:
+
+    <!-- @scripthaus command deploy - deploy the app -->
+    <!-- @scripthaus env REGION=us-east-1 -->
+    [:backtick][:backtick][:backtick]bash
+    ./deploy.sh "$REGION"
+    [:backtick][:backtick][:backtick]
+
+Includes:
+Other playbooks can be included with the front matter 'include' key or with an
+html comment anywhere in the playbook:
+
+    <!-- @scripthaus include ./common.md -->
+
+Include paths are relative to the including playbook ("^" and "~/" also work).
+'list' and 'run' see the union of all commands, included commands keep their own
+front matter and resolve ":playbook" relative to their own file.  Include cycles
+produce warnings.  Duplicate command names (in one playbook or across includes)
+produce a warning with both locations, and 'run' refuses to run the ambiguous
+command until one of them is renamed.
+
+Arguments:
+Each 'arg' directive declares the next positional argument for the command.
+Each 'flag' directive declares a named flag that can be passed anywhere in the
+script arguments as "--[name] [val]" or "--[name]=[val]".  When a command
+declares flags, unknown "--" flags are errors, the declared flags are removed
+from the script arguments, and "--" stops flag parsing.
+
+'scripthaus run' checks that required arguments were passed and exports each
+argument as SH_ARG_[NAME] (uppercased, "-" becomes "_").  'list' and 'show'
+print a usage line generated from the declared arguments.
+
+    --required               - the run fails if the argument is missing
+    --default [val]          - value for SH_ARG_[NAME] if the argument is missing
+    --bool                   - (flag only) flag takes no value, SH_ARG_[NAME] is set to "1"
+    --desc [text]            - description of the argument (rest of the line)
 
+    # @scripthaus arg env --required --desc "target environment"
+    # @scripthaus flag region --default us-east-1
 `))
 
 func replaceBacktick(str string) string {
@@ -0,0 +1,304 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package history
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encrypted history.  when a history key is set ($SCRIPTHAUS_HISTORY_KEY or "history_key" in
+// config.json, either can be a secret reference like keychain://scripthaus/history) the cwd,
+// cmdline, and metadata columns are stored encrypted (AES-256-GCM).  the key is derived from the
+// history key with scrypt and a random salt stored in the db (scripthaus_meta KeySaltMdKey), so a
+// passphrase works as a history key.  encrypted values look like "enc2:[base64 nonce+ciphertext]".
+// plaintext values (written before the key was set) are still read normally, and so are "enc1:"
+// values (from older versions, the key was the sha256 of the history key), see EncryptHistory
+
+const encPrefix = "enc2:"
+const legacyEncPrefix = "enc1:"
+
+// scripthaus_meta key, the base64 salt for the history key (created the first time it is used)
+const KeySaltMdKey = "history_key_salt"
+
+// scrypt cost parameters for the history key, the recommended interactive parameters (N=2^14,
+// r=8, p=1, 16MB of memory).  the key is derived once per scripthaus process
+const scryptN = 1 << 14
+const scryptR = 8
+const scryptP = 1
+
+const keySaltLen = 32
+
+type historyCiphers struct {
+	Cur    cipher.AEAD // scrypt key, "enc2:" values
+	Legacy cipher.AEAD // sha256 key, "enc1:" values (read only)
+}
+
+var cipherLock = &sync.Mutex{}
+var cipherLoaded bool
+var historyCipher *historyCiphers
+var historyCipherErr error
+
+// the history key reference ("" if history encryption is not configured)
+func historyKeyRef() string {
+	keyRef := os.Getenv(base.HistoryKeyVarName)
+	if keyRef == "" {
+		keyRef = config.Get().HistoryKey
+	}
+	return keyRef
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create history cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// returns the db's history key salt, creating it if the db does not have one yet
+func getKeySalt(db *sqlx.DB) ([]byte, error) {
+	newSalt := make([]byte, keySaltLen)
+	_, err := rand.Read(newSalt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create history key salt: %w", err)
+	}
+	// a concurrent process may create it first, both use whichever was inserted
+	_, err = db.Exec(`INSERT OR IGNORE INTO scripthaus_meta (name, value) VALUES (?, ?)`, KeySaltMdKey, base64.StdEncoding.EncodeToString(newSalt))
+	if err != nil {
+		return nil, fmt.Errorf("cannot write history key salt: %w", err)
+	}
+	var saltStr string
+	err = db.Get(&saltStr, `SELECT value FROM scripthaus_meta WHERE name = ?`, KeySaltMdKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history key salt: %w", err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(saltStr)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("invalid history key salt in history db")
+	}
+	return salt, nil
+}
+
+// derives the history ciphers (once per process), called by getDBConn.  the db has the salt
+func loadHistoryCipher(db *sqlx.DB) {
+	cipherLock.Lock()
+	defer cipherLock.Unlock()
+	if cipherLoaded {
+		return
+	}
+	cipherLoaded = true
+	keyRef := historyKeyRef()
+	if keyRef == "" {
+		return
+	}
+	keyStr, err := secrets.Resolve(context.Background(), keyRef)
+	if err != nil {
+		historyCipherErr = fmt.Errorf("cannot resolve history key: %w", err)
+		return
+	}
+	if keyStr == "" {
+		historyCipherErr = fmt.Errorf("history key '%s' resolved to an empty value", keyRef)
+		return
+	}
+	salt, err := getKeySalt(db)
+	if err != nil {
+		historyCipherErr = err
+		return
+	}
+	var rtn historyCiphers
+	key, err := scrypt.Key([]byte(keyStr), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		historyCipherErr = fmt.Errorf("cannot derive history encryption key: %w", err)
+		return
+	}
+	rtn.Cur, err = newGCM(key)
+	if err != nil {
+		historyCipherErr = err
+		return
+	}
+	legacyKey := sha256.Sum256([]byte(keyStr))
+	rtn.Legacy, err = newGCM(legacyKey[:])
+	if err != nil {
+		historyCipherErr = err
+		return
+	}
+	historyCipher = &rtn
+}
+
+// returns nil (and no error) if history encryption is not configured
+func getHistoryCipher() (*historyCiphers, error) {
+	if historyKeyRef() == "" {
+		return nil, nil
+	}
+	cipherLock.Lock()
+	loaded := cipherLoaded
+	cipherLock.Unlock()
+	if !loaded {
+		// opening the db loads the ciphers
+		db, err := getDBConn()
+		if err != nil {
+			return nil, err
+		}
+		db.Close()
+	}
+	return historyCipher, historyCipherErr
+}
+
+// true if a history key is configured
+func IsEncrypted() bool {
+	hc, _ := getHistoryCipher()
+	return hc != nil
+}
+
+func encryptStr(hc *historyCiphers, val string) (string, error) {
+	if val == "" || strings.HasPrefix(val, encPrefix) {
+		return val, nil
+	}
+	aead := hc.Cur
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("cannot encrypt history item: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(val), nil)
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptStr(hc *historyCiphers, val string) (string, error) {
+	prefix := encPrefix
+	if strings.HasPrefix(val, legacyEncPrefix) {
+		prefix = legacyEncPrefix
+	} else if !strings.HasPrefix(val, encPrefix) {
+		return val, nil
+	}
+	if hc == nil {
+		return "", fmt.Errorf("scripthaus history is encrypted, set $%s (or \"history_key\" in config.json) to read it", base.HistoryKeyVarName)
+	}
+	aead := hc.Cur
+	if prefix == legacyEncPrefix {
+		aead = hc.Legacy
+	}
+	sealed, err := base64.StdEncoding.DecodeString(val[len(prefix):])
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted history value")
+	}
+	nonceSize := aead.NonceSize()
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt scripthaus history (wrong history key?)")
+	}
+	return string(plain), nil
+}
+
+// true if val is empty or encrypted with the current key
+func isEncryptedStr(val string) bool {
+	return val == "" || strings.HasPrefix(val, encPrefix)
+}
+
+// returns the item to write to the db, a copy with the sensitive fields encrypted (or the item
+// itself if encryption is not configured)
+func (item *HistoryItem) dbItem() (*HistoryItem, error) {
+	hc, err := getHistoryCipher()
+	if err != nil {
+		return nil, err
+	}
+	if hc == nil {
+		return item, nil
+	}
+	rtn := *item
+	for _, field := range []*string{&rtn.Cwd, &rtn.CmdLine, &rtn.Metadata} {
+		*field, err = encryptStr(hc, *field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &rtn, nil
+}
+
+// decrypts the sensitive fields of an item read from the db (in place)
+func (item *HistoryItem) decryptFields() error {
+	hc, err := getHistoryCipher()
+	if err != nil {
+		return err
+	}
+	for _, field := range []*string{&item.Cwd, &item.CmdLine, &item.Metadata} {
+		*field, err = decryptStr(hc, *field)
+		if err != nil {
+			return fmt.Errorf("history item %d: %w", item.HistoryId, err)
+		}
+	}
+	return nil
+}
+
+// encrypts all plaintext history items with the current history key (for history written before
+// the key was set), and re-encrypts "enc1:" items with the scrypt derived key.  returns the number
+// of items encrypted
+func EncryptHistory() (int, error) {
+	hc, err := getHistoryCipher()
+	if err != nil {
+		return 0, err
+	}
+	if hc == nil {
+		return 0, fmt.Errorf("no history key, set $%s or \"history_key\" in config.json", base.HistoryKeyVarName)
+	}
+	db, err := getDBConn()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var items []*HistoryItem
+	err = db.Select(&items, `SELECT * FROM history`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read history items: %w", err)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("cannot start transaction (for history encryption): %w", err)
+	}
+	var numEncrypted int
+	for _, item := range items {
+		if isEncryptedStr(item.Cwd) && isEncryptedStr(item.CmdLine) && isEncryptedStr(item.Metadata) {
+			continue
+		}
+		err = item.decryptFields()
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		encItem, err := item.dbItem()
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		_, err = tx.NamedExec(`UPDATE history SET cwd = :cwd, cmdline = :cmdline, metadata = :metadata WHERE historyid = :historyid`, encItem)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("cannot encrypt history item %d: %w", item.HistoryId, err)
+		}
+		numEncrypted++
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("cannot commit history encryption: %w", err)
+	}
+	return numEncrypted, nil
+}
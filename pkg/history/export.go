@@ -0,0 +1,232 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+)
+
+// 'manage export-json' and 'manage import-json'.  the export holds every column of the history
+// table (metadata as a JSON object) so it does not depend on the sqlite file or db version
+
+// version of the ExportFile format, bumped on incompatible changes
+const ExportSchemaVersion = 1
+
+type ExportFile struct {
+	SchemaVersion int          `json:"schema_version"`
+	ScVersion     string       `json:"scversion"` // the scripthaus version that wrote the export
+	ExportTs      int64        `json:"exportts"`  // unix milliseconds
+	Items         []ExportItem `json:"items"`
+}
+
+type ExportItem struct {
+	HistoryId       int64             `json:"historyid"`
+	Ts              int64             `json:"ts"`
+	ScVersion       string            `json:"scversion"`
+	ProjectDir      string            `json:"projectdir"`
+	ProjectName     string            `json:"projectname"`
+	PlaybookFile    string            `json:"playbookfile"`
+	PlaybookCommand string            `json:"playbookcommand"`
+	ScriptType      string            `json:"scripttype"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Cwd             string            `json:"cwd"`
+	HostName        string            `json:"hostname"`
+	IpAddr          string            `json:"ipaddr"`
+	SysUser         string            `json:"sysuser"`
+	CmdLine         []string          `json:"cmdline"`
+	DurationMs      *int64            `json:"durationms"`
+	ExitCode        *int64            `json:"exitcode"`
+}
+
+func makeExportItem(item *HistoryItem) ExportItem {
+	rtn := ExportItem{
+		HistoryId:       item.HistoryId,
+		Ts:              item.Ts,
+		ScVersion:       item.ScVersion,
+		ProjectDir:      item.ProjectDir,
+		ProjectName:     item.ProjectName,
+		PlaybookFile:    item.PlaybookFile,
+		PlaybookCommand: item.PlaybookCommand,
+		ScriptType:      item.ScriptType,
+		Cwd:             item.Cwd,
+		HostName:        item.HostName,
+		IpAddr:          item.IpAddr,
+		SysUser:         item.SysUser,
+		CmdLine:         item.DecodeCmdLine(),
+	}
+	if md := item.GetMetadata(); len(md) > 0 {
+		rtn.Metadata = md
+	}
+	if item.DurationMs.Valid {
+		durationMs := item.DurationMs.Int64
+		rtn.DurationMs = &durationMs
+	}
+	if item.ExitCode.Valid {
+		exitCode := item.ExitCode.Int64
+		rtn.ExitCode = &exitCode
+	}
+	return rtn
+}
+
+func (eitem ExportItem) toHistoryItem() *HistoryItem {
+	rtn := &HistoryItem{
+		Ts:              eitem.Ts,
+		ScVersion:       eitem.ScVersion,
+		ProjectDir:      eitem.ProjectDir,
+		ProjectName:     eitem.ProjectName,
+		PlaybookFile:    eitem.PlaybookFile,
+		PlaybookCommand: eitem.PlaybookCommand,
+		ScriptType:      eitem.ScriptType,
+		Cwd:             eitem.Cwd,
+		HostName:        eitem.HostName,
+		IpAddr:          eitem.IpAddr,
+		SysUser:         eitem.SysUser,
+	}
+	if len(eitem.Metadata) > 0 {
+		rtn.Metadata = marshalJsonNoErr(eitem.Metadata)
+	}
+	rtn.EncodeCmdLine(eitem.CmdLine)
+	if eitem.DurationMs != nil {
+		rtn.DurationMs = sql.NullInt64{Int64: *eitem.DurationMs, Valid: true}
+	}
+	if eitem.ExitCode != nil {
+		rtn.ExitCode = sql.NullInt64{Int64: *eitem.ExitCode, Valid: true}
+	}
+	return rtn
+}
+
+// writes the whole history table (ordered by historyid), returns the number of items written
+func ExportJson(w io.Writer) (int, error) {
+	db, err := getDBConn()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var items []*HistoryItem
+	err = db.Select(&items, `SELECT * FROM history ORDER BY historyid`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read history items: %w", err)
+	}
+	export := ExportFile{
+		SchemaVersion: ExportSchemaVersion,
+		ScVersion:     base.ScriptHausVersion,
+		ExportTs:      time.Now().UnixMilli(),
+		Items:         make([]ExportItem, 0, len(items)),
+	}
+	for _, item := range items {
+		err = item.decryptFields()
+		if err != nil {
+			return 0, err
+		}
+		export.Items = append(export.Items, makeExportItem(item))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(export)
+	if err != nil {
+		return 0, fmt.Errorf("cannot write history export: %w", err)
+	}
+	return len(items), nil
+}
+
+// reads an export and adds its items to the history table (in a single transaction).  imported
+// items get new history ids (after the existing items).  items that are already in the db (same
+// ts, hostname, playbook, command, and cmdline) are skipped, so importing twice is safe.
+// returns (numImported, numSkipped, error)
+func ImportJson(r io.Reader) (int, int, error) {
+	var export ExportFile
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&export)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot parse history export: %w", err)
+	}
+	if export.SchemaVersion == 0 {
+		return 0, 0, fmt.Errorf("invalid history export, no schema_version")
+	}
+	if export.SchemaVersion > ExportSchemaVersion {
+		return 0, 0, fmt.Errorf("history export has schema_version %d, this version of scripthaus can only import version %d (or lower)", export.SchemaVersion, ExportSchemaVersion)
+	}
+	db, err := getDBConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot start transaction (for history import): %w", err)
+	}
+	// cmdline is compared after the query (it can be encrypted)
+	existsSql := `
+        SELECT * FROM history
+        WHERE ts = ? AND hostname = ? AND playbookfile = ? AND playbookcommand = ?
+`
+	insertSql := `
+        INSERT INTO history
+            (historyid, ts, scversion,
+             projectdir, projectname, playbookfile, playbookcommand, scripttype,
+             metadata, cwd, hostname, ipaddr, sysuser, cmdline, durationms, exitcode)
+        VALUES
+            (NULL,     :ts,:scversion,
+            :projectdir,:projectname,:playbookfile,:playbookcommand,:scripttype,
+            :metadata,:cwd,:hostname,:ipaddr,:sysuser,:cmdline,:durationms,:exitcode)
+`
+	var numImported, numSkipped int
+	for _, eitem := range export.Items {
+		item := eitem.toHistoryItem()
+		exists, err := importItemExists(tx, existsSql, item)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, fmt.Errorf("cannot import history item %d: %w", eitem.HistoryId, err)
+		}
+		if exists {
+			numSkipped++
+			continue
+		}
+		dbItem, err := item.dbItem()
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+		_, err = tx.NamedExec(insertSql, dbItem)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, fmt.Errorf("cannot import history item %d: %w", eitem.HistoryId, err)
+		}
+		numImported++
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot commit history import: %w", err)
+	}
+	return numImported, numSkipped, nil
+}
+
+func importItemExists(tx *sqlx.Tx, existsSql string, item *HistoryItem) (bool, error) {
+	var matches []*HistoryItem
+	err := tx.Select(&matches, existsSql, item.Ts, item.HostName, item.PlaybookFile, item.PlaybookCommand)
+	if err != nil {
+		return false, err
+	}
+	for _, match := range matches {
+		err = match.decryptFields()
+		if err != nil {
+			return false, err
+		}
+		if match.CmdLine == item.CmdLine {
+			return true, nil
+		}
+	}
+	return false, nil
+}
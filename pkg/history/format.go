@@ -0,0 +1,108 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// the fields available to 'history --format' templates.  ExitCode and DurationMs are 0 while the
+// command is still running (Finished is false)
+type TemplateItem struct {
+	HistoryId       int64
+	Ts              int64  // unix milliseconds
+	Date            string // local time, "2006-01-02 15:04:05"
+	ScriptName      string // as shown by 'history', e.g. ".build" (or the commands of a pipeline)
+	Version         string
+	ProjectDir      string
+	ProjectName     string
+	PlaybookFile    string
+	PlaybookCommand string
+	ScriptType      string
+	Args            []string
+	CmdLine         string // the script arguments, shell quoted
+	Cwd             string
+	HostName        string
+	IpAddr          string
+	SysUser         string
+	Finished        bool
+	DurationMs      int64
+	ExitCode        int64
+	RunDir          string
+	Pipeline        string
+	Matrix          string
+	TermReason      string
+	RunCount        int // 'history --unique', number of identical runs (0 otherwise)
+}
+
+func (item *HistoryItem) TemplateData(henv HistoryEnv) TemplateItem {
+	md := item.GetMetadata()
+	rtn := TemplateItem{
+		HistoryId:       item.HistoryId,
+		Ts:              item.Ts,
+		Date:            time.UnixMilli(item.Ts).Format("2006-01-02 15:04:05"),
+		ScriptName:      item.ScriptString(henv),
+		Version:         item.ScVersion,
+		ProjectDir:      item.ProjectDir,
+		ProjectName:     item.ProjectName,
+		PlaybookFile:    item.PlaybookFile,
+		PlaybookCommand: item.PlaybookCommand,
+		ScriptType:      item.ScriptType,
+		Args:            item.DecodeCmdLine(),
+		CmdLine:         item.CmdLineStr(),
+		Cwd:             item.Cwd,
+		HostName:        item.HostName,
+		IpAddr:          item.IpAddr,
+		SysUser:         item.SysUser,
+		Finished:        item.ExitCode.Valid,
+		DurationMs:      item.DurationMs.Int64,
+		ExitCode:        item.ExitCode.Int64,
+		RunDir:          md["rundir"],
+		Pipeline:        md[PipelineMdKey],
+		Matrix:          md[MatrixMdKey],
+		TermReason:      md[TermReasonMdKey],
+		RunCount:        item.RunCount,
+	}
+	if rtn.Pipeline != "" {
+		rtn.ScriptName = rtn.Pipeline
+	}
+	return rtn
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		barr, err := json.Marshal(v)
+		return string(barr), err
+	},
+	"join": func(sep string, arr []string) string {
+		return strings.Join(arr, sep)
+	},
+}
+
+// parses a 'history --format' template (Go text/template syntax, with "json" and "join" functions)
+func ParseFormatTemplate(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writes the item using the template, followed by a newline
+func (item *HistoryItem) WriteTemplate(w io.Writer, tmpl *template.Template, henv HistoryEnv) error {
+	err := tmpl.Execute(w, item.TemplateData(henv))
+	if err != nil {
+		return fmt.Errorf("cannot format history item %d: %w", item.HistoryId, err)
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -10,7 +10,7 @@ import (
 	"net"
 	"os"
 	"os/user"
-	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -19,11 +19,38 @@ import (
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
 	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/render"
 )
 
 const VersionMdKey = "version"
 
+// HistoryItem metadata key, set for 'run a | b' (the item is for the first command)
+const PipelineMdKey = "pipeline"
+
+// HistoryItem metadata key, set for each cell of a matrix run ("env=dev,region=us")
+const MatrixMdKey = "matrix"
+
+// HistoryItem metadata key, set for the runs of 'scripthaus bench' (the bench id, the same for
+// all of the runs of one benchmark)
+const BenchMdKey = "bench"
+
+// HistoryItem metadata key, set if scripthaus terminated the command ("timeout", "interrupt", or "cancelled"),
+// or to TermReasonInterrupted if scripthaus itself stopped before the run finished
+const TermReasonMdKey = "termreason"
+
+// HistoryItem metadata key, the environment variables scripthaus set for the run (--env, --env-file,
+// 'env' directives, and the playbook env) as a JSON array of VAR=VAL entries.  only recorded with
+// "record_env" set
+const EnvMdKey = "env"
+
+// HistoryItem metadata key, the pid of the scripthaus process that recorded the run (see SweepInterrupted)
+const PidMdKey = "pid"
+
+// the run's scripthaus process crashed, was killed, or exited before the run finished
+const TermReasonInterrupted = "interrupted"
+
 var createDBSql string = `
 CREATE TABLE scripthaus_meta (
     name varchar(30) PRIMARY KEY,
@@ -55,6 +82,7 @@ INSERT INTO scripthaus_meta (name, value) VALUES ('version', '1');
 type HistoryQuery struct {
 	ShowAll bool
 	ShowNum int
+	Unique  bool // collapse identical runs to the most recent one (see uniqueKey), sets RunCount
 }
 
 type HistoryItem struct {
@@ -66,7 +94,7 @@ type HistoryItem struct {
 	PlaybookFile    string // can have prefix "^" or "." (".." will be resolved away)
 	PlaybookCommand string
 	ScriptType      string // language
-	Metadata        string
+	Metadata        string // JSON object (see SetMetadata)
 	Cwd             string
 	HostName        string
 	IpAddr          string
@@ -74,6 +102,8 @@ type HistoryItem struct {
 	CmdLine         string
 	DurationMs      sql.NullInt64 // update
 	ExitCode        sql.NullInt64 // update
+
+	RunCount int `db:"-"` // number of identical runs (only set for HistoryQuery.Unique)
 }
 
 type HistoryEnv struct {
@@ -82,15 +112,17 @@ type HistoryEnv struct {
 }
 
 func (henv HistoryEnv) TruncatePath(fullPath string) string {
-	if henv.Cwd == "/" || henv.Cwd == "" {
+	sep := string(filepath.Separator)
+	if henv.Cwd == "" || filepath.Dir(henv.Cwd) == henv.Cwd {
+		// empty or the root directory
 		return fullPath
 	}
-	if strings.HasPrefix(fullPath, henv.Cwd+"/") {
+	if strings.HasPrefix(fullPath, henv.Cwd+sep) {
 		return "." + fullPath[len(henv.Cwd):]
 	}
-	parentDir := path.Dir(henv.Cwd)
-	if parentDir != "/" && parentDir != "" {
-		if strings.HasPrefix(fullPath, parentDir+"/") {
+	parentDir := filepath.Dir(henv.Cwd)
+	if parentDir != "" && filepath.Dir(parentDir) != parentDir {
+		if strings.HasPrefix(fullPath, parentDir+sep) {
 			return ".." + fullPath[len(parentDir):]
 		}
 	}
@@ -98,7 +130,7 @@ func (henv HistoryEnv) TruncatePath(fullPath string) string {
 }
 
 func stripTrailingSlash(s string) string {
-	if len(s) >= 2 && strings.HasSuffix(s, "/") {
+	if len(s) >= 2 && (strings.HasSuffix(s, "/") || strings.HasSuffix(s, string(filepath.Separator))) {
 		return s[:len(s)-1]
 	}
 	return s
@@ -114,39 +146,6 @@ func MakeHistoryEnv() HistoryEnv {
 	return rtn
 }
 
-func (item *HistoryItem) MarshalJSON() ([]byte, error) {
-	jm := make(map[string]interface{})
-	jm["historyid"] = item.HistoryId
-	jm["ts"] = item.Ts
-	jm["date"] = time.UnixMilli(item.Ts).Format("2006-01-02T15:04:05")
-	jm["version"] = item.ScVersion
-	if item.ProjectDir != "" {
-		jm["projectdir"] = item.ProjectDir
-	}
-	if item.ProjectName != "" {
-		jm["projectname"] = item.ProjectName
-	}
-	if item.PlaybookFile != "" {
-		jm["playbookfile"] = item.PlaybookFile
-	}
-	if item.PlaybookCommand != "" {
-		jm["playbookcommand"] = item.PlaybookCommand
-	}
-	jm["scripttype"] = item.ScriptType
-	jm["cwd"] = item.Cwd
-	jm["hostname"] = item.HostName
-	jm["ipaddr"] = item.IpAddr
-	jm["sysuser"] = item.SysUser
-	jm["cmdline"] = item.CmdLine
-	if item.DurationMs.Valid {
-		jm["durationms"] = item.DurationMs.Int64
-	}
-	if item.ExitCode.Valid {
-		jm["exitcode"] = item.ExitCode.Int64
-	}
-	return json.Marshal(jm)
-}
-
 func (item *HistoryItem) DecodeCmdLine() []string {
 	if item.CmdLine == "" {
 		return nil
@@ -159,8 +158,33 @@ func (item *HistoryItem) DecodeCmdLine() []string {
 	return rtn
 }
 
-func (item *HistoryItem) CompactString(henv HistoryEnv) string {
-	return fmt.Sprintf("%5d  %s %s\n", item.HistoryId, item.ScriptString(henv), shellescape.QuoteCommand(item.DecodeCmdLine()))
+// the shell-quoted command line (the script arguments) of the history item
+func (item *HistoryItem) CmdLineStr() string {
+	return shellescape.QuoteCommand(item.DecodeCmdLine())
+}
+
+func (item *HistoryItem) CompactString(henv HistoryEnv, color render.Colorizer) string {
+	if pipeline := item.Pipeline(); pipeline != "" {
+		return fmt.Sprintf("%s%s  %s\n", color.Dim(fmt.Sprintf("%5d", item.HistoryId)), item.runCountStr(color), color.Name(pipeline))
+	}
+	return fmt.Sprintf("%s%s  %s %s%s\n", color.Dim(fmt.Sprintf("%5d", item.HistoryId)), item.runCountStr(color), color.Name(item.ScriptString(henv)), shellescape.QuoteCommand(item.DecodeCmdLine()), item.matrixSuffix(color))
+}
+
+// "  3x" for unique history items, "" otherwise
+func (item *HistoryItem) runCountStr(color render.Colorizer) string {
+	if item.RunCount == 0 {
+		return ""
+	}
+	return "  " + color.Dim(fmt.Sprintf("%4dx", item.RunCount))
+}
+
+// " [env=dev]" for matrix runs, "" otherwise
+func (item *HistoryItem) matrixSuffix(color render.Colorizer) string {
+	cellName := item.MatrixCell()
+	if cellName == "" {
+		return ""
+	}
+	return " " + color.Dim("["+cellName+"]")
 }
 
 func (item *HistoryItem) ScriptString(henv HistoryEnv) string {
@@ -169,7 +193,7 @@ func (item *HistoryItem) ScriptString(henv HistoryEnv) string {
 	}
 	if strings.HasPrefix(item.PlaybookFile, ".") {
 		if henv.ProjectDir != item.ProjectDir {
-			projectDirStr := path.Base(item.ProjectDir)
+			projectDirStr := filepath.Base(item.ProjectDir)
 			return fmt.Sprintf("[%s]%s::%s", projectDirStr, item.PlaybookFile[1:], item.PlaybookCommand)
 		} else {
 			return fmt.Sprintf("%s%s", item.PlaybookFile, item.PlaybookCommand)
@@ -179,21 +203,104 @@ func (item *HistoryItem) ScriptString(henv HistoryEnv) string {
 
 }
 
-func (item *HistoryItem) FullString(henv HistoryEnv) string {
+func (item *HistoryItem) FullString(henv HistoryEnv, color render.Colorizer) string {
 	tsStr := time.UnixMilli(item.Ts).Format("[2006-01-02 15:04:05]")
-	line1 := fmt.Sprintf("%5d  %s %s %s\n", item.HistoryId, tsStr, item.ScriptString(henv), shellescape.QuoteCommand(item.DecodeCmdLine()))
+	line1 := fmt.Sprintf("%s  %s %s %s%s\n", color.Dim(fmt.Sprintf("%5d", item.HistoryId)), color.Dim(tsStr), color.Name(item.ScriptString(henv)), shellescape.QuoteCommand(item.DecodeCmdLine()), item.matrixSuffix(color))
+	if pipeline := item.Pipeline(); pipeline != "" {
+		line1 = fmt.Sprintf("%s  %s %s\n", color.Dim(fmt.Sprintf("%5d", item.HistoryId)), color.Dim(tsStr), color.Name(pipeline))
+	}
 	line2 := fmt.Sprintf("       cwd: %s", item.Cwd)
 	if item.DurationMs.Valid {
 		line2 += fmt.Sprintf(" | duration: %0.3fms", float64(item.DurationMs.Int64)/1000)
 	}
 	if item.ExitCode.Valid {
-		line2 += fmt.Sprintf(" | exitcode: %d", item.ExitCode.Int64)
+		line2 += fmt.Sprintf(" | exitcode: %s", color.ExitCode(int(item.ExitCode.Int64)))
+	}
+	if termReason := item.GetMetadata()[TermReasonMdKey]; termReason != "" {
+		line2 += fmt.Sprintf(" | terminated: %s", termReason)
 	}
 	line2 += "\n"
-	line3 := fmt.Sprintf("       user: %s | host: %s | ip: %s\n", item.SysUser, item.HostName, item.IpAddr)
+	line3 := fmt.Sprintf("       user: %s | host: %s | ip: %s", item.SysUser, item.HostName, item.IpAddr)
+	if item.RunCount > 0 {
+		line3 += fmt.Sprintf(" | runs: %d", item.RunCount)
+	}
+	line3 += "\n"
 	return line1 + line2 + line3 + "\n"
 }
 
+// sets a key in the Metadata JSON object (saved by UpdateHistoryItem)
+func (item *HistoryItem) SetMetadata(key string, val string) {
+	md := item.GetMetadata()
+	md[key] = val
+	item.Metadata = marshalJsonNoErr(md)
+}
+
+// returns the Metadata JSON object, never nil
+func (item *HistoryItem) GetMetadata() map[string]string {
+	md := make(map[string]string)
+	if item.Metadata != "" {
+		json.Unmarshal([]byte(item.Metadata), &md)
+	}
+	return md
+}
+
+// the per-run log directory (see pkg/runlog), "" if the run was not logged to one
+func (item *HistoryItem) RunDir() string {
+	return item.GetMetadata()["rundir"]
+}
+
+// the playbook file the item ran from ("" if it cannot be resolved, e.g. the playbook was removed).
+// project playbooks (".") are resolved from the item's project directory
+func (item *HistoryItem) ResolvedPlaybookFile() string {
+	if item.PlaybookFile == "" || item.PlaybookFile == "-" {
+		return ""
+	}
+	resolver := pathutil.DefaultResolver()
+	if strings.HasPrefix(item.PlaybookFile, ".") {
+		if item.ProjectDir == "" {
+			return ""
+		}
+		resolver.Cwd = item.ProjectDir
+	}
+	playbook, err := resolver.ResolvePlaybook(item.PlaybookFile)
+	if err != nil {
+		return ""
+	}
+	return playbook.ResolvedFile
+}
+
+// the commands of a 'run a | b' pipeline, "" if the item is for a single command
+func (item *HistoryItem) Pipeline() string {
+	return item.GetMetadata()[PipelineMdKey]
+}
+
+// the matrix cell of a matrix run ("env=dev,region=us"), "" if the item is not part of one
+func (item *HistoryItem) MatrixCell() string {
+	return item.GetMetadata()[MatrixMdKey]
+}
+
+// records the VAR=VAL entries scripthaus set for the run (see EnvMdKey)
+func (item *HistoryItem) SetEnv(env []string) {
+	if env == nil {
+		env = []string{}
+	}
+	item.SetMetadata(EnvMdKey, marshalJsonNoErr(env))
+}
+
+// the VAR=VAL entries scripthaus set for the run, returns false if they were not recorded
+func (item *HistoryItem) GetEnv() ([]string, bool) {
+	envStr, found := item.GetMetadata()[EnvMdKey]
+	if !found {
+		return nil, false
+	}
+	var rtn []string
+	err := json.Unmarshal([]byte(envStr), &rtn)
+	if err != nil {
+		return nil, false
+	}
+	return rtn, true
+}
+
 func (item *HistoryItem) EncodeCmdLine(args []string) {
 	item.CmdLine = marshalJsonNoErr(args)
 }
@@ -261,6 +368,146 @@ func RemoveHistoryItems(removeAll bool, startId int, endId int) (int, error) {
 	return int(numRemoved), nil
 }
 
+// runs ANALYZE and VACUUM on the history db.  sqlite does not shrink the db file when rows are
+// deleted, VACUUM rebuilds it.  returns the file size (bytes) before and after
+func VacuumDB() (int64, int64, error) {
+	dbFileName, err := GetHistoryDBFileName()
+	if err != nil {
+		return 0, 0, err
+	}
+	db, err := getDBConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	finfo, err := os.Stat(dbFileName)
+	if err != nil {
+		return 0, 0, wrapFsErr("scripthaus history db", dbFileName, err)
+	}
+	beforeSize := finfo.Size()
+	_, err = db.Exec("ANALYZE")
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot analyze history db: %w", err)
+	}
+	_, err = db.Exec("VACUUM")
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot vacuum history db: %w", err)
+	}
+	finfo, err = os.Stat(dbFileName)
+	if err != nil {
+		return 0, 0, wrapFsErr("scripthaus history db", dbFileName, err)
+	}
+	return beforeSize, finfo.Size(), nil
+}
+
+// the most recent finished run in the current project (runs with the project's playbooks or
+// from a directory inside the project), or in the current directory when there is no project.
+// failedOnly only matches runs with a non-zero exit code.  returns nil (and no error) if there is none
+func FindLastRun(henv HistoryEnv, failedOnly bool) (*HistoryItem, error) {
+	// cwd is matched here (not in the query) because it can be encrypted
+	sqlStr := `SELECT * FROM history WHERE exitcode IS NOT NULL`
+	if failedOnly {
+		sqlStr += ` AND exitcode <> 0`
+	}
+	sqlStr += ` ORDER BY ts DESC`
+	db, err := getDBConn()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Queryx(sqlStr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query history db: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item := &HistoryItem{}
+		err = rows.StructScan(item)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read history (query scan): %w", err)
+		}
+		err = item.decryptFields()
+		if err != nil {
+			return nil, err
+		}
+		if henv.ranIn(item) {
+			return item, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+// true if the item ran in the env's project (or in its cwd when there is no project)
+func (henv HistoryEnv) ranIn(item *HistoryItem) bool {
+	if henv.ProjectDir != "" {
+		return item.ProjectDir == henv.ProjectDir || item.Cwd == henv.ProjectDir || strings.HasPrefix(item.Cwd, henv.ProjectDir+string(filepath.Separator))
+	}
+	return item.Cwd == henv.Cwd
+}
+
+// the finished runs of the most recent benchmark of the same command (playbook, command, and
+// arguments) as item, other than the benchmark benchId.  returns nil if there is none
+func FindPreviousBench(item *HistoryItem, benchId string) ([]*HistoryItem, error) {
+	// cmdline is matched here (not in the query) because it can be encrypted
+	sqlStr := `SELECT * FROM history WHERE playbookfile = ? AND playbookcommand = ? AND exitcode IS NOT NULL ORDER BY ts DESC`
+	db, err := getDBConn()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Queryx(sqlStr, item.PlaybookFile, item.PlaybookCommand)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query history db: %w", err)
+	}
+	defer rows.Close()
+	var rtn []*HistoryItem
+	prevBenchId := ""
+	for rows.Next() {
+		hitem := &HistoryItem{}
+		err = rows.StructScan(hitem)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read history (query scan): %w", err)
+		}
+		err = hitem.decryptFields()
+		if err != nil {
+			return nil, err
+		}
+		itemBenchId := hitem.GetMetadata()[BenchMdKey]
+		if itemBenchId == "" || itemBenchId == benchId || hitem.CmdLine != item.CmdLine || hitem.ProjectDir != item.ProjectDir {
+			continue
+		}
+		if prevBenchId == "" {
+			prevBenchId = itemBenchId
+		}
+		if itemBenchId == prevBenchId {
+			rtn = append(rtn, hitem)
+		}
+	}
+	return rtn, rows.Err()
+}
+
+// returns nil (and no error) if the history item does not exist
+func GetHistoryItem(historyId int) (*HistoryItem, error) {
+	db, err := getDBConn()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var item HistoryItem
+	err = db.Get(&item, `SELECT * FROM history WHERE historyid = ?`, historyId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read history item %d: %w", historyId, err)
+	}
+	err = item.decryptFields()
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
 func InsertHistoryItem(item *HistoryItem) error {
 	sqlStr := `
         INSERT INTO history 
@@ -272,15 +519,20 @@ func InsertHistoryItem(item *HistoryItem) error {
             :projectdir,:projectname,:playbookfile,:playbookcommand,:scripttype,
             :metadata,:cwd,:hostname,:ipaddr,:sysuser,:cmdline)
 `
+	dbItem, err := item.dbItem()
+	if err != nil {
+		return err
+	}
 	db, err := getDBConn()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	_, err = db.NamedExec(sqlStr, item)
+	result, err := db.NamedExec(sqlStr, dbItem)
 	if err != nil {
 		return fmt.Errorf("cannot insert into db: %w", err)
 	}
+	item.HistoryId, _ = result.LastInsertId()
 	return nil
 }
 
@@ -288,15 +540,20 @@ func UpdateHistoryItem(item *HistoryItem) error {
 	sqlStr := `
         UPDATE history
         SET durationms = :durationms,
-            exitcode = :exitcode
-        WHERE ts = :ts
+            exitcode = :exitcode,
+            metadata = :metadata
+        WHERE ts = :ts AND (historyid = :historyid OR :historyid = 0)
 `
+	dbItem, err := item.dbItem()
+	if err != nil {
+		return err
+	}
 	db, err := getDBConn()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	_, err = db.NamedExec(sqlStr, item)
+	_, err = db.NamedExec(sqlStr, dbItem)
 	if err != nil {
 		return fmt.Errorf("cannot update db: %w", err)
 	}
@@ -318,14 +575,36 @@ func marshalJsonNoErr(val interface{}) string {
 	return string(rtn)
 }
 
+// the address of the first network interface that is up (not loopback), IPv4 addresses are
+// preferred.  only enumerates the local interfaces, no network traffic.  returns "" if there is none
 func GetLocalIpAddr() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+	ifaces, err := net.Interfaces()
 	if err != nil {
 		return ""
 	}
-	defer conn.Close()
-	udpAddr := conn.LocalAddr().(*net.UDPAddr)
-	return udpAddr.IP.String()
+	var ipv6Addr string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				return ipNet.IP.String()
+			}
+			if ipv6Addr == "" {
+				ipv6Addr = ipNet.IP.String()
+			}
+		}
+	}
+	return ipv6Addr
 }
 
 func BuildHistoryItem() *HistoryItem {
@@ -333,15 +612,60 @@ func BuildHistoryItem() *HistoryItem {
 	rtn.Ts = time.Now().UnixMilli()
 	rtn.ScVersion = base.ScriptHausVersion
 	rtn.Cwd, _ = os.Getwd()
-	rtn.HostName, _ = os.Hostname()
-	rtn.IpAddr = GetLocalIpAddr()
+	if collectIp := config.Get().CollectIpAddr; collectIp == nil || *collectIp {
+		rtn.HostName, _ = os.Hostname()
+		rtn.IpAddr = GetLocalIpAddr()
+	}
 	osUser, _ := user.Current()
 	if osUser != nil {
 		rtn.SysUser = osUser.Username
 	}
+	rtn.SetMetadata(PidMdKey, strconv.Itoa(os.Getpid()))
 	return &rtn
 }
 
+// marks the unfinished runs (no exit code) whose scripthaus process no longer exists as
+// interrupted (exit code 1, the duration is unknown).  these are runs where scripthaus was
+// killed outright (SIGKILL, a power loss), other early stops are recorded when they happen.
+// only runs recorded on this host are checked.  returns the number of runs marked
+func SweepInterrupted() (int, error) {
+	db, err := getDBConn()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var items []*HistoryItem
+	err = db.Select(&items, `SELECT * FROM history WHERE exitcode IS NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot query history db: %w", err)
+	}
+	hostName, _ := os.Hostname()
+	var interrupted []*HistoryItem
+	for _, item := range items {
+		if item.HostName != "" && item.HostName != hostName {
+			continue
+		}
+		if err = item.decryptFields(); err != nil {
+			return 0, err
+		}
+		pid, err := strconv.Atoi(item.GetMetadata()[PidMdKey])
+		if err != nil || pid == os.Getpid() || processExists(pid) {
+			// runs recorded before pids were, or still running
+			continue
+		}
+		item.ExitCode = sql.NullInt64{Valid: true, Int64: 1}
+		item.SetMetadata(TermReasonMdKey, TermReasonInterrupted)
+		interrupted = append(interrupted, item)
+	}
+	db.Close()
+	for _, item := range interrupted {
+		if err = UpdateHistoryItem(item); err != nil {
+			return 0, err
+		}
+	}
+	return len(interrupted), nil
+}
+
 func wrapFsErr(fileType string, fileName string, err error) error {
 	if errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("%s '%s' does not exist", fileType, fileName)
@@ -365,7 +689,7 @@ func DebugDBFileError() error {
 	if err != nil {
 		return err
 	}
-	dirName := path.Dir(dbFileName)
+	dirName := filepath.Dir(dbFileName)
 	dirInfo, err := os.Stat(dirName)
 	if err != nil {
 		return wrapFsErr("scripthaus home directory", dirName, err)
@@ -393,7 +717,7 @@ func GetHistoryDBFileName() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return path.Join(scHome, base.DBFileName), nil
+	return filepath.Join(scHome, base.DBFileName), nil
 }
 
 func RemoveDB() error {
@@ -538,6 +862,7 @@ func getDBConn() (*sqlx.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	loadHistoryCipher(db)
 	return db, nil
 }
 
@@ -552,7 +877,7 @@ func HistoryDisabledFile() bool {
 	if scHome == "" {
 		return false
 	}
-	disableFile := path.Join(scHome, ".nohistory")
+	disableFile := filepath.Join(scHome, ".nohistory")
 	finfo, _ := os.Stat(disableFile)
 	if finfo != nil {
 		return true
@@ -560,18 +885,22 @@ func HistoryDisabledFile() bool {
 	return false
 }
 
+// the number of items to show (when ShowAll is not set)
+func (query HistoryQuery) limit() int {
+	if query.ShowNum > 0 {
+		return query.ShowNum
+	}
+	return 50
+}
+
 func QueryHistory(query HistoryQuery) ([]*HistoryItem, error) {
 	sqlStr := `
         SELECT * FROM history
         WHERE TRUE
         ORDER BY ts DESC
 `
-	if !query.ShowAll {
-		limit := 50
-		if query.ShowNum > 0 {
-			limit = query.ShowNum
-		}
-		sqlStr = sqlStr + " " + fmt.Sprintf("LIMIT %d", limit)
+	if !query.ShowAll && !query.Unique {
+		sqlStr = sqlStr + " " + fmt.Sprintf("LIMIT %d", query.limit())
 	}
 	var rtn []*HistoryItem
 	db, err := getDBConn()
@@ -589,8 +918,41 @@ func QueryHistory(query HistoryQuery) ([]*HistoryItem, error) {
 		if err != nil {
 			return nil, fmt.Errorf("cannot read history (query scan): %w", err)
 		}
+		err = item.decryptFields()
+		if err != nil {
+			return nil, err
+		}
 		rtn = append(rtn, item)
 	}
+	if query.Unique {
+		rtn = uniqueHistory(rtn)
+		if !query.ShowAll && len(rtn) > query.limit() {
+			rtn = rtn[:query.limit()]
+		}
+	}
 	reverseHistorySlice(rtn)
 	return rtn, nil
 }
+
+// runs are identical if they have the same project, playbook, command, arguments, and pipeline/matrix cell
+func (item *HistoryItem) uniqueKey() string {
+	md := item.GetMetadata()
+	return marshalJsonNoErr([]string{item.ProjectDir, item.PlaybookFile, item.PlaybookCommand, item.CmdLine, md[PipelineMdKey], md[MatrixMdKey]})
+}
+
+// items must be most recent first.  keeps the first (most recent) item of each group of identical runs
+func uniqueHistory(items []*HistoryItem) []*HistoryItem {
+	var rtn []*HistoryItem
+	seen := make(map[string]*HistoryItem)
+	for _, item := range items {
+		key := item.uniqueKey()
+		if first := seen[key]; first != nil {
+			first.RunCount++
+			continue
+		}
+		item.RunCount = 1
+		seen[key] = item
+		rtn = append(rtn, item)
+	}
+	return rtn
+}
@@ -0,0 +1,37 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package history
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// test vectors from RFC 7914, the history key is derived with scrypt.Key (see encrypt.go)
+func TestScryptKey(t *testing.T) {
+	tests := []struct {
+		password string
+		salt     string
+		n, r, p  int
+		want     string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, test := range tests {
+		key, err := scrypt.Key([]byte(test.password), []byte(test.salt), test.n, test.r, test.p, 64)
+		if err != nil {
+			t.Fatalf("scrypt(%q, %q, %d, %d, %d): %v", test.password, test.salt, test.n, test.r, test.p, err)
+		}
+		got := hex.EncodeToString(key)
+		if got != test.want {
+			t.Errorf("scrypt(%q, %q, %d, %d, %d) = %s, want %s", test.password, test.salt, test.n, test.r, test.p, got, test.want)
+		}
+	}
+}
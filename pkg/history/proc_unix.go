@@ -0,0 +1,21 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !windows
+// +build !windows
+
+package history
+
+import (
+	"errors"
+	"syscall"
+)
+
+func processExists(pid int) bool {
+	// signal 0 checks the pid without signaling, EPERM means it exists (another user's process)
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
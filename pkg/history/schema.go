@@ -0,0 +1,84 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package history
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// version of the HistoryItemJson schema.  new (optional) fields can be added without changing it,
+// it is bumped when a field is removed, renamed, or changes meaning
+const JsonSchemaVersion = 1
+
+// the stable JSON form of a history item ('history --json' and run log metadata.json).
+// optional fields are omitted when they are not set
+type HistoryItemJson struct {
+	SchemaVersion   int      `json:"schema_version"`
+	HistoryId       int64    `json:"historyid"`
+	Ts              int64    `json:"ts"`   // unix milliseconds
+	Date            string   `json:"date"` // local time, "2006-01-02T15:04:05"
+	Version         string   `json:"version"`
+	ProjectDir      string   `json:"projectdir,omitempty"`
+	ProjectName     string   `json:"projectname,omitempty"`
+	PlaybookFile    string   `json:"playbookfile,omitempty"`
+	PlaybookCommand string   `json:"playbookcommand,omitempty"`
+	ScriptType      string   `json:"scripttype"`
+	Cwd             string   `json:"cwd"`
+	HostName        string   `json:"hostname"`
+	IpAddr          string   `json:"ipaddr"`
+	SysUser         string   `json:"sysuser"`
+	CmdLine         string   `json:"cmdline"` // JSON array of the script arguments (as a string)
+	DurationMs      *int64   `json:"durationms,omitempty"`
+	ExitCode        *int64   `json:"exitcode,omitempty"`
+	RunDir          string   `json:"rundir,omitempty"`
+	Pipeline        string   `json:"pipeline,omitempty"`
+	Matrix          string   `json:"matrix,omitempty"`
+	TermReason      string   `json:"termreason,omitempty"`
+	Env             []string `json:"env,omitempty"`      // VAR=VAL entries set by scripthaus (with "record_env")
+	RunCount        int      `json:"runcount,omitempty"` // 'history --unique', number of identical runs
+}
+
+func (item *HistoryItem) ToJson() HistoryItemJson {
+	md := item.GetMetadata()
+	rtn := HistoryItemJson{
+		SchemaVersion:   JsonSchemaVersion,
+		HistoryId:       item.HistoryId,
+		Ts:              item.Ts,
+		Date:            time.UnixMilli(item.Ts).Format("2006-01-02T15:04:05"),
+		Version:         item.ScVersion,
+		ProjectDir:      item.ProjectDir,
+		ProjectName:     item.ProjectName,
+		PlaybookFile:    item.PlaybookFile,
+		PlaybookCommand: item.PlaybookCommand,
+		ScriptType:      item.ScriptType,
+		Cwd:             item.Cwd,
+		HostName:        item.HostName,
+		IpAddr:          item.IpAddr,
+		SysUser:         item.SysUser,
+		CmdLine:         item.CmdLine,
+		RunDir:          md["rundir"],
+		Pipeline:        md[PipelineMdKey],
+		Matrix:          md[MatrixMdKey],
+		TermReason:      md[TermReasonMdKey],
+		RunCount:        item.RunCount,
+	}
+	rtn.Env, _ = item.GetEnv()
+	if item.DurationMs.Valid {
+		durationMs := item.DurationMs.Int64
+		rtn.DurationMs = &durationMs
+	}
+	if item.ExitCode.Valid {
+		exitCode := item.ExitCode.Int64
+		rtn.ExitCode = &exitCode
+	}
+	return rtn
+}
+
+func (item *HistoryItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(item.ToJson())
+}
@@ -0,0 +1,301 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// converts npm scripts (package.json) and make targets (Makefile) to playbook commands
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const SourceNpm = "npm"
+const SourceMake = "make"
+
+// an imported command (always a bash script)
+type Command struct {
+	Name       string
+	OrigName   string // name of the npm script or make target
+	ShortText  string
+	ScriptText string
+}
+
+var invalidNameCharRe = regexp.MustCompile("[^a-zA-Z0-9_/-]+")
+
+// converts an npm script / make target name to a valid playbook command name
+func commandName(origName string) string {
+	name := strings.Trim(invalidNameCharRe.ReplaceAllString(origName, "-"), "-/")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// guesses the source type from the file name ("" if unknown)
+func DetectSource(fileName string) string {
+	baseName := filepath.Base(fileName)
+	if baseName == "package.json" {
+		return SourceNpm
+	}
+	if baseName == "Makefile" || baseName == "makefile" || baseName == "GNUmakefile" || strings.HasSuffix(baseName, ".mk") {
+		return SourceMake
+	}
+	return ""
+}
+
+// returns (commands, warnings, err)
+func Parse(source string, data []byte) ([]Command, []string, error) {
+	switch source {
+	case SourceNpm:
+		return ParsePackageJson(data)
+
+	case SourceMake:
+		return ParseMakefile(data)
+
+	default:
+		return nil, nil, fmt.Errorf("invalid import source '%s', must be one of: %s, %s", source, SourceNpm, SourceMake)
+	}
+}
+
+// adds a command, checking for names that are invalid or collide after conversion
+func addCommand(rtn []Command, warnings []string, cmd Command) ([]Command, []string) {
+	if cmd.Name == "" {
+		return rtn, append(warnings, fmt.Sprintf("cannot convert '%s' to a command name (skipping)", cmd.OrigName))
+	}
+	for _, existing := range rtn {
+		if existing.Name == cmd.Name {
+			return rtn, append(warnings, fmt.Sprintf("'%s' and '%s' both convert to command name '%s' (skipping '%s')", existing.OrigName, cmd.OrigName, cmd.Name, cmd.OrigName))
+		}
+	}
+	if cmd.Name != cmd.OrigName {
+		warnings = append(warnings, fmt.Sprintf("'%s' imported as command '%s'", cmd.OrigName, cmd.Name))
+	}
+	return append(rtn, cmd), warnings
+}
+
+// returns the "scripts" from package.json in file order (a map would lose the order)
+func ParsePackageJson(data []byte) ([]Command, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("invalid package.json, expected a JSON object")
+	}
+	var rtn []Command
+	var warnings []string
+	foundScripts := false
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid package.json: %w", err)
+		}
+		if keyTok != "scripts" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, nil, fmt.Errorf("invalid package.json: %w", err)
+			}
+			continue
+		}
+		foundScripts = true
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil, nil, fmt.Errorf("invalid package.json, \"scripts\" must be an object")
+		}
+		for dec.More() {
+			nameTok, err := dec.Token()
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid package.json: %w", err)
+			}
+			var scriptText string
+			if err := dec.Decode(&scriptText); err != nil {
+				return nil, nil, fmt.Errorf("invalid package.json, script '%v' must be a string", nameTok)
+			}
+			origName := fmt.Sprintf("%v", nameTok)
+			cmd := Command{
+				Name:     commandName(origName),
+				OrigName: origName,
+				// npm puts node_modules/.bin on the PATH and appends extra args to the script
+				ScriptText: fmt.Sprintf("export PATH=\"$PWD/node_modules/.bin:$PATH\"\n%s \"$@\"\n", strings.TrimSpace(scriptText)),
+			}
+			rtn, warnings = addCommand(rtn, warnings, cmd)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, fmt.Errorf("invalid package.json: %w", err)
+		}
+	}
+	if !foundScripts {
+		return nil, nil, fmt.Errorf("package.json has no \"scripts\"")
+	}
+	return rtn, warnings, nil
+}
+
+var makeRuleRe = regexp.MustCompile("^([^\\s:=#][^:=#]*?)\\s*::?(.*)$")
+var makeDirectiveRe = regexp.MustCompile("^(ifeq|ifneq|ifdef|ifndef|else|endif|define|endef|include|-include|sinclude|export|unexport|override|vpath)\\b")
+
+// a rule being parsed
+type makeRule struct {
+	Targets []string
+	Prereqs []string
+	Desc    string
+	Recipe  []string
+}
+
+// parses the explicit rules in a Makefile.  pattern rules, special targets, and variables are
+// skipped.  recipes that use make variables or have prerequisites are imported with a warning
+func ParseMakefile(data []byte) ([]Command, []string, error) {
+	var rules []*makeRule
+	var warnings []string
+	var curRule *makeRule
+	var lastComment string
+	inDefine := false
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for idx := 0; idx < len(lines); idx++ {
+		line := lines[idx]
+		// join backslash continuations (recipes keep them, the shell handles them)
+		for strings.HasSuffix(line, "\\") && idx+1 < len(lines) {
+			idx++
+			line = line + "\n" + lines[idx]
+		}
+		if inDefine {
+			if strings.HasPrefix(strings.TrimSpace(line), "endef") {
+				inDefine = false
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			if curRule != nil {
+				curRule.Recipe = append(curRule.Recipe, line[1:])
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			lastComment = ""
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			lastComment = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			continue
+		}
+		curRule = nil
+		if makeDirectiveRe.MatchString(trimmed) {
+			if strings.HasPrefix(trimmed, "define") {
+				inDefine = true
+			}
+			lastComment = ""
+			continue
+		}
+		m := makeRuleRe.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(m[2], "=") {
+			// not a rule, or a ":=" / "::=" variable assignment
+			lastComment = ""
+			continue
+		}
+		rule := &makeRule{Targets: strings.Fields(m[1]), Desc: lastComment}
+		rest := m[2]
+		// self-documenting Makefiles use "target: deps ## description"
+		if descIdx := strings.Index(rest, "##"); descIdx != -1 {
+			rule.Desc = strings.TrimSpace(rest[descIdx+2:])
+			rest = rest[:descIdx]
+		} else if commentIdx := strings.Index(rest, "#"); commentIdx != -1 {
+			rest = rest[:commentIdx]
+		}
+		if semiIdx := strings.Index(rest, ";"); semiIdx != -1 {
+			rule.Recipe = append(rule.Recipe, strings.TrimSpace(rest[semiIdx+1:]))
+			rest = rest[:semiIdx]
+		}
+		if strings.Contains(rest, "=") {
+			// target-specific variable ("target: VAR = value")
+			lastComment = ""
+			continue
+		}
+		rule.Prereqs = strings.Fields(strings.ReplaceAll(rest, "|", " "))
+		rules = append(rules, rule)
+		curRule = rule
+		lastComment = ""
+	}
+	var rtn []Command
+	for _, rule := range rules {
+		for _, target := range rule.Targets {
+			if strings.HasPrefix(target, ".") || strings.ContainsAny(target, "%$") {
+				continue
+			}
+			if len(rule.Recipe) == 0 {
+				if len(rule.Prereqs) > 0 {
+					warnings = append(warnings, fmt.Sprintf("make target '%s' has no recipe, only prerequisites (%s) (skipping)", target, strings.Join(rule.Prereqs, " ")))
+				}
+				continue
+			}
+			scriptText, usesVars := makeRecipeScript(rule.Recipe, target)
+			cmd := Command{Name: commandName(target), OrigName: target, ShortText: rule.Desc, ScriptText: scriptText}
+			if len(rule.Prereqs) > 0 {
+				warnings = append(warnings, fmt.Sprintf("make target '%s' has prerequisites (%s) that are not run by the imported command", target, strings.Join(rule.Prereqs, " ")))
+			}
+			if usesVars {
+				warnings = append(warnings, fmt.Sprintf("make target '%s' uses make variables, check the imported script", target))
+			}
+			rtn, warnings = addCommand(rtn, warnings, cmd)
+		}
+	}
+	if len(rtn) == 0 && len(warnings) == 0 {
+		return nil, nil, fmt.Errorf("no make targets with recipes found")
+	}
+	return rtn, warnings, nil
+}
+
+// converts make recipe lines to a bash script (returns usesVars if the recipe references make
+// variables other than $@).  make stops at the first failing line, so multi-line recipes get "set -e"
+func makeRecipeScript(recipe []string, target string) (string, bool) {
+	usesVars := false
+	var buf strings.Builder
+	if len(recipe) > 1 {
+		buf.WriteString("set -e\n")
+	}
+	for _, line := range recipe {
+		line = strings.TrimSpace(line)
+		// "@" (silent) and "-" (ignore errors) prefixes
+		ignoreErr := false
+		for len(line) > 0 && (line[0] == '@' || line[0] == '-' || line[0] == '+') {
+			ignoreErr = ignoreErr || line[0] == '-'
+			line = strings.TrimSpace(line[1:])
+		}
+		var lineVars bool
+		line, lineVars = unescapeMakeLine(line, target)
+		usesVars = usesVars || lineVars
+		if ignoreErr && len(recipe) > 1 {
+			line += " || true"
+		}
+		if line != "" {
+			buf.WriteString(line + "\n")
+		}
+	}
+	return buf.String(), usesVars
+}
+
+// "$$" is a literal "$" and "$@" is the target name, any other "$" is a make variable
+func unescapeMakeLine(line string, target string) (string, bool) {
+	var buf strings.Builder
+	usesVars := false
+	for idx := 0; idx < len(line); idx++ {
+		if line[idx] != '$' || idx+1 == len(line) {
+			buf.WriteByte(line[idx])
+			continue
+		}
+		next := line[idx+1]
+		if next == '$' {
+			buf.WriteByte('$')
+			idx++
+		} else if next == '@' {
+			buf.WriteString(target)
+			idx++
+		} else {
+			usesVars = true
+			buf.WriteByte('$')
+		}
+	}
+	return buf.String(), usesVars
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// newline delimited JSON-RPC 2.0 over a stream (used by 'scripthaus mcp' and 'scripthaus daemon')
+package jsonrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const maxMessageSize = 10 * 1024 * 1024
+
+// JSON-RPC error codes
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+)
+
+type Request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"` // empty for notifications
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+func (req Request) IsNotification() bool {
+	return len(req.Id) == 0
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func MakeError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type response struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JsonRpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// writes responses and notifications (safe to use from multiple goroutines)
+type Conn struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+func MakeConn(w io.Writer) *Conn {
+	return &Conn{w: w}
+}
+
+func (c *Conn) writeMessage(msg interface{}) error {
+	barr, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err = c.w.Write(append(barr, '\n'))
+	return err
+}
+
+// rpcErr is sent if it is not nil (result is ignored)
+func (c *Conn) Respond(id json.RawMessage, result interface{}, rpcErr *Error) error {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	resp := response{JsonRpc: "2.0", Id: id, Result: result, Error: rpcErr}
+	if rpcErr != nil {
+		resp.Result = nil
+	} else if result == nil {
+		resp.Result = struct{}{}
+	}
+	err := c.writeMessage(resp)
+	if err != nil && rpcErr == nil {
+		return c.writeMessage(response{JsonRpc: "2.0", Id: id, Error: MakeError(ErrCodeInternal, "cannot encode result: %v", err)})
+	}
+	return err
+}
+
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.writeMessage(notification{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+// reads requests from r (one per line) until EOF, calling handleFn for each.  malformed
+// requests get an error response
+func ReadRequests(r io.Reader, conn *Conn, handleFn func(req Request)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req Request
+		err := json.Unmarshal([]byte(line), &req)
+		if err != nil {
+			conn.Respond(nil, nil, MakeError(ErrCodeParse, "parse error: %v", err))
+			continue
+		}
+		if req.Method == "" {
+			conn.Respond(req.Id, nil, MakeError(ErrCodeInvalidRequest, "invalid request, no method"))
+			continue
+		}
+		handleFn(req)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// section 1 man pages (roff) for the scripthaus commands (see 'scripthaus man').  the pages
+// are generated from the command registry, the description is the command's help text
+package manpage
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Option struct {
+	Name string // e.g. "-n, --runs [n]"
+	Desc string
+}
+
+type Page struct {
+	Name        string // e.g. "scripthaus-run"
+	Summary     string
+	Synopsis    string // e.g. "scripthaus run [run-opts] [playbook]::[command]"
+	Description string // preformatted text (printed as is)
+	Options     []Option
+	SeeAlso     []string // page names (section 1)
+}
+
+// escapes text for roff, backslashes, and lines that would start with a control character
+func escape(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\e`)
+	lines := strings.Split(text, "\n")
+	for idx, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[idx] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dashes in option names are escaped so they are not rendered as hyphens
+func escapeOpt(text string) string {
+	return strings.ReplaceAll(escape(text), "-", `\-`)
+}
+
+func (p Page) Roff(version string) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, ".TH %s 1 \"\" \"scripthaus %s\" \"ScriptHaus Manual\"\n", strings.ToUpper(p.Name), version)
+	fmt.Fprintf(&buf, ".SH NAME\n%s \\- %s\n", escapeOpt(p.Name), escape(p.Summary))
+	if p.Synopsis != "" {
+		fmt.Fprintf(&buf, ".SH SYNOPSIS\n.nf\n%s\n.fi\n", escape(p.Synopsis))
+	}
+	if strings.TrimSpace(p.Description) != "" {
+		fmt.Fprintf(&buf, ".SH DESCRIPTION\n.nf\n%s\n.fi\n", escape(strings.TrimSpace(p.Description)))
+	}
+	if len(p.Options) > 0 {
+		buf.WriteString(".SH OPTIONS\n")
+		for _, opt := range p.Options {
+			fmt.Fprintf(&buf, ".TP\n.B %s\n%s\n", escapeOpt(opt.Name), escape(strings.ReplaceAll(opt.Desc, "\n", " ")))
+		}
+	}
+	if len(p.SeeAlso) > 0 {
+		var refs []string
+		for _, name := range p.SeeAlso {
+			refs = append(refs, fmt.Sprintf(".BR %s (1)", escapeOpt(name)))
+		}
+		buf.WriteString(".SH SEE ALSO\n" + strings.Join(refs, ",\n") + "\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// a Model Context Protocol (MCP) server over stdio (newline delimited JSON-RPC 2.0).
+// only the "tools" capability is implemented
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/jsonrpc"
+)
+
+const ProtocolVersion = "2024-11-05"
+
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+
+	// returns the text result.  an error is returned to the client as a tool error (isError)
+	Handler func(ctx context.Context, args json.RawMessage) (string, error) `json:"-"`
+}
+
+type Server struct {
+	Name    string
+	Version string
+	Tools   []Tool
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type toolResult struct {
+	Content []textContent `json:"content"`
+	IsError bool          `json:"isError,omitempty"`
+}
+
+// reads requests from r until EOF, writing responses to w.  requests are handled in order
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	conn := jsonrpc.MakeConn(w)
+	return jsonrpc.ReadRequests(r, conn, func(req jsonrpc.Request) {
+		if req.IsNotification() {
+			// e.g. "notifications/initialized", no response
+			return
+		}
+		result, rpcErr := s.handleRequest(ctx, req)
+		conn.Respond(req.Id, result, rpcErr)
+	})
+}
+
+func (s *Server) handleRequest(ctx context.Context, req jsonrpc.Request) (interface{}, *jsonrpc.Error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": ProtocolVersion,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": s.Name, "version": s.Version},
+		}, nil
+
+	case "ping":
+		return map[string]interface{}{}, nil
+
+	case "tools/list":
+		tools := s.Tools
+		if tools == nil {
+			tools = []Tool{}
+		}
+		return map[string]interface{}{"tools": tools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return nil, jsonrpc.MakeError(jsonrpc.ErrCodeInvalidParams, "invalid params: %v", err)
+		}
+		tool := s.findTool(params.Name)
+		if tool == nil {
+			return nil, jsonrpc.MakeError(jsonrpc.ErrCodeInvalidParams, "unknown tool '%s'", params.Name)
+		}
+		if len(params.Arguments) == 0 {
+			params.Arguments = json.RawMessage("{}")
+		}
+		text, err := tool.Handler(ctx, params.Arguments)
+		if err != nil {
+			return toolResult{Content: []textContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return toolResult{Content: []textContent{{Type: "text", Text: text}}}, nil
+
+	default:
+		return nil, jsonrpc.MakeError(jsonrpc.ErrCodeMethodNotFound, "method '%s' not found", req.Method)
+	}
+}
+
+func (s *Server) findTool(name string) *Tool {
+	for idx := range s.Tools {
+		if s.Tools[idx].Name == name {
+			return &s.Tools[idx]
+		}
+	}
+	return nil
+}
+
+// true if any of the names matches one of the patterns ("*" matches any characters)
+func Allowed(patterns []string, names ...string) bool {
+	for _, pattern := range patterns {
+		var reStr strings.Builder
+		reStr.WriteString("^")
+		for idx, part := range strings.Split(pattern, "*") {
+			if idx > 0 {
+				reStr.WriteString(".*")
+			}
+			reStr.WriteString(regexp.QuoteMeta(part))
+		}
+		reStr.WriteString("$")
+		re := regexp.MustCompile(reStr.String())
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	textm "github.com/yuin/goldmark/text"
+)
+
+// code blocks without scripthaus directives (e.g. someone else's README) can be selected by
+// index, "README.md::#3" (the third fenced code block), or by heading, "README.md::Install
+// dependencies" (the first code block under that heading, case insensitive)
+
+// true for "#[n]" and for names that cannot be command names (headings)
+func IsBlockSelector(name string) bool {
+	return strings.HasPrefix(name, "#") || (name != "" && !IsValidScriptName(name))
+}
+
+// returns a command for the selected code block (Name is the selector, IsBlock is set)
+func (src *PlaybookSource) FindBlock(selector string) (*commanddef.CommandDef, error) {
+	// parse the commands first, sets the playbook's config (lang_aliases)
+	if _, _, err := src.Commands(); err != nil {
+		return nil, err
+	}
+	blockNum := 0
+	if strings.HasPrefix(selector, "#") {
+		var err error
+		blockNum, err = strconv.Atoi(selector[1:])
+		if err != nil || blockNum <= 0 {
+			return nil, fmt.Errorf("invalid block selector '%s', must be #[n] (n starts at 1) or a heading", selector)
+		}
+	}
+	mdSource := src.Source
+	if fmEnd := findFrontMatterEnd(mdSource); fmEnd != -1 {
+		mdSource = blankFrontMatter(mdSource, fmEnd)
+	}
+	doc := goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser().Parse(textm.NewReader(mdSource))
+	srcLines := makeLineIndex(mdSource)
+	numBlocks := 0
+	heading := ""
+	underHeading := false
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		if headingNode, ok := node.(*ast.Heading); ok {
+			heading = strings.TrimSpace(string(headingNode.Text(mdSource)))
+			underHeading = strings.EqualFold(heading, strings.TrimSpace(selector))
+			continue
+		}
+		codeNode, ok := node.(*ast.FencedCodeBlock)
+		if !ok {
+			continue
+		}
+		numBlocks++
+		if (blockNum > 0 && numBlocks == blockNum) || (blockNum == 0 && underHeading) {
+			return src.makeBlockCommand(selector, heading, codeNode, mdSource, srcLines)
+		}
+	}
+	if blockNum > 0 {
+		return nil, fmt.Errorf("block %s not found in playbook %s (it has %d code blocks)", selector, src.Playbook.OrigShowStr(), numBlocks)
+	}
+	return nil, fmt.Errorf("no code block under a heading '%s' in playbook %s", selector, src.Playbook.OrigShowStr())
+}
+
+func (src *PlaybookSource) makeBlockCommand(selector string, heading string, codeNode *ast.FencedCodeBlock, mdSource []byte, srcLines *lineIndex) (*commanddef.CommandDef, error) {
+	if codeNode.Info == nil {
+		lineNo := 0
+		if codeNode.Lines().Len() > 0 {
+			lineNo = srcLines.lineNo(codeNode.Lines().At(0).Start) - 1
+		}
+		return nil, fmt.Errorf("block %s (line %d) has no language, cannot run it", selector, lineNo)
+	}
+	infoText := string(codeNode.Info.Text(mdSource))
+	lineNo := srcLines.lineNo(codeNode.Info.Segment.Start)
+	lang, blockInfo := parseInfo(infoText)
+	lang, langOpts := resolveLangAlias(src.Playbook, lang)
+	scriptText := textFromLines(mdSource, codeNode.Lines())
+	if inSlice("strip-prompt", langOpts) {
+		scriptText = stripPrompts(scriptText)
+	}
+	if blockInfo["interpreter"] == "" && !base.IsValidScriptType(lang) {
+		return nil, fmt.Errorf("block %s (line %d) has language '%s', cannot run it", selector, lineNo, lang)
+	}
+	cdef := &commanddef.CommandDef{Playbook: src.Playbook}
+	cdef.Name = selector
+	cdef.IsBlock = true
+	cdef.Lang = lang
+	cdef.Section = heading
+	cdef.ScriptText = scriptText
+	cdef.Info = blockInfo
+	cdef.RawDirectives = ExtractRawDirectives(scriptText)
+	cdef.NumParts = 1
+	cdef.StartLineNo = lineNo
+	cdef.CodeStartIndex, cdef.EndIndex = codeBlockRange(codeNode, mdSource, srcLines)
+	cdef.StartIndex = cdef.CodeStartIndex
+	cdef.RawCodeText = strings.TrimSpace(string(mdSource[cdef.CodeStartIndex:cdef.EndIndex]))
+	return cdef, nil
+}
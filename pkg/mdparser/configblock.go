@@ -0,0 +1,109 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/config"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/yuin/goldmark/ast"
+)
+
+// code fence language for playbook-wide settings (an alternative to YAML front matter)
+const ConfigBlockLang = "scripthaus"
+
+func isConfigBlock(codeNode *ast.FencedCodeBlock, mdSource []byte) bool {
+	if codeNode.Info == nil {
+		return false
+	}
+	lang, _ := parseInfo(string(codeNode.Info.Text(mdSource)))
+	return lang == ConfigBlockLang
+}
+
+// parses a ```scripthaus block into cfg.  one "[key] [value]" setting per line ("key: value"
+// also works), blank lines and "#" comments are skipped.  the keys are the same as the front
+// matter keys, plus "lang_alias [lang] [scripttype] [options]".  lineNo is the line of the
+// block's first line (for warnings).  returns warnings
+func parseConfigBlock(text string, lineNo int, cfg *pathutil.PlaybookConfig) []string {
+	var warnings []string
+	for idx, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key := strings.TrimSuffix(fields[0], ":")
+		val := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		values := fields[1:]
+		if len(values) == 0 {
+			warnings = append(warnings, fmt.Sprintf("scripthaus config block, '%s' has no value (line %d)", key, lineNo+idx))
+			continue
+		}
+		switch key {
+		case "env":
+			for _, envPair := range values {
+				if strings.Index(envPair, "=") == -1 {
+					warnings = append(warnings, fmt.Sprintf("scripthaus config block, 'env' entry '%s' must be of the form VAR=VAL (line %d)", envPair, lineNo+idx))
+					continue
+				}
+				cfg.Env = append(cfg.Env, envPair)
+			}
+
+		case "cd":
+			cfg.ChangeDir = val
+
+		case "shell":
+			cfg.Shell = val
+
+		case "tags", "tag":
+			cfg.Tags = append(cfg.Tags, values...)
+
+		case "default_command":
+			cfg.DefaultCommand = val
+
+		case "autoname":
+			if val != "headings" && val != "none" {
+				warnings = append(warnings, fmt.Sprintf("scripthaus config block, 'autoname' must be 'headings' or 'none', got '%s' (line %d)", val, lineNo+idx))
+				continue
+			}
+			cfg.AutoName = val
+
+		case "include":
+			for _, includePath := range values {
+				cfg.Includes = append(cfg.Includes, pathutil.IncludeRef{Path: includePath, LineNo: lineNo + idx})
+			}
+
+		case "lang_alias":
+			if len(values) < 2 {
+				warnings = append(warnings, fmt.Sprintf("scripthaus config block, usage: lang_alias [lang] [scripttype] [options] (line %d)", lineNo+idx))
+				continue
+			}
+			if cfg.LangAliases == nil {
+				cfg.LangAliases = make(map[string]string)
+			}
+			cfg.LangAliases[values[0]] = strings.Join(values[1:], " ")
+
+		default:
+			warnings = append(warnings, fmt.Sprintf("scripthaus config block, unknown key '%s' (line %d)", key, lineNo+idx))
+		}
+	}
+	return warnings
+}
+
+// the playbook's lang aliases (front matter or config block) take precedence over config.json.
+// returns (scripttype, options)
+func resolveLangAlias(playbook *pathutil.ResolvedPlaybook, lang string) (string, []string) {
+	if playbook.Config != nil {
+		fields := strings.Fields(playbook.Config.LangAliases[lang])
+		if len(fields) > 0 {
+			return fields[0], fields[1:]
+		}
+	}
+	return config.Get().ResolveLangAlias(lang)
+}
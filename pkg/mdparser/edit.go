@@ -0,0 +1,152 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+)
+
+// returns the (start, end) positions of a command in its playbook file.  the range covers the
+// code block, and if withDoc is set, the help text before it.  multi-block commands are an error
+func CommandRange(cdef *commanddef.CommandDef, withDoc bool) (int, int, error) {
+	if cdef.NumParts > 1 {
+		return 0, 0, fmt.Errorf("command '%s' is made up of %d code blocks, it must be edited manually", cdef.Name, cdef.NumParts)
+	}
+	if cdef.EndIndex <= cdef.CodeStartIndex {
+		return 0, 0, fmt.Errorf("cannot find the code block for command '%s'", cdef.Name)
+	}
+	if withDoc {
+		return cdef.StartIndex, cdef.EndIndex, nil
+	}
+	return cdef.CodeStartIndex, cdef.EndIndex, nil
+}
+
+// returns the 1-indexed (startLineNo, endLineNo) of the range (inclusive)
+func RangeLineNos(mdSource []byte, startPos int, endPos int) (int, int) {
+	srcLines := makeLineIndex(mdSource)
+	endLine := srcLines.lineNo(endPos)
+	if endPos > 0 && mdSource[endPos-1] == '\n' {
+		endLine--
+	}
+	return srcLines.lineNo(startPos), endLine
+}
+
+// removes mdSource[startPos:endPos] along with the blank lines that follow it, leaving
+// exactly one blank line between the surrounding text
+func RemoveRange(mdSource []byte, startPos int, endPos int) []byte {
+	for endPos < len(mdSource) {
+		lineEnd := bytes.IndexByte(mdSource[endPos:], '\n')
+		if lineEnd == -1 || len(bytes.TrimSpace(mdSource[endPos:endPos+lineEnd])) != 0 {
+			break
+		}
+		endPos += lineEnd + 1
+	}
+	before := bytes.TrimRight(mdSource[:startPos], "\r\n")
+	after := mdSource[endPos:]
+	var buf bytes.Buffer
+	buf.Write(before)
+	if len(before) > 0 && len(after) > 0 {
+		buf.WriteString("\n\n")
+	} else if len(before) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.Write(after)
+	return buf.Bytes()
+}
+
+var cmdDirectiveNameRe = regexp.MustCompile("(?m)^((?:(?:#|//|--)\\s+|\\s*<!--\\s*)@scripthaus\\s+command\\s+)(\\S+)")
+
+// renames the command in the extracted command text (the 'command' directive, and a
+// level-4 heading that exactly matches the old name)
+func RenameCommandText(cmdText string, oldName string, newName string) string {
+	renamed := false
+	cmdText = cmdDirectiveNameRe.ReplaceAllStringFunc(cmdText, func(m string) string {
+		sub := cmdDirectiveNameRe.FindStringSubmatch(m)
+		if renamed || sub[2] != oldName {
+			return m
+		}
+		renamed = true
+		return sub[1] + newName
+	})
+	headingRe := regexp.MustCompile("(?m)^(####[ \\t]+)" + regexp.QuoteMeta(oldName) + "[ \\t]*$")
+	if loc := headingRe.FindStringSubmatchIndex(cmdText); loc != nil {
+		cmdText = cmdText[:loc[3]] + newName + cmdText[loc[1]:]
+	}
+	return cmdText
+}
+
+// returns the position to insert a new command at the end of the section with the given
+// title (a level 1-3 heading), found is false if there is no such section
+func findSectionEnd(mdSource []byte, title string) (int, bool) {
+	pos := 0
+	if fmEnd := findFrontMatterEnd(mdSource); fmEnd != -1 {
+		pos = fmEnd
+	}
+	sectionLevel := 0
+	var fenceStr string
+	for pos < len(mdSource) {
+		lineEnd := bytes.IndexByte(mdSource[pos:], '\n')
+		nextPos := len(mdSource)
+		if lineEnd != -1 {
+			nextPos = pos + lineEnd + 1
+		}
+		line := strings.TrimRight(string(mdSource[pos:nextPos]), "\r\n")
+		if fenceStr != "" {
+			if m := fenceCloseRe.FindStringSubmatch(line); m != nil && m[1][0] == fenceStr[0] && len(m[1]) >= len(fenceStr) {
+				fenceStr = ""
+			}
+		} else if m := fenceOpenRe.FindStringSubmatch(line); m != nil {
+			fenceStr = m[2]
+		} else if m := sectionHeadingRe.FindStringSubmatch(line); m != nil {
+			level := len(m[1])
+			if sectionLevel > 0 && level <= sectionLevel {
+				return pos, true
+			}
+			if sectionLevel == 0 && strings.TrimSpace(m[2]) == title {
+				sectionLevel = level
+			}
+		}
+		pos = nextPos
+	}
+	return len(mdSource), sectionLevel > 0
+}
+
+var sectionHeadingRe = regexp.MustCompile("^ {0,3}(#{1,3})\\s+(.*?)\\s*#*\\s*$")
+
+// adds cmdText to the playbook.  if section is set the command is added to the end of
+// that section (a new "## [section]" heading is appended if it does not exist), otherwise
+// the command is appended to the end of the playbook
+func InsertCommandText(mdSource []byte, cmdText string, section string) []byte {
+	cmdText = strings.Trim(cmdText, "\r\n")
+	insertPos := len(mdSource)
+	if section != "" {
+		var found bool
+		insertPos, found = findSectionEnd(mdSource, section)
+		if !found {
+			cmdText = fmt.Sprintf("## %s\n\n%s", section, cmdText)
+		}
+	}
+	before := bytes.TrimRight(mdSource[:insertPos], "\r\n")
+	after := mdSource[insertPos:]
+	var buf bytes.Buffer
+	buf.Write(before)
+	if len(before) > 0 {
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString(cmdText)
+	buf.WriteString("\n")
+	if len(after) > 0 {
+		buf.WriteString("\n")
+		buf.Write(after)
+	}
+	return buf.Bytes()
+}
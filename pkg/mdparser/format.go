@@ -0,0 +1,197 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+var fenceOpenRe = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})\\s*(.*)$")
+var fenceCloseRe = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*$")
+var fmtDirectiveRe = regexp.MustCompile("^(#|//|--)\\s+@scripthaus\\s+(\\S+)(?:\\s+(.*))?$")
+var fmtHtmlDirectiveRe = regexp.MustCompile("^\\s*<!--\\s*@scripthaus\\s+(\\S+)(?:\\s+(.*?))?\\s*-->\\s*$")
+var sectionLineRe = regexp.MustCompile("^ {0,3}(#{1,3}(\\s|$)|(-\\s*){3,}$|(\\*\\s*){3,}$|(_\\s*){3,}$)")
+var cmdDataRe = regexp.MustCompile("^(\\S+)(?:\\s+-\\s*|\\s+)(.*)$")
+
+// whitespace separated directives, safe to collapse spacing
+var fieldDirectives = map[string]bool{"alias": true, "tag": true, "os": true, "arch": true, "shellopts": true, "require-env": true, "env": true, "nolog": true, "hook": true, "notify": true, "stdin": true, "esm": true, "matrix": true, "timeout": true, "runtime": true, "db": true}
+
+// "Command" => "command", "require_env" => "require-env", "shell-opts" => "shellopts".
+// unknown directive types are returned unchanged
+func normalizeDirectiveType(dirType string) string {
+	canon := strings.ReplaceAll(strings.ToLower(dirType), "_", "-")
+	for _, known := range commanddef.DirectiveTypes {
+		if canon == known || strings.ReplaceAll(canon, "-", "") == strings.ReplaceAll(known, "-", "") {
+			return known
+		}
+	}
+	return dirType
+}
+
+func normalizeDirectiveData(dirType string, data string) string {
+	data = strings.TrimSpace(data)
+	if dirType == "command" {
+		m := cmdDataRe.FindStringSubmatch(data)
+		if m == nil || strings.TrimSpace(m[2]) == "" {
+			return data
+		}
+		return fmt.Sprintf("%s - %s", m[1], strings.TrimSpace(m[2]))
+	}
+	if fieldDirectives[dirType] {
+		return strings.Join(strings.Fields(data), " ")
+	}
+	return data
+}
+
+func formatDirective(prefix string, dirType string, data string, suffix string) string {
+	dirType = normalizeDirectiveType(dirType)
+	data = normalizeDirectiveData(dirType, data)
+	if data == "" {
+		return fmt.Sprintf("%s @scripthaus %s%s", prefix, dirType, suffix)
+	}
+	return fmt.Sprintf("%s @scripthaus %s %s%s", prefix, dirType, data, suffix)
+}
+
+// "``` Bash  part=2" => "```bash part=2"
+func formatFenceInfo(info string) string {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return ""
+	}
+	if lowerLang := strings.ToLower(fields[0]); base.IsValidScriptType(lowerLang) {
+		fields[0] = lowerLang
+	}
+	return strings.Join(fields, " ")
+}
+
+// returns (formatted, sectionStarts), sectionStarts are the positions of the
+// headings (level 1-3) and thematic breaks that start new sections in the output
+func formatLines(mdSource []byte) (string, []int) {
+	var buf strings.Builder
+	var sectionStarts []int
+	fmEnd := findFrontMatterEnd(mdSource)
+	if fmEnd != -1 {
+		buf.Write(mdSource[:fmEnd])
+		mdSource = mdSource[fmEnd:]
+	}
+	var fenceStr string // non-empty when inside a fenced code block
+	lines := strings.SplitAfter(string(mdSource), "\n")
+	for _, line := range lines {
+		eol := ""
+		if strings.HasSuffix(line, "\r\n") {
+			eol = "\r\n"
+		} else if strings.HasSuffix(line, "\n") {
+			eol = "\n"
+		}
+		text := strings.TrimSuffix(line, eol)
+		if fenceStr != "" {
+			if m := fenceCloseRe.FindStringSubmatch(text); m != nil && m[1][0] == fenceStr[0] && len(m[1]) >= len(fenceStr) {
+				fenceStr = ""
+			} else if m := fmtDirectiveRe.FindStringSubmatch(strings.TrimRight(text, " \t")); m != nil {
+				text = formatDirective(m[1], m[2], m[3], "")
+			}
+			buf.WriteString(text + eol)
+			continue
+		}
+		if m := fenceOpenRe.FindStringSubmatch(text); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+			fenceStr = m[2]
+			text = m[1] + m[2] + formatFenceInfo(m[3])
+		} else if m := fmtHtmlDirectiveRe.FindStringSubmatch(text); m != nil {
+			text = formatDirective("<!--", m[1], m[2], " -->")
+		} else if sectionLineRe.MatchString(text) {
+			sectionStarts = append(sectionStarts, buf.Len())
+		}
+		buf.WriteString(text + eol)
+	}
+	return buf.String(), sectionStarts
+}
+
+// sorts the commands in each section by name.  a command moves together with its
+// help text (everything from StartIndex up to the start of the next command)
+func sortSections(mdText string, sectionStarts []int) (string, []string, error) {
+	defs, _, err := ParseCommands(&pathutil.ResolvedPlaybook{}, []byte(mdText))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, def := range defs {
+		if def.NumParts > 1 {
+			return mdText, []string{fmt.Sprintf("not sorting commands, playbook has multi-block command '%s'", def.Name)}, nil
+		}
+	}
+	bounds := append([]int{0}, sectionStarts...)
+	bounds = append(bounds, len(mdText))
+	var buf strings.Builder
+	defIdx := 0
+	for secIdx := 0; secIdx < len(bounds)-1; secIdx++ {
+		secStart, secEnd := bounds[secIdx], bounds[secIdx+1]
+		var starts []int
+		var names []string
+		for ; defIdx < len(defs) && defs[defIdx].StartIndex < secEnd; defIdx++ {
+			starts = append(starts, defs[defIdx].StartIndex)
+			names = append(names, defs[defIdx].Name)
+		}
+		if len(starts) < 2 {
+			buf.WriteString(mdText[secStart:secEnd])
+			continue
+		}
+		type cmdChunk struct {
+			Name string
+			Text string
+		}
+		var chunks []cmdChunk
+		for idx, start := range starts {
+			end := secEnd
+			if idx+1 < len(starts) {
+				end = starts[idx+1]
+			}
+			chunks = append(chunks, cmdChunk{Name: names[idx], Text: mdText[start:end]})
+		}
+		lastText := chunks[len(chunks)-1].Text
+		tail := lastText[len(strings.TrimRight(lastText, "\r\n")):]
+		if tail == "" {
+			tail = "\n"
+		}
+		sort.SliceStable(chunks, func(i, j int) bool {
+			return chunks[i].Name < chunks[j].Name
+		})
+		buf.WriteString(mdText[secStart:starts[0]])
+		for idx, chunk := range chunks {
+			if idx > 0 {
+				buf.WriteString("\n\n")
+			}
+			buf.WriteString(strings.TrimRight(chunk.Text, "\r\n"))
+		}
+		buf.WriteString(tail)
+	}
+	return buf.String(), nil, nil
+}
+
+// normalizes a playbook: directive spelling and spacing ("#  @scripthaus Command foo-bar   -  desc"
+// => "# @scripthaus command foo-bar - desc"), code fence info strings, and html comment directives.
+// if sortCommands is set, the commands under each section (level 1-3 heading or thematic break)
+// are sorted by name.  front matter is left as is.  returns (formatted, warnings, err)
+func FormatPlaybook(mdSource []byte, sortCommands bool) ([]byte, []string, error) {
+	mdText, sectionStarts := formatLines(mdSource)
+	if len(mdText) > 0 && !strings.HasSuffix(mdText, "\n") {
+		mdText += "\n"
+	}
+	if !sortCommands {
+		return []byte(mdText), nil, nil
+	}
+	sortedText, warnings, err := sortSections(mdText, sectionStarts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(sortedText), warnings, nil
+}
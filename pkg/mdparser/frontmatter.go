@@ -0,0 +1,222 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+var fmKeyRe = regexp.MustCompile("^([a-zA-Z_][a-zA-Z0-9_-]*)\\s*:(?:\\s+(.*)|\\s*)$")
+
+// returns the markdown without the front matter block (for rendering the playbook)
+func StripFrontMatter(mdSource []byte) []byte {
+	if fmEnd := findFrontMatterEnd(mdSource); fmEnd != -1 {
+		return mdSource[fmEnd:]
+	}
+	return mdSource
+}
+
+// returns the end index of the front matter block ("---" lines at the very top of the file), -1 if none
+func findFrontMatterEnd(mdSource []byte) int {
+	if !bytes.HasPrefix(mdSource, []byte("---\n")) && !bytes.HasPrefix(mdSource, []byte("---\r\n")) {
+		return -1
+	}
+	pos := bytes.IndexByte(mdSource, '\n') + 1
+	for pos < len(mdSource) {
+		lineEnd := bytes.IndexByte(mdSource[pos:], '\n')
+		var line []byte
+		if lineEnd == -1 {
+			line = mdSource[pos:]
+			lineEnd = len(mdSource)
+		} else {
+			line = mdSource[pos : pos+lineEnd]
+			lineEnd = pos + lineEnd + 1
+		}
+		if string(bytes.TrimRight(line, "\r \t")) == "---" {
+			return lineEnd
+		}
+		pos = lineEnd
+	}
+	return -1
+}
+
+// blanks out the front matter (keeping newlines) so the markdown parser ignores it
+// but all of the positions and line numbers stay the same
+func blankFrontMatter(mdSource []byte, fmEnd int) []byte {
+	rtn := make([]byte, len(mdSource))
+	copy(rtn, mdSource)
+	for idx := 0; idx < fmEnd; idx++ {
+		if rtn[idx] != '\n' {
+			rtn[idx] = ' '
+		}
+	}
+	return rtn
+}
+
+func unquoteYamlStr(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	if hashIdx := strings.Index(s, " #"); hashIdx != -1 {
+		s = strings.TrimSpace(s[:hashIdx])
+	}
+	return s
+}
+
+func parseYamlInlineList(s string) []string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	var rtn []string
+	for _, item := range strings.Split(s, ",") {
+		item = unquoteYamlStr(item)
+		if item != "" {
+			rtn = append(rtn, item)
+		}
+	}
+	return rtn
+}
+
+type yamlValue struct {
+	Scalar string
+	List   []string
+	Map    [][2]string // ordered key/value pairs
+	LineNo int
+}
+
+// parses the small subset of YAML used in playbook front matter.  top level
+// keys with scalar values, inline lists ([a, b]), block lists ("- a"), and
+// one level of nested "key: value" maps.  lineNo is the line of the first front matter line.
+func parseSimpleYaml(text string, lineNo int) (map[string]*yamlValue, []string, []string) {
+	rtn := make(map[string]*yamlValue)
+	var keys []string
+	var warnings []string
+	var curVal *yamlValue
+	for idx, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r \t")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		curLineNo := lineNo + idx
+		if line[0] == ' ' || line[0] == '\t' {
+			if curVal == nil {
+				warnings = append(warnings, fmt.Sprintf("front matter, unexpected indented line (line %d)", curLineNo))
+				continue
+			}
+			if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+				curVal.List = append(curVal.List, unquoteYamlStr(strings.TrimPrefix(trimmed, "-")))
+				continue
+			}
+			m := fmKeyRe.FindStringSubmatch(trimmed)
+			if m == nil {
+				warnings = append(warnings, fmt.Sprintf("front matter, cannot parse line '%s' (line %d)", trimmed, curLineNo))
+				continue
+			}
+			curVal.Map = append(curVal.Map, [2]string{m[1], unquoteYamlStr(m[2])})
+			continue
+		}
+		m := fmKeyRe.FindStringSubmatch(line)
+		if m == nil {
+			warnings = append(warnings, fmt.Sprintf("front matter, cannot parse line '%s' (line %d)", trimmed, curLineNo))
+			curVal = nil
+			continue
+		}
+		curVal = &yamlValue{LineNo: curLineNo}
+		valStr := strings.TrimSpace(m[2])
+		if strings.HasPrefix(valStr, "[") {
+			curVal.List = parseYamlInlineList(valStr)
+		} else {
+			curVal.Scalar = unquoteYamlStr(valStr)
+		}
+		if _, exists := rtn[m[1]]; !exists {
+			keys = append(keys, m[1])
+		}
+		rtn[m[1]] = curVal
+	}
+	return rtn, keys, warnings
+}
+
+// returns (config, warnings).  config is nil if there is no front matter
+func parseFrontMatter(mdSource []byte, fmEnd int) (*pathutil.PlaybookConfig, []string) {
+	firstNl := bytes.IndexByte(mdSource, '\n')
+	body := string(mdSource[firstNl+1 : fmEnd])
+	// remove the closing "---" line
+	if lastNl := strings.LastIndex(strings.TrimRight(body, "\n"), "\n"); lastNl != -1 {
+		body = body[:lastNl]
+	} else {
+		body = ""
+	}
+	vals, keys, warnings := parseSimpleYaml(body, 2)
+	rtn := &pathutil.PlaybookConfig{}
+	for _, key := range keys {
+		val := vals[key]
+		switch key {
+		case "env":
+			for _, kv := range val.Map {
+				rtn.Env = append(rtn.Env, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+			}
+			for _, envPair := range val.List {
+				if strings.Index(envPair, "=") == -1 {
+					warnings = append(warnings, fmt.Sprintf("front matter 'env' entry '%s' must be of the form VAR=VAL (line %d)", envPair, val.LineNo))
+					continue
+				}
+				rtn.Env = append(rtn.Env, envPair)
+			}
+
+		case "cd":
+			rtn.ChangeDir = val.Scalar
+
+		case "shell":
+			rtn.Shell = val.Scalar
+
+		case "tags":
+			if val.Scalar != "" {
+				rtn.Tags = append(rtn.Tags, strings.Fields(val.Scalar)...)
+			}
+			rtn.Tags = append(rtn.Tags, val.List...)
+
+		case "default_command":
+			rtn.DefaultCommand = val.Scalar
+
+		case "autoname":
+			if val.Scalar != "headings" && val.Scalar != "none" {
+				warnings = append(warnings, fmt.Sprintf("front matter 'autoname' must be 'headings' or 'none', got '%s' (line %d)", val.Scalar, val.LineNo))
+				break
+			}
+			rtn.AutoName = val.Scalar
+
+		case "lang_aliases":
+			for _, kv := range val.Map {
+				if rtn.LangAliases == nil {
+					rtn.LangAliases = make(map[string]string)
+				}
+				rtn.LangAliases[kv[0]] = kv[1]
+			}
+
+		case "include":
+			includes := val.List
+			if val.Scalar != "" {
+				includes = append(includes, val.Scalar)
+			}
+			for _, includePath := range includes {
+				rtn.Includes = append(rtn.Includes, pathutil.IncludeRef{Path: includePath, LineNo: val.LineNo})
+			}
+
+		default:
+			warnings = append(warnings, fmt.Sprintf("front matter, unknown key '%s' (line %d)", key, val.LineNo))
+		}
+	}
+	return rtn, warnings
+}
@@ -0,0 +1,186 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+type includeState struct {
+	Root     *pathutil.ResolvedPlaybook
+	Stack    []string // resolved files currently being parsed (for cycle detection)
+	Seen     map[string]bool
+	CmdIdx   map[string]int // command name or alias -> index in Defs of the first definition
+	Defs     []commanddef.CommandDef
+	Warnings []string
+}
+
+// resolves an include path relative to the including playbook's directory
+func resolveIncludePath(includingFile string, includePath string) (string, error) {
+	if strings.HasPrefix(includePath, "^") {
+		rpb, err := pathutil.DefaultResolver().ResolvePlaybook(includePath)
+		if err != nil {
+			return "", err
+		}
+		return rpb.ResolvedFile, nil
+	}
+	if strings.HasPrefix(includePath, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(homeDir, includePath[2:]), nil
+	}
+	if filepath.IsAbs(includePath) {
+		return filepath.Clean(includePath), nil
+	}
+	baseDir := filepath.Dir(includingFile)
+	if includingFile == "-" {
+		var err error
+		baseDir, err = os.Getwd()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(baseDir, includePath), nil
+}
+
+func (state *includeState) parseFile(playbook *pathutil.ResolvedPlaybook, mdSource []byte) error {
+	state.Stack = append(state.Stack, playbook.ResolvedFile)
+	state.Seen[playbook.ResolvedFile] = true
+	defer func() {
+		state.Stack = state.Stack[:len(state.Stack)-1]
+	}()
+	defs, warnings, err := ParseCommands(playbook, mdSource)
+	if err != nil {
+		return err
+	}
+	state.Warnings = append(state.Warnings, warnings...)
+	for _, def := range defs {
+		// later definitions are not listed, but the first one becomes ambiguous (it will not run)
+		if firstIdx, found := state.CmdIdx[def.Name]; found {
+			firstDef := &state.Defs[firstIdx]
+			if firstDef.Name != def.Name || firstDef.Playbook.ResolvedFile != def.Playbook.ResolvedFile {
+				// duplicates within one file are already reported by ParseCommands
+				state.Warnings = append(state.Warnings, fmt.Sprintf("duplicate command '%s' at %s (already defined at %s)", def.Name, def.Location(), firstDef.Location()))
+			}
+			firstDef.DuplicateLocs = append(firstDef.DuplicateLocs, def.Location())
+			continue
+		}
+		state.CmdIdx[def.Name] = len(state.Defs)
+		var aliases []string
+		for _, alias := range def.Aliases {
+			if firstIdx, found := state.CmdIdx[alias]; found {
+				if firstIdx == len(state.Defs) {
+					// the alias repeats the command's own name (or another of its aliases)
+					continue
+				}
+				firstDef := &state.Defs[firstIdx]
+				firstDef.DuplicateLocs = append(firstDef.DuplicateLocs, def.Location())
+				state.Warnings = append(state.Warnings, fmt.Sprintf("alias '%s' for command '%s' at %s collides with a name already defined at %s, ignoring alias", alias, def.Name, def.Location(), firstDef.Location()))
+				continue
+			}
+			state.CmdIdx[alias] = len(state.Defs)
+			aliases = append(aliases, alias)
+		}
+		def.Aliases = aliases
+		state.Defs = append(state.Defs, def)
+	}
+	if playbook.Config == nil {
+		return nil
+	}
+	for _, include := range playbook.Config.Includes {
+		includeLoc := fmt.Sprintf("%s:%d", playbook.ResolvedFile, include.LineNo)
+		includeFile, err := resolveIncludePath(playbook.ResolvedFile, include.Path)
+		if err != nil {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("cannot resolve include '%s' at %s: %v", include.Path, includeLoc, err))
+			continue
+		}
+		if inSlice(includeFile, state.Stack) {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("include cycle at %s, '%s' is already being included (%s), ignoring", includeLoc, include.Path, strings.Join(append(state.Stack, includeFile), " -> ")))
+			continue
+		}
+		if state.Seen[includeFile] {
+			// already included through another path, commands are already in the list
+			continue
+		}
+		found, includeSource, err := pathutil.TryReadFile(includeFile, "included playbook", false)
+		if err != nil {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("cannot read include '%s' at %s: %v", include.Path, includeLoc, err))
+			continue
+		}
+		if !found {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("include '%s' at %s not found (resolved to '%s')", include.Path, includeLoc, includeFile))
+			continue
+		}
+		// included commands are run/listed/logged under the root playbook's name, but
+		// resolve :playbook (and nested includes) relative to their own file
+		includePlaybook := &pathutil.ResolvedPlaybook{
+			OrigName:      state.Root.OrigName,
+			CanonicalName: state.Root.CanonicalName,
+			ResolvedFile:  includeFile,
+			ProjectDir:    state.Root.ProjectDir,
+			ProjectName:   state.Root.ProjectName,
+		}
+		err = state.parseFile(includePlaybook, includeSource)
+		if err != nil {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("cannot parse include '%s' at %s: %v", include.Path, includeLoc, err))
+		}
+	}
+	return nil
+}
+
+func inSlice(s string, arr []string) bool {
+	for _, val := range arr {
+		if s == val {
+			return true
+		}
+	}
+	return false
+}
+
+// like ParseCommands, but also parses all of the playbooks included with "include"
+// (front matter or <!-- @scripthaus include [file] -->) and returns the union of the commands
+func ParsePlaybook(playbook *pathutil.ResolvedPlaybook, mdSource []byte) ([]commanddef.CommandDef, []string, error) {
+	state := &includeState{
+		Root:   playbook,
+		Seen:   make(map[string]bool),
+		CmdIdx: make(map[string]int),
+	}
+	err := state.parseFile(playbook, mdSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state.Defs, state.Warnings, nil
+}
+
+// the resolved files of the playbook and of all of the playbooks it includes (sorted), includes
+// that cannot be read are left out
+func PlaybookFiles(playbook *pathutil.ResolvedPlaybook, mdSource []byte) ([]string, error) {
+	state := &includeState{
+		Root:   playbook,
+		Seen:   make(map[string]bool),
+		CmdIdx: make(map[string]int),
+	}
+	err := state.parseFile(playbook, mdSource)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []string
+	for fileName := range state.Seen {
+		rtn = append(rtn, fileName)
+	}
+	sort.Strings(rtn)
+	return rtn, nil
+}
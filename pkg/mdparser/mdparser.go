@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/scripthaus-dev/scripthaus/pkg/base"
@@ -21,28 +22,41 @@ import (
 	textm "github.com/yuin/goldmark/text"
 )
 
-// TODO more efficient (create newline position array, binary search)
-// 1-indexed
-func findLineNo(pos int, mdSource []byte) int {
-	return bytes.Count(mdSource[:pos], []byte{'\n'}) + 1
+// newline positions of a source, built once per parse so line lookups are a binary search
+type lineIndex struct {
+	newlines []int
+	srcLen   int
 }
 
-// TODO more efficient
-// lineNo is 1-indexed
-func findLinePos(lineNo int, mdSource []byte) int {
+func makeLineIndex(mdSource []byte) *lineIndex {
+	rtn := &lineIndex{srcLen: len(mdSource)}
+	for pos := 0; pos < len(mdSource); {
+		nlIdx := bytes.IndexByte(mdSource[pos:], '\n')
+		if nlIdx == -1 {
+			break
+		}
+		rtn.newlines = append(rtn.newlines, pos+nlIdx)
+		pos += nlIdx + 1
+	}
+	return rtn
+}
+
+// 1-indexed line number of pos
+func (li *lineIndex) lineNo(pos int) int {
+	// number of newlines before pos
+	return sort.SearchInts(li.newlines, pos) + 1
+}
+
+// position of the newline that ends the line before lineNo (1-indexed).  0 for the first
+// line, the end of the source if there is no such line
+func (li *lineIndex) linePos(lineNo int) int {
 	if lineNo <= 1 {
 		return 0
 	}
-	curLine := 1
-	for idx := 0; idx < len(mdSource); idx++ {
-		if mdSource[idx] == '\n' {
-			curLine++
-			if curLine == lineNo {
-				return idx
-			}
-		}
+	if lineNo-2 >= len(li.newlines) {
+		return li.srcLen
 	}
-	return len(mdSource)
+	return li.newlines[lineNo-2]
 }
 
 func mdIndexBackToNewLine(mdIdx int, mdSource []byte) int {
@@ -63,7 +77,12 @@ func parseInfo(info string) (string, map[string]string) {
 	language := split[0]
 
 	fields := map[string]string{}
-	for _, field := range split[1:] {
+	for idx, field := range split[1:] {
+		if strings.HasPrefix(field, "interpreter=") {
+			// interpreter takes the rest of the info string (e.g. "interpreter=deno run -A -")
+			fields["interpreter"] = strings.TrimSpace(strings.Join(split[idx+1:], " ")[len("interpreter="):])
+			break
+		}
 		splitField := strings.SplitN(field, "=", 2)
 		if len(splitField) > 1 {
 			fields[splitField[0]] = splitField[1]
@@ -75,17 +94,22 @@ func parseInfo(info string) (string, map[string]string) {
 	return language, fields
 }
 
-func rawCodeText(name string, block *ast.FencedCodeBlock, mdSource []byte) string {
+// returns (startPos, endPos) of the fenced block, from the start of the opening fence
+// line to the end of the closing fence line (not including its newline)
+func codeBlockRange(block *ast.FencedCodeBlock, mdSource []byte, srcLines *lineIndex) (int, int) {
 	lines := block.Lines()
 	startPos := mdIndexBackToNewLine(block.Info.Segment.Start, mdSource)
 	if lines.Len() == 0 {
-		infoLineNo := findLineNo(block.Info.Segment.Start, mdSource)
-		endPos := findLinePos(infoLineNo+2, mdSource)
-		return string(mdSource[startPos:endPos])
+		infoLineNo := srcLines.lineNo(block.Info.Segment.Start)
+		return startPos, srcLines.linePos(infoLineNo + 2)
 	}
 	lastSeg := lines.At(lines.Len() - 1)
-	lastCodeLine := findLineNo(lastSeg.Start, mdSource)
-	endPos := findLinePos(lastCodeLine+2, mdSource)
+	lastCodeLine := srcLines.lineNo(lastSeg.Start)
+	return startPos, srcLines.linePos(lastCodeLine + 2)
+}
+
+func rawCodeText(name string, block *ast.FencedCodeBlock, mdSource []byte, srcLines *lineIndex) string {
+	startPos, endPos := codeBlockRange(block, mdSource, srcLines)
 	return string(mdSource[startPos:endPos])
 }
 
@@ -100,7 +124,7 @@ func textFromLines(mdSource []byte, lines *textm.Segments) string {
 
 // returns (pos, lineno)
 // lineno is 1-indexed
-func blockStartIndex(block ast.Node, mdSource []byte) (int, int) {
+func blockStartIndex(block ast.Node, mdSource []byte, srcLines *lineIndex) (int, int) {
 	if block.Type() != ast.TypeBlock {
 		return -1, 0
 	}
@@ -109,10 +133,10 @@ func blockStartIndex(block ast.Node, mdSource []byte) (int, int) {
 		return -1, 0
 	}
 	mdIdx := mdIndexBackToNewLine(segs.At(0).Start, mdSource)
-	return mdIdx, findLineNo(mdIdx, mdSource)
+	return mdIdx, srcLines.lineNo(mdIdx)
 }
 
-var directiveRe = regexp.MustCompile("^(?:#|//)\\s+@scripthaus\\s+(\\S+)(?:\\s+(.*))?")
+var directiveRe = regexp.MustCompile("^(?:#|//|--)\\s+@scripthaus\\s+(\\S+)(?:\\s+(.*))?")
 
 func ExtractRawDirectives(codeText string) []commanddef.RawDirective {
 	var rtn []commanddef.RawDirective
@@ -131,6 +155,143 @@ func ExtractRawDirectives(codeText string) []commanddef.RawDirective {
 	return rtn
 }
 
+func hasDirective(dirs []commanddef.RawDirective, dirType string) bool {
+	for _, dir := range dirs {
+		if dir.Type == dirType {
+			return true
+		}
+	}
+	return false
+}
+
+var htmlDirectiveRe = regexp.MustCompile("<!--\\s*@scripthaus\\s+(\\S+)(?:\\s+(.*?))?\\s*-->")
+
+// directives in the html comments in text (e.g. the html block before a code block), LineNo
+// is relative to text
+func ExtractHtmlDirectives(text string) []commanddef.RawDirective {
+	var rtn []commanddef.RawDirective
+	for idx, line := range strings.Split(text, "\n") {
+		m := htmlDirectiveRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		rtn = append(rtn, commanddef.RawDirective{Type: m[1], Data: strings.TrimSpace(m[2]), LineNo: idx + 1})
+	}
+	return rtn
+}
+
+// directives in html comments outside of code blocks, e.g. <!-- @scripthaus include ./common.md -->
+// LineNo is the line in the playbook (not relative to the block)
+func extractHtmlDirectives(htmlNode *ast.HTMLBlock, mdSource []byte, srcLines *lineIndex) []commanddef.RawDirective {
+	var rtn []commanddef.RawDirective
+	lines := htmlNode.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		m := htmlDirectiveRe.FindStringSubmatch(string(seg.Value(mdSource)))
+		if m == nil {
+			continue
+		}
+		rtn = append(rtn, commanddef.RawDirective{Type: m[1], Data: strings.TrimSpace(m[2]), LineNo: srcLines.lineNo(seg.Start)})
+	}
+	return rtn
+}
+
+// returns (aliases, warnings)
+func getAliasDirectives(cmdName string, dirs []commanddef.RawDirective, blockLineNo int) ([]string, []string) {
+	var aliases []string
+	var warnings []string
+	for _, dir := range dirs {
+		if dir.Type != "alias" {
+			continue
+		}
+		fields, err := commanddef.SplitDirectiveData(dir.Data)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("'alias' directive for command '%s', %v (line %d)", cmdName, err, blockLineNo+dir.LineNo))
+			continue
+		}
+		for _, alias := range fields {
+			if !IsValidScriptName(alias) {
+				warnings = append(warnings, fmt.Sprintf("invalid alias '%s' for command '%s' (line %d)", alias, cmdName, blockLineNo+dir.LineNo))
+				continue
+			}
+			if alias == cmdName {
+				continue
+			}
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases, warnings
+}
+
+// "@scripthaus continue [name]", name is optional (defaults to the previous command)
+// returns (name, isContinue)
+func getContinueDirective(dirs []commanddef.RawDirective) (string, bool) {
+	for _, dir := range dirs {
+		if dir.Type == "continue" {
+			return strings.TrimSpace(dir.Data), true
+		}
+	}
+	return "", false
+}
+
+func findDefIdx(defs []commanddef.CommandDef, name string) int {
+	for idx := range defs {
+		if defs[idx].Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// appends a continuation block (part=N or 'continue') to an existing command.  returns warnings
+func mergeCommandPart(def *commanddef.CommandDef, lang string, scriptText string, rawDirs []commanddef.RawDirective, codeNode *ast.FencedCodeBlock, mdSource []byte, srcLines *lineIndex, lineNo int) []string {
+	var warnings []string
+	if lang != def.Lang {
+		warnings = append(warnings, fmt.Sprintf("command '%s' continued with a different language '%s' (expected '%s', line %d)", def.Name, lang, def.Lang, lineNo))
+	}
+	if !strings.HasSuffix(def.ScriptText, "\n") {
+		def.ScriptText += "\n"
+	}
+	def.ScriptText += scriptText
+	for _, dir := range rawDirs {
+		if dir.Type == "command" || dir.Type == "continue" {
+			continue
+		}
+		def.RawDirectives = append(def.RawDirectives, dir)
+	}
+	def.NumParts++
+	def.RawCodeText += "\n\n" + strings.TrimSpace(rawCodeText(def.Name, codeNode, mdSource, srcLines))
+	return warnings
+}
+
+// for "console" style blocks.  keeps lines starting with a "$ " prompt (prompt removed)
+// and scripthaus directives, drops everything else (command output)
+func stripPrompts(scriptText string) string {
+	var buf strings.Builder
+	for _, line := range strings.SplitAfter(scriptText, "\n") {
+		if strings.HasPrefix(line, "$ ") {
+			buf.WriteString(line[2:])
+		} else if strings.TrimRight(line, "\r\n") == "$" {
+			buf.WriteString("\n")
+		} else if directiveRe.MatchString(line) {
+			buf.WriteString(line)
+		}
+	}
+	return buf.String()
+}
+
+var slugInvalidRe = regexp.MustCompile("[^a-z0-9_]+")
+
+// "Install Dependencies!" => "install-dependencies", returns "" if no valid name can be made
+func slugifyHeading(heading string) string {
+	slug := slugInvalidRe.ReplaceAllString(strings.ToLower(heading), "-")
+	slug = strings.Trim(slug, "-")
+	if !IsValidScriptName(slug) {
+		return ""
+	}
+	return slug
+}
+
 var hasDashPrefix = regexp.MustCompile("^\\s+-\\s+(.*)")
 
 func GetCommandDirective(dirs []commanddef.RawDirective) (string, string) {
@@ -155,10 +316,23 @@ func GetCommandDirective(dirs []commanddef.RawDirective) (string, string) {
 }
 
 func ParseCommands(playbook *pathutil.ResolvedPlaybook, mdSource []byte) ([]commanddef.CommandDef, []string, error) {
+	var defs []commanddef.CommandDef
+	var warnings []string
+
+	// set from the front matter, config blocks, and include comments (parsing again starts over)
+	playbook.Config = nil
+	fmEnd := findFrontMatterEnd(mdSource)
+	if fmEnd != -1 {
+		pbConfig, fmWarnings := parseFrontMatter(mdSource, fmEnd)
+		playbook.Config = pbConfig
+		warnings = append(warnings, fmWarnings...)
+		mdSource = blankFrontMatter(mdSource, fmEnd)
+	}
 	md := goldmark.New(
 		goldmark.WithExtensions(extension.GFM),
 	)
 	node := md.Parser().Parse(textm.NewReader(mdSource))
+	srcLines := makeLineIndex(mdSource)
 	doc, isDoc := node.(*ast.Document)
 	if !isDoc {
 		return nil, nil, fmt.Errorf("Invalid MD parse, did not return valid document (type)")
@@ -175,33 +349,133 @@ func ParseCommands(playbook *pathutil.ResolvedPlaybook, mdSource []byte) ([]comm
 	// * rendering the raw markdown text to the console for "help" feels currently impossible with goldmark
 	// * gomarkdown is not going to work either because it does not parse fenced code block infos correctly
 
-	var defs []commanddef.CommandDef
-	var warnings []string
-
+	// config blocks apply to the whole playbook, so they are read before any commands
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		codeNode, _ := node.(*ast.FencedCodeBlock)
+		if codeNode == nil || !isConfigBlock(codeNode, mdSource) {
+			continue
+		}
+		if playbook.Config == nil {
+			playbook.Config = &pathutil.PlaybookConfig{}
+		}
+		lineNo := srcLines.lineNo(codeNode.Info.Segment.Start) + 1
+		warnings = append(warnings, parseConfigBlock(textFromLines(mdSource, codeNode.Lines()), lineNo, playbook.Config)...)
+	}
+	autoNameHeadings := playbook.Config != nil && playbook.Config.AutoName == "headings"
 	breakIdx := -1
+	headingName := "" // slugified level-4 heading (for autoname: headings)
+	sectionTitle := ""
+	// html comment directives by heading level (0 is before the first heading), a heading
+	// drops the directives of its level and below
+	var sectionDirs [4][]commanddef.RawDirective
+	sectionLevel := 0
+	// html comment directives for the code block right after the comment
+	var nextBlockDirs []commanddef.RawDirective
+	nextBlockDirsIdx := -1
 	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		commentDirs, commentIdx := nextBlockDirs, nextBlockDirsIdx
+		nextBlockDirs, nextBlockDirsIdx = nil, -1
 		breakNode, _ := node.(*ast.ThematicBreak)
 		headingNode, _ := node.(*ast.Heading)
 		codeNode, _ := node.(*ast.FencedCodeBlock)
+		htmlNode, _ := node.(*ast.HTMLBlock)
 
 		if breakNode != nil {
 			breakIdx = -1
+			headingName = ""
 			continue
 		}
 		if headingNode != nil && headingNode.Level < 4 {
 			breakIdx = -1
+			headingName = ""
+			sectionTitle = strings.TrimSpace(string(headingNode.Text(mdSource)))
+			for level := headingNode.Level; level < len(sectionDirs); level++ {
+				sectionDirs[level] = nil
+			}
+			sectionLevel = headingNode.Level
 			continue
 		}
 		if headingNode != nil && headingNode.Level == 4 {
-			breakIdx, _ = blockStartIndex(headingNode, mdSource)
+			breakIdx, _ = blockStartIndex(headingNode, mdSource, srcLines)
+			headingName = slugifyHeading(string(headingNode.Text(mdSource)))
 			continue
 		}
 
+		if htmlNode != nil {
+			htmlDirs := extractHtmlDirectives(htmlNode, mdSource, srcLines)
+			// a comment with a 'command' directive (and the comments right after it) hold the
+			// directives of the next code block
+			blockDirs := append(commentDirs, htmlDirs...)
+			if hasDirective(blockDirs, "command") || hasDirective(blockDirs, "continue") {
+				switch node.NextSibling().(type) {
+				case *ast.FencedCodeBlock, *ast.HTMLBlock:
+					nextBlockDirs, nextBlockDirsIdx = blockDirs, commentIdx
+					if nextBlockDirsIdx == -1 {
+						nextBlockDirsIdx, _ = blockStartIndex(htmlNode, mdSource, srcLines)
+					}
+				default:
+					warnings = append(warnings, fmt.Sprintf("html comment with a 'command' directive must be right before a code block (line %d)", blockDirs[0].LineNo))
+				}
+				continue
+			}
+			for _, dir := range htmlDirs {
+				if dir.Type == "include" {
+					if playbook.Config == nil {
+						playbook.Config = &pathutil.PlaybookConfig{}
+					}
+					playbook.Config.Includes = append(playbook.Config.Includes, pathutil.IncludeRef{Path: dir.Data, LineNo: dir.LineNo})
+				} else if commanddef.IsSectionDirective(dir.Type) {
+					sectionDirs[sectionLevel] = append(sectionDirs[sectionLevel], dir)
+				} else {
+					warnings = append(warnings, fmt.Sprintf("invalid directive '%s' in html comment (line %d)", dir.Type, dir.LineNo))
+				}
+			}
+			if len(htmlDirs) > 0 {
+				// not part of the next command's help text
+				continue
+			}
+		}
+
+		if codeNode != nil && isConfigBlock(codeNode, mdSource) {
+			breakIdx = -1
+			continue
+		}
 		if codeNode != nil && codeNode.Info != nil {
-			lineNo := findLineNo(codeNode.Info.Segment.Start, mdSource)
+			lineNo := srcLines.lineNo(codeNode.Info.Segment.Start)
 			scriptText := textFromLines(mdSource, codeNode.Lines())
 			rawDirs := ExtractRawDirectives(scriptText)
+			if len(commentDirs) > 0 {
+				if hasDirective(rawDirs, "command") || hasDirective(rawDirs, "continue") {
+					warnings = append(warnings, fmt.Sprintf("code block has a 'command' directive in both the html comment before it and the block, using the html comment (line %d)", lineNo))
+				}
+				// LineNo is relative to the block (negative for the comment lines)
+				for idx := range commentDirs {
+					commentDirs[idx].LineNo -= lineNo
+				}
+				rawDirs = append(commentDirs, rawDirs...)
+			}
 			name, shortDesc := GetCommandDirective(rawDirs)
+			continueName, isContinue := getContinueDirective(rawDirs)
+			if name == "" && isContinue {
+				name = continueName
+				if name == "" && len(defs) > 0 {
+					name = defs[len(defs)-1].Name
+				}
+				if name == "" {
+					warnings = append(warnings, fmt.Sprintf("'continue' directive without a previous command (line %d)", lineNo))
+					breakIdx = -1
+					continue
+				}
+			}
+			if name == "" && autoNameHeadings && len(rawDirs) == 0 && headingName != "" {
+				autoLang, _ := parseInfo(string(codeNode.Info.Text(mdSource)))
+				autoLang, _ = resolveLangAlias(playbook, autoLang)
+				if base.IsValidScriptType(autoLang) && findDefIdx(defs, headingName) == -1 {
+					name = headingName
+				}
+				// only the first block under a heading gets the name
+				headingName = ""
+			}
 			if name == "" {
 				if len(rawDirs) != 0 {
 					warnings = append(warnings, fmt.Sprintf("code block has scripthaus directives, but no 'command' directive (line %d)", lineNo))
@@ -213,35 +487,77 @@ func ParseCommands(playbook *pathutil.ResolvedPlaybook, mdSource []byte) ([]comm
 			// this is a scripthaus code block
 			infoText := string(codeNode.Info.Text(mdSource))
 			lang, blockInfo := parseInfo(infoText)
-			if !base.IsValidScriptType(lang) {
+			lang, langOpts := resolveLangAlias(playbook, lang)
+			for _, langOpt := range langOpts {
+				if langOpt == "strip-prompt" {
+					scriptText = stripPrompts(scriptText)
+				} else {
+					warnings = append(warnings, fmt.Sprintf("invalid lang_aliases option '%s' for language '%s' (line %d)", langOpt, lang, lineNo))
+				}
+			}
+			hasInterpreter := blockInfo["interpreter"] != "" || hasDirective(rawDirs, "interpreter")
+			if !hasInterpreter && !base.IsValidScriptType(lang) {
 				warnings = append(warnings, fmt.Sprintf("scripthaus code block found info='%s' with invalid language '%s' (line %d)", infoText, lang, lineNo))
 				continue
 			}
+			if isContinue || blockInfo["part"] != "" {
+				prevIdx := findDefIdx(defs, name)
+				if prevIdx != -1 {
+					mergeWarnings := mergeCommandPart(&defs[prevIdx], lang, scriptText, rawDirs, codeNode, mdSource, srcLines, lineNo)
+					warnings = append(warnings, mergeWarnings...)
+					breakIdx = -1
+					continue
+				}
+				if isContinue {
+					warnings = append(warnings, fmt.Sprintf("'continue' directive for unknown command '%s' (line %d)", name, lineNo))
+					breakIdx = -1
+					continue
+				}
+			}
 			newDef := &commanddef.CommandDef{Playbook: playbook}
 			newDef.Name = name
 			newDef.ShortText = shortDesc
 			newDef.Lang = lang
+			newDef.Section = sectionTitle
 			newDef.ScriptText = scriptText
 			newDef.Info = blockInfo
 			newDef.RawDirectives = rawDirs
+			for _, dirs := range sectionDirs {
+				newDef.SectionDirectives = append(newDef.SectionDirectives, dirs...)
+			}
+			newDef.NumParts = 1
+			aliases, aliasWarnings := getAliasDirectives(name, rawDirs, lineNo)
+			newDef.Aliases = aliases
+			warnings = append(warnings, aliasWarnings...)
 			cbStartIdx := mdIndexBackToNewLine(codeNode.Info.Segment.Start, mdSource)
+			newDef.StartLineNo = srcLines.lineNo(cbStartIdx)
+			// the html comment with the block's directives goes with the code block
+			if len(commentDirs) > 0 && commentIdx != -1 {
+				cbStartIdx = commentIdx
+			}
 			if breakIdx == -1 {
 				newDef.StartIndex = cbStartIdx
-				newDef.StartLineNo = findLineNo(cbStartIdx, mdSource)
 				// no HelpText in this case
 			} else {
 				newDef.StartIndex = breakIdx
-				newDef.StartLineNo = findLineNo(cbStartIdx, mdSource)
 				newDef.HelpText = strings.TrimSpace(string(mdSource[breakIdx:cbStartIdx]))
 			}
-			newDef.RawCodeText = strings.TrimSpace(rawCodeText(newDef.Name, codeNode, mdSource))
+			_, newDef.EndIndex = codeBlockRange(codeNode, mdSource, srcLines)
+			newDef.CodeStartIndex = cbStartIdx
+			newDef.RawCodeText = strings.TrimSpace(string(mdSource[cbStartIdx:newDef.EndIndex]))
+			if newDef.EndIndex < len(mdSource) {
+				newDef.EndIndex++ // include the closing fence's newline
+			}
+			if dupIdx := findDefIdx(defs, name); dupIdx != -1 {
+				warnings = append(warnings, fmt.Sprintf("duplicate command '%s' (lines %d and %d)", name, defs[dupIdx].StartLineNo, newDef.StartLineNo))
+			}
 			defs = append(defs, *newDef)
 			breakIdx = -1
 			continue
 		}
 
 		if breakIdx == -1 && node.Type() == ast.TypeBlock {
-			breakIdx, _ = blockStartIndex(node, mdSource)
+			breakIdx, _ = blockStartIndex(node, mdSource, srcLines)
 		}
 
 	}
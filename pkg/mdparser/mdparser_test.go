@@ -0,0 +1,245 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// a playbook with numCommands commands, each with a few paragraphs of help text
+func makeBigPlaybook(numCommands int) []byte {
+	var buf strings.Builder
+	buf.WriteString("# Big Playbook\n\n")
+	for i := 0; i < numCommands; i++ {
+		if i%50 == 0 {
+			fmt.Fprintf(&buf, "## Section %d\n\n", i/50)
+		}
+		fmt.Fprintf(&buf, "Help text for command %d, which does something useful.\n", i)
+		buf.WriteString(strings.Repeat("More explanation of what the command does and why.\n", 10))
+		fmt.Fprintf(&buf, "\n```bash\n# @scripthaus command cmd-%d - short description %d\n# @scripthaus tag t%d\n", i, i, i%10)
+		buf.WriteString(strings.Repeat("echo \"a line of the script\"\n", 20))
+		buf.WriteString("```\n\n")
+	}
+	return []byte(buf.String())
+}
+
+func TestParseCommandsLineNos(t *testing.T) {
+	mdSource := makeBigPlaybook(20)
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "big.md", ResolvedFile: "/tmp/big.md"}
+	defs, warnings, err := ParseCommands(playbook, mdSource)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	if len(defs) != 20 {
+		t.Fatalf("expected 20 commands, got %d", len(defs))
+	}
+	lines := strings.Split(string(mdSource), "\n")
+	for _, def := range defs {
+		startLine, endLine := RangeLineNos(mdSource, def.CodeStartIndex, def.EndIndex)
+		if !strings.HasPrefix(lines[startLine-1], "```bash") || lines[endLine-1] != "```" {
+			t.Errorf("command %s, bad code block lines %d-%d: %q %q", def.Name, startLine, endLine, lines[startLine-1], lines[endLine-1])
+		}
+		if def.StartLineNo != startLine {
+			t.Errorf("command %s, start line %d does not match the code block line %d", def.Name, def.StartLineNo, startLine)
+		}
+		helpLine, _ := RangeLineNos(mdSource, def.StartIndex, def.EndIndex)
+		if !strings.HasPrefix(lines[helpLine-1], "Help text for command") {
+			t.Errorf("command %s, bad help text line %d: %q", def.Name, helpLine, lines[helpLine-1])
+		}
+		if strings.TrimSpace(string(mdSource[def.CodeStartIndex:def.EndIndex])) != def.RawCodeText {
+			t.Errorf("command %s, code range does not match the raw code text", def.Name)
+		}
+	}
+}
+
+const sectionPlaybook = "# Top\n\n<!-- @scripthaus tag all -->\n\n## Backend\n\n<!-- @scripthaus cd ./backend -->\n\n" +
+	"Help for a.\n\n```bash\n# @scripthaus command a\necho a\n```\n\n" +
+	"```bash\n# @scripthaus command b\n# @scripthaus cd :current\necho b\n```\n\n" +
+	"## Frontend\n\n```bash\n# @scripthaus command c\necho c\n```\n"
+
+func TestSectionDirectives(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "sect.md", ResolvedFile: "/tmp/sect.md"}
+	defs, warnings, err := ParseCommands(playbook, []byte(sectionPlaybook))
+	if err != nil || len(warnings) > 0 || len(defs) != 3 {
+		t.Fatalf("parse error: %v %v %d", err, warnings, len(defs))
+	}
+	expectedDirs := []string{"/tmp/backend", "", ""}
+	for idx := range defs {
+		def := &defs[idx]
+		if strings.Join(def.GetTags(), ",") != "all" || def.ChangeDir != expectedDirs[idx] {
+			t.Errorf("command %s, bad tags %v or cd %q", def.Name, def.GetTags(), def.ChangeDir)
+		}
+	}
+	if defs[0].HelpText != "Help for a." {
+		t.Errorf("section directives should not be part of the help text, got %q", defs[0].HelpText)
+	}
+}
+
+const configBlockPlaybook = "# Config\n\n```scripthaus\n# settings\nshell: bash\nenv A=1 B=2\ndefault_command b\nlang_alias console bash strip-prompt\n```\n\n" +
+	"```console\n# @scripthaus command b\n$ echo hi\nhi\n```\n"
+
+func TestConfigBlock(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "cfg.md", ResolvedFile: "/tmp/cfg.md"}
+	for i := 0; i < 2; i++ {
+		defs, warnings, err := ParseCommands(playbook, []byte(configBlockPlaybook))
+		if err != nil || len(warnings) > 0 || len(defs) != 1 {
+			t.Fatalf("parse error: %v %v %d", err, warnings, len(defs))
+		}
+		cfg := playbook.Config
+		if cfg == nil || cfg.Shell != "bash" || strings.Join(cfg.Env, " ") != "A=1 B=2" || cfg.DefaultCommand != "b" {
+			t.Fatalf("bad playbook config: %#v", cfg)
+		}
+		if defs[0].Lang != "bash" || !strings.HasSuffix(defs[0].ScriptText, "\necho hi\n") {
+			t.Errorf("lang_alias not applied, lang=%s script=%q", defs[0].Lang, defs[0].ScriptText)
+		}
+	}
+}
+
+const dupPlaybook = "# Dup\n\n" +
+	"```bash\n# @scripthaus command a\necho 1\n```\n\n" +
+	"```bash\n# @scripthaus command a\necho 2\n```\n"
+
+const sqlPlaybook = "<!-- @scripthaus db sqlite:./app.db -->\n\n```sql\n-- @scripthaus command find\n-- @scripthaus arg email\nSELECT * FROM users WHERE email = :email;\n```\n\n" +
+	"```sql\n-- @scripthaus command count\n-- @scripthaus db $DATABASE_URL\nSELECT count(*) FROM users;\n```\n"
+
+func TestSqlDirectives(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "sql.md", ResolvedFile: "/tmp/sql.md"}
+	defs, warnings, err := ParseCommands(playbook, []byte(sqlPlaybook))
+	if err != nil || len(warnings) > 0 || len(defs) != 2 {
+		t.Fatalf("parse error: %v %v %d", err, warnings, len(defs))
+	}
+	for idx := range defs {
+		if dirWarnings := defs[idx].DirectiveWarnings(); len(dirWarnings) > 0 {
+			t.Errorf("command %s, directive warnings %v", defs[idx].Name, dirWarnings)
+		}
+	}
+	if defs[0].Name != "find" || defs[0].Db != "sqlite:./app.db" || len(defs[0].Args) != 1 {
+		t.Errorf("bad sql command %q db %q args %v", defs[0].Name, defs[0].Db, defs[0].Args)
+	}
+	if defs[1].Db != "$DATABASE_URL" {
+		t.Errorf("'db' directive should override the section, got %q", defs[1].Db)
+	}
+}
+
+func TestDuplicateCommands(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "dup.md", ResolvedFile: "/tmp/dup.md"}
+	defs, warnings, err := ParsePlaybook(playbook, []byte(dupPlaybook))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0] != "duplicate command 'a' (lines 3 and 8)" {
+		t.Errorf("bad warnings: %v", warnings)
+	}
+	if len(defs) != 1 || strings.Join(defs[0].DuplicateLocs, ",") != "/tmp/dup.md:8" {
+		t.Fatalf("bad defs: %v", defs)
+	}
+	if err := defs[0].CheckPlatform(); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous command should not run, got %v", err)
+	}
+}
+
+const htmlCommentPlaybook = "# Html\n\nHelp for hello.\n\n" +
+	"<!-- @scripthaus command hello - says hello -->\n<!-- @scripthaus env NAME=world -->\n" +
+	"```bash\necho \"hello $NAME\"\n```\n\n" +
+	"<!-- @scripthaus command orphan -->\n\nSome text.\n"
+
+func TestHtmlCommentCommand(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "html.md", ResolvedFile: "/tmp/html.md"}
+	defs, warnings, err := ParseCommands(playbook, []byte(htmlCommentPlaybook))
+	if err != nil || len(defs) != 1 {
+		t.Fatalf("parse error: %v %v %d", err, warnings, len(defs))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "must be right before a code block (line 11)") {
+		t.Errorf("bad warnings: %v", warnings)
+	}
+	def := &defs[0]
+	if def.Name != "hello" || def.ShortText != "says hello" || def.HelpText != "Help for hello." {
+		t.Errorf("bad command: name=%q short=%q help=%q", def.Name, def.ShortText, def.HelpText)
+	}
+	def.DirectiveWarnings()
+	if strings.Join(def.Env, " ") != "NAME=world" {
+		t.Errorf("env directive not applied: %v", def.Env)
+	}
+	if !strings.HasPrefix(def.RawCodeText, "<!-- @scripthaus command hello") || strings.Contains(def.ScriptText, "@scripthaus") {
+		t.Errorf("bad code text: raw=%q script=%q", def.RawCodeText, def.ScriptText)
+	}
+	startLine, _ := RangeLineNos([]byte(htmlCommentPlaybook), def.CodeStartIndex, def.EndIndex)
+	if startLine != 5 || def.StartLineNo != 7 || def.StartLineNo+def.RawDirectives[0].LineNo != 5 {
+		t.Errorf("bad line numbers: code start %d, start %d, directive %d", startLine, def.StartLineNo, def.RawDirectives[0].LineNo)
+	}
+}
+
+const blockPlaybook = `# Project
+
+` + "```" + `
+no language
+` + "```" + `
+
+## Install Dependencies
+
+` + "```bash" + `
+npm install
+` + "```" + `
+`
+
+func TestFindBlock(t *testing.T) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "README.md", ResolvedFile: "/tmp/README.md"}
+	src := &PlaybookSource{Playbook: playbook, Source: []byte(blockPlaybook)}
+	for _, selector := range []string{"#2", "install dependencies"} {
+		cdef, err := src.FindBlock(selector)
+		if err != nil {
+			t.Fatalf("FindBlock(%q): %v", selector, err)
+		}
+		if !cdef.IsBlock || cdef.Lang != "bash" || cdef.ScriptText != "npm install\n" || cdef.StartLineNo != 9 || cdef.Section != "Install Dependencies" {
+			t.Errorf("FindBlock(%q): bad block lang=%q script=%q line=%d section=%q", selector, cdef.Lang, cdef.ScriptText, cdef.StartLineNo, cdef.Section)
+		}
+	}
+	for _, selector := range []string{"#1", "#3", "#0", "No Such Heading"} {
+		if _, err := src.FindBlock(selector); err == nil {
+			t.Errorf("FindBlock(%q): expected an error", selector)
+		}
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	mdSource := []byte("a\nbb\n\nccc\nd")
+	lines := makeLineIndex(mdSource)
+	for pos := 0; pos <= len(mdSource); pos++ {
+		expected := strings.Count(string(mdSource[:pos]), "\n") + 1
+		if lines.lineNo(pos) != expected {
+			t.Errorf("lineNo(%d) = %d, expected %d", pos, lines.lineNo(pos), expected)
+		}
+	}
+	// the position of the newline ending the previous line
+	expectedPos := map[int]int{0: 0, 1: 0, 2: 1, 3: 4, 4: 5, 5: 9, 6: len(mdSource), 10: len(mdSource)}
+	for lineNo, expected := range expectedPos {
+		if lines.linePos(lineNo) != expected {
+			t.Errorf("linePos(%d) = %d, expected %d", lineNo, lines.linePos(lineNo), expected)
+		}
+	}
+}
+
+// ~3MB playbook
+func BenchmarkParseCommands(b *testing.B) {
+	mdSource := makeBigPlaybook(2500)
+	b.SetBytes(int64(len(mdSource)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		playbook := &pathutil.ResolvedPlaybook{OrigName: "big.md", ResolvedFile: "/tmp/big.md"}
+		_, _, err := ParseCommands(playbook, mdSource)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mdparser
+
+import (
+	"fmt"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// a playbook's markdown, read once.  the same bytes are used for parsing and for
+// anything that needs the source (line numbers, edits), so the file is not re-read
+type PlaybookSource struct {
+	Playbook *pathutil.ResolvedPlaybook
+	Source   []byte
+
+	parsed   bool
+	cmdDefs  []commanddef.CommandDef
+	warnings []string
+	parseErr error
+}
+
+func ReadPlaybookSource(playbook *pathutil.ResolvedPlaybook) (*PlaybookSource, error) {
+	found, mdSource, err := pathutil.TryReadFile(playbook.ResolvedFile, "playbook", false)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot find playbook '%s' (resolved to '%s')", playbook.OrigName, playbook.ResolvedFile)
+	}
+	return &PlaybookSource{Playbook: playbook, Source: mdSource}, nil
+}
+
+// parses the playbook (including its includes) on the first call, later calls return the same results
+// returns (cmdDefs, warnings, err)
+func (src *PlaybookSource) Commands() ([]commanddef.CommandDef, []string, error) {
+	if !src.parsed {
+		src.cmdDefs, src.warnings, src.parseErr = ParsePlaybook(src.Playbook, src.Source)
+		src.parsed = true
+	}
+	return src.cmdDefs, src.warnings, src.parseErr
+}
+
+// the source of the playbook that defines cdef (it can come from an included playbook)
+func (src *PlaybookSource) CommandSource(cdef *commanddef.CommandDef) ([]byte, error) {
+	if cdef.Playbook == nil || cdef.Playbook.ResolvedFile == src.Playbook.ResolvedFile {
+		return src.Source, nil
+	}
+	inclSrc, err := ReadPlaybookSource(cdef.Playbook)
+	if err != nil {
+		return nil, err
+	}
+	return inclSrc.Source, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// completion notifications (desktop and Slack/Discord webhooks), used by 'run --notify' and
+// the 'notify' directive
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const ModeAlways = "always"
+const ModeFailure = "failure" // only notify if the command failed
+
+const sendTimeout = 5 * time.Second
+
+// normalizes a notify mode ("" means always), returns "" if the mode is invalid
+func ParseMode(mode string) string {
+	switch strings.TrimSpace(mode) {
+	case "", ModeAlways:
+		return ModeAlways
+
+	case ModeFailure, "failed", "error":
+		return ModeFailure
+
+	default:
+		return ""
+	}
+}
+
+type Message struct {
+	Command    string
+	ExitCode   int
+	DurationMs int64
+	HostName   string
+}
+
+func (msg Message) Title() string {
+	if msg.ExitCode == 0 {
+		return fmt.Sprintf("scripthaus: %s finished", msg.Command)
+	}
+	return fmt.Sprintf("scripthaus: %s failed", msg.Command)
+}
+
+func (msg Message) Text() string {
+	var hostStr string
+	if msg.HostName != "" {
+		hostStr = fmt.Sprintf(" on %s", msg.HostName)
+	}
+	return fmt.Sprintf("'%s' exited with code %d after %s%s", msg.Command, msg.ExitCode, formatDuration(msg.DurationMs), hostStr)
+}
+
+func formatDuration(durationMs int64) string {
+	duration := time.Duration(durationMs) * time.Millisecond
+	if duration < time.Minute {
+		return fmt.Sprintf("%0.1fs", duration.Seconds())
+	}
+	return duration.Round(time.Second).String()
+}
+
+// sends a desktop notification (osascript on macOS, notify-send on Linux)
+func Desktop(msg Message) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptQuote(msg.Text()), appleScriptQuote(msg.Title()))
+		cmd = exec.Command("osascript", "-e", script)
+
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return fmt.Errorf("notify-send not found in PATH")
+		}
+		urgency := "normal"
+		if msg.ExitCode != 0 {
+			urgency = "critical"
+		}
+		cmd = exec.Command("notify-send", "-u", urgency, "-a", "scripthaus", msg.Title(), msg.Text())
+
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func appleScriptQuote(str string) string {
+	str = strings.ReplaceAll(str, "\\", "\\\\")
+	return "\"" + strings.ReplaceAll(str, "\"", "\\\"") + "\""
+}
+
+// posts the message to a Slack or Discord incoming webhook (Discord is detected by the URL)
+func Webhook(url string, msg Message) error {
+	text := fmt.Sprintf("*%s*\n%s", msg.Title(), msg.Text())
+	payload := map[string]string{"text": text}
+	if strings.Contains(url, "discord.com/") || strings.Contains(url, "discordapp.com/") {
+		payload = map[string]string{"content": strings.ReplaceAll(text, "*", "**")}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancelFn()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cannot post to webhook: %s %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
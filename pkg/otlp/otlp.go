@@ -0,0 +1,194 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// minimal OpenTelemetry trace export (OTLP/HTTP with the JSON encoding), one span per run.
+// W3C trace context (TRACEPARENT) is used to join an existing trace and passed on to the command
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TraceParentVarName = "TRACEPARENT"
+const DefaultServiceName = "scripthaus"
+const exportTimeout = 5 * time.Second
+
+// from config.json "otlp"
+type Config struct {
+	Endpoint    string            `json:"endpoint"` // e.g. "http://localhost:4318" ("/v1/traces" is added)
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+type Span struct {
+	Name         string
+	TraceId      string // 32 hex chars
+	SpanId       string // 16 hex chars
+	ParentSpanId string // "" for a root span
+	Start        time.Time
+	End          time.Time
+	Attrs        map[string]interface{} // string, int, int64, or bool values
+	Error        bool
+}
+
+var traceParentRe = regexp.MustCompile("^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$")
+
+func randomHex(numBytes int) string {
+	barr := make([]byte, numBytes)
+	rand.Read(barr)
+	return hex.EncodeToString(barr)
+}
+
+// starts a span, a child of traceParent (a W3C traceparent header value) if it is valid
+func StartSpan(name string, traceParent string) *Span {
+	span := &Span{Name: name, SpanId: randomHex(8), Start: time.Now(), Attrs: make(map[string]interface{})}
+	if m := traceParentRe.FindStringSubmatch(strings.TrimSpace(traceParent)); m != nil {
+		span.TraceId = m[1]
+		span.ParentSpanId = m[2]
+	} else {
+		span.TraceId = randomHex(16)
+	}
+	return span
+}
+
+// the traceparent value for children of this span
+func (span *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", span.TraceId, span.SpanId)
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 is a string in the JSON encoding
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+func makeKeyValue(key string, val interface{}) keyValue {
+	var av anyValue
+	switch v := val.(type) {
+	case int:
+		intStr := strconv.Itoa(v)
+		av.IntValue = &intStr
+
+	case int64:
+		intStr := strconv.FormatInt(v, 10)
+		av.IntValue = &intStr
+
+	case bool:
+		av.BoolValue = &v
+
+	default:
+		str := fmt.Sprintf("%v", v)
+		av.StringValue = &str
+	}
+	return keyValue{Key: key, Value: av}
+}
+
+// sorted by key
+func makeAttributes(attrs map[string]interface{}) []keyValue {
+	var keys []string
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rtn := []keyValue{}
+	for _, key := range keys {
+		rtn = append(rtn, makeKeyValue(key, attrs[key]))
+	}
+	return rtn
+}
+
+const spanKindInternal = 1
+const statusCodeOk = 1
+const statusCodeError = 2
+
+// the ExportTraceServiceRequest JSON body
+func (span *Span) requestBody(serviceName string, serviceVersion string) ([]byte, error) {
+	statusCode := statusCodeOk
+	if span.Error {
+		statusCode = statusCodeError
+	}
+	spanJson := map[string]interface{}{
+		"traceId":           span.TraceId,
+		"spanId":            span.SpanId,
+		"name":              span.Name,
+		"kind":              spanKindInternal,
+		"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+		"attributes":        makeAttributes(span.Attrs),
+		"status":            map[string]interface{}{"code": statusCode},
+	}
+	if span.ParentSpanId != "" {
+		spanJson["parentSpanId"] = span.ParentSpanId
+	}
+	resourceAttrs := map[string]interface{}{"service.name": serviceName, "service.version": serviceVersion}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": makeAttributes(resourceAttrs)},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "scripthaus", "version": serviceVersion},
+						"spans": []interface{}{spanJson},
+					},
+				},
+			},
+		},
+	}
+	return json.Marshal(body)
+}
+
+// sends the (ended) span to the collector
+func Export(cfg *Config, serviceVersion string, span *Span) error {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	body, err := span.requestBody(serviceName, serviceVersion)
+	if err != nil {
+		return fmt.Errorf("cannot encode span: %w", err)
+	}
+	url := strings.TrimRight(cfg.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancelFn()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid otlp endpoint '%s': %w", cfg.Endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, val := range cfg.Headers {
+		req.Header.Set(name, val)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send span to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("cannot send span to %s: %s %s", url, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/render"
+)
+
+const FormatText = "text"
+const FormatJson = "json"
+
+// version of the CommandEntry JSON schema ('list --json'), bumped when a field is removed,
+// renamed, or changes meaning (see history.JsonSchemaVersion)
+const ListSchemaVersion = 1
+
+const maxUsageLen = 40
+const maxShortTextLen = 80
+
+// one command in a playbook listing (also the JSON format for 'list --json')
+type CommandEntry struct {
+	SchemaVersion int      `json:"schema_version"`
+	Name          string   `json:"name"`
+	FullName      string   `json:"fullname"` // name to pass to 'scripthaus run'
+	Usage         string   `json:"usage"`
+	ShortText     string   `json:"shorttext,omitempty"`
+	Lang          string   `json:"lang"`
+	Aliases       []string `json:"aliases,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Section       string   `json:"section,omitempty"`
+	Playbook      string   `json:"playbook"`
+	PlaybookFile  string   `json:"playbookfile"` // file the command is defined in (can be an included file)
+	LineNo        int      `json:"lineno"`
+	Origin        string   `json:"origin,omitempty"`     // 'list --everywhere', where the playbook comes from
+	ShadowedBy    string   `json:"shadowedby,omitempty"` // 'list --everywhere', fullname of the command that runs for this name
+}
+
+type PlaybookListing struct {
+	Playbook *pathutil.ResolvedPlaybook
+	Origin   string // "global", "project", etc. ('list --everywhere')
+	Commands []CommandEntry
+}
+
+type ListFormatter interface {
+	WriteList(w io.Writer, listings []PlaybookListing) error
+}
+
+// color and showLocation only apply to the text format (JSON always has the location)
+func GetListFormatter(format string, color render.Colorizer, showLocation bool) (ListFormatter, error) {
+	switch format {
+	case "", FormatText:
+		return textListFormatter{Color: color, ShowLocation: showLocation}, nil
+
+	case FormatJson:
+		return jsonListFormatter{}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid output format '%s', must be one of: %s, %s", format, FormatText, FormatJson)
+	}
+}
+
+func MakeCommandEntry(cdef *commanddef.CommandDef) CommandEntry {
+	return CommandEntry{
+		SchemaVersion: ListSchemaVersion,
+		Name:          cdef.Name,
+		FullName:      cdef.OrigScriptName(),
+		Usage:         cdef.UsageStr(),
+		ShortText:     cdef.ShortText,
+		Lang:          cdef.Lang,
+		Aliases:       cdef.Aliases,
+		Tags:          cdef.GetTags(),
+		Section:       cdef.Section,
+		Playbook:      cdef.Playbook.OrigName,
+		PlaybookFile:  cdef.Playbook.ResolvedFile,
+		LineNo:        cdef.StartLineNo,
+	}
+}
+
+type textListFormatter struct {
+	Color        render.Colorizer
+	ShowLocation bool // "file:line" after each command (list -v)
+}
+
+// commands are grouped under their section (markdown heading), in order of first appearance.
+// commands without a section are listed first
+func (f textListFormatter) WriteList(w io.Writer, listings []PlaybookListing) error {
+	for idx, listing := range listings {
+		if idx > 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		if listing.Origin != "" {
+			fmt.Fprintf(w, "%s %s\n", f.Color.Heading(listing.Playbook.OrigShowStr()), f.Color.Dim("["+listing.Origin+"]"))
+		} else {
+			fmt.Fprintf(w, "%s\n", f.Color.Heading(listing.Playbook.OrigShowStr()))
+		}
+		maxScriptNameLen := 0
+		for _, entry := range listing.Commands {
+			if len(entry.Usage) > maxScriptNameLen {
+				maxScriptNameLen = len(entry.Usage)
+			}
+		}
+		if maxScriptNameLen > maxUsageLen {
+			maxScriptNameLen = maxUsageLen
+		}
+		for _, section := range groupBySection(listing.Commands) {
+			indent := "  "
+			if section.Title != "" {
+				fmt.Fprintf(w, "  %s\n", f.Color.Heading(section.Title))
+				indent = "    "
+			}
+			for _, entry := range section.Commands {
+				f.writeTextEntry(w, indent, maxScriptNameLen, entry)
+			}
+		}
+	}
+	return nil
+}
+
+type sectionGroup struct {
+	Title    string
+	Commands []CommandEntry
+}
+
+func groupBySection(entries []CommandEntry) []*sectionGroup {
+	rtn := []*sectionGroup{{Title: ""}}
+	groupMap := map[string]*sectionGroup{"": rtn[0]}
+	for _, entry := range entries {
+		group := groupMap[entry.Section]
+		if group == nil {
+			group = &sectionGroup{Title: entry.Section}
+			groupMap[entry.Section] = group
+			rtn = append(rtn, group)
+		}
+		group.Commands = append(group.Commands, entry)
+	}
+	return rtn
+}
+
+func (f textListFormatter) writeTextEntry(w io.Writer, indent string, maxScriptNameLen int, entry CommandEntry) {
+	var aliasStr string
+	if len(entry.Aliases) > 0 {
+		aliasStr = fmt.Sprintf(" (alias %s)", strings.Join(entry.Aliases, ", "))
+	}
+	if len(entry.Tags) > 0 {
+		aliasStr += fmt.Sprintf(" [%s]", strings.Join(entry.Tags, ", "))
+	}
+	if entry.ShadowedBy != "" {
+		aliasStr += fmt.Sprintf(" (shadowed by %s)", entry.ShadowedBy)
+	}
+	if f.ShowLocation {
+		aliasStr += fmt.Sprintf("  %s:%d", entry.PlaybookFile, entry.LineNo)
+	}
+	aliasStr = f.Color.Dim(aliasStr)
+	// pad before coloring, the escape codes would throw off the alignment
+	usageStr := fmt.Sprintf("%-*s", maxScriptNameLen, entry.Usage)
+	if f.Color.Enabled && len(entry.Usage) <= len(usageStr) {
+		usageStr = f.Color.Name(entry.Usage) + usageStr[len(entry.Usage):]
+	}
+	if entry.ShortText != "" {
+		shortText := entry.ShortText
+		if len(shortText) > maxShortTextLen {
+			shortText = shortText[0:maxShortTextLen-3] + "..."
+		}
+		fmt.Fprintf(w, "%s%s - %s%s\n", indent, usageStr, shortText, aliasStr)
+	} else {
+		fmt.Fprintf(w, "%s%s%s\n", indent, usageStr, aliasStr)
+	}
+}
+
+// a single JSON array with the commands from all of the playbooks
+type jsonListFormatter struct{}
+
+func (jsonListFormatter) WriteList(w io.Writer, listings []PlaybookListing) error {
+	entries := []CommandEntry{}
+	for _, listing := range listings {
+		entries = append(entries, listing.Commands...)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !windows
+// +build !windows
+
+package pathutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// takes an exclusive flock on the directory (blocks until it is available)
+func lockDir(dirName string) (func(), error) {
+	fd, err := os.Open(dirName)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+		fd.Close()
+	}, nil
+}
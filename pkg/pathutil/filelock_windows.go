@@ -0,0 +1,15 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build windows
+// +build windows
+
+package pathutil
+
+// directories cannot be locked on windows, updates are still atomic (temp file + rename)
+func lockDir(dirName string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pathutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// reads fileName, passes its contents to modifyFn, and atomically replaces the file with the
+// result (written to a temp file in the same directory and renamed over the original).  an
+// exclusive lock on the file's directory is held for the whole read-modify-write so concurrent
+// updates (e.g. two 'add' commands) do not lose each other's changes.  symlinks are followed so
+// the link target is updated.  if modifyFn returns an error the file is not modified
+func UpdateFileLocked(fileName string, modifyFn func(data []byte) ([]byte, error)) error {
+	realName, err := filepath.EvalSymlinks(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot resolve '%s': %w", fileName, err)
+	}
+	dirName := filepath.Dir(realName)
+	unlockFn, err := lockDir(dirName)
+	if err != nil {
+		return fmt.Errorf("cannot lock directory '%s': %w", dirName, err)
+	}
+	defer unlockFn()
+	finfo, err := os.Stat(realName)
+	if err != nil {
+		return fmt.Errorf("cannot stat '%s': %w", fileName, err)
+	}
+	// the rename only needs directory permissions, do not replace files we could not write to
+	wfd, err := os.OpenFile(realName, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open '%s' for writing: %w", fileName, err)
+	}
+	wfd.Close()
+	data, err := os.ReadFile(realName)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %w", fileName, err)
+	}
+	newData, err := modifyFn(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(realName, newData, finfo.Mode().Perm())
+}
+
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) (rtnErr error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("cannot create temp file for '%s': %w", fileName, err)
+	}
+	tmpName := tmpFile.Name()
+	defer func() {
+		if rtnErr != nil {
+			tmpFile.Close()
+			os.Remove(tmpName)
+		}
+	}()
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		return fmt.Errorf("cannot write '%s': %w", tmpName, err)
+	}
+	err = tmpFile.Chmod(perm)
+	if err != nil {
+		return fmt.Errorf("cannot set permissions on '%s': %w", tmpName, err)
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot sync '%s': %w", tmpName, err)
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close '%s': %w", tmpName, err)
+	}
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		return fmt.Errorf("cannot replace '%s': %w", fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pathutil
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const GitIgnoreFileName = ".gitignore"
+
+// a single .gitignore rule
+type ignoreRule struct {
+	BaseDir string // directory of the .gitignore file (relative to the walk root, "" for the root)
+	Negate  bool
+	DirOnly bool
+	Re      *regexp.Regexp
+}
+
+// converts a gitignore glob to a regexp matching a path relative to the .gitignore's directory
+func globToRegexp(glob string, anchored bool) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+	if !anchored {
+		buf.WriteString("(?:.*/)?")
+	}
+	for idx := 0; idx < len(glob); idx++ {
+		ch := glob[idx]
+		if strings.HasPrefix(glob[idx:], "**/") {
+			buf.WriteString("(?:.*/)?")
+			idx += 2
+		} else if strings.HasPrefix(glob[idx:], "/**") && idx+3 == len(glob) {
+			buf.WriteString("(?:/.*)?")
+			idx += 2
+		} else if strings.HasPrefix(glob[idx:], "**") {
+			buf.WriteString(".*")
+			idx++
+		} else if ch == '*' {
+			buf.WriteString("[^/]*")
+		} else if ch == '?' {
+			buf.WriteString("[^/]")
+		} else if ch == '[' {
+			endIdx := strings.IndexByte(glob[idx+1:], ']')
+			if endIdx == -1 {
+				buf.WriteString("\\[")
+				continue
+			}
+			class := glob[idx+1 : idx+1+endIdx]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			idx += endIdx + 1
+		} else if ch == '\\' && idx+1 < len(glob) {
+			idx++
+			buf.WriteString(regexp.QuoteMeta(string(glob[idx])))
+		} else {
+			buf.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// parses the contents of a .gitignore file.  baseDir is the directory of the file relative to the walk root.
+// invalid patterns are skipped
+func parseGitIgnore(data []byte, baseDir string) []ignoreRule {
+	var rtn []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{BaseDir: baseDir}
+		if strings.HasPrefix(line, "!") {
+			rule.Negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.DirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		re, err := globToRegexp(line, anchored)
+		if err != nil {
+			continue
+		}
+		rule.Re = re
+		rtn = append(rtn, rule)
+	}
+	return rtn
+}
+
+// relPath is relative to the walk root.  the last matching rule wins
+func isIgnored(rules []ignoreRule, relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.DirOnly && !isDir {
+			continue
+		}
+		matchPath := relPath
+		if rule.BaseDir != "" {
+			if !strings.HasPrefix(relPath, rule.BaseDir+"/") {
+				continue
+			}
+			matchPath = relPath[len(rule.BaseDir)+1:]
+		}
+		if rule.Re.MatchString(matchPath) {
+			ignored = !rule.Negate
+		}
+	}
+	return ignored
+}
+
+// walks rootDir (respecting .gitignore files, skipping .git directories) and returns the
+// paths (relative to rootDir, sorted) of every .md file that contains scripthaus directives.
+// if recursive is false only the files directly in rootDir are returned
+func FindPlaybookFiles(rootDir string, recursive bool) ([]string, error) {
+	var rtn []string
+	err := findPlaybookFilesInDir(rootDir, "", nil, recursive, &rtn)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rtn)
+	return rtn, nil
+}
+
+func findPlaybookFilesInDir(rootDir string, relDir string, rules []ignoreRule, recursive bool, rtn *[]string) error {
+	dirName := path.Join(rootDir, relDir)
+	found, ignoreData, err := TryReadFile(path.Join(dirName, GitIgnoreFileName), "gitignore file", true)
+	if err != nil {
+		return err
+	}
+	if found {
+		// copy so sibling directories do not share rules
+		rules = append(append([]ignoreRule{}, rules...), parseGitIgnore(ignoreData, relDir)...)
+	}
+	entries, err := os.ReadDir(dirName)
+	if err != nil {
+		if relDir != "" && os.IsPermission(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		relPath := path.Join(relDir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// do not follow symlinked directories (avoids cycles)
+			continue
+		}
+		if isDir && entry.Name() == ".git" {
+			continue
+		}
+		if isIgnored(rules, relPath, isDir) {
+			continue
+		}
+		if isDir {
+			if !recursive {
+				continue
+			}
+			err = findPlaybookFilesInDir(rootDir, relPath, rules, recursive, rtn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".md") {
+			continue
+		}
+		found, data, err := TryReadFile(path.Join(rootDir, relPath), "playbook", true)
+		if err != nil || !found {
+			continue
+		}
+		if bytes.Contains(data, []byte("@scripthaus")) || bytes.Contains(data, []byte("autoname:")) {
+			*rtn = append(*rtn, relPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pathutil
+
+import (
+	"testing"
+)
+
+func TestGitIgnore(t *testing.T) {
+	rules := parseGitIgnore([]byte("# comment\nnode_modules/\n*.log\n/build\ndocs/**/gen\n!keep.log\n"), "")
+	rules = append(rules, parseGitIgnore([]byte("tmp\n"), "sub")...)
+	checkIgnored := func(relPath string, isDir bool, expected bool) {
+		if isIgnored(rules, relPath, isDir) != expected {
+			t.Errorf("isIgnored(%s, %v) expected %v", relPath, isDir, expected)
+		}
+	}
+	checkIgnored("node_modules", true, true)
+	checkIgnored("a/node_modules", true, true)
+	checkIgnored("node_modules", false, false)
+	checkIgnored("x.log", false, true)
+	checkIgnored("a/b/x.log", false, true)
+	checkIgnored("keep.log", false, false)
+	checkIgnored("build", true, true)
+	checkIgnored("a/build", true, false)
+	checkIgnored("docs/gen", true, true)
+	checkIgnored("docs/a/b/gen", true, true)
+	checkIgnored("sub/tmp", true, true)
+	checkIgnored("tmp", true, false)
+	checkIgnored("README.md", false, false)
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package pathutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// scripthaus.lock (next to the project's scripthaus.md) pins the registry playbooks the project
+// uses.  a pinned "@team/name" resolves to the pinned copy, which is stored by its sha256 in
+// $SCRIPTHAUS_HOME/registry/.locked (so projects can pin different versions)
+const LockFileName = "scripthaus.lock"
+const lockedDirName = ".locked"
+
+var sha256HexRe = regexp.MustCompile("^[0-9a-f]{64}$")
+
+type LockEntry struct {
+	Revision string `json:"revision,omitempty"` // git commit of the registry (git registries only)
+	Sha256   string `json:"sha256"`
+}
+
+type Lockfile struct {
+	Playbooks map[string]LockEntry `json:"playbooks"`
+}
+
+// returns the lock file name for the current project ("" if there is no project)
+func (r Resolver) ProjectLockFile() string {
+	rootDir, err := r.FindPrefixDir(".")
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(rootDir, LockFileName)
+}
+
+// a missing lock file returns an empty lockfile
+func (r Resolver) ReadLockfile(fileName string) (*Lockfile, error) {
+	rtn := &Lockfile{Playbooks: make(map[string]LockEntry)}
+	found, err := r.tryFindFile(fileName, "lock file", false)
+	if err != nil || !found {
+		return rtn, err
+	}
+	_, data, err := TryReadFile(fileName, "lock file", false)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, rtn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse lock file '%s': %w", fileName, err)
+	}
+	if rtn.Playbooks == nil {
+		rtn.Playbooks = make(map[string]LockEntry)
+	}
+	return rtn, nil
+}
+
+func WriteLockfile(fileName string, lockfile *Lockfile) error {
+	data, err := json.MarshalIndent(lockfile, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fileName, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write lock file '%s': %w", fileName, err)
+	}
+	return nil
+}
+
+// the pinned names (sorted)
+func (lockfile *Lockfile) Names() []string {
+	var rtn []string
+	for name := range lockfile.Playbooks {
+		rtn = append(rtn, name)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// the stored copy of a pinned playbook
+func LockedPlaybookFile(scHomeDir string, sha256Hex string) string {
+	return filepath.Join(scHomeDir, RegistryDirName, lockedDirName, sha256Hex+".md")
+}
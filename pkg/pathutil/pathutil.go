@@ -13,22 +13,47 @@ import (
 	"io"
 	"io/fs"
 	"os"
-	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/scripthaus-dev/scripthaus/pkg/base"
 )
 
 var DefaultScFile = "scripthaus.md"
+
+// fetched registry playbooks ("@team/name") are in $SCRIPTHAUS_HOME/registry/[team]/[name].md
+const RegistryDirName = "registry"
+
 var dotPrefixRe = regexp.MustCompile("^([.]+)[a-zA-Z_]")
 
 type ResolvedPlaybook struct {
-	OrigName      string // the name passed by the user
-	CanonicalName string // canonicalized name (for history)
-	ResolvedFile  string // the absolute resolved file name of playbook
-	ProjectDir    string // if this is a project playbook, this is the project directory
-	ProjectName   string // if this is a project playbook, this is the project name (unused right now)
+	OrigName      string          // the name passed by the user
+	CanonicalName string          // canonicalized name (for history)
+	ResolvedFile  string          // the absolute resolved file name of playbook
+	ProjectDir    string          // if this is a project playbook, this is the project directory
+	ProjectName   string          // if this is a project playbook, this is the project name (unused right now)
+	Config        *PlaybookConfig // playbook-wide defaults (set by the parser, can be nil)
+}
+
+// playbook-wide defaults that apply to every command in the playbook (from the front matter
+// or a ```scripthaus config block)
+type PlaybookConfig struct {
+	Env            []string // VAR=VAL entries
+	ChangeDir      string   // same values as the 'cd' directive
+	Shell          string   // shell used to run 'sh' blocks
+	Tags           []string
+	DefaultCommand string            // command to run when none is specified
+	AutoName       string            // "headings" names un-annotated blocks after their level-4 heading
+	LangAliases    map[string]string // fence language => "[scripttype] [options]", over config.json's lang_aliases
+	Includes       []IncludeRef
+}
+
+type IncludeRef struct {
+	Path   string // as written in the playbook
+	LineNo int
 }
 
 func (pb *ResolvedPlaybook) OrigShowStr() string {
@@ -42,7 +67,7 @@ func (pb *ResolvedPlaybook) PlaybookDir() string {
 	if pb.CanonicalName == "-" {
 		return ""
 	}
-	return path.Dir(pb.ResolvedFile)
+	return filepath.Dir(pb.ResolvedFile)
 }
 
 // sets overrides for testing
@@ -53,6 +78,7 @@ type Resolver struct {
 	TestFiles       []string
 	TestDirs        []string
 	TestBadPermDirs []string
+	ScPath          string
 }
 
 type resolveStatInfo struct {
@@ -68,21 +94,21 @@ func (r Resolver) statInfo(fileName string) (resolveStatInfo, error) {
 		}
 		return resolveStatInfo{IsDir: finfo.IsDir()}, nil
 	} else {
-		if !strings.HasPrefix(fileName, "/") {
-			fileName = path.Join(r.Cwd, fileName)
+		if !filepath.IsAbs(fileName) {
+			fileName = filepath.Join(r.Cwd, fileName)
 		}
 		if inSlice(fileName, r.TestFiles) {
 			return resolveStatInfo{}, nil
 		}
-		if len(fileName) > 1 && strings.HasSuffix(fileName, "/") {
+		if len(fileName) > 1 && hasTrailingSep(fileName) {
 			fileName = fileName[:len(fileName)-1]
 		}
 		if inSlice(fileName, r.TestDirs) {
 			return resolveStatInfo{IsDir: true}, nil
 		}
-		baseName := path.Base(fileName)
+		baseName := filepath.Base(fileName)
 		for _, badDir := range r.TestBadPermDirs {
-			if fileName == badDir || path.Join(badDir, baseName) == fileName {
+			if fileName == badDir || filepath.Join(badDir, baseName) == fileName {
 				return resolveStatInfo{}, fs.ErrPermission
 			}
 		}
@@ -113,6 +139,89 @@ func (r Resolver) GetScHomeDir() (string, error) {
 	return GetScHomeDir()
 }
 
+// used when SCRIPTHAUS_PATH is not set, the project playbook then the global playbook
+const DefaultScPath = "." + string(filepath.ListSeparator) + "^"
+
+// returns the SCRIPTHAUS_PATH entries (":" separated, ";" on windows), isDefault is true when SCRIPTHAUS_PATH is not set
+func (r Resolver) GetScPath() ([]string, bool) {
+	scPath := r.ScPath
+	if scPath == "" {
+		scPath = os.Getenv(base.ScPathVarName)
+	}
+	isDefault := false
+	if scPath == "" {
+		scPath = DefaultScPath
+		isDefault = true
+	}
+	var rtn []string
+	for _, entry := range filepath.SplitList(scPath) {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			rtn = append(rtn, entry)
+		}
+	}
+	return rtn, isDefault
+}
+
+// resolves the playbooks on SCRIPTHAUS_PATH in order (duplicates removed).  entries can be
+// any playbook name (".", "^", a directory, or an .md file).  returns (playbooks, warnings),
+// entries that cannot be resolved are skipped (only warned about if SCRIPTHAUS_PATH is set)
+func (r Resolver) ResolvePathPlaybooks() ([]*ResolvedPlaybook, []string) {
+	var rtn []*ResolvedPlaybook
+	var warnings []string
+	entries, isDefault := r.GetScPath()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry == "-" {
+			warnings = append(warnings, fmt.Sprintf("%s entry '-' (<stdin>) is not allowed, skipping", base.ScPathVarName))
+			continue
+		}
+		rpb, err := r.ResolvePlaybook(entry)
+		if err != nil {
+			if !isDefault {
+				warnings = append(warnings, fmt.Sprintf("%s entry '%s': %v", base.ScPathVarName, entry, err))
+			}
+			continue
+		}
+		if seen[rpb.ResolvedFile] {
+			continue
+		}
+		seen[rpb.ResolvedFile] = true
+		rtn = append(rtn, rpb)
+	}
+	return rtn, warnings
+}
+
+// every playbook that can be run from the current directory: the SCRIPTHAUS_PATH playbooks (in
+// order, the precedence for bare command names), then the project chain (".", "..", "...", etc.)
+// and the global playbook "^" if they are not on SCRIPTHAUS_PATH.  returns (playbooks, numPath,
+// warnings), the first numPath playbooks are from SCRIPTHAUS_PATH
+func (r Resolver) ResolveEverywherePlaybooks() ([]*ResolvedPlaybook, int, []string) {
+	rtn, warnings := r.ResolvePathPlaybooks()
+	numPath := len(rtn)
+	seen := make(map[string]bool)
+	for _, rpb := range rtn {
+		seen[rpb.ResolvedFile] = true
+	}
+	var names []string
+	for prefix := "."; ; prefix += "." {
+		if _, err := r.FindPrefixDir(prefix); err != nil {
+			break
+		}
+		names = append(names, prefix)
+	}
+	names = append(names, "^")
+	for _, name := range names {
+		rpb, err := r.ResolvePlaybook(name)
+		if err != nil || seen[rpb.ResolvedFile] {
+			continue
+		}
+		seen[rpb.ResolvedFile] = true
+		rtn = append(rtn, rpb)
+	}
+	return rtn, numPath, warnings
+}
+
 func DefaultResolver() Resolver {
 	return Resolver{}
 }
@@ -133,7 +242,7 @@ func SplitScriptName(scriptName string) (string, string, error) {
 		fields := strings.SplitN(scriptName, "::", 2)
 		return fields[0], fields[1], nil
 	}
-	if strings.HasSuffix(scriptName, ".md") {
+	if strings.HasSuffix(scriptName, ".md") || strings.HasPrefix(scriptName, "@") {
 		return scriptName, "", nil
 	}
 	if strings.HasPrefix(scriptName, "^") {
@@ -149,16 +258,37 @@ func SplitScriptName(scriptName string) (string, string, error) {
 	return "", scriptName, nil
 }
 
+// true for a trailing "/" (or "\\" on windows)
+func hasTrailingSep(name string) bool {
+	return strings.HasSuffix(name, "/") || strings.HasSuffix(name, string(filepath.Separator))
+}
+
+// true if name is an absolute path, or starts with "./" or "../" (or ".\\" and "..\\" on windows)
+func isPathName(name string) bool {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return true
+	}
+	for _, prefix := range []string{".", ".."} {
+		if strings.HasPrefix(name, prefix+"/") || strings.HasPrefix(name, prefix+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // return parent directory, dir should be absolute, returns "" when no more parents
 func parentDir(dirName string) string {
 	// dir must be absolute
-	if dirName == "" || dirName == "/" || !strings.HasPrefix(dirName, "/") {
+	if dirName == "" || !filepath.IsAbs(dirName) {
 		return ""
 	}
-	if len(dirName) > 1 && strings.HasSuffix(dirName, "/") {
-		dirName = dirName[:len(dirName)-1]
+	dirName = filepath.Clean(dirName)
+	parent := filepath.Dir(dirName)
+	if parent == dirName {
+		// the root ("/" or a volume root like "C:\\")
+		return ""
 	}
-	return path.Dir(dirName)
+	return parent
 }
 
 func (r Resolver) findScRootDir(curDir string, allowCurrent bool) (string, error) {
@@ -166,7 +296,7 @@ func (r Resolver) findScRootDir(curDir string, allowCurrent bool) (string, error
 		curDir = parentDir(curDir)
 	}
 	for curDir != "" {
-		fileName := path.Join(curDir, DefaultScFile)
+		fileName := filepath.Join(curDir, DefaultScFile)
 		found, err := r.tryFindFile(fileName, "playbook", true)
 		if err != nil {
 			return "", err
@@ -182,13 +312,13 @@ func (r Resolver) findScRootDir(curDir string, allowCurrent bool) (string, error
 func (r Resolver) resolvePlaybookInDir(origName string, curDir string, playbookName string) (string, error) {
 	if playbookName == "" {
 		playbookName = DefaultScFile
-	} else if strings.HasSuffix(playbookName, "/") {
+	} else if hasTrailingSep(playbookName) {
 		playbookName = playbookName + DefaultScFile
 	}
-	fullPath := path.Join(curDir, playbookName)
+	fullPath := filepath.Join(curDir, playbookName)
 	finfo, err := r.statInfo(fullPath)
 	if err == nil && finfo.IsDir {
-		fullPath = path.Join(fullPath, DefaultScFile)
+		fullPath = filepath.Join(fullPath, DefaultScFile)
 		finfo, err = r.statInfo(fullPath)
 	}
 	displayName := origName
@@ -254,7 +384,7 @@ func (r Resolver) ResolvePlaybook(playbookName string) (*ResolvedPlaybook, error
 		}, nil
 	}
 	prefixMatch := base.PlaybookPrefixRe.FindStringSubmatch(playbookName)
-	if prefixMatch != nil {
+	if prefixMatch != nil && !isPathName(playbookName) {
 		// covers ^, [.]+, and also plain non-prefixed names
 		prefix := prefixMatch[1]
 		if prefix == "" {
@@ -262,7 +392,7 @@ func (r Resolver) ResolvePlaybook(playbookName string) (*ResolvedPlaybook, error
 			if err != nil {
 				return nil, fmt.Errorf("cannot get current working directory: %w", err)
 			}
-			fullPath := path.Join(curDir, playbookName)
+			fullPath := filepath.Join(curDir, playbookName)
 			found, err := r.tryFindFile(fullPath, "playbook", false)
 			if err != nil {
 				return nil, err
@@ -301,28 +431,27 @@ func (r Resolver) ResolvePlaybook(playbookName string) (*ResolvedPlaybook, error
 		}
 	}
 	if strings.HasPrefix(playbookName, "@") {
-		// future namespaces
-		return nil, fmt.Errorf("cannot resolve playbook '%s', @-prefix not supported", playbookName)
+		return r.resolveRegistryPlaybook(playbookName)
 	}
-	if strings.HasPrefix(playbookName, "./") || strings.HasPrefix(playbookName, "/") || strings.HasPrefix(playbookName, "../") {
+	if isPathName(playbookName) {
 		// absolute/relative path
 		var fullPath string
-		if strings.HasPrefix(playbookName, "/") {
+		if filepath.IsAbs(playbookName) {
 			fullPath = playbookName
 		} else {
 			curDir, err := r.Getwd()
 			if err != nil {
 				return nil, fmt.Errorf("cannot get current working directory: %w", err)
 			}
-			fullPath = path.Clean(path.Join(curDir, playbookName))
+			fullPath = filepath.Clean(filepath.Join(curDir, playbookName))
 		}
 		var resolvedFile string
 		var err error
-		if strings.HasSuffix(fullPath, "/") {
+		if hasTrailingSep(fullPath) {
 			resolvedFile, err = r.resolvePlaybookInDir(playbookName, fullPath, "")
 		} else {
-			dirName := path.Dir(fullPath)
-			baseName := path.Base(fullPath)
+			dirName := filepath.Dir(fullPath)
+			baseName := filepath.Base(fullPath)
 			resolvedFile, err = r.resolvePlaybookInDir(playbookName, dirName, baseName)
 		}
 		if err != nil {
@@ -337,21 +466,64 @@ func (r Resolver) ResolvePlaybook(playbookName string) (*ResolvedPlaybook, error
 	return nil, fmt.Errorf("invalid playbook name '%s'", playbookName)
 }
 
+// "@team/name", the pinned copy if the project's lock file pins it, otherwise the latest fetched copy
+func (r Resolver) resolveRegistryPlaybook(playbookName string) (*ResolvedPlaybook, error) {
+	m := base.RegistryNameRe.FindStringSubmatch(playbookName)
+	if m == nil {
+		return nil, fmt.Errorf("cannot resolve playbook '%s', registry playbooks must be @[team]/[name]", playbookName)
+	}
+	canonicalName := fmt.Sprintf("@%s/%s", m[1], m[2])
+	scHomeDir, err := r.GetScHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	fullPath := filepath.Join(scHomeDir, RegistryDirName, m[1], m[2]+".md")
+	notFetchedErr := fmt.Errorf("registry playbook '%s' has not been fetched (run 'scripthaus fetch %s')", playbookName, canonicalName)
+	if lockFileName := r.ProjectLockFile(); lockFileName != "" {
+		lockfile, err := r.ReadLockfile(lockFileName)
+		if err != nil {
+			return nil, err
+		}
+		if entry, ok := lockfile.Playbooks[canonicalName]; ok {
+			if !sha256HexRe.MatchString(entry.Sha256) {
+				return nil, fmt.Errorf("invalid sha256 for '%s' in lock file '%s'", canonicalName, lockFileName)
+			}
+			fullPath = LockedPlaybookFile(scHomeDir, entry.Sha256)
+			notFetchedErr = fmt.Errorf("registry playbook '%s' is pinned in '%s', but the pinned version has not been fetched (run 'scripthaus fetch')", playbookName, lockFileName)
+		}
+	}
+	found, err := r.tryFindFile(fullPath, "playbook", false)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, notFetchedErr
+	}
+	return &ResolvedPlaybook{
+		OrigName:      playbookName,
+		CanonicalName: canonicalName,
+		ResolvedFile:  fullPath,
+	}, nil
+}
+
 func GetScHomeDir() (string, error) {
 	scHome := os.Getenv(base.ScHomeVarName)
 	if scHome == "" {
 		homeVar := os.Getenv(base.HomeVarName)
+		if homeVar == "" && runtime.GOOS == "windows" {
+			homeVar = os.Getenv(base.WinHomeVarName)
+		}
 		if homeVar == "" {
 			return "", fmt.Errorf("Cannot resolve scripthaus home directory (SCRIPTHAUS_HOME and HOME not set)")
 		}
-		scHome = path.Join(homeVar, "scripthaus")
+		scHome = filepath.Join(homeVar, "scripthaus")
 	}
 	return scHome, nil
 }
 
 func (r Resolver) tryFindFiles(dirName string, names []string, fileType string, ignorePermissionErr bool) (bool, string, error) {
 	for _, fileName := range names {
-		fullName := path.Join(dirName, fileName)
+		fullName := filepath.Join(dirName, fileName)
 		found, err := r.tryFindFile(fullName, fileType, ignorePermissionErr)
 		if err != nil {
 			return false, "", err
@@ -429,8 +601,29 @@ func ReadShebangFromFile(fileName string) string {
 	return ReadShebang(data)
 }
 
-// returns (found, bytes, err)
+// the cached contents of stdin (see readStdin)
+var stdinOnce sync.Once
+var stdinBytes []byte
+var stdinErr error
+
+// stdin can only be read once, so it is read on the first call and later calls return the same bytes
+// (a playbook from stdin is read more than once, e.g. to resolve a command and then to show it)
+func readStdin() ([]byte, error) {
+	stdinOnce.Do(func() {
+		stdinBytes, stdinErr = io.ReadAll(os.Stdin)
+	})
+	return stdinBytes, stdinErr
+}
+
+// returns (found, bytes, err).  fullPath "-" reads from stdin
 func TryReadFile(fullPath string, fileType string, ignorePermissionErr bool) (bool, []byte, error) {
+	if fullPath == "-" {
+		rtnBytes, err := readStdin()
+		if err != nil {
+			return true, nil, fmt.Errorf("cannot read %s from <stdin>: %w", fileType, err)
+		}
+		return true, rtnBytes, nil
+	}
 	fd, err := os.Open(fullPath)
 	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil, nil
@@ -7,6 +7,7 @@
 package pathutil
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -25,6 +26,8 @@ func TestSplitScriptName(t *testing.T) {
 	trySplit(t, "..foo", "..", "foo")
 	trySplit(t, "hello", "", "hello")
 	trySplit(t, "@sawka::foo", "@sawka", "foo")
+	trySplit(t, "@team/ops::deploy", "@team/ops", "deploy")
+	trySplit(t, "@team/ops", "@team/ops", "")
 	trySplit(t, ".hello.md::test", ".hello.md", "test")
 	trySplit(t, "./foo.md::bar", "./foo.md", "bar")
 	trySplit(t, ".", ".", "")
@@ -63,6 +66,9 @@ func TestResolvePlaybook(t *testing.T) {
 			"/*test/home",
 			"/*test/home/scripthaus",
 			"/*test/home/scripthaus/alt",
+			"/*test/home/scripthaus/tools",
+			"/*test/home/scripthaus/registry",
+			"/*test/home/scripthaus/registry/team",
 			"/*test/home/project",
 			"/*test/home/project/subproject1",
 			"/*test/home/project/subproject2",
@@ -74,6 +80,8 @@ func TestResolvePlaybook(t *testing.T) {
 			"/*test/home/scripthaus/scripthaus.md",
 			"/*test/home/scripthaus/foo.md",
 			"/*test/home/scripthaus/alt/more.md",
+			"/*test/home/scripthaus/tools/scripthaus.md",
+			"/*test/home/scripthaus/registry/team/ops.md",
 			"/*test/home/project/scripthaus.md",
 			"/*test/home/project/p1.md",
 			"/*test/home/project/subproject1/scripthaus.md",
@@ -86,12 +94,18 @@ func TestResolvePlaybook(t *testing.T) {
 	tryResolve(t, resolver, "-", "-", false)
 	tryResolve(t, resolver, "^", "/*test/home/scripthaus/scripthaus.md", false)
 	tryResolve(t, resolver, "^foo.md", "/*test/home/scripthaus/foo.md", false)
+	tryResolve(t, resolver, "^alt/more.md", "/*test/home/scripthaus/alt/more.md", false)
+	tryResolve(t, resolver, "^alt/", "", true)
+	tryResolve(t, resolver, "^tools/", "/*test/home/scripthaus/tools/scripthaus.md", false)
 	tryResolve(t, resolver, ".commands.md", "/*test/home/project/subproject1/commands.md", false)
 	tryResolve(t, resolver, ".commands.md", "/*test/home/project/subproject1/commands.md", false)
 	tryResolve(t, resolver, "foo.md", "/*test/home/project/subproject1/subdir2/foo.md", false)
 	tryResolve(t, resolver, "./foo.md", "/*test/home/project/subproject1/subdir2/foo.md", false)
 	tryResolve(t, resolver, "@sawka", "", true)
 	tryResolve(t, resolver, "@sawka/foo.md", "", true)
+	tryResolve(t, resolver, "@team/ops", "/*test/home/scripthaus/registry/team/ops.md", false)
+	tryResolve(t, resolver, "@team/ops.md", "/*test/home/scripthaus/registry/team/ops.md", false)
+	tryResolve(t, resolver, "@team/ops/more", "", true)
 	tryResolve(t, resolver, "..", "/*test/home/project/scripthaus.md", false)
 	tryResolve(t, resolver, "..p1.md", "/*test/home/project/p1.md", false)
 	tryResolve(t, resolver, "..subproject2/more-commands.md", "/*test/home/project/subproject2/more-commands.md", false)
@@ -104,3 +118,97 @@ func TestResolvePlaybook(t *testing.T) {
 	tryResolve(t, resolver, "*foo.md", "", true)
 	tryResolve(t, resolver, "foo.py", "", true)
 }
+
+func TestResolvePathPlaybooks(t *testing.T) {
+	resolver := Resolver{
+		TestMode:  true,
+		Cwd:       "/*test/home/project/subdir",
+		ScHomeDir: "/*test/home/scripthaus",
+		TestDirs: []string{
+			"/*test/home",
+			"/*test/home/scripthaus",
+			"/*test/home/project",
+			"/*test/home/project/subdir",
+			"/*test/home/other",
+		},
+		TestBadPermDirs: []string{"/", "/*test"},
+		TestFiles: []string{
+			"/*test/home/scripthaus/scripthaus.md",
+			"/*test/home/project/scripthaus.md",
+			"/*test/home/other/scripthaus.md",
+			"/*test/home/other/tools.md",
+		},
+	}
+	checkPath := func(scPath string, expected []string, numWarnings int) {
+		resolver.ScPath = scPath
+		playbooks, warnings := resolver.ResolvePathPlaybooks()
+		var files []string
+		for _, pb := range playbooks {
+			files = append(files, pb.ResolvedFile)
+		}
+		if strings.Join(files, ":") != strings.Join(expected, ":") {
+			t.Errorf("path '%s', expected %v, got %v", scPath, expected, files)
+		}
+		if len(warnings) != numWarnings {
+			t.Errorf("path '%s', expected %d warnings, got %v", scPath, numWarnings, warnings)
+		}
+	}
+	checkPath("", []string{"/*test/home/project/scripthaus.md", "/*test/home/scripthaus/scripthaus.md"}, 0)
+	checkPath("^:/*test/home/other:/*test/home/other/tools.md", []string{"/*test/home/scripthaus/scripthaus.md", "/*test/home/other/scripthaus.md", "/*test/home/other/tools.md"}, 0)
+	checkPath(".:^:.", []string{"/*test/home/project/scripthaus.md", "/*test/home/scripthaus/scripthaus.md"}, 0)
+	checkPath("/*test/home/missing:^", []string{"/*test/home/scripthaus/scripthaus.md"}, 1)
+}
+
+func TestResolveEverywherePlaybooks(t *testing.T) {
+	resolver := Resolver{
+		TestMode:  true,
+		Cwd:       "/*test/home/project/sub",
+		ScHomeDir: "/*test/home/scripthaus",
+		ScPath:    "^:/*test/home/other/tools.md",
+		TestDirs: []string{
+			"/*test/home",
+			"/*test/home/scripthaus",
+			"/*test/home/project",
+			"/*test/home/project/sub",
+			"/*test/home/other",
+		},
+		TestBadPermDirs: []string{"/", "/*test"},
+		TestFiles: []string{
+			"/*test/home/scripthaus/scripthaus.md",
+			"/*test/home/project/scripthaus.md",
+			"/*test/home/project/sub/scripthaus.md",
+			"/*test/home/other/tools.md",
+		},
+	}
+	playbooks, numPath, warnings := resolver.ResolveEverywherePlaybooks()
+	var files []string
+	for _, pb := range playbooks {
+		files = append(files, pb.ResolvedFile)
+	}
+	expected := []string{"/*test/home/scripthaus/scripthaus.md", "/*test/home/other/tools.md", "/*test/home/project/sub/scripthaus.md", "/*test/home/project/scripthaus.md"}
+	if strings.Join(files, ":") != strings.Join(expected, ":") || numPath != 2 || len(warnings) != 0 {
+		t.Errorf("expected %v (2 on path), got %v (%d on path), warnings %v", expected, files, numPath, warnings)
+	}
+}
+
+func TestParentDir(t *testing.T) {
+	tests := map[string]string{
+		"/home/mike/proj":  "/home/mike",
+		"/home/mike/proj/": "/home/mike",
+		"/home":            "/",
+		"/":                "",
+		"":                 "",
+		"relative/dir":     "",
+	}
+	for dirName, expected := range tests {
+		if got := parentDir(dirName); got != expected {
+			t.Errorf("parentDir(%q) = %q, expected %q", dirName, got, expected)
+		}
+	}
+	if !isPathName("./test.md") || !isPathName("../test.md") || !isPathName("/home/test.md") {
+		t.Errorf("isPathName should be true for relative and absolute paths")
+	}
+	if isPathName("test.md") || isPathName(".test.md") || isPathName("^test.md") {
+		t.Errorf("isPathName should be false for playbook names")
+	}
+}
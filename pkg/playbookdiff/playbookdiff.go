@@ -0,0 +1,268 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// compares the commands of two versions of a playbook (used by 'scripthaus diff').  a removed
+// and an added command with the same script body and language are reported as a rename
+package playbookdiff
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeRenamed = "renamed"
+	ChangeChanged = "changed"
+)
+
+type CommandChange struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`              // the new name (the old name for removed commands)
+	OldName string   `json:"oldname,omitempty"` // set for renamed commands
+	Parts   []string `json:"parts,omitempty"`   // what changed, "script", "lang", "fence", "help", or a directive type
+}
+
+type Result struct {
+	Commands []CommandChange `json:"commands"`
+	Playbook []string        `json:"playbook,omitempty"` // front matter (or config block) settings that changed
+}
+
+func (r Result) IsEmpty() bool {
+	return len(r.Commands) == 0 && len(r.Playbook) == 0
+}
+
+// number of command changes of each type
+func (r Result) Counts() map[string]int {
+	rtn := make(map[string]int)
+	for _, change := range r.Commands {
+		rtn[change.Type]++
+	}
+	return rtn
+}
+
+// the first definition of each name (later ones are duplicates that do not run)
+func firstDefs(defs []commanddef.CommandDef) ([]*commanddef.CommandDef, map[string]*commanddef.CommandDef) {
+	var list []*commanddef.CommandDef
+	byName := make(map[string]*commanddef.CommandDef)
+	for idx := range defs {
+		cdef := &defs[idx]
+		if byName[cdef.Name] != nil {
+			continue
+		}
+		byName[cdef.Name] = cdef
+		list = append(list, cdef)
+	}
+	return list, byName
+}
+
+// directive type => data of each directive (in order).  'command' and 'continue' directives only
+// name the command, they are compared as the command name
+func directiveMap(cdef *commanddef.CommandDef) map[string][]string {
+	rtn := make(map[string][]string)
+	for _, dirs := range [][]commanddef.RawDirective{cdef.RawDirectives, cdef.SectionDirectives} {
+		for _, dir := range dirs {
+			if dir.Type == "command" || dir.Type == "continue" {
+				continue
+			}
+			rtn[dir.Type] = append(rtn[dir.Type], dir.Data)
+		}
+	}
+	return rtn
+}
+
+func fenceStr(info map[string]string) string {
+	var entries []string
+	for key, val := range info {
+		entries = append(entries, key+"="+val)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+// what differs between two versions of a command (nil if nothing does)
+func changedParts(oldDef *commanddef.CommandDef, newDef *commanddef.CommandDef) []string {
+	var rtn []string
+	if oldDef.ScriptBody() != newDef.ScriptBody() {
+		rtn = append(rtn, "script")
+	}
+	if oldDef.Lang != newDef.Lang {
+		rtn = append(rtn, "lang")
+	}
+	if fenceStr(oldDef.Info) != fenceStr(newDef.Info) {
+		rtn = append(rtn, "fence")
+	}
+	if strings.TrimSpace(oldDef.HelpText) != strings.TrimSpace(newDef.HelpText) {
+		rtn = append(rtn, "help")
+	}
+	oldDirs := directiveMap(oldDef)
+	newDirs := directiveMap(newDef)
+	var dirTypes []string
+	for dirType, data := range newDirs {
+		if !reflect.DeepEqual(data, oldDirs[dirType]) {
+			dirTypes = append(dirTypes, dirType)
+		}
+	}
+	for dirType := range oldDirs {
+		if _, found := newDirs[dirType]; !found {
+			dirTypes = append(dirTypes, dirType)
+		}
+	}
+	sort.Strings(dirTypes)
+	return append(rtn, dirTypes...)
+}
+
+// the playbook settings that differ (by their front matter names)
+func configChanges(oldConfig *pathutil.PlaybookConfig, newConfig *pathutil.PlaybookConfig) []string {
+	if oldConfig == nil {
+		oldConfig = &pathutil.PlaybookConfig{}
+	}
+	if newConfig == nil {
+		newConfig = &pathutil.PlaybookConfig{}
+	}
+	var oldIncludes, newIncludes []string
+	for _, inc := range oldConfig.Includes {
+		oldIncludes = append(oldIncludes, inc.Path)
+	}
+	for _, inc := range newConfig.Includes {
+		newIncludes = append(newIncludes, inc.Path)
+	}
+	fields := []struct {
+		name   string
+		oldVal interface{}
+		newVal interface{}
+	}{
+		{"env", oldConfig.Env, newConfig.Env},
+		{"cd", oldConfig.ChangeDir, newConfig.ChangeDir},
+		{"shell", oldConfig.Shell, newConfig.Shell},
+		{"tags", oldConfig.Tags, newConfig.Tags},
+		{"default_command", oldConfig.DefaultCommand, newConfig.DefaultCommand},
+		{"autoname", oldConfig.AutoName, newConfig.AutoName},
+		{"lang_aliases", oldConfig.LangAliases, newConfig.LangAliases},
+		{"include", oldIncludes, newIncludes},
+	}
+	var rtn []string
+	for _, field := range fields {
+		if !reflect.DeepEqual(normalizeEmpty(field.oldVal), normalizeEmpty(field.newVal)) {
+			rtn = append(rtn, field.name)
+		}
+	}
+	return rtn
+}
+
+// empty slices and maps compare equal to nil ones
+func normalizeEmpty(val interface{}) interface{} {
+	rval := reflect.ValueOf(val)
+	if (rval.Kind() == reflect.Slice || rval.Kind() == reflect.Map) && rval.Len() == 0 {
+		return nil
+	}
+	return val
+}
+
+// compares the commands (and playbook settings) of the old and new versions of a playbook.
+// changes are in the order of the new playbook, followed by the removed commands
+func Diff(oldDefs []commanddef.CommandDef, oldConfig *pathutil.PlaybookConfig, newDefs []commanddef.CommandDef, newConfig *pathutil.PlaybookConfig) Result {
+	var rtn Result
+	oldList, oldByName := firstDefs(oldDefs)
+	newList, newByName := firstDefs(newDefs)
+	var removed []*commanddef.CommandDef
+	for _, oldDef := range oldList {
+		if newByName[oldDef.Name] == nil {
+			removed = append(removed, oldDef)
+		}
+	}
+	renamedFrom := make(map[string]*commanddef.CommandDef)
+	for _, newDef := range newList {
+		if oldByName[newDef.Name] != nil || newDef.ScriptBody() == "" {
+			continue
+		}
+		for idx, oldDef := range removed {
+			if oldDef.ScriptBody() == newDef.ScriptBody() && oldDef.Lang == newDef.Lang {
+				renamedFrom[newDef.Name] = oldDef
+				removed = append(removed[:idx], removed[idx+1:]...)
+				break
+			}
+		}
+	}
+	for _, newDef := range newList {
+		if oldDef := oldByName[newDef.Name]; oldDef != nil {
+			if parts := changedParts(oldDef, newDef); len(parts) > 0 {
+				rtn.Commands = append(rtn.Commands, CommandChange{Type: ChangeChanged, Name: newDef.Name, Parts: parts})
+			}
+		} else if oldDef := renamedFrom[newDef.Name]; oldDef != nil {
+			rtn.Commands = append(rtn.Commands, CommandChange{Type: ChangeRenamed, Name: newDef.Name, OldName: oldDef.Name, Parts: changedParts(oldDef, newDef)})
+		} else {
+			rtn.Commands = append(rtn.Commands, CommandChange{Type: ChangeAdded, Name: newDef.Name})
+		}
+	}
+	for _, oldDef := range removed {
+		rtn.Commands = append(rtn.Commands, CommandChange{Type: ChangeRemoved, Name: oldDef.Name})
+	}
+	rtn.Playbook = configChanges(oldConfig, newConfig)
+	return rtn
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr == "" {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), errStr)
+	}
+	return output, nil
+}
+
+// returns the contents of fileName at the git revision (found is false if the file did not
+// exist at that revision).  errors if fileName is not in a git repository or rev is not a commit
+func ReadAtRevision(fileName string, rev string) (bool, []byte, error) {
+	dir := filepath.Dir(fileName)
+	topLevel, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return false, nil, fmt.Errorf("'%s' is not in a git repository", fileName)
+	}
+	_, err = runGit(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid git revision '%s'", rev)
+	}
+	// the toplevel is resolved by git (symlinks), so the file has to be as well
+	realFile, err := filepath.EvalSymlinks(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return false, nil, err
+	}
+	if err != nil {
+		realDir, _ := filepath.EvalSymlinks(dir)
+		realFile = filepath.Join(realDir, filepath.Base(fileName))
+	}
+	relPath, err := filepath.Rel(strings.TrimSpace(string(topLevel)), realFile)
+	if err != nil {
+		return false, nil, err
+	}
+	objName := rev + ":" + filepath.ToSlash(relPath)
+	if _, err = runGit(dir, "cat-file", "-e", objName); err != nil {
+		return false, nil, nil
+	}
+	data, err := runGit(dir, "show", objName)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, data, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package playbookdiff
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const oldPlaybook = "---\nenv:\n  STAGE: dev\n---\n\n" +
+	"```bash\n# @scripthaus command deploy-prod\n# @scripthaus env REGION=us\n./deploy.sh prod\n```\n\n" +
+	"```bash\n# @scripthaus command migrate\n./migrate.sh\n```\n\n" +
+	"```bash\n# @scripthaus command same\necho same\n```\n\n" +
+	"```bash\n# @scripthaus command old-backup\n./backup.sh\n```\n"
+
+const newPlaybook = "---\nenv:\n  STAGE: prod\n---\n\n" +
+	"```bash\n# @scripthaus command deploy\n# @scripthaus env REGION=eu\n./deploy.sh prod\n```\n\n" +
+	"```bash\n# @scripthaus command migrate\n# @scripthaus timeout 5m\n./migrate.sh --all\n```\n\n" +
+	"```bash\n# @scripthaus command same\necho same\n```\n\n" +
+	"```bash\n# @scripthaus command rollback\n./rollback.sh\n```\n"
+
+func parseTestPlaybook(t *testing.T, mdSource string) ([]commanddef.CommandDef, *pathutil.PlaybookConfig) {
+	playbook := &pathutil.ResolvedPlaybook{OrigName: "test.md", ResolvedFile: "/tmp/test.md"}
+	defs, _, err := mdparser.ParseCommands(playbook, []byte(mdSource))
+	if err != nil {
+		t.Fatalf("cannot parse playbook: %v", err)
+	}
+	return defs, playbook.Config
+}
+
+func TestDiff(t *testing.T) {
+	oldDefs, oldConfig := parseTestPlaybook(t, oldPlaybook)
+	newDefs, newConfig := parseTestPlaybook(t, newPlaybook)
+	result := Diff(oldDefs, oldConfig, newDefs, newConfig)
+	expected := []CommandChange{
+		{Type: ChangeRenamed, Name: "deploy", OldName: "deploy-prod", Parts: []string{"env"}},
+		{Type: ChangeChanged, Name: "migrate", Parts: []string{"script", "timeout"}},
+		{Type: ChangeAdded, Name: "rollback"},
+		{Type: ChangeRemoved, Name: "old-backup"},
+	}
+	if !reflect.DeepEqual(result.Commands, expected) {
+		t.Errorf("bad command changes:\n got %+v\nwant %+v", result.Commands, expected)
+	}
+	if !reflect.DeepEqual(result.Playbook, []string{"env"}) {
+		t.Errorf("bad playbook changes %v", result.Playbook)
+	}
+	same := Diff(newDefs, newConfig, newDefs, newConfig)
+	if !same.IsEmpty() {
+		t.Errorf("same playbook should have no changes, got %+v", same)
+	}
+	added := Diff(nil, nil, newDefs, newConfig)
+	if counts := added.Counts(); counts[ChangeAdded] != 4 || len(added.Playbook) != 1 {
+		t.Errorf("new playbook should have 4 added commands and env, got %+v", added)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// git-style external subcommands.  'scripthaus foo' runs a 'scripthaus-foo' executable
+// found on PATH (when foo is not a built-in command)
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const ExePrefix = "scripthaus-"
+
+// context passed to plugins
+const ProjectDirVarName = "SCRIPTHAUS_PROJECT_DIR"
+const PlaybookVarName = "SCRIPTHAUS_PLAYBOOK"
+const VersionVarName = "SCRIPTHAUS_VERSION"
+
+var pluginNameRe = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_-]*$")
+
+// returns the full path of the plugin executable, "" if not found
+func Find(name string) string {
+	if !pluginNameRe.MatchString(name) {
+		return ""
+	}
+	exePath, err := exec.LookPath(ExePrefix + name)
+	if err != nil {
+		return ""
+	}
+	return exePath
+}
+
+// names of the plugins on PATH (sorted)
+func List() []string {
+	seen := make(map[string]bool)
+	var rtn []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), ExePrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(entry.Name(), ExePrefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if seen[name] || Find(name) == "" {
+				continue
+			}
+			seen[name] = true
+			rtn = append(rtn, name)
+		}
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// the plugin's environment.  playbookFile is the global --playbook option (can be "").
+// context that cannot be resolved (e.g. no project root) is left unset
+func Env(playbookFile string) []string {
+	resolver := pathutil.DefaultResolver()
+	env := append(os.Environ(), VersionVarName+"="+base.ScriptHausVersion)
+	if scHome, err := resolver.GetScHomeDir(); err == nil {
+		env = append(env, base.ScHomeVarName+"="+scHome)
+	}
+	if projectDir, err := resolver.FindPrefixDir("."); err == nil {
+		env = append(env, ProjectDirVarName+"="+projectDir)
+	}
+	if playbookFile == "" {
+		playbookFile = "."
+	}
+	if playbook, err := resolver.ResolvePlaybook(playbookFile); err == nil {
+		env = append(env, PlaybookVarName+"="+playbook.ResolvedFile)
+	}
+	return env
+}
@@ -0,0 +1,368 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// a shared registry of playbooks for 'scripthaus publish' and 'scripthaus fetch'.  playbooks
+// are named "@[team]/[name]" and stored as "[team]/[name].md" in the registry.  the registry is
+// a git repository (publish and fetch) or an https url (fetch only, "[url]/[team]/[name].md").
+// fetched playbooks are saved to $SCRIPTHAUS_HOME/registry/[team]/[name].md, which is where
+// "@team/name" resolves (see pathutil.ResolvePlaybook), unless the project pins a version in
+// scripthaus.lock (see pathutil/lockfile.go)
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const fetchTimeout = 30 * time.Second
+const maxPlaybookSize = 10 * 1024 * 1024
+
+// the 'registry' setting in config.json
+type Config struct {
+	Git  string `json:"git,omitempty"`  // git repository url (publish and fetch)
+	Url  string `json:"url,omitempty"`  // https base url (fetch only)
+	Team string `json:"team,omitempty"` // default team for 'publish' (when no @team/name is given)
+
+	SignKey          string `json:"sign_key,omitempty"`          // ssh private key file, 'publish' signs playbooks with it
+	RequireSignature bool   `json:"require_signature,omitempty"` // 'fetch' rejects unsigned playbooks
+}
+
+type Name struct {
+	Team string
+	Name string
+}
+
+func (n Name) String() string {
+	return fmt.Sprintf("@%s/%s", n.Team, n.Name)
+}
+
+// the path of the playbook in the registry (and under $SCRIPTHAUS_HOME/registry)
+func (n Name) RelPath() string {
+	return n.Team + "/" + n.Name + ".md"
+}
+
+// parses "@team/name" (a trailing ".md" is allowed)
+func ParseName(name string) (Name, error) {
+	m := base.RegistryNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return Name{}, fmt.Errorf("invalid registry playbook name '%s', must be @[team]/[name]", name)
+	}
+	return Name{Team: m[1], Name: m[2]}, nil
+}
+
+func (cfg *Config) check() error {
+	if cfg == nil || (cfg.Git == "" && cfg.Url == "") {
+		return fmt.Errorf("no registry configured, set \"registry\": {\"git\": \"[repo-url]\"} (or \"url\") in $SCRIPTHAUS_HOME/config.json")
+	}
+	if cfg.Git != "" && cfg.Url != "" {
+		return fmt.Errorf("invalid registry config, set one of \"git\" or \"url\" (not both)")
+	}
+	if cfg.Url != "" && !strings.HasPrefix(cfg.Url, "https://") && !strings.HasPrefix(cfg.Url, "http://") {
+		return fmt.Errorf("invalid registry url '%s', must be an https:// url", cfg.Url)
+	}
+	return nil
+}
+
+// the registry's location (for messages)
+func (cfg *Config) Location() string {
+	if cfg.Git != "" {
+		return cfg.Git
+	}
+	return cfg.Url
+}
+
+// the local file for a fetched playbook
+func LocalFile(name Name) (string, error) {
+	scHome, err := pathutil.GetScHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(scHome, pathutil.RegistryDirName, filepath.FromSlash(name.RelPath())), nil
+}
+
+// a playbook downloaded from the registry
+type Fetched struct {
+	Name     Name
+	Data     []byte
+	Revision string // git commit of the registry ("" for url registries)
+	Sha256   string
+
+	Checksum  []byte // the published [name].md.sha256 (nil if there is none)
+	Signature []byte // the published [name].md.sig (nil if there is none)
+}
+
+func makeFetched(name Name, data []byte, revision string) *Fetched {
+	sum := sha256.Sum256(data)
+	return &Fetched{Name: name, Data: data, Revision: revision, Sha256: hex.EncodeToString(sum[:])}
+}
+
+func (f *Fetched) LockEntry() pathutil.LockEntry {
+	return pathutil.LockEntry{Revision: f.Revision, Sha256: f.Sha256}
+}
+
+// downloads the latest version of the playbook from the registry
+func Fetch(cfg *Config, name Name) (*Fetched, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	if cfg.Git != "" {
+		var rtn *Fetched
+		err := withClone(cfg.Git, true, func(cloneDir string) error {
+			fileName := filepath.Join(cloneDir, filepath.FromSlash(name.RelPath()))
+			data, err := readRegistryFile(fileName, name)
+			if err != nil {
+				return err
+			}
+			revision, err := runGit(cloneDir, "rev-parse", "HEAD")
+			if err != nil {
+				return err
+			}
+			rtn = makeFetched(name, data, revision)
+			rtn.Checksum, _ = os.ReadFile(fileName + ChecksumSuffix)
+			rtn.Signature, _ = os.ReadFile(fileName + SigSuffix)
+			return nil
+		})
+		return rtn, err
+	}
+	url := strings.TrimSuffix(cfg.Url, "/") + "/" + name.RelPath()
+	data, err := fetchUrl(url, name, false)
+	if err != nil {
+		return nil, err
+	}
+	rtn := makeFetched(name, data, "")
+	rtn.Checksum, err = fetchUrl(url+ChecksumSuffix, name, true)
+	if err != nil {
+		return nil, err
+	}
+	rtn.Signature, err = fetchUrl(url+SigSuffix, name, true)
+	if err != nil {
+		return nil, err
+	}
+	return rtn, nil
+}
+
+// downloads the pinned version of the playbook.  git registries check out the pinned revision,
+// url registries only serve the latest version so it must still match the pinned sha256
+func FetchPinned(cfg *Config, name Name, entry pathutil.LockEntry) (*Fetched, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	var rtn *Fetched
+	if cfg.Git != "" && entry.Revision != "" {
+		err := withClone(cfg.Git, false, func(cloneDir string) error {
+			data, err := runGitBytes(cloneDir, "show", entry.Revision+":"+name.RelPath())
+			if err != nil {
+				return fmt.Errorf("cannot find pinned revision %s of %s in the registry: %w", entry.Revision, name, err)
+			}
+			rtn = makeFetched(name, data, entry.Revision)
+			rtn.Checksum, _ = runGitBytes(cloneDir, "show", entry.Revision+":"+name.RelPath()+ChecksumSuffix)
+			rtn.Signature, _ = runGitBytes(cloneDir, "show", entry.Revision+":"+name.RelPath()+SigSuffix)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		rtn, err = Fetch(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rtn.Sha256 != entry.Sha256 {
+		return nil, fmt.Errorf("%s in the registry does not match the pinned sha256 in %s (run 'scripthaus update %s' to use the registry's version)", name, pathutil.LockFileName, name)
+	}
+	return rtn, nil
+}
+
+func readRegistryFile(fileName string, name Name) ([]byte, error) {
+	found, data, err := pathutil.TryReadFile(fileName, "playbook", false)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("playbook %s not found in the registry", name)
+	}
+	return data, nil
+}
+
+// optional files return nil (not an error) if they do not exist
+func fetchUrl(url string, name Name, optional bool) ([]byte, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancelFn()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry url: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && optional {
+		return nil, nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("playbook %s not found in the registry (%s)", name, url)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("cannot fetch %s: %s (%s)", name, resp.Status, url)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPlaybookSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch %s: %w", name, err)
+	}
+	if len(data) > maxPlaybookSize {
+		return nil, fmt.Errorf("cannot fetch %s: playbook is larger than %dMB", name, maxPlaybookSize/(1024*1024))
+	}
+	return data, nil
+}
+
+// adds (or updates) the playbook and its checksum (and signature if signKey is set) in a git
+// registry and pushes it.  returns false if the registry already has the same contents (nothing
+// is pushed)
+func Publish(cfg *Config, name Name, data []byte, signKey string) (bool, error) {
+	if err := cfg.check(); err != nil {
+		return false, err
+	}
+	if cfg.Git == "" {
+		return false, fmt.Errorf("cannot publish to registry url '%s' (read only), publishing requires a \"git\" registry", cfg.Url)
+	}
+	changed := false
+	err := withClone(cfg.Git, true, func(cloneDir string) error {
+		fileName := filepath.Join(cloneDir, filepath.FromSlash(name.RelPath()))
+		err := os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(fileName, data, 0644)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(fileName+ChecksumSuffix, []byte(ChecksumLine(makeFetched(name, data, ""))), 0644)
+		if err != nil {
+			return err
+		}
+		files := []string{name.RelPath(), name.RelPath() + ChecksumSuffix}
+		if signKey != "" {
+			if err = signFile(signKey, fileName); err != nil {
+				return err
+			}
+			files = append(files, name.RelPath()+SigSuffix)
+		} else if _, err := os.Stat(fileName + SigSuffix); err == nil {
+			// a stale signature would fail verification
+			if _, err = runGit(cloneDir, "rm", "-q", name.RelPath()+SigSuffix); err != nil {
+				return err
+			}
+		}
+		if _, err = runGit(cloneDir, append([]string{"add"}, files...)...); err != nil {
+			return err
+		}
+		// nothing to publish if the playbook, checksum, and signature are unchanged
+		status, err := runGit(cloneDir, "status", "--porcelain")
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			return nil
+		}
+		if _, err = runGit(cloneDir, "commit", "-q", "-m", "publish "+name.String()); err != nil {
+			return err
+		}
+		if _, err = runGit(cloneDir, "push", "-q", "origin", "HEAD"); err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// clones the repository into a temporary directory (removed after fn returns)
+func withClone(repoUrl string, shallow bool, fn func(cloneDir string) error) error {
+	tempDir, err := os.MkdirTemp("", "scripthaus-registry-")
+	if err != nil {
+		return fmt.Errorf("cannot create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	cloneDir := filepath.Join(tempDir, "registry")
+	cloneArgs := []string{"clone", "-q"}
+	if shallow {
+		cloneArgs = append(cloneArgs, "--depth", "1")
+	} else {
+		cloneArgs = append(cloneArgs, "--no-checkout")
+	}
+	if _, err = runGit(tempDir, append(cloneArgs, repoUrl, cloneDir)...); err != nil {
+		return err
+	}
+	return fn(cloneDir)
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	output, err := runGitBytes(dir, args...)
+	return strings.TrimSpace(string(output)), err
+}
+
+func runGitBytes(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr == "" {
+			errStr = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), errStr)
+	}
+	return output, nil
+}
+
+// saves a fetched playbook to $SCRIPTHAUS_HOME/registry as the latest version of the playbook
+// (and as a pinned copy if pin is true), returns the file name
+func SaveLocal(fetched *Fetched, pin bool) (string, error) {
+	fileName, err := LocalFile(fetched.Name)
+	if err != nil {
+		return "", err
+	}
+	if pin {
+		scHome, err := pathutil.GetScHomeDir()
+		if err != nil {
+			return "", err
+		}
+		fileName = pathutil.LockedPlaybookFile(scHome, fetched.Sha256)
+	}
+	err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return "", fmt.Errorf("cannot create registry directory: %w", err)
+	}
+	// write to a temp file and rename so a failed write never leaves a partial playbook
+	tempName := fileName + ".tmp"
+	err = os.WriteFile(tempName, fetched.Data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("cannot write playbook %s: %w", fileName, err)
+	}
+	err = os.Rename(tempName, fileName)
+	if err != nil {
+		os.Remove(tempName)
+		return "", fmt.Errorf("cannot write playbook %s: %w", fileName, err)
+	}
+	return fileName, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+// checksums and signatures.  publish writes [name].md.sha256 (sha256sum format) next to the
+// playbook and, with a signing key, [name].md.sig (an ssh signature, "ssh-keygen -Y sign -n
+// scripthaus").  fetch checks both when they exist, before the playbook is saved.  the key that
+// signs a team's playbooks is trusted on first use (saved in $SCRIPTHAUS_HOME/registry/
+// .trusted-keys.json), after that the team's playbooks must be signed by the same key
+
+const SigNamespace = "scripthaus"
+const ChecksumSuffix = ".sha256"
+const SigSuffix = ".sig"
+const trustedKeysFileName = ".trusted-keys.json"
+
+var sha256HexRe = regexp.MustCompile("^[0-9a-f]{64}$")
+var sshFingerprintRe = regexp.MustCompile(`SHA256:[A-Za-z0-9+/=]+`)
+
+type VerifyResult struct {
+	Checksum bool   // matched a published checksum
+	Signer   string // fingerprint of the signing key ("" if unsigned)
+	NewKey   bool   // the signer was not trusted before (trusted now)
+}
+
+// the sha256 from a sha256sum style line ("[hex]  [file]")
+func ParseChecksum(data []byte) (string, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !sha256HexRe.MatchString(strings.ToLower(fields[0])) {
+		return "", fmt.Errorf("invalid sha256 checksum")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func ChecksumLine(fetched *Fetched) string {
+	return fmt.Sprintf("%s  %s.md\n", fetched.Sha256, fetched.Name.Name)
+}
+
+// checks the published checksum and signature (and expectedSha256 if set).  a new signing key
+// is added to the trusted keys
+func Verify(cfg *Config, fetched *Fetched, expectedSha256 string) (VerifyResult, error) {
+	var rtn VerifyResult
+	if fetched.Checksum != nil {
+		sum, err := ParseChecksum(fetched.Checksum)
+		if err != nil {
+			return rtn, fmt.Errorf("%s%s: %w", fetched.Name, ChecksumSuffix, err)
+		}
+		if sum != fetched.Sha256 {
+			return rtn, fmt.Errorf("%s does not match its published checksum (expected sha256 %s, got %s)", fetched.Name, sum, fetched.Sha256)
+		}
+		rtn.Checksum = true
+	}
+	if expectedSha256 != "" && strings.ToLower(expectedSha256) != fetched.Sha256 {
+		return rtn, fmt.Errorf("%s does not match --sha256 (expected %s, got %s)", fetched.Name, expectedSha256, fetched.Sha256)
+	}
+	trustedFile, trusted, err := readTrustedKeys()
+	if err != nil {
+		return rtn, err
+	}
+	team := "@" + fetched.Name.Team
+	if fetched.Signature == nil {
+		if trusted[team] != "" {
+			return rtn, fmt.Errorf("%s is not signed, but %s playbooks were signed by %s before (see %s)", fetched.Name, team, trusted[team], trustedFile)
+		}
+		if cfg != nil && cfg.RequireSignature {
+			return rtn, fmt.Errorf("%s is not signed (the registry config has \"require_signature\")", fetched.Name)
+		}
+		return rtn, nil
+	}
+	rtn.Signer, err = checkSshSignature(fetched.Data, fetched.Signature)
+	if err != nil {
+		return rtn, fmt.Errorf("%s has a bad signature: %w", fetched.Name, err)
+	}
+	if trusted[team] == "" {
+		trusted[team] = rtn.Signer
+		rtn.NewKey = true
+		return rtn, writeTrustedKeys(trustedFile, trusted)
+	}
+	if trusted[team] != rtn.Signer {
+		return rtn, fmt.Errorf("%s is signed by %s, but %s playbooks are trusted from %s (if the key was rotated, remove it from %s)", fetched.Name, rtn.Signer, team, trusted[team], trustedFile)
+	}
+	return rtn, nil
+}
+
+// verifies the signature (without checking the signer), returns the signer's key fingerprint
+func checkSshSignature(data []byte, sig []byte) (string, error) {
+	sigFile, err := os.CreateTemp("", "scripthaus-sig-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create temp file: %w", err)
+	}
+	defer os.Remove(sigFile.Name())
+	_, err = sigFile.Write(sig)
+	sigFile.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot write temp file: %w", err)
+	}
+	cmd := exec.Command("ssh-keygen", "-Y", "check-novalidate", "-n", SigNamespace, "-s", sigFile.Name())
+	cmd.Stdin = bytes.NewReader(data)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ssh-keygen: %s", strings.TrimSpace(strings.ReplaceAll(string(output), "\n", " ")))
+	}
+	fingerprint := sshFingerprintRe.FindString(string(output))
+	if fingerprint == "" {
+		return "", fmt.Errorf("cannot find the key fingerprint in ssh-keygen output '%s'", strings.TrimSpace(string(output)))
+	}
+	return fingerprint, nil
+}
+
+// signs fileName with the ssh private key, writes fileName.sig
+func signFile(keyFile string, fileName string) error {
+	os.Remove(fileName + SigSuffix)
+	cmd := exec.Command("ssh-keygen", "-Y", "sign", "-q", "-n", SigNamespace, "-f", keyFile, fileName)
+	cmd.Stdin = os.Stdin // for the key's passphrase
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot sign with '%s': %s", keyFile, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// team ("@team") => key fingerprint
+func readTrustedKeys() (string, map[string]string, error) {
+	scHome, err := pathutil.GetScHomeDir()
+	if err != nil {
+		return "", nil, err
+	}
+	fileName := filepath.Join(scHome, pathutil.RegistryDirName, trustedKeysFileName)
+	rtn := make(map[string]string)
+	found, data, err := pathutil.TryReadFile(fileName, "trusted keys file", false)
+	if err != nil || !found {
+		return fileName, rtn, err
+	}
+	err = json.Unmarshal(data, &rtn)
+	if err != nil {
+		return fileName, nil, fmt.Errorf("cannot parse trusted keys file '%s': %w", fileName, err)
+	}
+	return fileName, rtn, nil
+}
+
+func writeTrustedKeys(fileName string, trusted map[string]string) error {
+	data, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fileName, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write trusted keys file '%s': %w", fileName, err)
+	}
+	return nil
+}
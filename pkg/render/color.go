@@ -0,0 +1,92 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package render
+
+import (
+	"fmt"
+	"os"
+)
+
+const ColorAuto = "auto"
+const ColorAlways = "always"
+const ColorNever = "never"
+
+var colorMode = ColorAuto
+
+// sets the global color mode (from --color), must be one of auto, always, or never
+func SetColorMode(mode string) error {
+	if mode != ColorAuto && mode != ColorAlways && mode != ColorNever {
+		return fmt.Errorf("invalid color mode '%s', must be one of: %s, %s, %s", mode, ColorAuto, ColorAlways, ColorNever)
+	}
+	colorMode = mode
+	return nil
+}
+
+// "always" and "never" are absolute, "auto" colors only terminals (and respects NO_COLOR)
+func ColorEnabled(fd *os.File) bool {
+	switch colorMode {
+	case ColorAlways:
+		return true
+
+	case ColorNever:
+		return false
+
+	default:
+		return IsTerminal(fd)
+	}
+}
+
+// applies the scripthaus color theme to output text.  the zero value does not color anything
+type Colorizer struct {
+	Enabled bool
+}
+
+func MakeColorizer(fd *os.File) Colorizer {
+	return Colorizer{Enabled: ColorEnabled(fd)}
+}
+
+func (c Colorizer) style(style string, text string) string {
+	if !c.Enabled || text == "" {
+		return text
+	}
+	return style + text + ansiReset
+}
+
+// command names
+func (c Colorizer) Name(text string) string {
+	return c.style(ansiBold+ansiCyan, text)
+}
+
+// playbook names and section headings
+func (c Colorizer) Heading(text string) string {
+	return c.style(ansiBold, text)
+}
+
+// secondary information (aliases, tags, timestamps, locations)
+func (c Colorizer) Dim(text string) string {
+	return c.style(ansiGray, text)
+}
+
+func (c Colorizer) Warning(text string) string {
+	return c.style(ansiYellow, text)
+}
+
+func (c Colorizer) Error(text string) string {
+	return c.style(ansiBold+ansiRed, text)
+}
+
+func (c Colorizer) Success(text string) string {
+	return c.style(ansiGreen, text)
+}
+
+// green for a zero exit code, red otherwise
+func (c Colorizer) ExitCode(exitCode int) string {
+	if exitCode == 0 {
+		return c.Success(fmt.Sprintf("%d", exitCode))
+	}
+	return c.Error(fmt.Sprintf("%d", exitCode))
+}
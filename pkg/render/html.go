@@ -0,0 +1,51 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package render
+
+import (
+	"html"
+	"strings"
+)
+
+// css for the classes used by CodeBlockHTML
+const HighlightCss = `pre.sh-code { background: #f6f8fa; padding: 12px; overflow-x: auto; border-radius: 6px; }
+pre.sh-code .sh-comment { color: #6a737d; }
+pre.sh-code .sh-directive { color: #a626a4; }
+pre.sh-code .sh-string { color: #22863a; }
+pre.sh-code .sh-keyword { color: #005cc5; font-weight: bold; }
+pre.sh-code .sh-var { color: #d73a49; }
+`
+
+func htmlStyle(kind string, text string) string {
+	if kind == tokPlain {
+		return html.EscapeString(text)
+	}
+	return "<span class=\"sh-" + kind + "\">" + html.EscapeString(text) + "</span>"
+}
+
+// renders a code block (without the fences) as an HTML <pre> with syntax highlighting for known languages
+func CodeBlockHTML(lang string, code string) string {
+	keywords := keywordsForLang(lang)
+	commentPrefix := commentPrefixForLang(lang)
+	isShell := isShellLang(lang)
+	var buf strings.Builder
+	buf.WriteString("<pre class=\"sh-code\"><code")
+	if lang != "" {
+		buf.WriteString(" class=\"language-" + html.EscapeString(lang) + "\"")
+	}
+	buf.WriteString(">")
+	for _, line := range strings.Split(strings.TrimRight(code, "\n"), "\n") {
+		if keywords == nil {
+			buf.WriteString(html.EscapeString(line))
+		} else {
+			buf.WriteString(highlightLine(line, keywords, commentPrefix, isShell, htmlStyle))
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("</code></pre>\n")
+	return buf.String()
+}
@@ -0,0 +1,271 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// renders playbook markdown with ANSI terminal styling (headings, emphasis,
+// inline code, lists, and syntax-highlighted code blocks)
+package render
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiRed       = "\x1b[31m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiBlue      = "\x1b[34m"
+	ansiMagenta   = "\x1b[35m"
+	ansiCyan      = "\x1b[36m"
+	ansiGray      = "\x1b[90m"
+)
+
+// true if fd is a terminal and NO_COLOR is not set
+func IsTerminal(fd *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	finfo, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+	return finfo.Mode()&os.ModeCharDevice != 0
+}
+
+var headingRe = regexp.MustCompile("^ {0,3}(#{1,6})\\s+(.*?)\\s*#*\\s*$")
+var listItemRe = regexp.MustCompile("^(\\s*)([*+-]|\\d+[.)])\\s+(.*)$")
+var quoteRe = regexp.MustCompile("^\\s*>\\s?(.*)$")
+var hruleRe = regexp.MustCompile("^ {0,3}((-\\s*){3,}|(\\*\\s*){3,}|(_\\s*){3,})$")
+var fenceRe = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^\\s`]*)")
+
+var inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+var boldRe = regexp.MustCompile("\\*\\*([^*]+)\\*\\*|__([^_]+)__")
+var italicRe = regexp.MustCompile("(^|[^*\\w])\\*([^*\\s][^*]*)\\*|(^|[^_\\w])_([^_\\s][^_]*)_")
+var linkRe = regexp.MustCompile("\\[([^\\]]+)\\]\\(([^)\\s]+)\\)")
+
+// styles inline markdown (code spans, bold, italic, links)
+func renderInline(text string) string {
+	// pull out code spans first so their contents are not styled
+	var codeSpans []string
+	text = inlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		codeSpans = append(codeSpans, m[1:len(m)-1])
+		return "\x00" + string(rune('0'+len(codeSpans)-1)) + "\x00"
+	})
+	text = linkRe.ReplaceAllString(text, ansiUnderline+"$1"+ansiReset+" "+ansiGray+"($2)"+ansiReset)
+	text = boldRe.ReplaceAllString(text, ansiBold+"$1$2"+ansiReset)
+	text = italicRe.ReplaceAllString(text, "$1$3"+ansiItalic+"$2$4"+ansiReset)
+	for idx, span := range codeSpans {
+		text = strings.Replace(text, "\x00"+string(rune('0'+idx))+"\x00", ansiCyan+span+ansiReset, 1)
+	}
+	return text
+}
+
+// renders markdown text for the terminal
+func Markdown(mdText string) string {
+	var buf strings.Builder
+	lines := strings.Split(mdText, "\n")
+	for idx := 0; idx < len(lines); idx++ {
+		line := strings.TrimRight(lines[idx], "\r")
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			var codeLines []string
+			fenceStr := m[1]
+			for idx++; idx < len(lines); idx++ {
+				codeLine := strings.TrimRight(lines[idx], "\r")
+				if strings.HasPrefix(strings.TrimSpace(codeLine), fenceStr) {
+					break
+				}
+				codeLines = append(codeLines, codeLine)
+			}
+			buf.WriteString(CodeBlock(m[2], strings.Join(codeLines, "\n")))
+			continue
+		}
+		if m := headingRe.FindStringSubmatch(line); m != nil {
+			style := ansiBold
+			if len(m[1]) <= 2 {
+				style = ansiBold + ansiUnderline
+			}
+			buf.WriteString(style + m[2] + ansiReset + "\n")
+			continue
+		}
+		if hruleRe.MatchString(line) {
+			buf.WriteString(ansiGray + strings.Repeat("─", 40) + ansiReset + "\n")
+			continue
+		}
+		if m := quoteRe.FindStringSubmatch(line); m != nil {
+			buf.WriteString(ansiGray + "│ " + ansiReset + ansiItalic + renderInline(m[1]) + ansiReset + "\n")
+			continue
+		}
+		if m := listItemRe.FindStringSubmatch(line); m != nil {
+			bullet := m[2]
+			if bullet == "*" || bullet == "-" || bullet == "+" {
+				bullet = "•"
+			}
+			buf.WriteString(m[1] + ansiYellow + bullet + ansiReset + " " + renderInline(m[3]) + "\n")
+			continue
+		}
+		buf.WriteString(renderInline(line) + "\n")
+	}
+	return strings.TrimRight(buf.String(), "\n") + "\n"
+}
+
+var shKeywords = []string{"if", "then", "else", "elif", "fi", "for", "while", "until", "do", "done", "case", "esac", "in", "function", "return", "export", "local", "set", "echo", "cd", "exit"}
+var pyKeywords = []string{"def", "class", "if", "elif", "else", "for", "while", "in", "import", "from", "as", "return", "with", "try", "except", "finally", "raise", "pass", "not", "and", "or", "is", "None", "True", "False", "lambda", "print"}
+var jsKeywords = []string{"function", "const", "let", "var", "if", "else", "for", "while", "return", "import", "from", "export", "async", "await", "new", "class", "try", "catch", "throw", "null", "undefined", "true", "false"}
+
+func isShellLang(lang string) bool {
+	switch lang {
+	case "sh", "bash", "zsh", "ksh", "tcsh", "fish", "console", "shell":
+		return true
+
+	default:
+		return false
+	}
+}
+
+func keywordsForLang(lang string) []string {
+	if isShellLang(lang) {
+		return shKeywords
+	}
+	switch lang {
+	case "python", "python2", "python3", "py":
+		return pyKeywords
+
+	case "js", "node", "javascript", "ts", "typescript":
+		return jsKeywords
+
+	default:
+		return nil
+	}
+}
+
+func commentPrefixForLang(lang string) string {
+	if keywordsForLang(lang) == nil {
+		return ""
+	}
+	if lang == "js" || lang == "node" || lang == "javascript" || lang == "ts" || lang == "typescript" {
+		return "//"
+	}
+	return "#"
+}
+
+var stringRe = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'[^']*'`)
+var wordRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+var varRe = regexp.MustCompile(`\$\{?[A-Za-z_][A-Za-z0-9_]*\}?|\$[0-9@*#?]`)
+
+// token kinds passed to a styleFn
+const (
+	tokPlain     = ""
+	tokComment   = "comment"
+	tokDirective = "directive"
+	tokString    = "string"
+	tokKeyword   = "keyword"
+	tokVar       = "var"
+)
+
+// styles a token of the given kind (the output format, e.g. ANSI or HTML)
+type styleFn func(kind string, text string) string
+
+var ansiTokColors = map[string]string{
+	tokComment:   ansiGray,
+	tokDirective: ansiMagenta,
+	tokString:    ansiGreen,
+	tokKeyword:   ansiBlue,
+	tokVar:       ansiRed,
+}
+
+func ansiStyle(kind string, text string) string {
+	if kind == tokPlain {
+		return text
+	}
+	return ansiTokColors[kind] + text + ansiReset
+}
+
+// highlights one line of code (comments, strings, keywords, shell variables)
+func highlightLine(line string, keywords []string, commentPrefix string, isShell bool, style styleFn) string {
+	trimmed := strings.TrimSpace(line)
+	if commentPrefix != "" && strings.HasPrefix(trimmed, commentPrefix) {
+		if strings.Contains(trimmed, "@scripthaus") {
+			return style(tokDirective, line)
+		}
+		return style(tokComment, line)
+	}
+	var buf strings.Builder
+	lastIdx := 0
+	for _, loc := range stringRe.FindAllStringIndex(line, -1) {
+		buf.WriteString(highlightWords(line[lastIdx:loc[0]], keywords, isShell, style))
+		buf.WriteString(style(tokString, line[loc[0]:loc[1]]))
+		lastIdx = loc[1]
+	}
+	buf.WriteString(highlightWords(line[lastIdx:], keywords, isShell, style))
+	return buf.String()
+}
+
+func highlightWords(text string, keywords []string, isShell bool, style styleFn) string {
+	var buf strings.Builder
+	lastIdx := 0
+	var tokLocs [][]int
+	if isShell {
+		tokLocs = varRe.FindAllStringIndex(text, -1)
+	}
+	for _, loc := range tokLocs {
+		buf.WriteString(highlightKeywords(text[lastIdx:loc[0]], keywords, style))
+		buf.WriteString(style(tokVar, text[loc[0]:loc[1]]))
+		lastIdx = loc[1]
+	}
+	buf.WriteString(highlightKeywords(text[lastIdx:], keywords, style))
+	return buf.String()
+}
+
+func highlightKeywords(text string, keywords []string, style styleFn) string {
+	var buf strings.Builder
+	lastIdx := 0
+	for _, loc := range wordRe.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		if !inList(word, keywords) {
+			continue
+		}
+		buf.WriteString(style(tokPlain, text[lastIdx:loc[0]]))
+		buf.WriteString(style(tokKeyword, word))
+		lastIdx = loc[1]
+	}
+	buf.WriteString(style(tokPlain, text[lastIdx:]))
+	return buf.String()
+}
+
+func inList(word string, list []string) bool {
+	for _, item := range list {
+		if word == item {
+			return true
+		}
+	}
+	return false
+}
+
+// renders a code block (without the fences) with syntax highlighting for known languages
+func CodeBlock(lang string, code string) string {
+	keywords := keywordsForLang(lang)
+	commentPrefix := commentPrefixForLang(lang)
+	isShell := isShellLang(lang)
+	var buf strings.Builder
+	if lang != "" {
+		buf.WriteString(ansiGray + "  ┌ " + lang + ansiReset + "\n")
+	}
+	for _, line := range strings.Split(strings.TrimRight(code, "\n"), "\n") {
+		buf.WriteString(ansiGray + "  │ " + ansiReset)
+		if keywords == nil {
+			buf.WriteString(line)
+		} else {
+			buf.WriteString(highlightLine(line, keywords, commentPrefix, isShell, ansiStyle))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,228 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// machine-readable run reports (JSON and JUnit XML) for CI, used by 'scripthaus run --report'
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const FormatJson = "json"
+const FormatJunit = "junit"
+
+func Formats() []string {
+	return []string{FormatJson, FormatJunit}
+}
+
+// a --report argument, [format]=[file]
+type Spec struct {
+	Format string
+	File   string
+}
+
+func ParseSpec(specStr string) (Spec, error) {
+	eqIdx := strings.Index(specStr, "=")
+	if eqIdx == -1 {
+		return Spec{}, fmt.Errorf("invalid report '%s', must be [format]=[file]", specStr)
+	}
+	spec := Spec{Format: specStr[:eqIdx], File: specStr[eqIdx+1:]}
+	if spec.Format == "junit-xml" || spec.Format == "xml" {
+		spec.Format = FormatJunit
+	}
+	if spec.Format != FormatJson && spec.Format != FormatJunit {
+		return Spec{}, fmt.Errorf("invalid report format '%s', must be one of: %s", spec.Format, strings.Join(Formats(), ", "))
+	}
+	if spec.File == "" {
+		return Spec{}, fmt.Errorf("invalid report '%s', no file given", specStr)
+	}
+	return spec, nil
+}
+
+// the result of running one command
+type Result struct {
+	Command    string    `json:"command"` // name passed to 'scripthaus run'
+	Name       string    `json:"name"`
+	Playbook   string    `json:"playbook"`
+	Args       []string  `json:"args"`
+	Matrix     string    `json:"matrix,omitempty"` // matrix cell ("env=dev,region=us") for matrix runs
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationms"`
+	ExitCode   int       `json:"exitcode"`
+	Error      string    `json:"error,omitempty"` // set if the command could not be run
+	OutputFile string    `json:"outputfile,omitempty"`
+	Warnings   []string  `json:"warnings"`
+}
+
+// the command name plus the matrix cell, e.g. "deploy[env=dev]"
+func (r Result) testName() string {
+	if r.Matrix == "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s[%s]", r.Name, r.Matrix)
+}
+
+func (r Result) Failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+type Report struct {
+	Version    string    `json:"scripthausversion"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationms"`
+	ExitCode   int       `json:"exitcode"` // 0 if every command succeeded
+	Results    []Result  `json:"results"`
+}
+
+func MakeReport(version string) *Report {
+	return &Report{Version: version, Start: time.Now(), Results: []Result{}}
+}
+
+func (rpt *Report) Add(result Result) {
+	if result.Args == nil {
+		result.Args = []string{}
+	}
+	if result.Warnings == nil {
+		result.Warnings = []string{}
+	}
+	rpt.Results = append(rpt.Results, result)
+}
+
+var unsafeFileCharsRe = regexp.MustCompile("[^a-zA-Z0-9_.-]+")
+
+// where to capture the output of a command, next to the report file (report.json -> report.build.log)
+func OutputFileName(reportFile string, cmdName string) string {
+	base := strings.TrimSuffix(reportFile, filepath.Ext(reportFile))
+	return fmt.Sprintf("%s.%s.log", base, unsafeFileCharsRe.ReplaceAllString(cmdName, "_"))
+}
+
+func (rpt *Report) finish() {
+	rpt.End = time.Now()
+	rpt.DurationMs = rpt.End.Sub(rpt.Start).Milliseconds()
+	rpt.ExitCode = 0
+	for _, result := range rpt.Results {
+		if result.Failed() {
+			rpt.ExitCode = 1
+		}
+	}
+}
+
+func (rpt *Report) Write(spec Spec) error {
+	rpt.finish()
+	var barr []byte
+	var err error
+	if spec.Format == FormatJunit {
+		barr, err = rpt.junitXml()
+	} else {
+		barr, err = json.MarshalIndent(rpt, "", "  ")
+		barr = append(barr, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode %s report: %w", spec.Format, err)
+	}
+	err = os.WriteFile(spec.File, barr, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write report '%s': %w", spec.File, err)
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitSecs(durationMs int64) string {
+	return fmt.Sprintf("%0.3f", float64(durationMs)/1000)
+}
+
+// one testsuite per playbook, one testcase per command.  the captured output is included as system-out
+func (rpt *Report) junitXml() ([]byte, error) {
+	top := junitTestSuites{Name: "scripthaus", Time: junitSecs(rpt.DurationMs)}
+	suiteIdx := make(map[string]int)
+	for _, result := range rpt.Results {
+		idx, found := suiteIdx[result.Playbook]
+		if !found {
+			idx = len(top.Suites)
+			suiteIdx[result.Playbook] = idx
+			top.Suites = append(top.Suites, junitTestSuite{Name: result.Playbook, Timestamp: result.Start.Format(time.RFC3339)})
+		}
+		suite := &top.Suites[idx]
+		tc := junitTestCase{Name: result.testName(), ClassName: result.Playbook, Time: junitSecs(result.DurationMs)}
+		if result.Error != "" {
+			tc.Error = &junitMessage{Message: result.Error, Type: "error", Text: strings.Join(result.Warnings, "\n")}
+			suite.Errors++
+		} else if result.ExitCode != 0 {
+			tc.Failure = &junitMessage{Message: fmt.Sprintf("exit code %d", result.ExitCode), Type: "exitcode", Text: strings.Join(result.Warnings, "\n")}
+			suite.Failures++
+		}
+		if result.OutputFile != "" {
+			output, err := os.ReadFile(result.OutputFile)
+			if err == nil {
+				tc.SystemOut = string(output)
+			}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for idx := range top.Suites {
+		suite := &top.Suites[idx]
+		var durationMs int64
+		for _, result := range rpt.Results {
+			if result.Playbook == suite.Name {
+				durationMs += result.DurationMs
+			}
+		}
+		suite.Time = junitSecs(durationMs)
+		top.Tests += suite.Tests
+		top.Failures += suite.Failures
+		top.Errors += suite.Errors
+	}
+	barr, err := xml.MarshalIndent(top, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(barr, '\n')...), nil
+}
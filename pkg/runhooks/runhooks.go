@@ -0,0 +1,87 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// user-level hooks from config.json ("pre_run" and "post_run"), shell commands that run
+// around every 'scripthaus run' with environment variables describing the command
+package runhooks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+)
+
+const PreRun = "pre_run"
+const PostRun = "post_run"
+
+const hookTimeout = 30 * time.Second
+
+type Outcome struct {
+	ExitCode   int
+	DurationMs int64
+	Error      string // set if the command could not be started
+}
+
+// the hook's environment (outcome is nil for pre_run)
+func makeEnv(hookName string, execItem *commanddef.ExecItem, outcome *Outcome) []string {
+	cdef := execItem.CmdDef
+	env := append(os.Environ(),
+		"SCRIPTHAUS_HOOK="+hookName,
+		"SCRIPTHAUS_COMMAND="+cdef.OrigScriptName(),
+		"SCRIPTHAUS_COMMAND_NAME="+cdef.Name,
+		"SCRIPTHAUS_PLAYBOOK="+cdef.Playbook.ResolvedFile,
+		"SCRIPTHAUS_LANG="+cdef.Lang,
+	)
+	if execItem.MatrixCell != "" {
+		env = append(env, "SCRIPTHAUS_MATRIX="+execItem.MatrixCell)
+	}
+	if execItem.HItem != nil && execItem.HItem.HistoryId != 0 {
+		env = append(env, "SCRIPTHAUS_HISTORY_ID="+strconv.FormatInt(execItem.HItem.HistoryId, 10))
+	}
+	if outcome != nil {
+		env = append(env,
+			"SCRIPTHAUS_EXIT_CODE="+strconv.Itoa(outcome.ExitCode),
+			"SCRIPTHAUS_DURATION_MS="+strconv.FormatInt(outcome.DurationMs, 10),
+		)
+		if outcome.Error != "" {
+			env = append(env, "SCRIPTHAUS_ERROR="+outcome.Error)
+		}
+	}
+	return env
+}
+
+// runs the hook's shell command (sh -c, or cmd /c on windows) and waits for it.  the hook's
+// output goes to stderr so it does not mix with the command's stdout.  hooks are killed after 30s
+func Run(hookName string, shellCmd string, execItem *commanddef.ExecItem, outcome *Outcome) error {
+	if shellCmd == "" {
+		return nil
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe", "/c", shellCmd)
+	} else {
+		cmd = exec.Command("sh", "-c", shellCmd)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = makeEnv(hookName, execItem, outcome)
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("cannot run %s hook: %w", hookName, err)
+	}
+	timer := time.AfterFunc(hookTimeout, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", hookName, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// per-run log directories ($SCRIPTHAUS_HOME/runs/[historyid]), enabled with "run_logs" in config.json.
+// each directory has metadata.json, stdout.log, stderr.log, and env-diff.json
+package runlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+	"github.com/scripthaus-dev/scripthaus/pkg/secrets"
+)
+
+const RunsDirName = "runs"
+
+type RunLog struct {
+	Dir        string
+	execItem   *commanddef.ExecItem
+	stdoutFd   *os.File
+	stderrFd   *os.File
+	startTs    time.Time
+	secretVals []string
+}
+
+type metadata struct {
+	HistoryId  int64            `json:"historyid"`
+	Command    string           `json:"command"`
+	Playbook   string           `json:"playbook"`
+	Lang       string           `json:"lang"`
+	Args       []string         `json:"args"`
+	Cwd        string           `json:"cwd"`
+	CmdDir     string           `json:"cmddir,omitempty"` // where the command ran (if not cwd)
+	Start      time.Time        `json:"start"`
+	End        *time.Time       `json:"end,omitempty"`
+	DurationMs *int64           `json:"durationms,omitempty"`
+	ExitCode   *int             `json:"exitcode,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Warnings   []string         `json:"warnings"`
+	History    *json.RawMessage `json:"history,omitempty"`
+}
+
+// env-diff.json, the difference between the scripthaus environment and the command's
+type EnvDiff struct {
+	Added   map[string]string `json:"added"`
+	Changed map[string]string `json:"changed"`
+	Removed []string          `json:"removed"`
+}
+
+func RunsDir() (string, error) {
+	scHome, err := pathutil.GetScHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(scHome, RunsDirName), nil
+}
+
+// creates the run directory for the (already inserted) history item and tees the command's
+// stdout/stderr into it.  call Finish after the command exits
+func Start(execItem *commanddef.ExecItem) (*RunLog, error) {
+	if execItem.HItem == nil || execItem.HItem.HistoryId == 0 {
+		return nil, fmt.Errorf("cannot create run log, command is not logged to history")
+	}
+	runsDir, err := RunsDir()
+	if err != nil {
+		return nil, err
+	}
+	rl := &RunLog{
+		Dir:        filepath.Join(runsDir, strconv.FormatInt(execItem.HItem.HistoryId, 10)),
+		execItem:   execItem,
+		startTs:    time.Now(),
+		secretVals: execItem.SecretVals,
+	}
+	// the directory can exist if history was cleared (ids are re-used), start fresh
+	os.RemoveAll(rl.Dir)
+	err = os.MkdirAll(rl.Dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create run log directory %s: %w", rl.Dir, err)
+	}
+	rl.stdoutFd, err = os.OpenFile(filepath.Join(rl.Dir, "stdout.log"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err == nil {
+		rl.stderrFd, err = os.OpenFile(filepath.Join(rl.Dir, "stderr.log"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	}
+	if err != nil {
+		rl.closeFiles()
+		return nil, fmt.Errorf("cannot create run log files: %w", err)
+	}
+	cmd := execItem.Cmd
+	cmd.Stdout = teeWriter(cmd.Stdout, rl.stdoutFd)
+	cmd.Stderr = teeWriter(cmd.Stderr, rl.stderrFd)
+	err = rl.writeJson("env-diff.json", rl.makeEnvDiff())
+	if err == nil {
+		err = rl.writeJson("metadata.json", rl.makeMetadata(nil, ""))
+	}
+	if err != nil {
+		rl.closeFiles()
+		return nil, err
+	}
+	execItem.HItem.SetMetadata("rundir", rl.Dir)
+	return rl, nil
+}
+
+func teeWriter(w io.Writer, fd *os.File) io.Writer {
+	if w == nil {
+		return fd
+	}
+	return io.MultiWriter(w, fd)
+}
+
+func (rl *RunLog) closeFiles() {
+	if rl.stdoutFd != nil {
+		rl.stdoutFd.Close()
+	}
+	if rl.stderrFd != nil {
+		rl.stderrFd.Close()
+	}
+}
+
+// closes the log files, redacts secrets, and writes the final metadata.json
+func (rl *RunLog) Finish(exitCode int, runErr error) error {
+	rl.closeFiles()
+	if len(rl.secretVals) > 0 {
+		for _, logName := range []string{"stdout.log", "stderr.log"} {
+			logFile := filepath.Join(rl.Dir, logName)
+			barr, err := os.ReadFile(logFile)
+			if err != nil {
+				return err
+			}
+			redacted := secrets.Redact([]string{string(barr)}, rl.secretVals)[0]
+			err = os.WriteFile(logFile, []byte(redacted), 0600)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var errStr string
+	if runErr != nil {
+		errStr = runErr.Error()
+	}
+	return rl.writeJson("metadata.json", rl.makeMetadata(&exitCode, errStr))
+}
+
+func (rl *RunLog) makeMetadata(exitCode *int, errStr string) metadata {
+	cdef := rl.execItem.CmdDef
+	hitem := rl.execItem.HItem
+	md := metadata{
+		HistoryId: hitem.HistoryId,
+		Command:   cdef.OrigScriptName(),
+		Playbook:  cdef.Playbook.ResolvedFile,
+		Lang:      cdef.Lang,
+		Args:      hitem.DecodeCmdLine(),
+		Cwd:       hitem.Cwd,
+		Start:     rl.startTs,
+		Error:     errStr,
+		Warnings:  cdef.Warnings,
+	}
+	if md.Args == nil {
+		md.Args = []string{}
+	}
+	if md.Warnings == nil {
+		md.Warnings = []string{}
+	}
+	if rl.execItem.Cmd.Dir != "" && rl.execItem.Cmd.Dir != hitem.Cwd {
+		md.CmdDir = rl.execItem.Cmd.Dir
+	}
+	if exitCode != nil {
+		endTs := time.Now()
+		durationMs := endTs.Sub(rl.startTs).Milliseconds()
+		md.End = &endTs
+		md.DurationMs = &durationMs
+		md.ExitCode = exitCode
+	}
+	if barr, err := hitem.MarshalJSON(); err == nil {
+		rawMsg := json.RawMessage(barr)
+		md.History = &rawMsg
+	}
+	return md
+}
+
+// the difference between the scripthaus environment and the command's environment (secrets are redacted)
+func (rl *RunLog) makeEnvDiff() EnvDiff {
+	rtn := EnvDiff{Added: map[string]string{}, Changed: map[string]string{}, Removed: []string{}}
+	cmdEnv := rl.execItem.Cmd.Env
+	if cmdEnv == nil {
+		// nil means the command inherits our environment
+		return rtn
+	}
+	baseMap := envToMap(os.Environ())
+	cmdMap := envToMap(cmdEnv)
+	for name, val := range cmdMap {
+		baseVal, found := baseMap[name]
+		if !found {
+			rtn.Added[name] = secrets.Redact([]string{val}, rl.secretVals)[0]
+		} else if baseVal != val {
+			rtn.Changed[name] = secrets.Redact([]string{val}, rl.secretVals)[0]
+		}
+	}
+	for name := range baseMap {
+		if _, found := cmdMap[name]; !found {
+			rtn.Removed = append(rtn.Removed, name)
+		}
+	}
+	sort.Strings(rtn.Removed)
+	return rtn
+}
+
+// later entries win (same as exec.Cmd)
+func envToMap(env []string) map[string]string {
+	rtn := make(map[string]string)
+	for _, entry := range env {
+		eqIdx := strings.Index(entry, "=")
+		if eqIdx <= 0 {
+			continue
+		}
+		rtn[entry[:eqIdx]] = entry[eqIdx+1:]
+	}
+	return rtn
+}
+
+func (rl *RunLog) writeJson(fileName string, val interface{}) error {
+	barr, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	fullName := filepath.Join(rl.Dir, fileName)
+	err = os.WriteFile(fullName, append(barr, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", fullName, err)
+	}
+	return nil
+}
+
+// reads the env-diff.json of a run log directory
+func ReadEnvDiff(runDir string) (*EnvDiff, error) {
+	barr, err := os.ReadFile(filepath.Join(runDir, "env-diff.json"))
+	if err != nil {
+		return nil, err
+	}
+	var rtn EnvDiff
+	err = json.Unmarshal(barr, &rtn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid env-diff.json in %s: %w", runDir, err)
+	}
+	return &rtn, nil
+}
+
+// the first and last numLines lines of a run log file ("stdout.log" or "stderr.log").  if the
+// file has at most 2*numLines lines, head has all of them and tail is empty.  also returns the
+// total number of lines
+func ReadLogHeadTail(runDir string, logName string, numLines int) ([]string, []string, int, error) {
+	barr, err := os.ReadFile(filepath.Join(runDir, logName))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	text := strings.TrimSuffix(string(barr), "\n")
+	if text == "" {
+		return nil, nil, 0, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) <= 2*numLines {
+		return lines, nil, len(lines), nil
+	}
+	return lines[:numLines], lines[len(lines)-numLines:], len(lines), nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// resolves the 'runtime' directive (python=3.11 node=20) to an installed interpreter, so a
+// command does not run under whatever version is first on PATH.  versions are looked up in the
+// config's runtime_paths, then with mise, then with asdf.  a version matches itself and the
+// versions it is a prefix of ("3.11" matches "3.11.4"), the highest installed match is used
+package runtimes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// runtime name => its executable names (the first is the one blocks run with)
+var executables = map[string][]string{
+	"python": {"python3", "python"},
+	"node":   {"node"},
+	"bun":    {"bun"},
+	"deno":   {"deno"},
+	"ruby":   {"ruby"},
+	"perl":   {"perl"},
+}
+
+func IsKnown(name string) bool {
+	_, found := executables[name]
+	return found
+}
+
+// the runtime an executable belongs to ("python3" => "python"), "" if none
+func ForExecutable(exeName string) string {
+	exeName = strings.TrimSuffix(exeName, ".exe")
+	for name, exeNames := range executables {
+		for _, candidate := range exeNames {
+			if candidate == exeName {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+type Resolved struct {
+	Name    string
+	Version string // as written in the directive
+	Exe     string // absolute path of the interpreter
+	Source  string // "config", "mise", or "asdf"
+}
+
+// the directory the runtime's executables are in (prepended to the command's PATH)
+func (r Resolved) BinDir() string {
+	return filepath.Dir(r.Exe)
+}
+
+// true if version is wanted or more specific ("3.11.4" matches "3.11", "3.110" does not)
+func versionMatches(version string, wanted string) bool {
+	return version == wanted || strings.HasPrefix(version, wanted+".")
+}
+
+// compares dotted versions numerically (non-numeric parts compare as strings)
+func compareVersions(v1 string, v2 string) int {
+	parts1 := strings.Split(v1, ".")
+	parts2 := strings.Split(v2, ".")
+	for idx := 0; idx < len(parts1) && idx < len(parts2); idx++ {
+		num1, err1 := strconv.Atoi(parts1[idx])
+		num2, err2 := strconv.Atoi(parts2[idx])
+		if err1 == nil && err2 == nil {
+			if num1 != num2 {
+				if num1 < num2 {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if cmp := strings.Compare(parts1[idx], parts2[idx]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(parts1) - len(parts2)
+}
+
+func runTool(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr == "" {
+			errStr = err.Error()
+		}
+		return "", fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), errStr)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// the runtime's executable in an install directory (exeName first)
+func findExe(installDir string, name string, exeName string) string {
+	for _, candidate := range append([]string{exeName}, executables[name]...) {
+		exePath := filepath.Join(installDir, "bin", candidate)
+		if finfo, err := os.Stat(exePath); err == nil && !finfo.IsDir() {
+			return exePath
+		}
+	}
+	return ""
+}
+
+func resolveMise(name string, version string, exeName string) string {
+	if _, err := exec.LookPath("mise"); err != nil {
+		return ""
+	}
+	installDir, err := runTool("mise", "where", name+"@"+version)
+	if err != nil || installDir == "" {
+		return ""
+	}
+	return findExe(installDir, name, exeName)
+}
+
+func resolveAsdf(name string, version string, exeName string) string {
+	if _, err := exec.LookPath("asdf"); err != nil {
+		return ""
+	}
+	output, err := runTool("asdf", "list", name)
+	if err != nil {
+		return ""
+	}
+	bestVersion := ""
+	for _, line := range strings.Split(output, "\n") {
+		installed := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+		if versionMatches(installed, version) && (bestVersion == "" || compareVersions(installed, bestVersion) > 0) {
+			bestVersion = installed
+		}
+	}
+	if bestVersion == "" {
+		return ""
+	}
+	installDir, err := runTool("asdf", "where", name, bestVersion)
+	if err != nil || installDir == "" {
+		return ""
+	}
+	return findExe(installDir, name, exeName)
+}
+
+// the config's runtime_paths, runtime name => version => executable
+type Paths map[string]map[string]string
+
+var resolveLock = &sync.Mutex{}
+var resolveCache = make(map[string]Resolved)
+
+// finds the interpreter for the runtime version (paths is checked first).  exeName is the
+// executable the command runs ("" for the runtime's default)
+func Resolve(name string, version string, exeName string, paths Paths) (Resolved, error) {
+	if !IsKnown(name) {
+		return Resolved{}, fmt.Errorf("unknown runtime '%s'", name)
+	}
+	if exeName == "" {
+		exeName = executables[name][0]
+	}
+	cacheKey := name + "@" + version + "/" + exeName
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	if rtn, found := resolveCache[cacheKey]; found {
+		return rtn, nil
+	}
+	rtn := Resolved{Name: name, Version: version}
+	if exePath := paths[name][version]; exePath != "" {
+		if _, err := os.Stat(exePath); err != nil {
+			return Resolved{}, fmt.Errorf("runtime %s=%s, runtime_paths entry '%s' not found", name, version, exePath)
+		}
+		rtn.Exe, rtn.Source = exePath, "config"
+	} else if exePath := resolveMise(name, version, exeName); exePath != "" {
+		rtn.Exe, rtn.Source = exePath, "mise"
+	} else if exePath := resolveAsdf(name, version, exeName); exePath != "" {
+		rtn.Exe, rtn.Source = exePath, "asdf"
+	} else {
+		return Resolved{}, fmt.Errorf("runtime %s=%s is not installed (install it with mise or asdf, or add it to \"runtime_paths\" in config.json)", name, version)
+	}
+	resolveCache[cacheKey] = rtn
+	return rtn, nil
+}
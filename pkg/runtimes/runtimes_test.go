@@ -0,0 +1,34 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package runtimes
+
+import "testing"
+
+func TestVersions(t *testing.T) {
+	matches := []struct {
+		version string
+		wanted  string
+		match   bool
+	}{
+		{"3.11", "3.11", true},
+		{"3.11.4", "3.11", true},
+		{"3.110", "3.11", false},
+		{"20.11.0", "20", true},
+		{"200.1", "20", false},
+	}
+	for _, test := range matches {
+		if versionMatches(test.version, test.wanted) != test.match {
+			t.Errorf("versionMatches(%q, %q) should be %v", test.version, test.wanted, test.match)
+		}
+	}
+	if compareVersions("3.11.10", "3.11.9") <= 0 || compareVersions("3.9", "3.11") >= 0 || compareVersions("3.11", "3.11.0") >= 0 {
+		t.Errorf("bad compareVersions")
+	}
+	if ForExecutable("python3") != "python" || ForExecutable("node.exe") != "node" || ForExecutable("python2") != "" {
+		t.Errorf("bad ForExecutable")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scripthaus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/history"
+)
+
+// building and running commands.  Command.Run and every run path of the CLI ('scripthaus run'
+// with tags, matrix, --each, and pipelines, the daemon, and mcp) go through these, the CLI adds
+// its output, run logs, hooks, and notifications with ExecHooks
+
+// called around the start of a run (any of them can be nil)
+type ExecHooks struct {
+	// after the run is added to the history sink (so HistoryId is set), right before the
+	// commands start
+	BeforeStart func(execItems []*commanddef.ExecItem)
+
+	// after the commands started, the returned function (if not nil) is called once they exited
+	Started func(execItems []*commanddef.ExecItem) func()
+}
+
+// a command of a pipeline.  RunPipeline connects the stdin and stdout of the stages
+type PipeStage struct {
+	Def  *commanddef.CommandDef
+	Spec commanddef.SpecType
+}
+
+// checks the command's arguments, environment, and platform, and builds it
+func BuildExecItem(ctx context.Context, cdef *commanddef.CommandDef, runSpec commanddef.SpecType) (*commanddef.ExecItem, error) {
+	err := cdef.CheckCommand(runSpec)
+	if err != nil {
+		return nil, err
+	}
+	return cdef.BuildExecCommand(ctx, runSpec)
+}
+
+// BuildExecItem for one cell of a matrix run (see commanddef.MatrixCells), the cell's VAR=VAL
+// values are added to the environment and the cell is recorded in the history item
+func BuildMatrixCell(ctx context.Context, cdef *commanddef.CommandDef, runSpec commanddef.SpecType, cell []string) (*commanddef.ExecItem, error) {
+	cellSpec := runSpec
+	cellSpec.Env = append(append([]string(nil), runSpec.Env...), cell...)
+	execItem, err := BuildExecItem(ctx, cdef, cellSpec)
+	if err != nil {
+		return nil, err
+	}
+	execItem.MatrixCell = commanddef.MatrixCellName(cell)
+	if execItem.HItem != nil {
+		execItem.HItem.SetMetadata(history.MatrixMdKey, execItem.MatrixCell)
+	}
+	return execItem, nil
+}
+
+// runs a built command and waits for it to exit, the run is recorded in sink (nil for none).
+// the result is always returned.  err is set if the command could not be started (Started is
+// false and ExitCode is 1) or failed for a reason other than its exit code
+func RunExecItem(execItem *commanddef.ExecItem, sink HistorySink, hooks ExecHooks) (*RunResult, error) {
+	return runExecItems([]*commanddef.ExecItem{execItem}, sink, hooks)
+}
+
+func closeFiles(files []*os.File) {
+	for _, fd := range files {
+		fd.Close()
+	}
+}
+
+// runs the stages at the same time, the stdout of each command is piped into the stdin of the
+// next.  recorded as one history item (the first command, with the whole pipeline in its
+// metadata).  the exit code is the last non-zero exit code (like bash's pipefail).  returns a
+// nil result if a stage could not be built, otherwise like RunExecItem
+func RunPipeline(ctx context.Context, stages []PipeStage, sink HistorySink, hooks ExecHooks) (*RunResult, error) {
+	// pipe idx connects command idx to command idx+1
+	var readers, writers []*os.File
+	for idx := 0; idx < len(stages)-1; idx++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			closeFiles(readers)
+			closeFiles(writers)
+			return nil, fmt.Errorf("cannot create pipe: %w", err)
+		}
+		readers = append(readers, reader)
+		writers = append(writers, writer)
+	}
+	var execItems []*commanddef.ExecItem
+	var stageStrs []string
+	for idx, stage := range stages {
+		runSpec := stage.Spec
+		if idx > 0 {
+			runSpec.Stdin = readers[idx-1]
+			runSpec.NoLog = true
+			runSpec.ForceLog = false
+		}
+		if idx < len(writers) {
+			runSpec.Stdout = writers[idx]
+		}
+		execItem, err := BuildExecItem(ctx, stage.Def, runSpec)
+		if err != nil {
+			for _, item := range execItems {
+				item.Cleanup()
+			}
+			closeFiles(readers)
+			closeFiles(writers)
+			return nil, err
+		}
+		execItems = append(execItems, execItem)
+		stageStrs = append(stageStrs, shellescape.QuoteCommand(append([]string{stage.Def.OrigScriptName()}, execItem.LogArgs...)))
+	}
+	pipeline := strings.Join(stageStrs, " | ")
+	if hitem := execItems[0].HItem; hitem != nil {
+		hitem.SetMetadata(history.PipelineMdKey, pipeline)
+	}
+	startedFn := hooks.Started
+	hooks.Started = func(started []*commanddef.ExecItem) func() {
+		// the commands have their own copies of the pipe fds, ours must be closed so readers see EOF
+		closeFiles(readers)
+		closeFiles(writers)
+		if startedFn == nil {
+			return nil
+		}
+		return startedFn(started)
+	}
+	rtn, err := runExecItems(execItems, sink, hooks)
+	rtn.Pipeline = pipeline
+	return rtn, err
+}
+
+// starts all of the commands, then waits for all of them.  the first command's history item
+// is the run's.  if a command cannot be started, the ones already started are killed
+func runExecItems(execItems []*commanddef.ExecItem, sink HistorySink, hooks ExecHooks) (*RunResult, error) {
+	hitem := execItems[0].HItem
+	rtn := &RunResult{HistoryItem: hitem}
+	var rec *RunRecord
+	if hitem != nil && sink != nil {
+		rec, rtn.HistoryErr = StartRun(sink, hitem)
+		// runs that end in an error (or a panic) are still finished
+		defer func() {
+			if !hitem.ExitCode.Valid {
+				rec.SetResult(1, time.Since(rec.StartTs), history.TermReasonInterrupted)
+			}
+			if finishErr := rec.Finish(); finishErr != nil && rtn.HistoryErr == nil {
+				rtn.HistoryErr = finishErr
+			}
+		}()
+	}
+	if hooks.BeforeStart != nil {
+		hooks.BeforeStart(execItems)
+	}
+	startTs := time.Now()
+	var runErr error
+	numStarted := 0
+	for _, item := range execItems {
+		err := item.Start()
+		if err != nil {
+			runErr = fmt.Errorf("cannot start command '%s': %w", item.CmdShortName(), err)
+			break
+		}
+		numStarted++
+	}
+	rtn.Started = (runErr == nil)
+	var stopFn func()
+	if hooks.Started != nil {
+		stopFn = hooks.Started(execItems[:numStarted])
+	}
+	for idx, item := range execItems {
+		if idx >= numStarted {
+			item.Cleanup()
+			continue
+		}
+		if !rtn.Started {
+			item.Cmd.Process.Kill()
+		}
+		err := item.Wait()
+		item.Cleanup()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) && runErr == nil {
+			runErr = fmt.Errorf("error running command '%s': %w", item.CmdShortName(), err)
+		}
+		if itemExitCode := commanddef.WaitExitCode(err); itemExitCode != 0 {
+			rtn.ExitCode = itemExitCode
+		}
+		if rtn.TermReason == "" {
+			rtn.TermReason = item.TermReason()
+		}
+	}
+	if stopFn != nil {
+		stopFn()
+	}
+	rtn.Duration = time.Since(startTs)
+	if !rtn.Started {
+		rtn.ExitCode = 1
+	}
+	if rec != nil {
+		rec.SetResult(rtn.ExitCode, rtn.Duration, rtn.TermReason)
+	}
+	return rtn, runErr
+}
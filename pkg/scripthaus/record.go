@@ -0,0 +1,85 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scripthaus
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/history"
+)
+
+// a run's history item from StartRun (Sink.Start) to Finish (Sink.Finish).  runs that are
+// still open when the process stops early (a panic, a fatal signal, an os.Exit) are finished by
+// FinishInterrupted, so the history row is marked interrupted instead of never getting an exit
+// code.  rows left by a process that was killed outright are marked by history.SweepInterrupted
+type RunRecord struct {
+	Sink    HistorySink
+	Item    *history.HistoryItem
+	StartTs time.Time
+}
+
+var openRunsLock = &sync.Mutex{}
+var openRuns = make(map[*RunRecord]bool)
+
+// calls sink.Start, the record is open (even if Start returned an error) until Finish
+func StartRun(sink HistorySink, item *history.HistoryItem) (*RunRecord, error) {
+	rec := &RunRecord{Sink: sink, Item: item, StartTs: time.Now()}
+	err := sink.Start(item)
+	openRunsLock.Lock()
+	defer openRunsLock.Unlock()
+	openRuns[rec] = true
+	return rec, err
+}
+
+// removes the record from the open runs, returns false if it was already finished
+func (rec *RunRecord) close() bool {
+	openRunsLock.Lock()
+	defer openRunsLock.Unlock()
+	if !openRuns[rec] {
+		return false
+	}
+	delete(openRuns, rec)
+	return true
+}
+
+// sets the item's exit code, duration, and termination reason ("" for none), nothing else has to
+// be recorded after this (FinishInterrupted keeps them)
+func (rec *RunRecord) SetResult(exitCode int, duration time.Duration, termReason string) {
+	rec.Item.ExitCode = sql.NullInt64{Valid: true, Int64: int64(exitCode)}
+	rec.Item.DurationMs = sql.NullInt64{Valid: true, Int64: duration.Milliseconds()}
+	if termReason != "" {
+		rec.Item.SetMetadata(history.TermReasonMdKey, termReason)
+	}
+}
+
+// calls Sink.Finish (once, later calls do nothing)
+func (rec *RunRecord) Finish() error {
+	if !rec.close() {
+		return nil
+	}
+	return rec.Sink.Finish(rec.Item)
+}
+
+// finishes every open run.  runs without a result are recorded as interrupted with exitCode and
+// the duration so far.  returns the number of runs that were interrupted
+func FinishInterrupted(exitCode int) int {
+	openRunsLock.Lock()
+	recs := openRuns
+	openRuns = make(map[*RunRecord]bool)
+	openRunsLock.Unlock()
+	numInterrupted := 0
+	for rec := range recs {
+		if !rec.Item.ExitCode.Valid {
+			rec.SetResult(exitCode, time.Since(rec.StartTs), history.TermReasonInterrupted)
+			numInterrupted++
+		}
+		rec.Sink.Finish(rec.Item)
+	}
+	return numInterrupted
+}
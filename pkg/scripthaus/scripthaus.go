@@ -0,0 +1,218 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Go API for loading playbooks and running their commands without shelling out to the
+// scripthaus CLI.
+//
+//	pb, err := scripthaus.LoadPlaybook("./scripthaus.md")
+//	if err != nil { ... }
+//	cmd := pb.Command("build")
+//	result, err := cmd.Run(ctx, scripthaus.RunOptions{Args: []string{"--release"}, Stdout: &buf})
+package scripthaus
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/history"
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+type Playbook struct {
+	src      *mdparser.PlaybookSource
+	commands []*Command
+	warnings []string
+}
+
+type Command struct {
+	playbook *Playbook
+	def      *commanddef.CommandDef
+}
+
+// receives the history item for each run.  Start is called before the command starts and
+// Finish after it exits (with ExitCode and DurationMs set).  errors are returned from Run
+// but do not stop the command
+type HistorySink interface {
+	Start(item *history.HistoryItem) error
+	Finish(item *history.HistoryItem) error
+}
+
+type RunOptions struct {
+	Args []string // script arguments (bound to the command's 'arg' directives)
+	Env  []string // extra environment (VAR=VAL), takes precedence over the playbook's env
+	Dir  string   // working directory, overrides the command's 'cd' directive
+
+	// nil means os.Stdin, os.Stdout, and os.Stderr
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// nil means the run is not logged (use DBHistory for the scripthaus history db)
+	History HistorySink
+
+	// overrides the command's 'timeout' directive.  on timeout (or when ctx is cancelled) the
+	// command gets SIGTERM, then SIGKILL after the "kill_grace" period
+	Timeout time.Duration
+}
+
+type RunResult struct {
+	ExitCode    int
+	Duration    time.Duration
+	HistoryItem *history.HistoryItem // nil if the run was not logged
+	HistoryErr  error                // set if the HistorySink returned an error
+	TermReason  string               // "timeout" or "cancelled" if the command was terminated (see RunOptions)
+	Started     bool                 // false if the command could not be started (see RunExecItem)
+	Pipeline    string               // the pipeline's commands, "a | b" (set by RunPipeline)
+}
+
+// loads a playbook.  path is resolved like the CLI's playbook names ("./file.md", "^",
+// "^/file.md", or an absolute path)
+func LoadPlaybook(path string) (*Playbook, error) {
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := mdparser.ReadPlaybookSource(resolvedPlaybook)
+	if err != nil {
+		return nil, err
+	}
+	return makePlaybook(src)
+}
+
+// parses a playbook from memory, fileName is used to resolve relative paths ('cd :playbook',
+// includes) and does not have to exist
+func ParsePlaybook(fileName string, mdSource []byte) (*Playbook, error) {
+	resolvedPlaybook, err := pathutil.DefaultResolver().ResolvePlaybook(fileName)
+	if err != nil {
+		absFile, absErr := filepath.Abs(fileName)
+		if absErr != nil {
+			return nil, err
+		}
+		resolvedPlaybook = &pathutil.ResolvedPlaybook{OrigName: fileName, CanonicalName: absFile, ResolvedFile: absFile}
+	}
+	return makePlaybook(&mdparser.PlaybookSource{Playbook: resolvedPlaybook, Source: mdSource})
+}
+
+func makePlaybook(src *mdparser.PlaybookSource) (*Playbook, error) {
+	cmdDefs, warnings, err := src.Commands()
+	if err != nil {
+		return nil, err
+	}
+	pb := &Playbook{src: src, warnings: warnings}
+	for idx := range cmdDefs {
+		pb.commands = append(pb.commands, &Command{playbook: pb, def: &cmdDefs[idx]})
+	}
+	return pb, nil
+}
+
+// the resolved (absolute) playbook file
+func (pb *Playbook) Path() string {
+	return pb.src.Playbook.ResolvedFile
+}
+
+func (pb *Playbook) Source() []byte {
+	return pb.src.Source
+}
+
+// parse warnings (the playbook is still usable)
+func (pb *Playbook) Warnings() []string {
+	return pb.warnings
+}
+
+// in playbook order (including commands from included playbooks)
+func (pb *Playbook) Commands() []*Command {
+	return pb.commands
+}
+
+// finds a command by name or alias, returns nil if not found
+func (pb *Playbook) Command(name string) *Command {
+	for _, cmd := range pb.commands {
+		if cmd.def.MatchesName(name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (cmd *Command) Name() string {
+	return cmd.def.Name
+}
+
+func (cmd *Command) ShortText() string {
+	return cmd.def.ShortText
+}
+
+func (cmd *Command) Lang() string {
+	return cmd.def.Lang
+}
+
+func (cmd *Command) Tags() []string {
+	return cmd.def.GetTags()
+}
+
+func (cmd *Command) Playbook() *Playbook {
+	return cmd.playbook
+}
+
+// the underlying definition (for callers that need the parsed directives)
+func (cmd *Command) Def() *commanddef.CommandDef {
+	return cmd.def
+}
+
+// runs the command and waits for it to exit.  a non-zero exit code is not an error, errors
+// are returned if the command could not be started (bad args, missing env, platform, etc.).
+// cancelling ctx terminates the command
+func (cmd *Command) Run(ctx context.Context, opts RunOptions) (*RunResult, error) {
+	runSpec := commanddef.SpecType{
+		ScriptArgs: opts.Args,
+		Env:        opts.Env,
+		Stdin:      opts.Stdin,
+		Stdout:     opts.Stdout,
+		Stderr:     opts.Stderr,
+		Timeout:    opts.Timeout,
+		NoLog:      opts.History == nil,
+	}
+	execItem, err := BuildExecItem(ctx, cmd.def, runSpec)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Dir != "" {
+		execItem.Cmd.Dir = opts.Dir
+		if execItem.HItem != nil {
+			execItem.HItem.Cwd = opts.Dir
+		}
+	}
+	rtn, err := RunExecItem(execItem, opts.History, ExecHooks{})
+	if err != nil {
+		return nil, err
+	}
+	return rtn, nil
+}
+
+type dbHistory struct{}
+
+// logs runs to the scripthaus history db (the same history the CLI shows).  safe to use from
+// concurrent runs (db writes are serialized)
+var DBHistory HistorySink = dbHistory{}
+
+var dbHistoryLock = &sync.Mutex{}
+
+func (dbHistory) Start(item *history.HistoryItem) error {
+	dbHistoryLock.Lock()
+	defer dbHistoryLock.Unlock()
+	return history.InsertHistoryItem(item)
+}
+
+func (dbHistory) Finish(item *history.HistoryItem) error {
+	dbHistoryLock.Lock()
+	defer dbHistoryLock.Unlock()
+	return history.UpdateHistoryItem(item)
+}
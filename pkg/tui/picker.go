@@ -0,0 +1,250 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// an fzf-style fuzzy picker for the terminal (used by 'scripthaus pick')
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ansiReset      = "\x1b[0m"
+	ansiReverse    = "\x1b[7m"
+	ansiGray       = "\x1b[90m"
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+)
+
+const defaultRows = 24
+const defaultCols = 80
+
+type PickItem struct {
+	Text    string // shown in the list and matched against the query
+	Preview string // shown in the preview pane when the item is selected (plain text)
+}
+
+// true if stdin and stdout are both terminals
+func IsInteractive() bool {
+	return isCharDevice(os.Stdin) && isCharDevice(os.Stdout)
+}
+
+func isCharDevice(fd *os.File) bool {
+	finfo, err := fd.Stat()
+	if err != nil {
+		return false
+	}
+	return finfo.Mode()&os.ModeCharDevice != 0
+}
+
+type picker struct {
+	Items    []PickItem
+	Texts    []string
+	Query    []rune
+	Filtered []int // indexes into Items
+	Selected int   // index into Filtered
+	Offset   int   // first visible row of Filtered
+	Rows     int
+	Cols     int
+}
+
+func (p *picker) filter() {
+	p.Filtered = FuzzyFilter(string(p.Query), p.Texts)
+	p.Selected = 0
+	p.Offset = 0
+}
+
+func (p *picker) move(delta int) {
+	p.Selected += delta
+	if p.Selected >= len(p.Filtered) {
+		p.Selected = len(p.Filtered) - 1
+	}
+	if p.Selected < 0 {
+		p.Selected = 0
+	}
+}
+
+// truncates to width runes (tabs are expanded)
+func fitWidth(text string, width int) string {
+	text = strings.ReplaceAll(text, "\t", "    ")
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text + strings.Repeat(" ", width-utf8.RuneCountInString(text))
+	}
+	runes := []rune(text)
+	return string(runes[:width-1]) + "…"
+}
+
+func (p *picker) render() string {
+	var buf strings.Builder
+	buf.WriteString(ansiClear)
+	buf.WriteString(fmt.Sprintf("%s  %d/%d%s\r\n", ansiGray, len(p.Filtered), len(p.Items), ansiReset))
+	listRows := p.Rows - 2
+	if listRows < 1 {
+		listRows = 1
+	}
+	if p.Selected < p.Offset {
+		p.Offset = p.Selected
+	}
+	if p.Selected >= p.Offset+listRows {
+		p.Offset = p.Selected - listRows + 1
+	}
+	listWidth := p.Cols / 2
+	if listWidth > 60 {
+		listWidth = 60
+	}
+	previewWidth := p.Cols - listWidth - 3
+	var previewLines []string
+	if len(p.Filtered) > 0 {
+		previewLines = strings.Split(p.Items[p.Filtered[p.Selected]].Preview, "\n")
+	}
+	for row := 0; row < listRows; row++ {
+		itemIdx := p.Offset + row
+		if itemIdx < len(p.Filtered) {
+			text := fitWidth("  "+p.Texts[p.Filtered[itemIdx]], listWidth)
+			if itemIdx == p.Selected {
+				text = ansiReverse + text + ansiReset
+			}
+			buf.WriteString(text)
+		} else {
+			buf.WriteString(strings.Repeat(" ", listWidth))
+		}
+		if previewWidth > 0 {
+			buf.WriteString(ansiGray + " │ " + ansiReset)
+			if row < len(previewLines) {
+				buf.WriteString(strings.TrimRight(fitWidth(strings.TrimRight(previewLines[row], "\r"), previewWidth), " "))
+			}
+		}
+		buf.WriteString("\r\n")
+	}
+	// the prompt goes last so the cursor is left on the query line
+	buf.WriteString("> " + string(p.Query))
+	return buf.String()
+}
+
+// handles one read from the terminal (an escape sequence, or one or more keys when typing
+// fast or pasting).  returns (done, cancelled)
+func (p *picker) handleInput(input []byte) (bool, bool) {
+	if len(input) == 1 && input[0] == 27 {
+		return true, true
+	}
+	if len(input) >= 3 && input[0] == 27 && (input[1] == '[' || input[1] == 'O') {
+		switch string(input[2:]) {
+		case "A":
+			p.move(-1)
+		case "B":
+			p.move(1)
+		case "5~":
+			p.move(-(p.Rows - 2))
+		case "6~":
+			p.move(p.Rows - 2)
+		}
+		return false, false
+	}
+	queryChanged := false
+	defer func() {
+		if queryChanged {
+			p.filter()
+		}
+	}()
+	for len(input) > 0 {
+		ch, size := utf8.DecodeRune(input)
+		input = input[size:]
+		switch ch {
+		case 3, 7, 27:
+			// ctrl-c, ctrl-g, esc
+			return true, true
+
+		case '\r', '\n':
+			if queryChanged {
+				p.filter()
+				queryChanged = false
+			}
+			return true, len(p.Filtered) == 0
+
+		case 16, 11:
+			// ctrl-p, ctrl-k
+			p.move(-1)
+
+		case 14:
+			// ctrl-n
+			p.move(1)
+
+		case 127, 8:
+			if len(p.Query) > 0 {
+				p.Query = p.Query[:len(p.Query)-1]
+				queryChanged = true
+			}
+
+		case 21:
+			// ctrl-u
+			p.Query = nil
+			queryChanged = true
+
+		default:
+			if ch == utf8.RuneError || ch < 32 {
+				continue
+			}
+			p.Query = append(p.Query, ch)
+			queryChanged = true
+		}
+	}
+	return false, false
+}
+
+// runs the picker on the terminal.  returns the index of the chosen item, or -1 if the
+// picker was cancelled (esc or ctrl-c)
+func Pick(items []PickItem, initialQuery string) (int, error) {
+	if len(items) == 0 {
+		return -1, fmt.Errorf("no commands to pick from")
+	}
+	tty, err := openTty()
+	if err != nil {
+		return -1, fmt.Errorf("cannot open terminal: %w", err)
+	}
+	defer tty.Close()
+	rows, cols, err := termSize(tty)
+	if err != nil {
+		// some terminals (e.g. under 'script') report a zero size
+		rows, cols = defaultRows, defaultCols
+	}
+	restoreFn, err := makeRaw(tty)
+	if err != nil {
+		return -1, fmt.Errorf("cannot set terminal to raw mode: %w", err)
+	}
+	defer restoreFn()
+	tty.WriteString(ansiAltScreen)
+	defer tty.WriteString(ansiMainScreen)
+	p := &picker{Items: items, Query: []rune(initialQuery), Rows: rows, Cols: cols}
+	for _, item := range items {
+		p.Texts = append(p.Texts, item.Text)
+	}
+	p.filter()
+	input := make([]byte, 64)
+	for {
+		_, err = tty.WriteString(p.render())
+		if err != nil {
+			return -1, err
+		}
+		numRead, err := tty.Read(input)
+		if err != nil {
+			return -1, fmt.Errorf("reading from terminal: %w", err)
+		}
+		done, cancelled := p.handleInput(input[:numRead])
+		if cancelled {
+			return -1, nil
+		}
+		if done {
+			return p.Filtered[p.Selected], nil
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !windows
+// +build !windows
+
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const ttyFileName = "/dev/tty"
+
+func openTty() (*os.File, error) {
+	return os.OpenFile(ttyFileName, os.O_RDWR, 0)
+}
+
+func runStty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// puts the terminal into raw mode, returns a function that restores the original settings
+func makeRaw(tty *os.File) (func(), error) {
+	savedState, err := runStty(tty, "-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = runStty(tty, "raw", "-echo")
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		runStty(tty, savedState)
+	}, nil
+}
+
+// returns (rows, cols)
+func termSize(tty *os.File) (int, int, error) {
+	sizeStr, err := runStty(tty, "size")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(sizeStr)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid terminal size '%s'", sizeStr)
+	}
+	rows, err1 := strconv.Atoi(fields[0])
+	cols, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || rows <= 0 || cols <= 0 {
+		return 0, 0, fmt.Errorf("invalid terminal size '%s'", sizeStr)
+	}
+	return rows, cols, nil
+}
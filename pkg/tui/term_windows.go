@@ -0,0 +1,27 @@
+// Copyright 2023 Michael Sawka
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build windows
+// +build windows
+
+package tui
+
+import (
+	"fmt"
+	"os"
+)
+
+func openTty() (*os.File, error) {
+	return nil, fmt.Errorf("the interactive picker is not supported on windows")
+}
+
+func makeRaw(tty *os.File) (func(), error) {
+	return nil, fmt.Errorf("the interactive picker is not supported on windows")
+}
+
+func termSize(tty *os.File) (int, int, error) {
+	return 0, 0, fmt.Errorf("the interactive picker is not supported on windows")
+}